@@ -18,6 +18,7 @@ package builder
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -41,6 +42,11 @@ const (
 	// supported environment variables
 	envSrcPkg    = "SRC_PKG"
 	envDeployPkg = "DEPLOY_PKG"
+
+	// directories holding the build secrets and configmaps, as
+	// <namespace>/<name>/<key> files
+	envBuildSecrets    = "BUILD_SECRETS"
+	envBuildConfigMaps = "BUILD_CONFIGMAPS"
 )
 
 type (
@@ -52,6 +58,18 @@ type (
 		// 1. SRC_PKG: path to source package directory
 		// 2. DEPLOY_PKG: path to deployment package directory
 		BuildCommand string `json:"command"`
+
+		// Env holds extra environment variables for the build command.
+		Env map[string]string `json:"env,omitempty"`
+
+		// SecretsDir is the directory in the shared volume the fetcher
+		// put the build secrets and configmaps in, if any. It is removed
+		// after the build.
+		SecretsDir string `json:"secretsDir,omitempty"`
+
+		// StreamLogs makes the builder stream the build output as it is
+		// produced, as newline-delimited PackageBuildEvents.
+		StreamLogs bool `json:"streamLogs,omitempty"`
 	}
 
 	PackageBuildResponse struct {
@@ -59,6 +77,14 @@ type (
 		BuildLogs        string `json:"buildLogs"`
 	}
 
+	// PackageBuildEvent is a message of a streamed build. All but the last
+	// one carry build output; the last one carries the result.
+	PackageBuildEvent struct {
+		Log        string                `json:"log,omitempty"`
+		Result     *PackageBuildResponse `json:"result,omitempty"`
+		StatusCode int                   `json:"statusCode,omitempty"`
+	}
+
 	Builder struct {
 		sharedVolumePath string
 	}
@@ -105,7 +131,7 @@ func (builder *Builder) Handler(w http.ResponseWriter, r *http.Request) {
 		builder.reply(w, "", e.Error(), http.StatusBadRequest)
 		return
 	}
-	log.Printf("Builder received request: %v", req)
+	log.Printf("Builder received request for source package %v with command %v", req.SrcPkgFilename, req.BuildCommand)
 
 	log.Println("Starting build...")
 	srcPkgPath := filepath.Join(builder.sharedVolumePath, req.SrcPkgFilename)
@@ -116,16 +142,41 @@ func (builder *Builder) Handler(w http.ResponseWriter, r *http.Request) {
 		// use default build command
 		buildCmd = "/build"
 	}
-	buildLogs, err := builder.build(buildCmd, srcPkgPath, deployPkgPath)
+
+	var secretsDir string
+	if len(req.SecretsDir) > 0 {
+		secretsDir = filepath.Join(builder.sharedVolumePath, filepath.Clean("/"+req.SecretsDir))
+		defer os.RemoveAll(secretsDir)
+	}
+
+	var stream *eventStream
+	var onLog func(string)
+	if req.StreamLogs {
+		stream = makeEventStream(w)
+		onLog = stream.log
+	}
+
+	// The build is killed if the client goes away, e.g. when buildermgr
+	// cancels it.
+	buildLogs, err := builder.build(r.Context(), buildCmd, srcPkgPath, deployPkgPath, req.Env, secretsDir, onLog)
 	if err != nil {
 		e := errors.New(fmt.Sprintf("Error building source package: %v", err))
 		log.Println(e.Error())
 		// append error at the end of build logs
 		buildLogs += fmt.Sprintf("%v\n", e.Error())
+		if stream != nil {
+			stream.log(fmt.Sprintf("%v\n", e.Error()))
+			stream.result(deployPkgFilename, buildLogs, http.StatusInternalServerError)
+			return
+		}
 		builder.reply(w, deployPkgFilename, buildLogs, http.StatusInternalServerError)
 		return
 	}
 
+	if stream != nil {
+		stream.result(deployPkgFilename, buildLogs, http.StatusOK)
+		return
+	}
 	builder.reply(w, deployPkgFilename, buildLogs, http.StatusOK)
 }
 
@@ -149,8 +200,11 @@ func (builder *Builder) reply(w http.ResponseWriter, pkgFilename string, buildLo
 	w.Write(rBody)
 }
 
-func (builder *Builder) build(command string, srcPkgPath string, deployPkgPath string) (string, error) {
-	cmd := exec.Command(command)
+// build runs the build command and returns its output. If onLog isn't
+// nil, it is also called with each line of output as it is produced.
+func (builder *Builder) build(ctx context.Context, command string, srcPkgPath string, deployPkgPath string,
+	buildEnv map[string]string, secretsDir string, onLog func(string)) (string, error) {
+	cmd := exec.CommandContext(ctx, command)
 
 	fi, err := os.Stat(srcPkgPath)
 	if err != nil {
@@ -162,30 +216,39 @@ func (builder *Builder) build(command string, srcPkgPath string, deployPkgPath s
 		cmd.Dir = path.Dir(srcPkgPath)
 	}
 
-	// set env variables for build command
-	cmd.Env = append(os.Environ(),
+	// set env variables for build command; the package's ones come
+	// first so that they can't override the ones set here
+	cmd.Env = os.Environ()
+	buildEnvNames := make([]string, 0, len(buildEnv))
+	for k, v := range buildEnv {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", k, v))
+		buildEnvNames = append(buildEnvNames, k)
+	}
+	cmd.Env = append(cmd.Env,
 		fmt.Sprintf("%v=%v", envSrcPkg, srcPkgPath),
 		fmt.Sprintf("%v=%v", envDeployPkg, deployPkgPath),
 	)
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", errors.New(fmt.Sprintf("Error creating stdout pipe for cmd: %v", err.Error()))
+	if len(secretsDir) > 0 {
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("%v=%v", envBuildSecrets, filepath.Join(secretsDir, "secrets")),
+			fmt.Sprintf("%v=%v", envBuildConfigMaps, filepath.Join(secretsDir, "configs")),
+		)
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return "", errors.New(fmt.Sprintf("Error creating stderr pipe for cmd: %v", err.Error()))
-	}
+	// stdout and stderr share a pipe so that the output is read in
+	// the order it is written.
+	out, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
 
 	var buildLogs string
 
 	fmt.Printf("\n=== Build Logs ===")
 	// Init logs
 	fmt.Printf("command=%v\n", command)
-	fmt.Printf("env=%v\n", cmd.Env)
+	// the values of the package's env variables may be credentials
+	fmt.Printf("env=%v, build env=%v\n", os.Environ(), buildEnvNames)
 
-	out := io.MultiReader(stdout, stderr)
 	scanner := bufio.NewScanner(out)
 
 	err = cmd.Start()
@@ -193,20 +256,33 @@ func (builder *Builder) build(command string, srcPkgPath string, deployPkgPath s
 		return "", errors.New(fmt.Sprintf("Error starting cmd: %v", err.Error()))
 	}
 
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
 	// Runtime logs
 	for scanner.Scan() {
-		output := scanner.Text()
-		fmt.Println(output)
-		buildLogs += fmt.Sprintf("%v\n", output)
+		output := fmt.Sprintf("%v\n", scanner.Text())
+		fmt.Print(output)
+		buildLogs += output
+		if onLog != nil {
+			onLog(output)
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
+		// Drain the pipe so that the command can exit
+		io.Copy(ioutil.Discard, out)
+		<-waitErr
 		scanErr := errors.New(fmt.Sprintf("Error reading cmd output: %v", err.Error()))
 		fmt.Println(scanErr)
 		return buildLogs, scanErr
 	}
 
-	err = cmd.Wait()
+	err = <-waitErr
 	if err != nil {
 		cmdErr := errors.New(fmt.Sprintf("Error waiting for cmd '%v': %v", command, err.Error()))
 		fmt.Println(cmdErr)
@@ -216,3 +292,44 @@ func (builder *Builder) build(command string, srcPkgPath string, deployPkgPath s
 
 	return buildLogs, nil
 }
+
+// eventStream writes the events of a streamed build to the response,
+// flushing each one so the client sees the output as it is produced.
+type eventStream struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+}
+
+func makeEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	return &eventStream{
+		w:       w,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (es *eventStream) log(output string) {
+	es.write(&PackageBuildEvent{Log: output})
+}
+
+func (es *eventStream) result(pkgFilename string, buildLogs string, statusCode int) {
+	es.write(&PackageBuildEvent{
+		Result: &PackageBuildResponse{
+			ArtifactFilename: pkgFilename,
+			BuildLogs:        buildLogs,
+		},
+		StatusCode: statusCode,
+	})
+}
+
+func (es *eventStream) write(event *PackageBuildEvent) {
+	err := es.encoder.Encode(event)
+	if err != nil {
+		log.Printf("Error writing build event: %v", err)
+		return
+	}
+	if f, ok := es.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
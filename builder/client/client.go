@@ -18,13 +18,18 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"golang.org/x/net/context/ctxhttp"
+
 	"github.com/fission/fission"
 	builder "github.com/fission/fission/builder"
 )
@@ -47,11 +52,75 @@ func (c *Client) Build(req *builder.PackageBuildRequest) (*builder.PackageBuildR
 		return nil, err
 	}
 
+	resp, err := c.post(context.Background(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return decodeResponse(resp)
+}
+
+// BuildWithLogs is like Build, but calls onLog with the build output as the
+// builder produces it. Builders that can't stream the output reply with all
+// of it once the build is done, which is passed to onLog at once. The build
+// is cancelled when the context is done.
+func (c *Client) BuildWithLogs(ctx context.Context, req *builder.PackageBuildRequest, onLog func(string)) (*builder.PackageBuildResponse, error) {
+	streamReq := *req
+	streamReq.StreamLogs = true
+	body, err := json.Marshal(&streamReq)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-ndjson") {
+		pkgBuildResp, err := decodeResponse(resp)
+		if pkgBuildResp != nil {
+			onLog(pkgBuildResp.BuildLogs)
+		}
+		return pkgBuildResp, err
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event builder.PackageBuildEvent
+		err = decoder.Decode(&event)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("error reading build output: %v", err)
+		}
+
+		if len(event.Log) > 0 {
+			onLog(event.Log)
+		}
+
+		if event.Result != nil {
+			if event.StatusCode != http.StatusOK {
+				return event.Result, fission.MakeError(fission.ErrorInternal, http.StatusText(event.StatusCode))
+			}
+			return event.Result, nil
+		}
+	}
+}
+
+// post sends the build request, retrying until the builder accepts it.
+func (c *Client) post(ctx context.Context, body []byte) (*http.Response, error) {
+	var err error
 	maxRetries := 20
 	var resp *http.Response
 
 	for i := 0; i < maxRetries; i++ {
-		resp, err = http.Post(c.url, "application/json", bytes.NewReader(body))
+		resp, err = ctxhttp.Post(ctx, nil, c.url, "application/json", bytes.NewReader(body))
 
 		if err == nil {
 			if resp.StatusCode == 200 {
@@ -60,7 +129,7 @@ func (c *Client) Build(req *builder.PackageBuildRequest) (*builder.PackageBuildR
 			err = fission.MakeErrorFromHTTP(resp)
 		}
 
-		if i < maxRetries-1 {
+		if i < maxRetries-1 && ctx.Err() == nil {
 			time.Sleep(50 * time.Duration(2*i) * time.Millisecond)
 			log.Printf("Error building package (%v), retrying", err)
 			continue
@@ -69,8 +138,10 @@ func (c *Client) Build(req *builder.PackageBuildRequest) (*builder.PackageBuildR
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	return resp, nil
+}
 
+func decodeResponse(resp *http.Response) (*builder.PackageBuildResponse, error) {
 	rBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("Error reading resp body: %v", err)
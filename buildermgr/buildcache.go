@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// buildCacheKey returns the key identifying the inputs of a package build:
+// the source archive checksum, the environment name and resource version,
+// the build command and the build env. It returns an empty string if the
+// source has no checksum, in which case the package is always built.
+func buildCacheKey(pkg *crd.Package, env *crd.Environment) string {
+	srcSum := sourceChecksum(pkg)
+	if len(srcSum) == 0 {
+		return ""
+	}
+
+	buildCmd := pkg.Spec.BuildCommand
+	if len(buildCmd) == 0 {
+		buildCmd = env.Spec.Builder.Command
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\n%v\n%v\n%v\n%v", srcSum, env.Metadata.Namespace, env.Metadata.Name,
+		env.Metadata.ResourceVersion, buildCmd)
+
+	// The build env can change the build output too. The contents of the
+	// build secrets and configmaps aren't part of the key.
+	envNames := make([]string, 0, len(pkg.Spec.BuildEnv))
+	for k := range pkg.Spec.BuildEnv {
+		envNames = append(envNames, k)
+	}
+	sort.Strings(envNames)
+	for _, k := range envNames {
+		fmt.Fprintf(h, "\nenv:%v=%v", k, pkg.Spec.BuildEnv[k])
+	}
+	for _, s := range pkg.Spec.BuildSecrets {
+		fmt.Fprintf(h, "\nsecret:%v", s.Name)
+	}
+	for _, c := range pkg.Spec.BuildConfigMaps {
+		fmt.Fprintf(h, "\nconfigmap:%v", c.Name)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sourceChecksum returns the SHA256 checksum of the source archive of the
+// package, or an empty string if it has none.
+func sourceChecksum(pkg *crd.Package) string {
+	if pkg.Spec.Source.Type == fission.ArchiveTypeLiteral && len(pkg.Spec.Source.Literal) > 0 {
+		sum := sha256.Sum256(pkg.Spec.Source.Literal)
+		return hex.EncodeToString(sum[:])
+	}
+	return pkg.Spec.Source.Checksum.Sum
+}
+
+// findCachedBuild returns a successfully built package in the namespace of
+// pkg whose build had the given cache key, or nil if there is none.
+// Packages of other namespaces are never reused, so that a deployment
+// archive isn't shared across namespaces.
+func (pkgw *packageWatcher) findCachedBuild(pkg *crd.Package, cacheKey string) *crd.Package {
+	if len(cacheKey) == 0 || pkgw.pkgStore == nil {
+		return nil
+	}
+
+	for _, obj := range pkgw.pkgStore.List() {
+		cached := obj.(*crd.Package)
+		if cached.Metadata.Namespace != pkg.Metadata.Namespace ||
+			cached.Metadata.Name == pkg.Metadata.Name ||
+			cached.Status.BuildStatus != fission.BuildStatusSucceeded ||
+			cached.Status.BuildCacheKey != cacheKey {
+			continue
+		}
+		if cached.Spec.Deployment.Type != fission.ArchiveTypeUrl || len(cached.Spec.Deployment.URL) == 0 {
+			continue
+		}
+		return cached
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func testSourcePackage(namespace string, name string, literal string) *crd.Package {
+	return &crd.Package{
+		Metadata: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: fission.PackageSpec{
+			Source: fission.Archive{Type: fission.ArchiveTypeLiteral, Literal: []byte(literal)},
+		},
+	}
+}
+
+func TestSourceChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("source"))
+	if s := sourceChecksum(testSourcePackage("default", "pkg", "source")); s != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected checksum of literal source, got %v", s)
+	}
+
+	pkg := &crd.Package{}
+	pkg.Spec.Source = fission.Archive{
+		Type:     fission.ArchiveTypeUrl,
+		URL:      "http://storagesvc/v1/archive?id=src",
+		Checksum: fission.Checksum{Type: fission.ChecksumTypeSHA256, Sum: "abc"},
+	}
+	if s := sourceChecksum(pkg); s != "abc" {
+		t.Fatalf("expected checksum of source URL, got %v", s)
+	}
+
+	// Sources without a checksum are always built
+	pkg.Spec.Source.Checksum = fission.Checksum{}
+	if key := buildCacheKey(pkg, testEnvironment("1", 0, 0)); key != "" {
+		t.Fatalf("expected no cache key for source without checksum, got %v", key)
+	}
+}
+
+func TestBuildCacheKey(t *testing.T) {
+	env := testEnvironment("1", 0, 0)
+	pkg := testSourcePackage("default", "pkg", "source")
+	key := buildCacheKey(pkg, env)
+	if len(key) == 0 {
+		t.Fatalf("expected cache key")
+	}
+	if other := testSourcePackage("default", "other", "source"); buildCacheKey(other, env) != key {
+		t.Fatalf("expected packages with the same inputs to have the same cache key")
+	}
+
+	// Every input of the build changes the key
+	for name, change := range map[string]func(pkg *crd.Package, env *crd.Environment){
+		"source":            func(pkg *crd.Package, env *crd.Environment) { pkg.Spec.Source.Literal = []byte("other") },
+		"build command":     func(pkg *crd.Package, env *crd.Environment) { pkg.Spec.BuildCommand = "make" },
+		"env build command": func(pkg *crd.Package, env *crd.Environment) { env.Spec.Builder.Command = "make" },
+		"env version":       func(pkg *crd.Package, env *crd.Environment) { env.Metadata.ResourceVersion = "2" },
+		"env namespace":     func(pkg *crd.Package, env *crd.Environment) { env.Metadata.Namespace = "other" },
+		"build env":         func(pkg *crd.Package, env *crd.Environment) { pkg.Spec.BuildEnv = map[string]string{"GOOS": "linux"} },
+		"build secret": func(pkg *crd.Package, env *crd.Environment) {
+			pkg.Spec.BuildSecrets = []fission.SecretReference{{Name: "creds"}}
+		},
+		"build configmap": func(pkg *crd.Package, env *crd.Environment) {
+			pkg.Spec.BuildConfigMaps = []fission.ConfigMapReference{{Name: "settings"}}
+		},
+	} {
+		changedPkg, changedEnv := pkg.DeepCopy(), env.DeepCopy()
+		change(changedPkg, changedEnv)
+		if buildCacheKey(changedPkg, changedEnv) == key {
+			t.Fatalf("expected %v to change the cache key", name)
+		}
+	}
+
+	// The build env is hashed in a stable order
+	pkg.Spec.BuildEnv = map[string]string{"A": "1", "B": "2", "C": "3", "D": "4"}
+	key = buildCacheKey(pkg, env)
+	for i := 0; i < 10; i++ {
+		if buildCacheKey(pkg, env) != key {
+			t.Fatalf("expected cache key not to depend on the order of the build env")
+		}
+	}
+}
+
+func TestFindCachedBuild(t *testing.T) {
+	cached := func(namespace string, name string, status fission.BuildStatus, cacheKey string) *crd.Package {
+		pkg := testSourcePackage(namespace, name, "source")
+		pkg.Spec.Deployment = fission.Archive{Type: fission.ArchiveTypeUrl, URL: "http://storagesvc/v1/archive?id=" + name}
+		pkg.Status = fission.PackageStatus{BuildStatus: status, BuildCacheKey: cacheKey}
+		return pkg
+	}
+
+	pkgw := &packageWatcher{pkgStore: k8sCache.NewStore(k8sCache.MetaNamespaceKeyFunc)}
+	pkg := testSourcePackage("default", "pkg", "source")
+	pkgw.pkgStore.Add(cached("other", "built", fission.BuildStatusSucceeded, "key"))
+	pkgw.pkgStore.Add(cached("default", "failed", fission.BuildStatusFailed, "key"))
+	pkgw.pkgStore.Add(cached("default", "other-key", fission.BuildStatusSucceeded, "other"))
+
+	// Builds of other namespaces aren't reused, nor are failed builds
+	if found := pkgw.findCachedBuild(pkg, "key"); found != nil {
+		t.Fatalf("expected no cached build, got %v/%v", found.Metadata.Namespace, found.Metadata.Name)
+	}
+
+	// Nor the previous build of the package itself
+	pkgw.pkgStore.Add(cached("default", "pkg", fission.BuildStatusSucceeded, "key"))
+	if found := pkgw.findCachedBuild(pkg, "key"); found != nil {
+		t.Fatalf("expected package not to reuse its own build")
+	}
+
+	pkgw.pkgStore.Add(cached("default", "built", fission.BuildStatusSucceeded, "key"))
+	if found := pkgw.findCachedBuild(pkg, "key"); found == nil || found.Metadata.Name != "built" {
+		t.Fatalf("expected build of package in the same namespace, got %v", found)
+	}
+	if found := pkgw.findCachedBuild(pkg, ""); found != nil {
+		t.Fatalf("expected packages without cache key never to reuse a build")
+	}
+}
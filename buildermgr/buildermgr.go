@@ -17,14 +17,20 @@ limitations under the License.
 package buildermgr
 
 import (
+	"fmt"
 	"log"
+	"net/http"
 
+	"github.com/gorilla/mux"
+	"go.opencensus.io/plugin/ochttp"
+
+	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
 	fetcherConfig "github.com/fission/fission/environments/fetcher/config"
 )
 
 // Start the buildermgr service.
-func Start(storageSvcUrl string, envBuilderNamespace string) error {
+func Start(port int, storageSvcUrl string, envBuilderNamespace string) error {
 
 	fissionClient, kubernetesClient, _, err := crd.MakeFissionClient()
 	if err != nil {
@@ -49,5 +55,19 @@ func Start(storageSvcUrl string, envBuilderNamespace string) error {
 		kubernetesClient, envBuilderNamespace, storageSvcUrl)
 	go pkgWatcher.watchPackages(fissionClient, kubernetesClient, envBuilderNamespace)
 
-	select {}
+	serve(port, pkgWatcher)
+	return nil
+}
+
+func serve(port int, pkgWatcher *packageWatcher) {
+	r := mux.NewRouter()
+	r.HandleFunc("/v2/buildLogs", pkgWatcher.buildLogsHandler).Methods("GET")
+	r.HandleFunc("/v2/rollback", pkgWatcher.rollbackHandler).Methods("POST")
+	address := fmt.Sprintf(":%v", port)
+	log.Printf("starting buildermgr at port %v", port)
+	r.Use(fission.LoggingMiddleware)
+	err := http.ListenAndServe(address, &ochttp.Handler{
+		Handler: r,
+	})
+	log.Fatal(err)
 }
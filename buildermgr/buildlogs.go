@@ -0,0 +1,361 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/storagesvc"
+	storageSvcClient "github.com/fission/fission/storagesvc/client"
+)
+
+const (
+	// Build logs longer than this are stored through the storage service,
+	// and only their tail is kept in the package status.
+	maxBuildLogSize = 16 * 1024
+
+	// Only the tail of the output of a running build is kept in memory,
+	// the output before it is spilled to a temporary file.
+	maxLiveBuildLogSize = 1024 * 1024
+
+	// Readers that fell behind the tail read the spilled output in
+	// chunks of this size.
+	buildLogReadSize = 64 * 1024
+)
+
+type (
+	// buildLog is the output of a running build. Readers wait on changed,
+	// which is closed and replaced whenever output is added.
+	//
+	// data holds the output from offset start on. Once the output grows
+	// past maxLiveBuildLogSize, all of it is written to spill too and
+	// data only keeps the tail.
+	buildLog struct {
+		lock    sync.Mutex
+		data    []byte
+		start   int64
+		spill   *os.File
+		done    bool
+		changed chan struct{}
+	}
+
+	// buildLogRegistry holds the output of the running builds, keyed by
+	// package namespace and name.
+	buildLogRegistry struct {
+		lock sync.Mutex
+		logs map[string]*buildLog
+	}
+)
+
+func makeBuildLogRegistry() *buildLogRegistry {
+	return &buildLogRegistry{
+		logs: make(map[string]*buildLog),
+	}
+}
+
+func buildLogKey(m *metav1.ObjectMeta) string {
+	return fmt.Sprintf("%v/%v", m.Namespace, m.Name)
+}
+
+// start registers the output of a new build of the package.
+func (reg *buildLogRegistry) start(m *metav1.ObjectMeta) *buildLog {
+	bl := &buildLog{
+		changed: make(chan struct{}),
+	}
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	reg.logs[buildLogKey(m)] = bl
+	return bl
+}
+
+// finish marks the build as done and removes its output from the registry;
+// readers get the log from the package afterwards.
+func (reg *buildLogRegistry) finish(m *metav1.ObjectMeta, bl *buildLog) {
+	bl.finish()
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	key := buildLogKey(m)
+	if reg.logs[key] == bl {
+		delete(reg.logs, key)
+	}
+}
+
+func (reg *buildLogRegistry) get(m *metav1.ObjectMeta) *buildLog {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	return reg.logs[buildLogKey(m)]
+}
+
+func (bl *buildLog) write(output string) {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	bl.data = append(bl.data, output...)
+
+	if bl.spill != nil {
+		_, err := bl.spill.WriteString(output)
+		if err != nil {
+			log.Printf("Error spilling build log: %v", err)
+			bl.removeSpill()
+		}
+	} else if len(bl.data) > maxLiveBuildLogSize && bl.start == 0 {
+		// Nothing was dropped yet, so data is all of the output
+		bl.createSpill()
+	}
+
+	if len(bl.data) > maxLiveBuildLogSize {
+		drop := len(bl.data) - maxLiveBuildLogSize
+		copy(bl.data, bl.data[drop:])
+		bl.data = bl.data[:maxLiveBuildLogSize]
+		bl.start += int64(drop)
+	}
+
+	close(bl.changed)
+	bl.changed = make(chan struct{})
+}
+
+func (bl *buildLog) createSpill() {
+	f, err := ioutil.TempFile("", "buildlog")
+	if err == nil {
+		bl.spill = f
+		_, err = f.Write(bl.data)
+	}
+	if err != nil {
+		log.Printf("Error spilling build log: %v", err)
+		bl.removeSpill()
+	}
+}
+
+func (bl *buildLog) removeSpill() {
+	if bl.spill == nil {
+		return
+	}
+	bl.spill.Close()
+	os.Remove(bl.spill.Name())
+	bl.spill = nil
+}
+
+// spillName returns the name of the file with all of the output, or an
+// empty string if the output was not spilled.
+func (bl *buildLog) spillName() string {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	if bl.spill == nil {
+		return ""
+	}
+	return bl.spill.Name()
+}
+
+func (bl *buildLog) finish() {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	bl.done = true
+	bl.removeSpill()
+	close(bl.changed)
+	bl.changed = make(chan struct{})
+}
+
+// read returns the output after offset and the offset it ends at, whether
+// the build is done, and a channel that is closed when there is more
+// output. Output that is no longer available is replaced by a note.
+func (bl *buildLog) read(offset int64) ([]byte, int64, bool, <-chan struct{}) {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	if offset < bl.start {
+		if bl.spill != nil {
+			size := bl.start - offset
+			if size > buildLogReadSize {
+				size = buildLogReadSize
+			}
+			data := make([]byte, size)
+			n, err := bl.spill.ReadAt(data, offset)
+			if n > 0 {
+				return data[:n], offset + int64(n), bl.done, bl.changed
+			}
+			log.Printf("Error reading spilled build log: %v", err)
+		}
+		note := fmt.Sprintf("[... %v bytes of build log dropped ...]\n", bl.start-offset)
+		return []byte(note), bl.start, bl.done, bl.changed
+	}
+
+	// The data is shifted in place as output is added, so readers get
+	// a copy.
+	data := make([]byte, int64(len(bl.data))-(offset-bl.start))
+	copy(data, bl.data[offset-bl.start:])
+	return data, offset + int64(len(data)), bl.done, bl.changed
+}
+
+// buildLogsHandler writes the build log of a package. With follow set, the
+// output of a pending or running build is streamed until the build is done.
+func (pkgw *packageWatcher) buildLogsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	m := &metav1.ObjectMeta{
+		Name:      query.Get("name"),
+		Namespace: query.Get("namespace"),
+	}
+	follow := query.Get("follow") == "true"
+	if len(m.Name) == 0 {
+		http.Error(w, "Package name is required", http.StatusBadRequest)
+		return
+	}
+	if len(m.Namespace) == 0 {
+		m.Namespace = metav1.NamespaceDefault
+	}
+
+	ctx := r.Context()
+	for {
+		if bl := pkgw.buildLogs.get(m); bl != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeLiveBuildLog(ctx, w, bl, follow)
+			return
+		}
+
+		pkg, err := pkgw.fissionClient.Packages(m.Namespace).Get(m.Name)
+		if errors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("Package %v not found", m.Name), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting package %v: %v", m.Name, err), http.StatusInternalServerError)
+			return
+		}
+
+		// Wait for the build of a pending package to start
+		if !follow || pkg.Status.BuildStatus != fission.BuildStatusPending {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeStoredBuildLog(ctx, w, pkgw.storageSvcUrl, pkg)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func writeLiveBuildLog(ctx context.Context, w http.ResponseWriter, bl *buildLog, follow bool) {
+	var offset int64
+	for {
+		data, next, done, changed := bl.read(offset)
+		if len(data) > 0 {
+			_, err := w.Write(data)
+			if err != nil {
+				return
+			}
+			offset = next
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			continue
+		}
+
+		if done || !follow {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// writeStoredBuildLog writes the full build log from the storage service if
+// there is one, and the log in the package status otherwise.
+func writeStoredBuildLog(ctx context.Context, w http.ResponseWriter, storageSvcUrl string, pkg *crd.Package) {
+	if len(pkg.Status.BuildLogUrl) > 0 {
+		logUrl, err := signArchiveUrl(ctx, storageSvcUrl, pkg.Status.BuildLogUrl)
+		var resp *http.Response
+		if err == nil {
+			resp, err = ctxhttp.Get(ctx, nil, logUrl)
+		}
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				io.Copy(w, resp.Body)
+				return
+			}
+			err = fission.MakeErrorFromHTTP(resp)
+		}
+		log.Printf("Error getting full build log of package %v: %v", pkg.Metadata.Name, err)
+	}
+	w.Write([]byte(pkg.Status.BuildLog))
+}
+
+// storeBuildLogs uploads build logs longer than maxBuildLogSize to the
+// storage service and returns their URL, or an empty string if the logs
+// fit in the package status. The output of the build is uploaded from
+// the file it was spilled to, if it was.
+func storeBuildLogs(ctx context.Context, storageSvcUrl string, pkg *crd.Package, buildLogs string, bl *buildLog) (string, error) {
+	if len(buildLogs) <= maxBuildLogSize {
+		return "", nil
+	}
+
+	fileName := bl.spillName()
+	if len(fileName) == 0 {
+		f, err := ioutil.TempFile("", "buildlog")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString(buildLogs)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		fileName = f.Name()
+	}
+
+	ssClient := storageSvcClient.MakeClient(storageSvcUrl)
+	metadata, err := uploadMetadata(ctx, storageSvcUrl, pkg)
+	if err != nil {
+		return "", err
+	}
+	metadata[storagesvc.MetadataContentType] = "text/plain"
+	id, err := ssClient.Upload(ctx, fileName, &metadata)
+	if err != nil {
+		return "", err
+	}
+	return ssClient.GetUrl(id), nil
+}
+
+// truncateBuildLogs returns the tail of build logs that don't fit in the
+// package status.
+func truncateBuildLogs(buildLogs string) string {
+	if len(buildLogs) <= maxBuildLogSize {
+		return buildLogs
+	}
+	return "[... build log truncated, use 'fission package build-logs' to get all of it ...]\n" +
+		buildLogs[len(buildLogs)-maxBuildLogSize:]
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildLogSpill(t *testing.T) {
+	reg := makeBuildLogRegistry()
+	m := &metav1.ObjectMeta{Name: "pkg", Namespace: "default"}
+	bl := reg.start(m)
+
+	var expected bytes.Buffer
+	line := strings.Repeat("x", 1023) + "\n"
+	for i := 0; i < 2*maxLiveBuildLogSize/len(line); i++ {
+		bl.write(line)
+		expected.WriteString(line)
+	}
+
+	if len(bl.data) != maxLiveBuildLogSize {
+		t.Fatalf("expected %v bytes in memory, got %v", maxLiveBuildLogSize, len(bl.data))
+	}
+
+	spilled, err := ioutil.ReadFile(bl.spillName())
+	if err != nil {
+		t.Fatalf("error reading spilled build log: %v", err)
+	}
+	if !bytes.Equal(spilled, expected.Bytes()) {
+		t.Fatalf("spilled build log has %v bytes, expected %v", len(spilled), expected.Len())
+	}
+
+	// A reader that starts after the output was spilled gets all of it
+	w := httptest.NewRecorder()
+	writeLiveBuildLog(context.Background(), w, bl, false)
+	if !bytes.Equal(w.Body.Bytes(), expected.Bytes()) {
+		t.Fatalf("live build log has %v bytes, expected %v", w.Body.Len(), expected.Len())
+	}
+
+	name := bl.spillName()
+	reg.finish(m, bl)
+	if reg.get(m) != nil {
+		t.Fatalf("finished build log is still registered")
+	}
+	if _, err := ioutil.ReadFile(name); err == nil {
+		t.Fatalf("spilled build log was not removed")
+	}
+
+	// Once the spilled output is gone, readers are told what was dropped
+	data, next, done, _ := bl.read(0)
+	if !done || next != bl.start || !strings.Contains(string(data), "bytes of build log dropped") {
+		t.Fatalf("unexpected read of dropped output: %q, %v, %v", data, next, done)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission/crd"
+)
+
+const (
+	defaultBuildTimeout        = 30 * time.Minute
+	defaultMaxConcurrentBuilds = 2
+)
+
+type (
+	// buildQueue limits the number of builds running at the same time for
+	// an environment. Builds over the limit wait for a slot in the order
+	// they were queued.
+	buildQueue struct {
+		slots   chan struct{}
+		waiting int32
+	}
+
+	// inflightBuild is a queued or running build of a package.
+	inflightBuild struct {
+		resourceVersion string
+		cancel          context.CancelFunc
+	}
+
+	// buildScheduler keeps track of the builds in flight, so that a build
+	// is cancelled when its package is updated or deleted, and of the
+	// build queues of the environments.
+	buildScheduler struct {
+		lock   sync.Mutex
+		builds map[string]*inflightBuild
+		queues map[string]*buildQueue
+	}
+)
+
+func makeBuildScheduler() *buildScheduler {
+	return &buildScheduler{
+		builds: make(map[string]*inflightBuild),
+		queues: make(map[string]*buildQueue),
+	}
+}
+
+// start registers a build of the package and returns its context. Any
+// build of an older version of the package is cancelled. It returns false
+// if this version of the package is already being built.
+func (bs *buildScheduler) start(m *metav1.ObjectMeta) (context.Context, bool) {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	key := buildLogKey(m)
+	if b, ok := bs.builds[key]; ok {
+		if b.resourceVersion == m.ResourceVersion {
+			return nil, false
+		}
+		log.Printf("Cancelling build of package %v with resource version %v, superseded by resource version %v",
+			m.Name, b.resourceVersion, m.ResourceVersion)
+		b.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bs.builds[key] = &inflightBuild{
+		resourceVersion: m.ResourceVersion,
+		cancel:          cancel,
+	}
+	return ctx, true
+}
+
+// finish unregisters the build of this version of the package.
+func (bs *buildScheduler) finish(m *metav1.ObjectMeta) {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	key := buildLogKey(m)
+	if b, ok := bs.builds[key]; ok && b.resourceVersion == m.ResourceVersion {
+		b.cancel()
+		delete(bs.builds, key)
+	}
+}
+
+// cancel cancels the build of the package, if any.
+func (bs *buildScheduler) cancel(m *metav1.ObjectMeta) {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	key := buildLogKey(m)
+	if b, ok := bs.builds[key]; ok {
+		log.Printf("Cancelling build of package %v with resource version %v", m.Name, b.resourceVersion)
+		b.cancel()
+		delete(bs.builds, key)
+	}
+}
+
+// queue returns the build queue of the environment. A new version of the
+// environment gets a new queue, as it has a new builder.
+func (bs *buildScheduler) queue(env *crd.Environment) *buildQueue {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	key := fmt.Sprintf("%v/%v/%v", env.Metadata.Namespace, env.Metadata.Name, env.Metadata.ResourceVersion)
+	q, ok := bs.queues[key]
+	if !ok {
+		limit := env.Spec.Builder.MaxConcurrentBuilds
+		if limit <= 0 {
+			limit = defaultMaxConcurrentBuilds
+		}
+		q = &buildQueue{
+			slots: make(chan struct{}, limit),
+		}
+		bs.queues[key] = q
+	}
+	return q
+}
+
+// acquire waits for a build slot until the context is done. If the build
+// has to wait, onQueued is called with its position in the queue first.
+func (q *buildQueue) acquire(ctx context.Context, onQueued func(depth int)) error {
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	depth := atomic.AddInt32(&q.waiting, 1)
+	defer atomic.AddInt32(&q.waiting, -1)
+	onQueued(int(depth))
+
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *buildQueue) release() {
+	<-q.slots
+}
+
+// buildTimeout returns the build timeout of the environment.
+func buildTimeout(env *crd.Environment) time.Duration {
+	if env.Spec.Builder.BuildTimeout > 0 {
+		return time.Duration(env.Spec.Builder.BuildTimeout) * time.Second
+	}
+	return defaultBuildTimeout
+}
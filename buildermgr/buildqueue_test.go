@@ -0,0 +1,194 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func testPackageMeta(resourceVersion string) *metav1.ObjectMeta {
+	return &metav1.ObjectMeta{
+		Name:            "pkg",
+		Namespace:       "default",
+		ResourceVersion: resourceVersion,
+	}
+}
+
+func testEnvironment(resourceVersion string, maxConcurrentBuilds int, buildTimeout int) *crd.Environment {
+	return &crd.Environment{
+		Metadata: metav1.ObjectMeta{
+			Name:            "env",
+			Namespace:       "default",
+			ResourceVersion: resourceVersion,
+		},
+		Spec: fission.EnvironmentSpec{
+			Builder: fission.Builder{
+				MaxConcurrentBuilds: maxConcurrentBuilds,
+				BuildTimeout:        buildTimeout,
+			},
+		},
+	}
+}
+
+func assertCancelled(t *testing.T, ctx context.Context, cancelled bool) {
+	if (ctx.Err() != nil) != cancelled {
+		t.Fatalf("expected build cancelled to be %v, got error %v", cancelled, ctx.Err())
+	}
+}
+
+func TestBuildSchedulerSupersede(t *testing.T) {
+	bs := makeBuildScheduler()
+
+	ctx1, ok := bs.start(testPackageMeta("1"))
+	if !ok {
+		t.Fatalf("first build was not started")
+	}
+
+	// The same version is not built twice
+	if _, ok := bs.start(testPackageMeta("1")); ok {
+		t.Fatalf("build of the same resource version was started again")
+	}
+	assertCancelled(t, ctx1, false)
+
+	// A new version cancels the build of the old one
+	ctx2, ok := bs.start(testPackageMeta("2"))
+	if !ok {
+		t.Fatalf("build of the new resource version was not started")
+	}
+	assertCancelled(t, ctx1, true)
+	assertCancelled(t, ctx2, false)
+
+	// The superseded build finishing doesn't unregister the new one
+	bs.finish(testPackageMeta("1"))
+	assertCancelled(t, ctx2, false)
+	if _, ok := bs.start(testPackageMeta("2")); ok {
+		t.Fatalf("build of resource version 2 is no longer registered")
+	}
+
+	bs.finish(testPackageMeta("2"))
+	assertCancelled(t, ctx2, true)
+	if _, ok := bs.start(testPackageMeta("2")); !ok {
+		t.Fatalf("finished build is still registered")
+	}
+}
+
+func TestBuildSchedulerDelete(t *testing.T) {
+	bs := makeBuildScheduler()
+
+	ctx, ok := bs.start(testPackageMeta("1"))
+	if !ok {
+		t.Fatalf("build was not started")
+	}
+
+	// Deleting the package cancels its build
+	bs.cancel(testPackageMeta(""))
+	assertCancelled(t, ctx, true)
+
+	if _, ok := bs.start(testPackageMeta("1")); !ok {
+		t.Fatalf("cancelled build is still registered")
+	}
+}
+
+func TestBuildQueueDepth(t *testing.T) {
+	bs := makeBuildScheduler()
+	env := testEnvironment("1", 1, 0)
+	q := bs.queue(env)
+	if bs.queue(env) != q {
+		t.Fatalf("environment got a new queue")
+	}
+	if bs.queue(testEnvironment("2", 1, 0)) == q {
+		t.Fatalf("new version of the environment got the old queue")
+	}
+
+	notQueued := func(depth int) {
+		t.Fatalf("build was queued at depth %v with a free slot", depth)
+	}
+	err := q.acquire(context.Background(), notQueued)
+	if err != nil {
+		t.Fatalf("error acquiring free slot: %v", err)
+	}
+
+	// Builds over the limit wait in order, reporting their position
+	depths := make(chan int, 2)
+	acquired := make(chan error, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		acquired <- q.acquire(context.Background(), func(depth int) { depths <- depth })
+	}()
+	if depth := <-depths; depth != 1 {
+		t.Fatalf("expected queue depth 1, got %v", depth)
+	}
+	go func() {
+		acquired <- q.acquire(ctx, func(depth int) { depths <- depth })
+	}()
+	if depth := <-depths; depth != 2 {
+		t.Fatalf("expected queue depth 2, got %v", depth)
+	}
+
+	// A cancelled build leaves the queue
+	cancel()
+	if err := <-acquired; err != context.Canceled {
+		t.Fatalf("expected cancelled build to fail with %v, got %v", context.Canceled, err)
+	}
+
+	q.release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("error acquiring released slot: %v", err)
+	}
+	q.release()
+
+	if err := q.acquire(context.Background(), notQueued); err != nil {
+		t.Fatalf("error acquiring free slot: %v", err)
+	}
+}
+
+func TestBuildQueueTimeout(t *testing.T) {
+	if timeout := buildTimeout(testEnvironment("1", 0, 0)); timeout != defaultBuildTimeout {
+		t.Fatalf("expected default build timeout %v, got %v", defaultBuildTimeout, timeout)
+	}
+	if timeout := buildTimeout(testEnvironment("1", 0, 60)); timeout != time.Minute {
+		t.Fatalf("expected build timeout %v, got %v", time.Minute, timeout)
+	}
+
+	q := makeBuildScheduler().queue(testEnvironment("1", 0, 0))
+	if cap(q.slots) != defaultMaxConcurrentBuilds {
+		t.Fatalf("expected %v build slots, got %v", defaultMaxConcurrentBuilds, cap(q.slots))
+	}
+	for i := 0; i < defaultMaxConcurrentBuilds; i++ {
+		if err := q.acquire(context.Background(), func(int) {}); err != nil {
+			t.Fatalf("error acquiring free slot: %v", err)
+		}
+	}
+
+	// A build that times out while queued gives up its place
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := q.acquire(ctx, func(int) {})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected queued build to fail with %v, got %v", context.DeadlineExceeded, err)
+	}
+	if q.waiting != 0 {
+		t.Fatalf("expected empty queue, got depth %v", q.waiting)
+	}
+}
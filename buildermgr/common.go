@@ -21,7 +21,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/dchest/uniuri"
 	"github.com/fission/fission"
@@ -29,8 +31,42 @@ import (
 	builderClient "github.com/fission/fission/builder/client"
 	"github.com/fission/fission/crd"
 	fetcherClient "github.com/fission/fission/environments/fetcher/client"
+	"github.com/fission/fission/storagesvc"
+	storageSvcClient "github.com/fission/fission/storagesvc/client"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	maxBuildLogsTailLines = 10
+
+	// Builds fetch their source right after the URL is signed
+	buildArchiveUrlTTL = 10 * time.Minute
+
+	// Build outputs are uploaded right after the upload is granted
+	buildUploadGrantTTL = 10 * time.Minute
+)
+
+// signArchiveUrl returns a signed download URL of the archive, if the
+// storage service only serves signed URLs.
+func signArchiveUrl(ctx context.Context, storageSvcUrl string, archiveUrl string) (string, error) {
+	signer := storageSvcClient.MakeArchiveUrlSigner(storageSvcUrl, os.Getenv("ARCHIVE_URL_ISSUER_TOKEN"))
+	return signer.Sign(ctx, archiveUrl, buildArchiveUrlTTL)
+}
+
+// uploadMetadata returns the metadata of archives uploaded for the
+// package, with a grant for the storage service to charge them to the
+// package's namespace.
+func uploadMetadata(ctx context.Context, storageSvcUrl string, pkg *crd.Package) (map[string]string, error) {
+	metadata := storagesvc.UploadMetadata(pkg.Metadata.Namespace, pkg.Metadata.Name, "buildermgr")
+	signer := storageSvcClient.MakeArchiveUrlSigner(storageSvcUrl, os.Getenv("ARCHIVE_URL_ISSUER_TOKEN"))
+	err := signer.GrantUpload(ctx, metadata, buildUploadGrantTTL)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
 // buildPackage helps to build source package into deployment package.
 // Following is the steps buildPackage function takes to complete the whole process.
 // 1. Send fetch request to fetcher to fetch source package.
@@ -38,13 +74,15 @@ import (
 // 3. Send upload request to fetcher to upload deployment package.
 // 4. Return upload response and build logs.
 // *. Return build logs and error if any one of steps above failed.
+// The build output is also passed to onLog as the builder produces it.
 func buildPackage(ctx context.Context, fissionClient *crd.FissionClient, envBuilderNamespace string,
-	storageSvcUrl string, pkg *crd.Package) (uploadResp *fission.ArchiveUploadResponse, buildLogs string, err error) {
+	storageSvcUrl string, pkg *crd.Package, onLog func(string)) (uploadResp *fission.ArchiveUploadResponse, buildLogs string, err error) {
 
 	env, err := fissionClient.Environments(pkg.Spec.Environment.Namespace).Get(pkg.Spec.Environment.Name)
 	if err != nil {
 		e := fmt.Sprintf("Error getting environment CRD info: %v", err)
 		log.Println(e)
+		onLog(fmt.Sprintf("%v\n", e))
 		return nil, e, fission.MakeError(http.StatusInternalServerError, e)
 	}
 
@@ -60,14 +98,46 @@ func buildPackage(ctx context.Context, fissionClient *crd.FissionClient, envBuil
 		KeepArchive: false,
 	}
 
+	sourceUrl, err := signArchiveUrl(ctx, storageSvcUrl, pkg.Spec.Source.URL)
+	if err != nil {
+		e := fmt.Sprintf("Error signing source package url: %v", err)
+		log.Println(e)
+		onLog(fmt.Sprintf("%v\n", e))
+		return nil, e, fission.MakeError(http.StatusInternalServerError, e)
+	}
+	if sourceUrl != pkg.Spec.Source.URL {
+		fetchReq.ArchiveUrl = sourceUrl
+	}
+
+	// The build secrets and configmaps go next to the source package, so
+	// that they don't end up in the deployment package
+	if len(pkg.Spec.BuildSecrets) > 0 || len(pkg.Spec.BuildConfigMaps) > 0 {
+		err = checkBuildReferences(pkg)
+		if err != nil {
+			e := err.Error()
+			log.Println(e)
+			onLog(fmt.Sprintf("%v\n", e))
+			return nil, e, fission.MakeError(fission.ErrorInvalidArgument, e)
+		}
+		fetchReq.Secrets = pkg.Spec.BuildSecrets
+		fetchReq.ConfigMaps = pkg.Spec.BuildConfigMaps
+		fetchReq.SecretsDir = fmt.Sprintf("%v-secrets", srcPkgFilename)
+	}
+
 	// send fetch request to fetcher
-	err = fetcherC.Fetch(ctx, fetchReq)
+	fetchResp, err := fetcherC.Fetch(ctx, fetchReq)
 	if err != nil {
 		e := fmt.Sprintf("Error fetching source package: %v", err)
 		log.Println(e)
+		onLog(fmt.Sprintf("%v\n", e))
 		return nil, e, fission.MakeError(http.StatusInternalServerError, e)
 	}
 
+	if len(fetchResp.GitCommit) > 0 {
+		pkg.Status.SourceCommit = fetchResp.GitCommit
+		onLog(fmt.Sprintf("Fetched source at commit %v\n", fetchResp.GitCommit))
+	}
+
 	buildCmd := pkg.Spec.BuildCommand
 	if len(buildCmd) == 0 {
 		buildCmd = env.Spec.Builder.Command
@@ -76,14 +146,17 @@ func buildPackage(ctx context.Context, fissionClient *crd.FissionClient, envBuil
 	pkgBuildReq := &builder.PackageBuildRequest{
 		SrcPkgFilename: srcPkgFilename,
 		BuildCommand:   buildCmd,
+		Env:            pkg.Spec.BuildEnv,
+		SecretsDir:     fetchReq.SecretsDir,
 	}
 
 	log.Printf("Start building with source package: %v", srcPkgFilename)
 	// send build request to builder
-	buildResp, err := builderC.Build(pkgBuildReq)
+	buildResp, err := builderC.BuildWithLogs(ctx, pkgBuildReq, onLog)
 	if err != nil {
 		e := fmt.Sprintf("Error building deployment package: %v", err)
 		log.Println(e)
+		onLog(fmt.Sprintf("%v\n", e))
 		var buildLogs string
 		if buildResp != nil {
 			buildLogs = buildResp.BuildLogs
@@ -96,10 +169,20 @@ func buildPackage(ctx context.Context, fissionClient *crd.FissionClient, envBuil
 
 	archivePackage := !env.Spec.KeepArchive
 
+	metadata, err := uploadMetadata(ctx, storageSvcUrl, pkg)
+	if err != nil {
+		e := fmt.Sprintf("Error granting upload of deployment package: %v", err)
+		log.Println(e)
+		onLog(fmt.Sprintf("%v\n", e))
+		buildResp.BuildLogs += fmt.Sprintf("%v\n", e)
+		return nil, buildResp.BuildLogs, fission.MakeError(http.StatusInternalServerError, e)
+	}
+
 	uploadReq := &fission.ArchiveUploadRequest{
 		Filename:       buildResp.ArtifactFilename,
 		StorageSvcUrl:  storageSvcUrl,
 		ArchivePackage: archivePackage,
+		Metadata:       metadata,
 	}
 
 	log.Printf("Start uploading deployment package: %v", buildResp.ArtifactFilename)
@@ -108,6 +191,7 @@ func buildPackage(ctx context.Context, fissionClient *crd.FissionClient, envBuil
 	if err != nil {
 		e := fmt.Sprintf("Error uploading deployment package: %v", err)
 		log.Println(e)
+		onLog(fmt.Sprintf("%v\n", e))
 		buildResp.BuildLogs += fmt.Sprintf("%v\n", e)
 		return nil, buildResp.BuildLogs, fission.MakeError(http.StatusInternalServerError, e)
 	}
@@ -115,13 +199,47 @@ func buildPackage(ctx context.Context, fissionClient *crd.FissionClient, envBuil
 	return uploadResp, buildResp.BuildLogs, nil
 }
 
+// checkBuildReferences makes sure that the build secrets and configmaps of
+// the package are in its namespace, so that a package can't read the
+// secrets of other namespaces through the builder.
+func checkBuildReferences(pkg *crd.Package) error {
+	for _, s := range pkg.Spec.BuildSecrets {
+		if s.Namespace != pkg.Metadata.Namespace {
+			return fmt.Errorf("Build secret %v.%v is not in the namespace of package %v", s.Name, s.Namespace, pkg.Metadata.Name)
+		}
+	}
+	for _, c := range pkg.Spec.BuildConfigMaps {
+		if c.Namespace != pkg.Metadata.Namespace {
+			return fmt.Errorf("Build configmap %v.%v is not in the namespace of package %v", c.Name, c.Namespace, pkg.Metadata.Name)
+		}
+	}
+	return nil
+}
+
 func updatePackage(fissionClient *crd.FissionClient,
 	pkg *crd.Package, status fission.BuildStatus, buildLogs string,
 	uploadResp *fission.ArchiveUploadResponse) (*crd.Package, error) {
 
+	// The build cache fields only describe successful builds, the full
+	// build log and source commit finished ones, and the queue depth
+	// queued ones. The revision history is kept across builds.
+	prevStatus := pkg.Status
 	pkg.Status = fission.PackageStatus{
 		BuildStatus: status,
-		BuildLog:    buildLogs,
+		BuildLog:    truncateBuildLogs(buildLogs),
+		Revision:    prevStatus.Revision,
+		Revisions:   prevStatus.Revisions,
+	}
+	if status == fission.BuildStatusSucceeded {
+		pkg.Status.BuildCacheKey = prevStatus.BuildCacheKey
+		pkg.Status.BuildCacheHit = prevStatus.BuildCacheHit
+	}
+	if status == fission.BuildStatusSucceeded || status == fission.BuildStatusFailed {
+		pkg.Status.BuildLogUrl = prevStatus.BuildLogUrl
+		pkg.Status.SourceCommit = prevStatus.SourceCommit
+	}
+	if status == fission.BuildStatusRunning {
+		pkg.Status.BuildQueueDepth = prevStatus.BuildQueueDepth
 	}
 
 	if uploadResp != nil {
@@ -130,6 +248,9 @@ func updatePackage(fissionClient *crd.FissionClient,
 			URL:      uploadResp.ArchiveDownloadUrl,
 			Checksum: uploadResp.Checksum,
 		}
+		if status == fission.BuildStatusSucceeded {
+			addPackageRevision(pkg)
+		}
 	}
 
 	// update package spec
@@ -139,6 +260,62 @@ func updatePackage(fissionClient *crd.FissionClient,
 		return nil, err
 	}
 
+	updateFunctionsPackageStatus(fissionClient, pkg, status, buildLogs)
+
 	// return resource version for function to update function package ref
 	return pkg, nil
 }
+
+// updateFunctionsPackageStatus sets the PackageReady condition of all
+// functions using the package according to the build status of the package.
+func updateFunctionsPackageStatus(fissionClient *crd.FissionClient,
+	pkg *crd.Package, status fission.BuildStatus, buildLogs string) {
+
+	var condStatus apiv1.ConditionStatus
+	var reason, message string
+	switch status {
+	case fission.BuildStatusRunning:
+		condStatus, reason = apiv1.ConditionFalse, "Building"
+	case fission.BuildStatusSucceeded:
+		condStatus, reason = apiv1.ConditionTrue, "BuildSucceeded"
+	case fission.BuildStatusFailed:
+		condStatus, reason, message = apiv1.ConditionFalse, "BuildFailed", buildLogsTail(buildLogs)
+	default:
+		return
+	}
+
+	fnList, err := fissionClient.Functions(pkg.Metadata.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Error getting function list to update status of functions of package %v: %v", pkg.Metadata.Name, err)
+		return
+	}
+
+	for _, fn := range fnList.Items {
+		if fn.Spec.Package.PackageRef.Name != pkg.Metadata.Name ||
+			fn.Spec.Package.PackageRef.Namespace != pkg.Metadata.Namespace {
+			continue
+		}
+		err = crd.UpdateFunctionStatus(fissionClient, fn.Metadata.Namespace, fn.Metadata.Name,
+			func(fnStatus *fission.FunctionStatus) bool {
+				changed := fnStatus.SetCondition(fission.FunctionPackageReady, condStatus, reason, message)
+				if status == fission.BuildStatusFailed && fnStatus.LastError != message {
+					fnStatus.LastError = message
+					changed = true
+				}
+				return changed
+			})
+		if err != nil {
+			log.Printf("Error updating status of function %v: %v", fn.Metadata.Name, err)
+		}
+	}
+}
+
+// buildLogsTail returns the last few lines of the build logs, which is
+// where builders usually print the reason of a failure.
+func buildLogsTail(buildLogs string) string {
+	lines := strings.Split(strings.TrimRight(buildLogs, "\n"), "\n")
+	if len(lines) > maxBuildLogsTailLines {
+		lines = lines[len(lines)-maxBuildLogsTailLines:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -27,9 +27,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	k8sCache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 
 	"github.com/fission/fission"
-	"github.com/fission/fission/cache"
 	"github.com/fission/fission/crd"
 	"k8s.io/client-go/kubernetes"
 )
@@ -42,6 +42,8 @@ type (
 		pkgStore         k8sCache.Store
 		builderNamespace string
 		storageSvcUrl    string
+		buildLogs        *buildLogRegistry
+		scheduler        *buildScheduler
 	}
 )
 
@@ -57,6 +59,8 @@ func makePackageWatcher(fissionClient *crd.FissionClient, k8sClientSet *kubernet
 		podStore:         store,
 		builderNamespace: builderNamespace,
 		storageSvcUrl:    storageSvcUrl,
+		buildLogs:        makeBuildLogRegistry(),
+		scheduler:        makeBuildScheduler(),
 	}
 	return pkgw
 }
@@ -66,26 +70,15 @@ func makePackageWatcher(fissionClient *crd.FissionClient, k8sClientSet *kubernet
 // Following is the steps build function takes to complete the whole process.
 // 1. Check package status
 // 2. Update package status to running state
-// 3. Check environment builder pod status
-// 4. Call buildPackage to build package
-// 5. Update package resource in package ref of functions that share the same package
-// 6. Update package status to succeed state
+// 3. Reuse the deployment archive of a package built from the same inputs, if any
+// 4. Wait for a free build slot of the environment
+// 5. Check environment builder pod status and call buildPackage to build package
+// 6. Update package resource in package ref of functions that share the same package
+// 7. Update package status to succeed state, recording the build cache key
 // *. Update package status to failed state,if any one of steps above failed/time out
-func (pkgw *packageWatcher) build(buildCache *cache.Cache, srcpkg *crd.Package) {
-
-	// Ignore non-pending state packages.
-	if srcpkg.Status.BuildStatus != fission.BuildStatusPending {
-		return
-	}
-
-	// Ignore duplicate build requests
-	key := fmt.Sprintf("%v-%v", srcpkg.Metadata.Name, srcpkg.Metadata.ResourceVersion)
-	err, _ := buildCache.Set(key, srcpkg)
-	if err != nil {
-		return
-	}
-	defer buildCache.Delete(key)
-
+// The build stops without updating the package once ctx is cancelled, i.e. when
+// a newer version of the package is built or the package is deleted.
+func (pkgw *packageWatcher) build(ctx context.Context, srcpkg *crd.Package) {
 	log.Printf("Start build for package %v with resource version %v", srcpkg.Metadata.Name, srcpkg.Metadata.ResourceVersion)
 
 	pkg, err := updatePackage(pkgw.fissionClient, srcpkg, fission.BuildStatusRunning, "", nil)
@@ -96,26 +89,80 @@ func (pkgw *packageWatcher) build(buildCache *cache.Cache, srcpkg *crd.Package)
 		return
 	}
 
+	// Make the output of the build available while it runs
+	blog := pkgw.buildLogs.start(&pkg.Metadata)
+	defer pkgw.buildLogs.finish(&pkg.Metadata, blog)
+
 	env, err := pkgw.fissionClient.Environments(pkg.Spec.Environment.Namespace).Get(pkg.Spec.Environment.Name)
 	if errors.IsNotFound(err) {
 		updatePackage(pkgw.fissionClient, pkg,
 			fission.BuildStatusFailed, "Environment not existed", nil)
 		return
+	} else if err != nil {
+		updatePackage(pkgw.fissionClient, pkg,
+			fission.BuildStatusFailed, fmt.Sprintf("Error getting environment: %v", err), nil)
+		return
+	}
+
+	// Reuse the deployment archive of a package built from the same
+	// inputs, if there is one.
+	cacheKey := buildCacheKey(pkg, env)
+	if cached := pkgw.findCachedBuild(pkg, cacheKey); cached != nil {
+		log.Printf("Build cache hit for package %v: reusing deployment archive of package %v",
+			pkg.Metadata.Name, cached.Metadata.Name)
+		pkg.Status.BuildCacheKey = cacheKey
+		pkg.Status.BuildCacheHit = true
+		buildLogs := fmt.Sprintf("Build cache hit: reused deployment archive of package %v\n", cached.Metadata.Name)
+		blog.write(buildLogs)
+		pkgw.finishBuild(pkg, buildLogs, &fission.ArchiveUploadResponse{
+			ArchiveDownloadUrl: cached.Spec.Deployment.URL,
+			Checksum:           cached.Spec.Deployment.Checksum,
+		})
+		return
+	}
+
+	// Wait for a free build slot of the environment
+	queue := pkgw.scheduler.queue(env)
+	queued := false
+	err = queue.acquire(ctx, func(depth int) {
+		queued = true
+		msg := fmt.Sprintf("Waiting for a free build slot of environment %v, position %v in queue\n",
+			env.Metadata.Name, depth)
+		log.Printf("Package %v: %v", pkg.Metadata.Name, msg)
+		blog.write(msg)
+		pkg.Status.BuildQueueDepth = depth
+		p, err := updatePackage(pkgw.fissionClient, pkg, fission.BuildStatusRunning, msg, nil)
+		if err == nil {
+			pkg = p
+		}
+	})
+	if err != nil {
+		log.Printf("Build of package %v cancelled while queued", pkg.Metadata.Name)
+		return
+	}
+	defer queue.release()
+
+	if queued {
+		pkg.Status.BuildQueueDepth = 0
+		p, err := updatePackage(pkgw.fissionClient, pkg, fission.BuildStatusRunning, "", nil)
+		if err == nil {
+			pkg = p
+		}
 	}
 
-	// Do health check for environment builder pod
-	for i := 0; i < 15; i++ {
+	timeout := buildTimeout(env)
+	buildCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Do health check for environment builder pod, until the build is
+	// cancelled or times out
+	for i := 0; i < 15 && buildCtx.Err() == nil; i++ {
 		// Informer store is not able to use label to find the pod,
 		// iterate all available environment builders.
 		items := pkgw.podStore.List()
-		if err != nil {
-			log.Printf("Error retrieving pod information for env %v: %v", err, env.Metadata.Name)
-			return
-		}
-
 		if len(items) == 0 {
 			log.Printf("Environment \"%v\" builder pod is not existed yet, retry again later.", pkg.Spec.Environment.Name)
-			time.Sleep(time.Duration(i*1) * time.Second)
+			sleepWithContext(buildCtx, time.Duration(i*1)*time.Second)
 			continue
 		}
 
@@ -146,7 +193,7 @@ func (pkgw *packageWatcher) build(buildCache *cache.Cache, srcpkg *crd.Package)
 
 			if !podIsReady {
 				log.Printf("Environment \"%v\" builder pod is not ready, retry again later.", pkg.Spec.Environment.Name)
-				time.Sleep(time.Duration(i*1) * time.Second)
+				sleepWithContext(buildCtx, time.Duration(i*1)*time.Second)
 				break
 			}
 
@@ -161,56 +208,52 @@ func (pkgw *packageWatcher) build(buildCache *cache.Cache, srcpkg *crd.Package)
 				log.Printf("Setup rolebinding for sa : %s.%s for pkg : %s.%s", fission.FissionBuilderSA, builderNs, pkg.Metadata.Name, pkg.Metadata.Namespace)
 			}
 
-			ctx := context.Background()
-			uploadResp, buildLogs, err := buildPackage(ctx, pkgw.fissionClient, builderNs, pkgw.storageSvcUrl, pkg)
-			if err != nil {
-				log.Printf("Error building package %v: %v", pkg.Metadata.Name, err)
-				updatePackage(pkgw.fissionClient, pkg, fission.BuildStatusFailed, buildLogs, nil)
-				return
+			// The fetcher reads the credentials of a private git source and the
+			// build secrets and configmaps of the package
+			if (pkg.Spec.Source.Git != nil && len(pkg.Spec.Source.Git.Secret) > 0) ||
+				len(pkg.Spec.BuildSecrets) > 0 || len(pkg.Spec.BuildConfigMaps) > 0 {
+				err := fission.SetupRoleBinding(pkgw.k8sClient, fission.SecretConfigMapGetterRB, pkg.Metadata.Namespace, fission.SecretConfigMapGetterCR, fission.ClusterRole, fission.FissionBuilderSA, builderNs)
+				if err != nil {
+					log.Printf("Error : %v in setting up the role binding %s for pkg : %s.%s", err, fission.SecretConfigMapGetterRB, pkg.Metadata.Name, pkg.Metadata.Namespace)
+					continue
+				}
 			}
 
-			log.Printf("Start updating info of package: %v", pkg.Metadata.Name)
+			uploadResp, buildLogs, buildErr := buildPackage(buildCtx, pkgw.fissionClient, builderNs, pkgw.storageSvcUrl, pkg, blog.write)
 
-			fnList, err := pkgw.fissionClient.
-				Functions(metav1.NamespaceAll).List(metav1.ListOptions{})
-			if err != nil {
-				e := fmt.Sprintf("Error getting function list: %v", err)
-				log.Println(e)
-				buildLogs += fmt.Sprintf("%v\n", e)
-				updatePackage(pkgw.fissionClient, pkg, fission.BuildStatusFailed, buildLogs, nil)
+			if ctx.Err() != nil {
+				log.Printf("Build of package %v cancelled", pkg.Metadata.Name)
+				return
 			}
-
-			// A package may be used by multiple functions. Update
-			// functions with old package resource version
-			for _, fn := range fnList.Items {
-				if fn.Spec.Package.PackageRef.Name == pkg.Metadata.Name &&
-					fn.Spec.Package.PackageRef.Namespace == pkg.Metadata.Namespace &&
-					fn.Spec.Package.PackageRef.ResourceVersion != pkg.Metadata.ResourceVersion {
-					fn.Spec.Package.PackageRef.ResourceVersion = pkg.Metadata.ResourceVersion
-					// update CRD
-					_, err = pkgw.fissionClient.Functions(fn.Metadata.Namespace).Update(&fn)
-					if err != nil {
-						e := fmt.Sprintf("Error updating function package resource version: %v", err)
-						log.Println(e)
-						buildLogs += fmt.Sprintf("%v\n", e)
-						updatePackage(pkgw.fissionClient, pkg, fission.BuildStatusFailed, buildLogs, nil)
-						return
-					}
-				}
+			if buildErr != nil && buildCtx.Err() == context.DeadlineExceeded {
+				msg := fmt.Sprintf("Build timed out after %v\n", timeout)
+				blog.write(msg)
+				buildLogs += msg
 			}
 
-			_, err = updatePackage(pkgw.fissionClient, pkg,
-				fission.BuildStatusSucceeded, buildLogs, uploadResp)
+			// Keep logs too long for the package status in the storage service
+			pkg.Status.BuildLogUrl, err = storeBuildLogs(context.Background(), pkgw.storageSvcUrl, pkg, buildLogs, blog)
 			if err != nil {
-				log.Printf("Error update package info: %v", err)
+				log.Printf("Error storing build logs of package %v: %v", pkg.Metadata.Name, err)
+			}
+
+			if buildErr != nil {
+				log.Printf("Error building package %v: %v", pkg.Metadata.Name, buildErr)
 				updatePackage(pkgw.fissionClient, pkg, fission.BuildStatusFailed, buildLogs, nil)
 				return
 			}
 
-			log.Printf("Completed build request for package: %v", pkg.Metadata.Name)
+			pkg.Status.BuildCacheKey = cacheKey
+			pkgw.finishBuild(pkg, buildLogs, uploadResp)
 			return
 		}
 	}
+	// A superseded or deleted package isn't marked as failed
+	if ctx.Err() != nil {
+		log.Printf("Build of package %v cancelled while waiting for the environment builder", pkg.Metadata.Name)
+		return
+	}
+
 	// build timeout
 	updatePackage(pkgw.fissionClient, pkg,
 		fission.BuildStatusFailed, "Build timeout due to environment builder not ready", nil)
@@ -221,18 +264,126 @@ func (pkgw *packageWatcher) build(buildCache *cache.Cache, srcpkg *crd.Package)
 	return
 }
 
+// sleepWithContext waits for the duration, or until the context is done.
+func sleepWithContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
+
+// finishBuild points the functions using the package at its new resource
+// version and marks the package as succeeded with the given deployment
+// archive.
+func (pkgw *packageWatcher) finishBuild(pkg *crd.Package, buildLogs string,
+	uploadResp *fission.ArchiveUploadResponse) {
+
+	log.Printf("Start updating info of package: %v", pkg.Metadata.Name)
+
+	err := pkgw.updateFunctions(pkg)
+	if err != nil {
+		e := err.Error()
+		log.Println(e)
+		buildLogs += fmt.Sprintf("%v\n", e)
+		updatePackage(pkgw.fissionClient, pkg, fission.BuildStatusFailed, buildLogs, nil)
+		return
+	}
+
+	_, err = updatePackage(pkgw.fissionClient, pkg,
+		fission.BuildStatusSucceeded, buildLogs, uploadResp)
+	if err != nil {
+		log.Printf("Error update package info: %v", err)
+		updatePackage(pkgw.fissionClient, pkg, fission.BuildStatusFailed, buildLogs, nil)
+		return
+	}
+
+	log.Printf("Completed build request for package: %v", pkg.Metadata.Name)
+}
+
+// updateFunctions points the functions using the package at its resource
+// version. A package may be used by multiple functions.
+func (pkgw *packageWatcher) updateFunctions(pkg *crd.Package) error {
+	fnList, err := pkgw.fissionClient.
+		Functions(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Error getting function list: %v", err)
+	}
+
+	for i := range fnList.Items {
+		fn := &fnList.Items[i]
+		if fn.Spec.Package.PackageRef.Name != pkg.Metadata.Name ||
+			fn.Spec.Package.PackageRef.Namespace != pkg.Metadata.Namespace {
+			continue
+		}
+
+		// Functions updated in the meantime are read again
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			if fn.Spec.Package.PackageRef.ResourceVersion == pkg.Metadata.ResourceVersion {
+				return nil
+			}
+			fn.Spec.Package.PackageRef.ResourceVersion = pkg.Metadata.ResourceVersion
+			_, err := pkgw.fissionClient.Functions(fn.Metadata.Namespace).Update(fn)
+			if errors.IsConflict(err) {
+				latest, getErr := pkgw.fissionClient.Functions(fn.Metadata.Namespace).Get(fn.Metadata.Name)
+				if getErr != nil {
+					return getErr
+				}
+				fn = latest
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error updating function package resource version: %v", err)
+		}
+	}
+	return nil
+}
+
+// startBuild starts a build of a pending package, cancelling any build of
+// an older version of it. Duplicate events of a version being built are
+// ignored.
+func (pkgw *packageWatcher) startBuild(pkg *crd.Package) {
+	if pkg.Status.BuildStatus != fission.BuildStatusPending {
+		return
+	}
+
+	m := pkg.Metadata
+	ctx, ok := pkgw.scheduler.start(&m)
+	if !ok {
+		return
+	}
+
+	go func() {
+		defer pkgw.scheduler.finish(&m)
+		pkgw.build(ctx, pkg)
+	}()
+}
+
 func (pkgw *packageWatcher) watchPackages(fissionClient *crd.FissionClient,
 	kubernetesClient *kubernetes.Clientset, builderNamespace string) {
-	buildCache := cache.MakeCache(0, 0)
 	lw := k8sCache.NewListWatchFromClient(pkgw.fissionClient.GetCrdClient(), "packages", apiv1.NamespaceAll, fields.Everything())
 	pkgStore, controller := k8sCache.NewInformer(lw, &crd.Package{}, 60*time.Second, k8sCache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			pkg := obj.(*crd.Package)
-			go pkgw.build(buildCache, pkg)
+			pkgw.startBuild(pkg)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			pkg := newObj.(*crd.Package)
-			go pkgw.build(buildCache, pkg)
+			pkgw.startBuild(pkg)
+		},
+		DeleteFunc: func(obj interface{}) {
+			pkg, ok := obj.(*crd.Package)
+			if !ok {
+				tombstone, ok := obj.(k8sCache.DeletedFinalStateUnknown)
+				if !ok {
+					return
+				}
+				pkg, ok = tombstone.Obj.(*crd.Package)
+				if !ok {
+					return
+				}
+			}
+			pkgw.scheduler.cancel(&pkg.Metadata)
 		},
 	})
 	pkgw.pkgStore = pkgStore
@@ -0,0 +1,180 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// The number of successful builds kept in the revision history of a
+// package. The storage service doesn't prune their archives.
+const maxPackageRevisions = 10
+
+// addPackageRevision records the deployment archive of the package as a new
+// revision, dropping the oldest revisions over maxPackageRevisions.
+func addPackageRevision(pkg *crd.Package) {
+	revision := pkg.Status.Revision
+	for _, r := range pkg.Status.Revisions {
+		if r.Revision > revision {
+			revision = r.Revision
+		}
+	}
+	revision++
+
+	var srcChecksum fission.Checksum
+	if sum := sourceChecksum(pkg); len(sum) > 0 {
+		srcChecksum = fission.Checksum{
+			Type: fission.ChecksumTypeSHA256,
+			Sum:  sum,
+		}
+	}
+
+	revisions := []fission.PackageRevision{{
+		Revision:           revision,
+		SourceChecksum:     srcChecksum,
+		SourceCommit:       pkg.Status.SourceCommit,
+		DeploymentUrl:      pkg.Spec.Deployment.URL,
+		DeploymentChecksum: pkg.Spec.Deployment.Checksum,
+		BuildLogUrl:        pkg.Status.BuildLogUrl,
+		Timestamp:          metav1.Now(),
+	}}
+	revisions = append(revisions, pkg.Status.Revisions...)
+	if len(revisions) > maxPackageRevisions {
+		revisions = revisions[:maxPackageRevisions]
+	}
+
+	pkg.Status.Revision = revision
+	pkg.Status.Revisions = revisions
+}
+
+// rollback points the package at the deployment archive of one of its
+// revisions, by default the one before the current one, and the functions
+// using the package at the new package version. The package is updated at
+// the resource version it was read at, so that a rollback racing with a
+// build or an update of the package fails instead of overwriting it.
+func (pkgw *packageWatcher) rollback(m *metav1.ObjectMeta, revision int) (*crd.Package, error) {
+	pkg, err := pkgw.fissionClient.Packages(m.Namespace).Get(m.Name)
+	if errors.IsNotFound(err) {
+		return nil, fission.MakeError(fission.ErrorNotFound, fmt.Sprintf("Package %v not found", m.Name))
+	} else if err != nil {
+		return nil, err
+	}
+
+	if pkg.Status.BuildStatus == fission.BuildStatusPending || pkg.Status.BuildStatus == fission.BuildStatusRunning {
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Package %v is being built, wait for the build to finish before rolling back", m.Name))
+	}
+
+	var target *fission.PackageRevision
+	for i, rev := range pkg.Status.Revisions {
+		if (revision > 0 && rev.Revision == revision) ||
+			(revision == 0 && (pkg.Status.Revision == 0 || rev.Revision < pkg.Status.Revision)) {
+			target = &pkg.Status.Revisions[i]
+			break
+		}
+	}
+	if target == nil {
+		if revision > 0 {
+			return nil, fission.MakeError(fission.ErrorNotFound,
+				fmt.Sprintf("Revision %v of package %v not found", revision, m.Name))
+		}
+		return nil, fission.MakeError(fission.ErrorNotFound,
+			fmt.Sprintf("Package %v has no previous revision", m.Name))
+	}
+
+	// The source stays as it is, so that a rebuild builds the latest source
+	pkg.Spec.Deployment = fission.Archive{
+		Type:     fission.ArchiveTypeUrl,
+		URL:      target.DeploymentUrl,
+		Checksum: target.DeploymentChecksum,
+	}
+	pkg.Status = fission.PackageStatus{
+		BuildStatus:  fission.BuildStatusSucceeded,
+		BuildLog:     fmt.Sprintf("Rolled back to revision %v\n", target.Revision),
+		BuildLogUrl:  target.BuildLogUrl,
+		SourceCommit: target.SourceCommit,
+		Revision:     target.Revision,
+		Revisions:    pkg.Status.Revisions,
+	}
+
+	pkg, err = pkgw.fissionClient.Packages(m.Namespace).Update(pkg)
+	if errors.IsConflict(err) {
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Package %v changed while rolling back, try again", m.Name))
+	} else if err != nil {
+		return nil, err
+	}
+	log.Printf("Rolled back package %v to revision %v", m.Name, pkg.Status.Revision)
+
+	err = pkgw.updateFunctions(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// rollbackHandler rolls a package back and responds with the package.
+func (pkgw *packageWatcher) rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	m := &metav1.ObjectMeta{
+		Name:      query.Get("name"),
+		Namespace: query.Get("namespace"),
+	}
+	if len(m.Name) == 0 {
+		http.Error(w, "Package name is required", http.StatusBadRequest)
+		return
+	}
+	if len(m.Namespace) == 0 {
+		m.Namespace = metav1.NamespaceDefault
+	}
+
+	var revision int
+	if s := query.Get("revision"); len(s) > 0 {
+		var err error
+		revision, err = strconv.Atoi(s)
+		if err != nil || revision < 0 {
+			http.Error(w, fmt.Sprintf("Invalid revision %v", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	pkg, err := pkgw.rollback(m, revision)
+	if err != nil {
+		log.Printf("Error rolling back package %v: %v", m.Name, err)
+		code, msg := fission.GetHTTPError(err)
+		http.Error(w, msg, code)
+		return
+	}
+
+	resp, err := json.Marshal(pkg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
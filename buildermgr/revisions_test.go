@@ -0,0 +1,149 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildermgr
+
+import (
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/crd/fake"
+)
+
+const testPackagePath = "/apis/fission.io/v1/namespaces/default/packages/pkg"
+
+func testRevisions(revisions ...int) []fission.PackageRevision {
+	var revs []fission.PackageRevision
+	for _, r := range revisions {
+		revs = append(revs, fission.PackageRevision{
+			Revision:      r,
+			DeploymentUrl: fmt.Sprintf("http://storagesvc/v1/archive?id=deploy-%v", r),
+			BuildLogUrl:   fmt.Sprintf("http://storagesvc/v1/archive?id=log-%v", r),
+		})
+	}
+	return revs
+}
+
+func TestAddPackageRevision(t *testing.T) {
+	pkg := testSourcePackage("default", "pkg", "source")
+	pkg.Spec.Deployment = fission.Archive{Type: fission.ArchiveTypeUrl, URL: "http://storagesvc/v1/archive?id=deploy-new"}
+
+	addPackageRevision(pkg)
+	if pkg.Status.Revision != 1 || len(pkg.Status.Revisions) != 1 ||
+		pkg.Status.Revisions[0].DeploymentUrl != pkg.Spec.Deployment.URL ||
+		pkg.Status.Revisions[0].SourceChecksum.Sum != sourceChecksum(pkg) {
+		t.Fatalf("expected first revision of package, got %+v", pkg.Status)
+	}
+
+	// Revisions continue after the latest one, even after a rollback to
+	// an older one, and only the newest are kept
+	pkg.Status.Revision = 3
+	pkg.Status.Revisions = testRevisions(12, 11, 10, 9, 8, 7, 6, 5, 4, 3)
+	addPackageRevision(pkg)
+	if pkg.Status.Revision != 13 || len(pkg.Status.Revisions) != maxPackageRevisions {
+		t.Fatalf("expected %v revisions up to revision 13, got %v %+v", maxPackageRevisions, pkg.Status.Revision, pkg.Status.Revisions)
+	}
+	for i, rev := range pkg.Status.Revisions {
+		if rev.Revision != 13-i {
+			t.Fatalf("expected revision %v at %v, got %v", 13-i, i, rev.Revision)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	server := fake.NewAPIServer()
+	defer server.Close()
+	fissionClient, _ := server.Clients()
+	pkgw := &packageWatcher{fissionClient: fissionClient}
+	m := &metav1.ObjectMeta{Name: "pkg", Namespace: "default"}
+
+	addPackage := func(status fission.PackageStatus) {
+		pkg := testSourcePackage("default", "pkg", "source")
+		pkg.Status = status
+		server.Add(testPackagePath, pkg)
+	}
+
+	fn := &crd.Function{}
+	fn.Spec.Package.PackageRef = fission.PackageRef{Name: "pkg", Namespace: "default"}
+	server.Add("/apis/fission.io/v1/namespaces/default/functions/hello", fn)
+
+	for _, v := range []struct {
+		name     string
+		status   fission.PackageStatus
+		revision int
+		expected int
+	}{
+		{"previous revision", fission.PackageStatus{Revision: 5, Revisions: testRevisions(5, 4, 3)}, 0, 4},
+		{"revision before rolled back one", fission.PackageStatus{Revision: 3, Revisions: testRevisions(5, 4, 3, 2, 1)}, 0, 2},
+		{"latest revision without current one", fission.PackageStatus{Revisions: testRevisions(2, 1)}, 0, 2},
+		{"given revision", fission.PackageStatus{Revision: 3, Revisions: testRevisions(5, 4, 3, 2, 1)}, 5, 5},
+	} {
+		addPackage(v.status)
+		pkg, err := pkgw.rollback(m, v.revision)
+		if err != nil {
+			t.Fatalf("%v: error rolling back: %v", v.name, err)
+		}
+		expectedUrl := fmt.Sprintf("http://storagesvc/v1/archive?id=deploy-%v", v.expected)
+		if pkg.Status.Revision != v.expected || pkg.Spec.Deployment.URL != expectedUrl ||
+			pkg.Status.BuildStatus != fission.BuildStatusSucceeded || len(pkg.Status.Revisions) != len(v.status.Revisions) {
+			t.Fatalf("%v: expected rollback to revision %v, got %v %+v", v.name, v.expected, pkg.Spec.Deployment.URL, pkg.Status)
+		}
+
+		var stored crd.Package
+		server.Get(testPackagePath, &stored)
+		if stored.Status.Revision != v.expected || stored.Spec.Source.Literal == nil {
+			t.Fatalf("%v: expected stored package at revision %v with its source, got %+v", v.name, v.expected, stored)
+		}
+		var updatedFn crd.Function
+		server.Get("/apis/fission.io/v1/namespaces/default/functions/hello", &updatedFn)
+		if updatedFn.Spec.Package.PackageRef.ResourceVersion != stored.Metadata.ResourceVersion {
+			t.Fatalf("%v: expected function to use rolled back package", v.name)
+		}
+	}
+
+	for _, v := range []struct {
+		name     string
+		status   fission.PackageStatus
+		revision int
+		code     int
+	}{
+		{"unknown revision", fission.PackageStatus{Revision: 5, Revisions: testRevisions(5, 4, 3)}, 9, fission.ErrorNotFound},
+		{"no previous revision", fission.PackageStatus{Revision: 1, Revisions: testRevisions(1)}, 0, fission.ErrorNotFound},
+		{"no revisions", fission.PackageStatus{}, 0, fission.ErrorNotFound},
+		{"pending build", fission.PackageStatus{BuildStatus: fission.BuildStatusPending, Revision: 5, Revisions: testRevisions(5, 4)}, 4, fission.ErrorInvalidArgument},
+		{"running build", fission.PackageStatus{BuildStatus: fission.BuildStatusRunning, Revision: 5, Revisions: testRevisions(5, 4)}, 0, fission.ErrorInvalidArgument},
+	} {
+		addPackage(v.status)
+		_, err := pkgw.rollback(m, v.revision)
+		fe, ok := err.(fission.Error)
+		if !ok || int(fe.Code) != v.code {
+			t.Fatalf("%v: expected rollback to fail with code %v, got %v", v.name, v.code, err)
+		}
+		var stored crd.Package
+		server.Get(testPackagePath, &stored)
+		if stored.Status.Revision != v.status.Revision {
+			t.Fatalf("%v: expected package not to change, got revision %v", v.name, stored.Status.Revision)
+		}
+	}
+
+	if _, err := pkgw.rollback(&metav1.ObjectMeta{Name: "missing", Namespace: "default"}, 0); err == nil {
+		t.Fatalf("expected rolling back missing package to fail")
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/satori/go.uuid"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func UrlForFunction(name, namespace string) string {
@@ -115,6 +116,50 @@ func MergePodSpecs(specs ...*apiv1.PodSpec) apiv1.PodSpec {
 	return *result
 }
 
+// RuntimeLifecycle returns the lifecycle of the runtime container of an
+// environment. Runtimes of v3 environments are drained before the pod
+// stops; earlier ones can't be told to, so the container just waits for
+// the grace period to let requests finish.
+func RuntimeLifecycle(envVersion int, gracePeriodSeconds int64) *apiv1.Lifecycle {
+	if envVersion >= 3 {
+		return &apiv1.Lifecycle{
+			PreStop: &apiv1.Handler{
+				HTTPGet: &apiv1.HTTPGetAction{
+					Path: EnvDrainPath,
+					Port: intstr.FromInt(8888),
+				},
+			},
+		}
+	}
+	return &apiv1.Lifecycle{
+		PreStop: &apiv1.Handler{
+			Exec: &apiv1.ExecAction{
+				Command: []string{
+					"/bin/sleep",
+					fmt.Sprintf("%v", gracePeriodSeconds),
+				},
+			},
+		},
+	}
+}
+
+// RuntimeReadinessProbe returns the readiness probe of the runtime
+// container of a v3 environment, or nil for earlier environments.
+func RuntimeReadinessProbe(envVersion int) *apiv1.Probe {
+	if envVersion < 3 {
+		return nil
+	}
+	return &apiv1.Probe{
+		PeriodSeconds: 1,
+		Handler: apiv1.Handler{
+			HTTPGet: &apiv1.HTTPGetAction{
+				Path: EnvReadinessPath,
+				Port: intstr.FromInt(8888),
+			},
+		},
+	}
+}
+
 // IsNetworkDialError returns true if its a network dial error
 func IsNetworkDialError(err error) bool {
 	netErr, ok := err.(net.Error)
@@ -33,6 +33,7 @@ import (
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
 	"github.com/fission/fission/fission/logdb"
+	storageSvcClient "github.com/fission/fission/storagesvc/client"
 )
 
 var podNamespace string
@@ -49,8 +50,11 @@ type (
 		fissionClient     *crd.FissionClient
 		kubernetesClient  *kubernetes.Clientset
 		storageServiceUrl string
+		archiveUrlSigner  *storageSvcClient.ArchiveUrlSigner
 		builderManagerUrl string
+		executorUrl       string
 		workflowApiUrl    string
+		gitWebhookSecret  string
 		functionNamespace string
 		useIstio          bool
 		featureStatus     map[string]string
@@ -72,6 +76,7 @@ func MakeAPI(featureStatus map[string]string) (*API, error) {
 	} else {
 		api.storageServiceUrl = "http://storagesvc"
 	}
+	api.archiveUrlSigner = storageSvcClient.MakeArchiveUrlSigner(api.storageServiceUrl, os.Getenv("ARCHIVE_URL_ISSUER_TOKEN"))
 
 	u = os.Getenv("BUILDER_MANAGER_URL")
 	if len(u) > 0 {
@@ -87,6 +92,13 @@ func MakeAPI(featureStatus map[string]string) (*API, error) {
 		api.workflowApiUrl = "http://workflows-apiserver"
 	}
 
+	u = os.Getenv("EXECUTOR_URL")
+	if len(u) > 0 {
+		api.executorUrl = strings.TrimSuffix(u, "/")
+	} else {
+		api.executorUrl = "http://executor"
+	}
+
 	fnNs := os.Getenv("FISSION_FUNCTION_NAMESPACE")
 	if len(fnNs) > 0 {
 		api.functionNamespace = fnNs
@@ -94,6 +106,9 @@ func MakeAPI(featureStatus map[string]string) (*API, error) {
 		api.functionNamespace = "fission-function"
 	}
 
+	// Shared secret of the git push webhooks, see GitWebhookApiPush
+	api.gitWebhookSecret = os.Getenv("GIT_WEBHOOK_SECRET")
+
 	api.featureStatus = featureStatus
 
 	return api, err
@@ -204,6 +219,8 @@ func (api *API) Serve(port int) {
 	r.HandleFunc("/v2/packages/{package}", api.PackageApiUpdate).Methods("PUT")
 	r.HandleFunc("/v2/packages/{package}", api.PackageApiDelete).Methods("DELETE")
 
+	r.HandleFunc("/v2/webhooks/git", api.GitWebhookApiPush).Methods("POST")
+
 	r.HandleFunc("/v2/functions", api.FunctionApiList).Methods("GET")
 	r.HandleFunc("/v2/functions", api.FunctionApiCreate).Methods("POST")
 	r.HandleFunc("/v2/functions/{function}", api.FunctionApiGet).Methods("GET")
@@ -263,9 +280,12 @@ func (api *API) Serve(port int) {
 	r.HandleFunc("/v2/canaryconfigs", api.CanaryConfigApiList).Methods("GET")
 
 	r.HandleFunc("/proxy/{dbType}", api.FunctionLogsApiPost).Methods("POST")
-	r.HandleFunc("/proxy/storage/v1/archive", api.StorageServiceProxy)
+	r.HandleFunc("/proxy/storage/v1/{path:archive|archives|metadata|usage|uploads|uploads/finalize}", api.StorageServiceProxy)
 	r.HandleFunc("/proxy/logs/{function}", api.FunctionPodLogs).Methods("POST")
 	r.HandleFunc("/proxy/workflows-apiserver/{path:.*}", api.WorkflowApiserverProxy)
+	r.HandleFunc("/proxy/executor/{path:"+executorProxyPaths+"}", api.ExecutorProxy)
+	r.HandleFunc("/proxy/buildermgr/{path:buildLogs}", api.BuilderMgrProxy).Methods("GET")
+	r.HandleFunc("/proxy/buildermgr/{path:rollback}", api.BuilderMgrProxy).Methods("POST")
 	r.HandleFunc("/proxy/svcname", api.GetSvcName).Queries("application", "").Methods("GET")
 
 	address := fmt.Sprintf(":%v", port)
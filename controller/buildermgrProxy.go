@@ -0,0 +1,55 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// BuilderMgrProxy proxies the build logs and rollback APIs of the builder
+// manager, so that the CLI can get and follow the build output of packages
+// and roll them back.
+func (api *API) BuilderMgrProxy(w http.ResponseWriter, r *http.Request) {
+	u := api.builderManagerUrl
+	builderMgrUrl, err := url.Parse(u)
+	if err != nil {
+		msg := fmt.Sprintf("Error parsing url %v: %v", u, err)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+	path := fmt.Sprintf("/v2/%s", vars["path"])
+	director := func(req *http.Request) {
+		req.URL.Scheme = builderMgrUrl.Scheme
+		req.URL.Host = builderMgrUrl.Host
+		req.URL.Path = path
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: director,
+		// Flush periodically so that followed build logs show up as
+		// they are produced.
+		FlushInterval: 100 * time.Millisecond,
+	}
+	proxy.ServeHTTP(w, r)
+}
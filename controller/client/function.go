@@ -21,9 +21,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
 	fv1 "github.com/fission/fission/pkg/apis/fission.io/v1"
 )
@@ -155,3 +157,72 @@ func (c *Client) FunctionList(functionNamespace string) ([]crd.Function, error)
 
 	return funcs, nil
 }
+
+// FunctionServiceList lists the function services of the executor for the
+// function, or for all functions in the namespace if the name is empty.
+func (c *Client) FunctionServiceList(m *metav1.ObjectMeta) ([]fission.FunctionServiceInfo, error) {
+	query := url.Values{}
+	query.Set("namespace", m.Namespace)
+	query.Set("name", m.Name)
+
+	resp, err := http.Get(c.executorProxyUrl("functionServices") + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	fsvcs := make([]fission.FunctionServiceInfo, 0)
+	err = json.Unmarshal(body, &fsvcs)
+	if err != nil {
+		return nil, err
+	}
+
+	return fsvcs, nil
+}
+
+// FunctionEvict removes the function services of the function.
+func (c *Client) FunctionEvict(m *metav1.ObjectMeta) error {
+	reqbody, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(c.executorProxyUrl("evict"), "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = c.handleResponse(resp)
+	return err
+}
+
+// FunctionWarm specializes a function service for the function and returns
+// its address.
+func (c *Client) FunctionWarm(m *metav1.ObjectMeta) (string, error) {
+	reqbody, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Post(c.executorProxyUrl("warm"), "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func (c *Client) executorProxyUrl(relativeUrl string) string {
+	return c.Url + "/proxy/executor/" + relativeUrl
+}
@@ -20,10 +20,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
 	fv1 "github.com/fission/fission/pkg/apis/fission.io/v1"
 )
@@ -141,3 +144,53 @@ func (c *Client) PackageList(pkgNamespace string) ([]crd.Package, error) {
 
 	return funcs, nil
 }
+
+// PackageBuildLogs returns the build log of the package. With follow set,
+// the output of a pending or running build is streamed until the build is
+// done. The caller must close the returned reader.
+func (c *Client) PackageBuildLogs(m *metav1.ObjectMeta, follow bool) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("namespace", m.Namespace)
+	query.Set("name", m.Name)
+	if follow {
+		query.Set("follow", "true")
+	}
+
+	resp, err := http.Get(c.Url + "/proxy/buildermgr/buildLogs?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fission.MakeErrorFromHTTP(resp)
+	}
+	return resp.Body, nil
+}
+
+// PackageRollback rolls the package back to the given revision, or to the
+// one before the current one if revision is 0, and returns the package.
+func (c *Client) PackageRollback(m *metav1.ObjectMeta, revision int) (*crd.Package, error) {
+	query := url.Values{}
+	query.Set("namespace", m.Namespace)
+	query.Set("name", m.Name)
+	if revision > 0 {
+		query.Set("revision", fmt.Sprintf("%v", revision))
+	}
+
+	resp, err := http.Post(c.Url+"/proxy/buildermgr/rollback?"+query.Encode(), "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg crd.Package
+	err = json.Unmarshal(body, &pkg)
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestExecutorProxy(t *testing.T) {
+	var executorRequests []string
+	executor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		executorRequests = append(executorRequests, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+	}))
+	defer executor.Close()
+
+	api := &API{executorUrl: executor.URL}
+	r := mux.NewRouter()
+	r.HandleFunc("/proxy/executor/{path:"+executorProxyPaths+"}", api.ExecutorProxy)
+	controller := httptest.NewServer(r)
+	defer controller.Close()
+
+	for _, v := range []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{"GET", "/proxy/executor/functionServices?namespace=default", "GET /v2/functionServices?namespace=default {}"},
+		{"POST", "/proxy/executor/evict", "POST /v2/evict {}"},
+		{"POST", "/proxy/executor/warm", "POST /v2/warm {}"},
+
+		// The API the router uses to get and tap function services
+		// isn't proxied
+		{"POST", "/proxy/executor/getServiceForFunction", ""},
+		{"POST", "/proxy/executor/tapService", ""},
+		{"POST", "/proxy/executor/evict/../getServiceForFunction", ""},
+	} {
+		executorRequests = nil
+		req, _ := http.NewRequest(v.method, controller.URL+v.path, strings.NewReader("{}"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error sending request to %v: %v", v.path, err)
+		}
+		resp.Body.Close()
+
+		if len(v.expected) == 0 {
+			if len(executorRequests) != 0 {
+				t.Fatalf("expected %v not to be proxied, got %v", v.path, executorRequests)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK || len(executorRequests) != 1 || executorRequests[0] != v.expected {
+			t.Fatalf("expected %v to be proxied as %q, got %v %v", v.path, v.expected, resp.StatusCode, executorRequests)
+		}
+	}
+}
@@ -84,6 +84,12 @@ func (a *API) FunctionApiCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = a.validateFunctionPackage(&f)
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+
 	fnew, err := a.fissionClient.Functions(f.Metadata.Namespace).Create(&f)
 	if err != nil {
 		a.respondWithError(w, err)
@@ -100,6 +106,24 @@ func (a *API) FunctionApiCreate(w http.ResponseWriter, r *http.Request) {
 	a.respondWithSuccess(w, resp)
 }
 
+// validateFunctionPackage rejects functions whose executor can't run the
+// package they reference.
+func (a *API) validateFunctionPackage(f *crd.Function) error {
+	if f.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType != fission.ExecutorTypeContainer {
+		return nil
+	}
+	pkgRef := f.Spec.Package.PackageRef
+	pkg, err := a.fissionClient.Packages(pkgRef.Namespace).Get(pkgRef.Name)
+	if err != nil {
+		return err
+	}
+	err = f.Spec.ValidatePackage(pkg.Spec)
+	if err != nil {
+		return fission.MakeError(fission.ErrorInvalidArgument, err.Error())
+	}
+	return nil
+}
+
 func (a *API) FunctionApiGet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["function"]
@@ -145,6 +169,12 @@ func (a *API) FunctionApiUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = a.validateFunctionPackage(&f)
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+
 	fnew, err := a.fissionClient.Functions(f.Metadata.Namespace).Update(&f)
 	if err != nil {
 		a.respondWithError(w, err)
@@ -0,0 +1,235 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+)
+
+type (
+	// gitRepository holds the URLs of a repository in the push event
+	// payloads of GitHub and GitLab.
+	gitRepository struct {
+		// GitHub
+		CloneUrl string `json:"clone_url"`
+		SshUrl   string `json:"ssh_url"`
+		HtmlUrl  string `json:"html_url"`
+
+		// GitLab
+		GitHttpUrl string `json:"git_http_url"`
+		GitSshUrl  string `json:"git_ssh_url"`
+		WebUrl     string `json:"web_url"`
+
+		DefaultBranch string `json:"default_branch"`
+	}
+
+	gitPushEvent struct {
+		Ref        string        `json:"ref"`
+		After      string        `json:"after"`
+		Repository gitRepository `json:"repository"`
+		Project    gitRepository `json:"project"`
+	}
+)
+
+// A push deleting a ref has an all-zero commit.
+const gitZeroCommit = "0000000000000000000000000000000000000000"
+
+func (e *gitPushEvent) urls() []string {
+	var urls []string
+	for _, repo := range []gitRepository{e.Repository, e.Project} {
+		for _, u := range []string{repo.CloneUrl, repo.SshUrl, repo.HtmlUrl, repo.GitHttpUrl, repo.GitSshUrl, repo.WebUrl} {
+			if len(u) > 0 {
+				urls = append(urls, normalizeGitUrl(u))
+			}
+		}
+	}
+	return urls
+}
+
+func (e *gitPushEvent) defaultBranch() string {
+	if len(e.Repository.DefaultBranch) > 0 {
+		return e.Repository.DefaultBranch
+	}
+	return e.Project.DefaultBranch
+}
+
+// matchesRef returns whether the push updated the ref a package is built
+// from. Packages without a ref are built from the default branch; packages
+// pinned to a commit are never rebuilt.
+func (e *gitPushEvent) matchesRef(ref string) bool {
+	if len(ref) == 0 {
+		ref = e.defaultBranch()
+	}
+	if len(ref) == 0 {
+		return false
+	}
+	return e.Ref == ref ||
+		e.Ref == "refs/heads/"+ref ||
+		e.Ref == "refs/tags/"+ref
+}
+
+// normalizeGitUrl reduces the HTTP(S), SSH and scp-like URLs of a
+// repository to the same "host/path" form.
+func normalizeGitUrl(u string) string {
+	u = strings.TrimSpace(u)
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	} else if i := strings.Index(u, ":"); i >= 0 {
+		// scp-like syntax, user@host:path
+		u = u[:i] + "/" + u[i+1:]
+	}
+	if i := strings.Index(u, "@"); i >= 0 && i < strings.Index(u+"/", "/") {
+		u = u[i+1:]
+	}
+	u = strings.TrimSuffix(strings.TrimSuffix(u, "/"), ".git")
+	return strings.ToLower(u)
+}
+
+// verifyGitWebhook checks the GitHub signature or GitLab token of a
+// webhook request against the configured secret.
+func (a *API) verifyGitWebhook(r *http.Request, body []byte) bool {
+
+	if token := r.Header.Get("X-Gitlab-Token"); len(token) > 0 {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(a.gitWebhookSecret)) == 1
+	}
+
+	var sig string
+	var newHash func() hash.Hash
+	if sig = r.Header.Get("X-Hub-Signature-256"); strings.HasPrefix(sig, "sha256=") {
+		newHash = sha256.New
+	} else if sig = r.Header.Get("X-Hub-Signature"); strings.HasPrefix(sig, "sha1=") {
+		newHash = sha1.New
+	} else {
+		return false
+	}
+
+	expected, err := hex.DecodeString(sig[strings.Index(sig, "=")+1:])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(a.gitWebhookSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// GitWebhookApiPush handles the push events of GitHub and GitLab webhooks,
+// rebuilding the packages whose git source is the pushed branch or tag.
+func (a *API) GitWebhookApiPush(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+
+	// Anyone who can reach the controller could trigger builds otherwise
+	if len(a.gitWebhookSecret) == 0 {
+		a.respondWithError(w, fission.MakeError(fission.ErrorNotAuthorized, "Git webhooks are disabled, GIT_WEBHOOK_SECRET is not set"))
+		return
+	}
+
+	if !a.verifyGitWebhook(r, body) {
+		a.respondWithError(w, fission.MakeError(fission.ErrorNotAuthorized, "Invalid webhook signature"))
+		return
+	}
+
+	// Acknowledge the other events, e.g. GitHub's ping, without doing anything
+	if event := r.Header.Get("X-GitHub-Event"); len(event) > 0 && event != "push" {
+		a.respondWithSuccess(w, []byte("[]"))
+		return
+	}
+
+	var push gitPushEvent
+	err = json.Unmarshal(body, &push)
+	if err != nil {
+		a.respondWithError(w, fission.MakeError(fission.ErrorInvalidArgument, "Error parsing push event: "+err.Error()))
+		return
+	}
+	if push.After == gitZeroCommit {
+		a.respondWithSuccess(w, []byte("[]"))
+		return
+	}
+
+	urls := push.urls()
+	if len(urls) == 0 {
+		a.respondWithError(w, fission.MakeError(fission.ErrorInvalidArgument, "Push event has no repository URL"))
+		return
+	}
+
+	ns := a.extractQueryParamFromRequest(r, "namespace")
+	if len(ns) == 0 {
+		ns = metav1.NamespaceAll
+	}
+	pkgs, err := a.fissionClient.Packages(ns).List(metav1.ListOptions{})
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+
+	rebuilt := make([]metav1.ObjectMeta, 0)
+	for _, pkg := range pkgs.Items {
+		src := pkg.Spec.Source.Git
+		if pkg.Spec.Source.Type != fission.ArchiveTypeGit || src == nil || !push.matchesRef(src.Ref) {
+			continue
+		}
+
+		matched := false
+		pkgUrl := normalizeGitUrl(src.URL)
+		for _, u := range urls {
+			matched = matched || u == pkgUrl
+		}
+		if !matched || (len(push.After) > 0 && push.After == pkg.Status.SourceCommit) {
+			continue
+		}
+
+		// Same as 'fission package rebuild'; the builder manager picks up pending packages
+		pkg.Status = fission.PackageStatus{
+			BuildStatus: fission.BuildStatusPending,
+			Revision:    pkg.Status.Revision,
+			Revisions:   pkg.Status.Revisions,
+		}
+		m, err := a.fissionClient.Packages(pkg.Metadata.Namespace).Update(&pkg)
+		if err != nil {
+			log.Errorf("Error rebuilding package %v.%v on push to %v: %v",
+				pkg.Metadata.Name, pkg.Metadata.Namespace, push.Ref, err)
+			continue
+		}
+		log.Infof("Rebuilding package %v.%v on push of %v to %v",
+			pkg.Metadata.Name, pkg.Metadata.Namespace, push.After, push.Ref)
+		rebuilt = append(rebuilt, m.Metadata)
+	}
+
+	resp, err := json.Marshal(rebuilt)
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+	a.respondWithSuccess(w, resp)
+}
@@ -22,6 +22,12 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/storagesvc"
 )
 
 func (api *API) StorageServiceProxy(w http.ResponseWriter, r *http.Request) {
@@ -33,13 +39,152 @@ func (api *API) StorageServiceProxy(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, msg, http.StatusInternalServerError)
 		return
 	}
+
+	// Downloads through the controller get a short lived signature, if
+	// the storage service needs signed URLs. They must be for a package
+	// using the archive.
+	path := mux.Vars(r)["path"]
+	if r.Method == http.MethodGet && path == "archive" && api.archiveUrlSigner.Enabled() {
+		err := api.authorizeArchiveDownload(r)
+		if err != nil {
+			api.respondWithError(w, err)
+			return
+		}
+		signed, err := api.archiveUrlSigner.Sign(r.Context(), u+"/v1/archive?"+r.URL.RawQuery, time.Minute)
+		if err != nil {
+			msg := fmt.Sprintf("Error signing download url: %v", err)
+			log.Println(msg)
+			http.Error(w, msg, http.StatusInternalServerError)
+			return
+		}
+		signedUrl, err := url.Parse(signed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		r.URL.RawQuery = signedUrl.RawQuery
+	}
+
+	// Uploads through the controller are charged to the namespace of the
+	// package they're for, which the storage service only takes from a
+	// grant
+	if isArchiveUpload(r.Method, path) && api.archiveUrlSigner.Enabled() {
+		r.Header.Del("X-Archive-" + storagesvc.MetadataGrant)
+		grant, err := api.archiveUrlSigner.UploadGrant(r.Context(),
+			r.Header.Get("X-Archive-"+storagesvc.MetadataNamespace), r.Header.Get("X-Archive-"+storagesvc.MetadataPackage), time.Minute)
+		if err != nil {
+			msg := fmt.Sprintf("Error granting upload: %v", err)
+			log.Println(msg)
+			http.Error(w, msg, http.StatusInternalServerError)
+			return
+		}
+		if len(grant) > 0 {
+			r.Header.Set("X-Archive-"+storagesvc.MetadataGrant, grant)
+		}
+	}
+
+	// Deletes through the controller must be for a package using the
+	// archive, or that it was uploaded for
+	if r.Method == http.MethodDelete && path == "archive" && api.archiveUrlSigner.Enabled() {
+		err := api.authorizeArchiveDelete(r)
+		if err != nil {
+			api.respondWithError(w, err)
+			return
+		}
+	}
+
+	// Only the requests of the CLI listing archives, showing their
+	// metadata and deleting them get the issuer token
+	if !isArchiveUpload(r.Method, path) {
+		r.Header.Del("Authorization")
+	}
+	if needsIssuerToken(r.Method, path) {
+		api.archiveUrlSigner.Authorize(r)
+	}
+
 	director := func(req *http.Request) {
 		req.URL.Scheme = ssUrl.Scheme
 		req.URL.Host = ssUrl.Host
-		req.URL.Path = "/v1/archive"
+		req.URL.Path = "/v1/" + path
 	}
 	proxy := &httputil.ReverseProxy{
 		Director: director,
 	}
 	proxy.ServeHTTP(w, r)
 }
+
+// isArchiveUpload returns whether a storage service request uploads an
+// archive, or looks one up to skip uploading it.
+func isArchiveUpload(method string, path string) bool {
+	switch path {
+	case "archive":
+		return method == http.MethodPost || method == http.MethodHead
+	case "uploads":
+		return method == http.MethodPost
+	}
+	return false
+}
+
+// needsIssuerToken returns whether a storage service request proxied for
+// the CLI gets the issuer token.
+func needsIssuerToken(method string, path string) bool {
+	switch path {
+	case "archives", "metadata", "usage":
+		return method == http.MethodGet
+	case "archive":
+		return method == http.MethodDelete
+	}
+	return false
+}
+
+// authorizeArchiveDownload checks that the archive of a download request
+// is used by the package given in its namespace and package query params.
+func (api *API) authorizeArchiveDownload(r *http.Request) error {
+	query := r.URL.Query()
+	id, ns, name := query.Get("id"), query.Get("namespace"), query.Get("package")
+	if len(id) == 0 || len(ns) == 0 || len(name) == 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Need id, namespace and package of archive to download")
+	}
+
+	pkg, err := api.fissionClient.Packages(ns).Get(name)
+	if err != nil {
+		return err
+	}
+	urls := []string{pkg.Spec.Source.URL, pkg.Spec.Deployment.URL, pkg.Status.BuildLogUrl}
+	for _, rev := range pkg.Status.Revisions {
+		urls = append(urls, rev.DeploymentUrl, rev.BuildLogUrl)
+	}
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err == nil && len(u) > 0 && parsed.Query().Get("id") == id {
+			return nil
+		}
+	}
+	return fission.MakeError(fission.ErrorNotAuthorized, fmt.Sprintf("Archive %v isn't used by package %v.%v", id, name, ns))
+}
+
+// authorizeArchiveDelete checks that the archive of a delete request is
+// used by the package given in its namespace and package query params, or
+// was uploaded for it, like the archives of deleted packages.
+func (api *API) authorizeArchiveDelete(r *http.Request) error {
+	err := api.authorizeArchiveDownload(r)
+	if err == nil {
+		return nil
+	}
+	if fe, ok := err.(fission.Error); ok && fe.Code == fission.ErrorInvalidArgument {
+		return err
+	}
+
+	query := r.URL.Query()
+	id, ns, name := query.Get("id"), query.Get("namespace"), query.Get("package")
+	m, err := api.archiveUrlSigner.Metadata(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	for _, o := range m.Owners {
+		if o.Namespace == ns && o.Package == name {
+			return nil
+		}
+	}
+	return fission.MakeError(fission.ErrorNotAuthorized, fmt.Sprintf("Archive %v isn't used by or uploaded for package %v.%v", id, name, ns))
+}
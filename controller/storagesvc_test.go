@@ -0,0 +1,110 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/crd/fake"
+	"github.com/fission/fission/storagesvc"
+	storageSvcClient "github.com/fission/fission/storagesvc/client"
+)
+
+func TestStorageServiceProxy(t *testing.T) {
+	var storageRequests []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageRequests = append(storageRequests, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization")))
+		if r.URL.Path == "/v1/metadata" {
+			json.NewEncoder(w).Encode(&storagesvc.ArchiveMetadata{
+				ID:     r.URL.Query().Get("id"),
+				Owners: []storagesvc.ArchiveOwner{{Namespace: "team-a", Package: "deleted"}},
+			})
+		}
+	}))
+	defer storage.Close()
+
+	server := fake.NewAPIServer()
+	defer server.Close()
+	fissionClient, _ := server.Clients()
+	pkg := &crd.Package{}
+	pkg.Spec.Deployment = fission.Archive{Type: fission.ArchiveTypeUrl, URL: "http://storagesvc/v1/archive?id=used"}
+	server.Add("/apis/fission.io/v1/namespaces/default/packages/pkg", pkg)
+
+	api := &API{
+		fissionClient:     fissionClient,
+		storageServiceUrl: storage.URL,
+		archiveUrlSigner:  storageSvcClient.MakeArchiveUrlSigner(storage.URL, "token"),
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/proxy/storage/v1/{path:archive|archives|metadata|usage|uploads|uploads/finalize}", api.StorageServiceProxy)
+	controller := httptest.NewServer(r)
+	defer controller.Close()
+
+	for _, v := range []struct {
+		method   string
+		path     string
+		code     int
+		expected []string
+	}{
+		// Requests of the CLI listing archives and showing their
+		// metadata get the issuer token
+		{"GET", "/archives?namespace=default", http.StatusOK, []string{"GET /v1/archives?namespace=default Bearer token"}},
+		{"GET", "/metadata?id=used", http.StatusOK, []string{"GET /v1/metadata?id=used Bearer token"}},
+		{"GET", "/usage", http.StatusOK, []string{"GET /v1/usage Bearer token"}},
+
+		// Chunked uploads don't need it, nor do they keep the token of
+		// the caller
+		{"PATCH", "/uploads?id=u", http.StatusOK, []string{"PATCH /v1/uploads?id=u"}},
+		{"HEAD", "/uploads?id=u", http.StatusOK, []string{"HEAD /v1/uploads?id=u"}},
+		{"DELETE", "/uploads?id=u", http.StatusOK, []string{"DELETE /v1/uploads?id=u"}},
+		{"POST", "/uploads/finalize?id=u", http.StatusOK, []string{"POST /v1/uploads/finalize?id=u"}},
+
+		// Deletes are for a package using the archive, or that it was
+		// uploaded for
+		{"DELETE", "/archive?id=used", http.StatusBadRequest, nil},
+		{"DELETE", "/archive?id=used&namespace=default&package=pkg", http.StatusOK,
+			[]string{"DELETE /v1/archive?id=used&namespace=default&package=pkg Bearer token"}},
+		{"DELETE", "/archive?id=other&namespace=default&package=pkg", http.StatusForbidden,
+			[]string{"GET /v1/metadata?id=other Bearer token"}},
+		{"DELETE", "/archive?id=other&namespace=team-a&package=deleted", http.StatusOK,
+			[]string{"GET /v1/metadata?id=other Bearer token", "DELETE /v1/archive?id=other&namespace=team-a&package=deleted Bearer token"}},
+		{"DELETE", "/archive?id=other&namespace=team-b&package=deleted", http.StatusForbidden,
+			[]string{"GET /v1/metadata?id=other Bearer token"}},
+	} {
+		storageRequests = nil
+		req, _ := http.NewRequest(v.method, controller.URL+"/proxy/storage/v1"+v.path, nil)
+		req.Header.Set("Authorization", "Bearer guess")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error sending request to %v: %v", v.path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != v.code || !reflect.DeepEqual(storageRequests, v.expected) {
+			t.Fatalf("expected %v %v to be proxied as %q with %v, got %v %q", v.method, v.path, v.expected, v.code, resp.StatusCode, storageRequests)
+		}
+	}
+}
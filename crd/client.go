@@ -194,16 +194,25 @@ func MakeFissionClient() (*FissionClient, *kubernetes.Clientset, *apiextensionsc
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	crdClient, err := GetCrdClient(config)
+	fc, err := MakeFissionClientForConfig(config)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	fc := &FissionClient{
-		crdClient: crdClient,
-	}
 	return fc, kubeClient, apiExtClient, nil
 }
 
+// MakeFissionClientForConfig returns a client of the fission resources of
+// the cluster of the given config.
+func MakeFissionClientForConfig(config *rest.Config) (*FissionClient, error) {
+	crdClient, err := GetCrdClient(rest.CopyConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	return &FissionClient{
+		crdClient: crdClient,
+	}, nil
+}
+
 func (fc *FissionClient) Functions(ns string) FunctionInterface {
 	return MakeFunctionInterface(fc.crdClient, ns)
 }
@@ -45,7 +45,7 @@ func ensureCRD(clientset apiextensionsclient.Interface, crd *apiextensionsv1beta
 
 		// return if the resource already exists
 		if errors.IsAlreadyExists(err) {
-			return nil
+			return ensureCRDSubresources(clientset, crd)
 		} else {
 			// The requests fail to connect to k8s api server before
 			// istio-prxoy is ready to serve traffic. Retry again.
@@ -58,6 +58,27 @@ func ensureCRD(clientset apiextensionsclient.Interface, crd *apiextensionsv1beta
 	return err
 }
 
+// ensureCRDSubresources enables the subresources of the given CRD type on an
+// existing CRD created by an older version of fission.
+func ensureCRDSubresources(clientset apiextensionsclient.Interface, crd *apiextensionsv1beta1.CustomResourceDefinition) error {
+	if crd.Spec.Subresources == nil {
+		return nil
+	}
+
+	existing, err := clientset.ApiextensionsV1beta1().CustomResourceDefinitions().Get(crd.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if existing.Spec.Subresources != nil {
+		return nil
+	}
+
+	log.Printf("Enabling subresources of CRD %v", crd.ObjectMeta.Name)
+	existing.Spec.Subresources = crd.Spec.Subresources
+	_, err = clientset.ApiextensionsV1beta1().CustomResourceDefinitions().Update(existing)
+	return err
+}
+
 // Ensure CRDs
 func EnsureFissionCRDs(clientset apiextensionsclient.Interface) error {
 	crds := []apiextensionsv1beta1.CustomResourceDefinition{
@@ -75,6 +96,9 @@ func EnsureFissionCRDs(clientset apiextensionsclient.Interface) error {
 					Plural:   "functions",
 					Singular: "function",
 				},
+				Subresources: &apiextensionsv1beta1.CustomResourceSubresources{
+					Status: &apiextensionsv1beta1.CustomResourceSubresourceStatus{},
+				},
 			},
 		},
 		// Environments (function containers)
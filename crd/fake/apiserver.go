@@ -0,0 +1,431 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake provides an in-memory API server for tests of code using the
+// Kubernetes and fission clients. It stores objects as JSON by their API
+// paths, and has none of the validation, defaulting or controllers of a
+// real cluster.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+
+	"github.com/fission/fission/crd"
+)
+
+type (
+	// APIServer serves the objects it stores over the Kubernetes API.
+	APIServer struct {
+		server *httptest.Server
+
+		lock     sync.Mutex
+		objects  map[objectKey]map[string]interface{}
+		requests []string
+		version  int
+
+		// closed when the server closes, to end watches
+		done chan struct{}
+	}
+
+	objectKey struct {
+		// API path of the group version, like /api/v1
+		groupVersion string
+		namespace    string
+		resource     string
+		name         string
+	}
+
+	// request is an API request parsed from its path.
+	request struct {
+		objectKey
+		subresource string
+	}
+)
+
+// NewAPIServer starts an API server without objects.
+func NewAPIServer() *APIServer {
+	s := &APIServer{
+		objects: make(map[objectKey]map[string]interface{}),
+		done:    make(chan struct{}),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Close shuts the server down.
+func (s *APIServer) Close() {
+	close(s.done)
+	s.server.Close()
+}
+
+// URL returns the base URL of the server.
+func (s *APIServer) URL() string {
+	return s.server.URL
+}
+
+// Clients returns the fission and Kubernetes clients of the server.
+func (s *APIServer) Clients() (*crd.FissionClient, *kubernetes.Clientset) {
+	// The server is local, requests don't need to be rate limited
+	config := &rest.Config{Host: s.server.URL, QPS: -1}
+	fissionClient, err := crd.MakeFissionClientForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+	kubernetesClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+	return fissionClient, kubernetesClient
+}
+
+// Add stores the object at the given object path, like
+// /api/v1/namespaces/default/pods/name, replacing any existing one.
+func (s *APIServer) Add(path string, obj interface{}) {
+	req, err := parsePath(path)
+	if err != nil || len(req.name) == 0 {
+		panic(fmt.Sprintf("invalid object path %v", path))
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	var object map[string]interface{}
+	err = json.Unmarshal(data, &object)
+	if err != nil {
+		panic(err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.store(req.objectKey, object)
+}
+
+// Get decodes the object at the given object path into obj, returning
+// false if there is none.
+func (s *APIServer) Get(path string, obj interface{}) bool {
+	req, err := parsePath(path)
+	if err != nil {
+		panic(fmt.Sprintf("invalid object path %v", path))
+	}
+
+	s.lock.Lock()
+	object, ok := s.objects[req.objectKey]
+	s.lock.Unlock()
+	if !ok {
+		return false
+	}
+	data, _ := json.Marshal(object)
+	if err := json.Unmarshal(data, obj); err != nil {
+		panic(err)
+	}
+	return true
+}
+
+// Requests returns the requests the server got, as "METHOD path", except
+// for watches.
+func (s *APIServer) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// ResetRequests forgets the requests the server got.
+func (s *APIServer) ResetRequests() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = nil
+}
+
+// parsePath parses the API path of an object or a list of objects.
+func parsePath(path string) (*request, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	req := &request{}
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		req.groupVersion, parts = "/"+strings.Join(parts[:2], "/"), parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		req.groupVersion, parts = "/"+strings.Join(parts[:3], "/"), parts[3:]
+	default:
+		return nil, fmt.Errorf("unknown API path %v", path)
+	}
+
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		req.namespace, parts = parts[1], parts[2:]
+	}
+	if len(parts) == 0 || len(parts) > 3 {
+		return nil, fmt.Errorf("unknown API path %v", path)
+	}
+	req.resource = parts[0]
+	if len(parts) > 1 {
+		req.name = parts[1]
+	}
+	if len(parts) > 2 {
+		req.subresource = parts[2]
+	}
+	return req, nil
+}
+
+// store sets the resource version and the namespace of the object and
+// stores it. It must be called with the lock held.
+func (s *APIServer) store(key objectKey, object map[string]interface{}) {
+	s.version++
+	metadata, _ := object["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+		object["metadata"] = metadata
+	}
+	metadata["name"] = key.name
+	if len(key.namespace) > 0 {
+		metadata["namespace"] = key.namespace
+	}
+	if uid, _ := metadata["uid"].(string); len(uid) == 0 {
+		metadata["uid"] = fmt.Sprintf("uid-%v", s.version)
+	}
+	metadata["resourceVersion"] = strconv.Itoa(s.version)
+	s.objects[key] = object
+}
+
+func (s *APIServer) handle(w http.ResponseWriter, r *http.Request) {
+	req, err := parsePath(r.URL.Path)
+	if err != nil {
+		writeStatus(w, http.StatusNotFound, "NotFound", err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("watch") == "true" {
+		s.watch(w, r)
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		data, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(data, &body)
+		}
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, fmt.Sprintf("%v %v", r.Method, r.URL.Path))
+
+	object, exists := s.objects[req.objectKey]
+	if len(req.subresource) > 0 && req.subresource != "status" {
+		// Other subresources, like scale, aren't stored
+		if !exists {
+			writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%v %v not found", req.resource, req.name))
+		} else if body != nil {
+			writeObject(w, http.StatusOK, body)
+		} else {
+			writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%v of %v not found", req.subresource, req.name))
+		}
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(req.name) == 0:
+		s.list(w, r, req)
+
+	case r.Method == http.MethodPost && len(req.name) == 0:
+		metadata, _ := body["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		if generateName, _ := metadata["generateName"].(string); len(name) == 0 && len(generateName) > 0 {
+			name = fmt.Sprintf("%v%v", generateName, s.version+1)
+		}
+		if len(name) == 0 {
+			writeStatus(w, http.StatusUnprocessableEntity, "Invalid", "name is required")
+			return
+		}
+		key := req.objectKey
+		key.name = name
+		if _, ok := s.objects[key]; ok {
+			writeStatus(w, http.StatusConflict, "AlreadyExists", fmt.Sprintf("%v %v already exists", req.resource, name))
+			return
+		}
+		s.store(key, body)
+		writeObject(w, http.StatusCreated, body)
+
+	case !exists:
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%v %v not found", req.resource, req.name))
+
+	case r.Method == http.MethodGet:
+		writeObject(w, http.StatusOK, object)
+
+	case r.Method == http.MethodPut:
+		s.store(req.objectKey, body)
+		writeObject(w, http.StatusOK, body)
+
+	case r.Method == http.MethodPatch:
+		// Merge patches and strategic merge patches of the fields tests
+		// use are the same
+		mergePatch(object, body)
+		s.store(req.objectKey, object)
+		writeObject(w, http.StatusOK, object)
+
+	case r.Method == http.MethodDelete:
+		delete(s.objects, req.objectKey)
+		writeStatus(w, http.StatusOK, "", "")
+
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// list writes the objects of the request's resource matching its
+// selectors. It must be called with the lock held.
+func (s *APIServer) list(w http.ResponseWriter, r *http.Request, req *request) {
+	labelSelector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	fieldSelector, err := fields.ParseSelector(r.URL.Query().Get("fieldSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	var keys []objectKey
+	for key := range s.objects {
+		if key.groupVersion != req.groupVersion || key.resource != req.resource ||
+			(len(req.namespace) > 0 && key.namespace != req.namespace) {
+			continue
+		}
+		metadata, _ := s.objects[key]["metadata"].(map[string]interface{})
+		objLabels := labels.Set{}
+		if l, ok := metadata["labels"].(map[string]interface{}); ok {
+			for k, v := range l {
+				objLabels[k], _ = v.(string)
+			}
+		}
+		objFields := fields.Set{"metadata.name": key.name, "metadata.namespace": key.namespace}
+		if labelSelector.Matches(objLabels) && fieldSelector.Matches(objFields) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	items := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, s.objects[key])
+	}
+	list := map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": strconv.Itoa(s.version)},
+		"items":    items,
+	}
+	// Informers need the kind of lists of built-in resources; the kind of
+	// other lists is left for the clients to default
+	if apiVersion, kind, ok := listKind(req); ok {
+		list["apiVersion"], list["kind"] = apiVersion, kind
+	}
+	writeObject(w, http.StatusOK, list)
+}
+
+// listKind returns the API version and the kind of a list of a built-in
+// resource.
+func listKind(req *request) (string, string, bool) {
+	gv, err := schema.ParseGroupVersion(strings.TrimPrefix(strings.TrimPrefix(req.groupVersion, "/api/"), "/apis/"))
+	if err != nil {
+		return "", "", false
+	}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.GroupVersion() != gv || strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		if plural, _ := meta.UnsafeGuessKindToResource(gvk); plural.Resource == req.resource {
+			return gv.String(), gvk.Kind + "List", true
+		}
+	}
+	return "", "", false
+}
+
+// watch holds the request open without events until the client or the
+// server goes away, so that informers only see the objects they list.
+func (s *APIServer) watch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	select {
+	case <-r.Context().Done():
+	case <-s.done:
+	}
+}
+
+// mergePatch applies the JSON merge patch to the object.
+func mergePatch(object map[string]interface{}, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(object, k)
+			continue
+		}
+		patchMap, isMap := v.(map[string]interface{})
+		objectMap, wasMap := object[k].(map[string]interface{})
+		if isMap && wasMap {
+			mergePatch(objectMap, patchMap)
+		} else {
+			object[k] = v
+		}
+	}
+}
+
+func writeObject(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason string, message string) {
+	status := "Success"
+	if code >= 300 {
+		status = "Failure"
+	}
+	writeObject(w, code, map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"metadata":   map[string]interface{}{},
+		"status":     status,
+		"message":    message,
+		"reason":     reason,
+		"code":       code,
+	})
+}
@@ -28,6 +28,7 @@ type (
 		Create(*Function) (*Function, error)
 		Get(name string) (*Function, error)
 		Update(*Function) (*Function, error)
+		UpdateStatus(*Function) (*Function, error)
 		Delete(name string, options *metav1.DeleteOptions) error
 		List(opts metav1.ListOptions) (*FunctionList, error)
 		Watch(opts metav1.ListOptions) (watch.Interface, error)
@@ -86,6 +87,23 @@ func (fc *functionClient) Update(f *Function) (*Function, error) {
 	return &result, nil
 }
 
+// UpdateStatus writes the status of the function through the status
+// subresource; changes to the rest of the object are ignored.
+func (fc *functionClient) UpdateStatus(f *Function) (*Function, error) {
+	var result Function
+	err := fc.client.Put().
+		Resource("functions").
+		Namespace(fc.namespace).
+		Name(f.Metadata.Name).
+		SubResource("status").
+		Body(f).
+		Do().Into(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 func (fc *functionClient) Delete(name string, opts *metav1.DeleteOptions) error {
 	return fc.client.Delete().
 		Namespace(fc.namespace).
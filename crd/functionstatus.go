@@ -0,0 +1,53 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+
+	fv1 "github.com/fission/fission/pkg/apis/fission.io/v1"
+)
+
+// UpdateFunctionStatus gets the latest version of the function, applies
+// mutate to its status and writes the status back, retrying on conflicts.
+// Nothing is written if mutate returns false.
+//
+// Every status write changes the resource version of the function, so
+// callers should only report transitions rather than every event.
+func UpdateFunctionStatus(fissionClient *FissionClient, namespace string, name string,
+	mutate func(status *fv1.FunctionStatus) bool) error {
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		fn, err := fissionClient.Functions(namespace).Get(name)
+		if err != nil {
+			return err
+		}
+
+		if !mutate(&fn.Status) {
+			return nil
+		}
+
+		_, err = fissionClient.Functions(namespace).UpdateStatus(fn)
+		if errors.IsNotFound(err) {
+			// Kubernetes clusters without CRD subresources support;
+			// the status is part of the object itself there.
+			_, err = fissionClient.Functions(namespace).Update(fn)
+		}
+		return err
+	})
+}
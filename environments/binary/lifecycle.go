@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Load states of the v3 environment interface
+const (
+	LOAD_STATE_LOADING = "loading"
+	LOAD_STATE_LOADED  = "loaded"
+	LOAD_STATE_FAILED  = "failed"
+)
+
+type (
+	// FunctionLoadStatus is reported on the load status endpoint of the
+	// v3 environment interface.
+	FunctionLoadStatus struct {
+		State string `json:"state"`
+		Error string `json:"error,omitempty"`
+	}
+
+	// FunctionHealth is reported for the function on the health endpoint
+	// of the v3 environment interface.
+	FunctionHealth struct {
+		Healthy bool   `json:"healthy"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	// lifecycle keeps the state the v3 environment interface reports.
+	lifecycle struct {
+		statusLock sync.RWMutex
+		uid        string
+		loadStatus *FunctionLoadStatus
+
+		// draining is set once the server is asked to drain; requests
+		// hold requestsLock for reading, which draining waits for.
+		draining     int32
+		requestsLock sync.RWMutex
+	}
+)
+
+func (lc *lifecycle) setLoadStatus(uid string, status *FunctionLoadStatus) {
+	lc.statusLock.Lock()
+	defer lc.statusLock.Unlock()
+	lc.uid = uid
+	lc.loadStatus = status
+}
+
+func (lc *lifecycle) getLoadStatus() (string, *FunctionLoadStatus) {
+	lc.statusLock.RLock()
+	defer lc.statusLock.RUnlock()
+	return lc.uid, lc.loadStatus
+}
+
+// startRequest returns false if the server is draining, or else marks a
+// request in flight until endRequest.
+func (lc *lifecycle) startRequest() bool {
+	if atomic.LoadInt32(&lc.draining) != 0 {
+		return false
+	}
+	lc.requestsLock.RLock()
+	return true
+}
+
+func (lc *lifecycle) endRequest() {
+	lc.requestsLock.RUnlock()
+}
+
+// LoadStatusHandler reports the load status of the function.
+func (bs *BinaryServer) LoadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	uid, status := bs.getLoadStatus()
+	if status == nil || uid != r.URL.Query().Get("uid") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("function not loaded"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// ReadinessHandler reports whether the server serves requests: it doesn't
+// while it loads the function, and once it drains.
+func (bs *BinaryServer) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	_, status := bs.getLoadStatus()
+	if atomic.LoadInt32(&bs.draining) != 0 || (status != nil && status.State == LOAD_STATE_LOADING) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DrainHandler stops taking requests and returns once the requests in
+// flight are done.
+func (bs *BinaryServer) DrainHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Draining ...")
+	atomic.StoreInt32(&bs.draining, 1)
+	bs.requestsLock.Lock()
+	bs.requestsLock.Unlock()
+	fmt.Println("Drained")
+	w.WriteHeader(http.StatusOK)
+}
+
+// HealthHandler reports the health of the function: whether it loaded,
+// and its workers are running in the persistent mode or its executable
+// is in place in the CGI mode.
+func (bs *BinaryServer) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	uid, status := bs.getLoadStatus()
+	health := make(map[string]FunctionHealth)
+	healthy := true
+	if status != nil {
+		h := FunctionHealth{Healthy: true}
+		switch status.State {
+		case LOAD_STATE_FAILED:
+			h = FunctionHealth{Error: status.Error}
+		case LOAD_STATE_LOADED:
+			var err error
+			if bs.pool != nil {
+				err = bs.pool.healthy()
+			} else {
+				_, err = os.Stat(bs.internalCodePath)
+			}
+			if err != nil {
+				h = FunctionHealth{Error: err.Error()}
+			}
+		}
+		healthy = h.Healthy
+		health[uid] = h
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// Settings of the persistent mode, read from the environment variables of
+// the function or else those of the server.
+const (
+	MODE_ENV_VAR    = "FISSION_BINARY_MODE"
+	WORKERS_ENV_VAR = "FISSION_BINARY_WORKERS"
+
+	MODE_CGI        = "cgi"
+	MODE_PERSISTENT = "persistent"
+
+	maxRestartBackoff = 30 * time.Second
+
+	// Responses are held in memory, so workers can't make them bigger
+	// than this
+	maxResponseLength = 64 << 20
+)
+
+type (
+	// FrameHeader is the first line of a frame, as JSON, followed by
+	// Length bytes of body. Requests and responses are both frames.
+	FrameHeader struct {
+		// Request fields
+		Method string `json:"method,omitempty"`
+		URI    string `json:"uri,omitempty"`
+
+		// Response fields
+		Status int `json:"status,omitempty"`
+
+		Headers http.Header `json:"headers,omitempty"`
+		Length  int64       `json:"length"`
+	}
+
+	// worker is a long running process of the executable that handles
+	// one request at a time.
+	worker struct {
+		cmd        *exec.Cmd
+		stdin      io.WriteCloser
+		stdoutPipe io.ReadCloser
+		stdout     *bufio.Reader
+
+		// closed once the process exited
+		exited chan struct{}
+
+		// generation of the pool the worker was started in
+		generation int32
+	}
+
+	// workerPool keeps a number of workers running, restarting those
+	// that crash, and hands each request to an idle one.
+	workerPool struct {
+		path string
+		env  []string
+		size int
+		idle chan *worker
+
+		// number of workers being restarted
+		restarting int32
+
+		// generation is increased on reloads; workers of older
+		// generations are restarted before they take another request
+		generation int32
+	}
+)
+
+func startWorkerPool(path string, env []string, size int) (*workerPool, error) {
+	pool := &workerPool{
+		path: path,
+		env:  env,
+		size: size,
+		idle: make(chan *worker, size),
+	}
+	for i := 0; i < size; i++ {
+		wk, err := pool.startWorker()
+		if err != nil {
+			// Don't leave the workers started so far running
+			close(pool.idle)
+			for started := range pool.idle {
+				started.stop()
+			}
+			return nil, err
+		}
+		pool.idle <- wk
+	}
+	return pool, nil
+}
+
+func (pool *workerPool) startWorker() (*worker, error) {
+	cmd := exec.Command(pool.path)
+	cmd.Env = pool.env
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	wk := &worker{
+		cmd:        cmd,
+		stdin:      stdin,
+		stdoutPipe: stdout,
+		stdout:     bufio.NewReader(stdout),
+		exited:     make(chan struct{}),
+		generation: atomic.LoadInt32(&pool.generation),
+	}
+	// Unlike cmd.Wait, this doesn't close the pipes while the server may
+	// still read from them; replace closes them.
+	go func() {
+		state, err := cmd.Process.Wait()
+		if err == nil {
+			fmt.Printf("Worker %v exited: %v\n", cmd.Process.Pid, state)
+		}
+		close(wk.exited)
+	}()
+	return wk, nil
+}
+
+// stop kills the worker process and closes its pipes.
+func (wk *worker) stop() {
+	wk.cmd.Process.Kill()
+	wk.stdin.Close()
+	wk.stdoutPipe.Close()
+}
+
+// replace starts a worker in place of one that exited or failed, retrying
+// with backoff if the executable keeps failing to start.
+func (pool *workerPool) replace(wk *worker) {
+	wk.stop()
+
+	atomic.AddInt32(&pool.restarting, 1)
+	backoff := 100 * time.Millisecond
+	for {
+		newWk, err := pool.startWorker()
+		if err == nil {
+			atomic.AddInt32(&pool.restarting, -1)
+			pool.idle <- newWk
+			return
+		}
+		fmt.Printf("Error restarting worker: %v\n", err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
+
+// reload restarts the workers, as they become idle, so that they read the
+// Secrets and ConfigMaps of the function again.
+func (pool *workerPool) reload() {
+	atomic.AddInt32(&pool.generation, 1)
+}
+
+// healthy returns an error if none of the workers is running.
+func (pool *workerPool) healthy() error {
+	if int(atomic.LoadInt32(&pool.restarting)) >= pool.size {
+		return errors.New("all workers are restarting")
+	}
+	return nil
+}
+
+func (pool *workerPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Failed to read request: %s", err)))
+		return
+	}
+
+	var wk *worker
+	for wk == nil {
+		select {
+		case wk = <-pool.idle:
+		case <-r.Context().Done():
+			return
+		}
+		// Workers that crashed while idle, or were started before a
+		// reload
+		select {
+		case <-wk.exited:
+			go pool.replace(wk)
+			wk = nil
+		default:
+			if wk.generation != atomic.LoadInt32(&pool.generation) {
+				go pool.replace(wk)
+				wk = nil
+			}
+		}
+	}
+
+	header, respBody, err := wk.invoke(r, body)
+	if err != nil {
+		// The worker may be anywhere in the protocol now, so it can't
+		// take another request
+		go pool.replace(wk)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(fmt.Sprintf("Function error: %s", err)))
+		return
+	}
+	pool.idle <- wk
+
+	for key, vals := range header.Headers {
+		for _, val := range vals {
+			w.Header().Add(key, val)
+		}
+	}
+	status := header.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(respBody)
+}
+
+// invoke sends the request to the worker and reads its response.
+func (wk *worker) invoke(r *http.Request, body []byte) (*FrameHeader, []byte, error) {
+	reqHeader, err := json.Marshal(FrameHeader{
+		Method:  r.Method,
+		URI:     r.RequestURI,
+		Headers: r.Header,
+		Length:  int64(len(body)),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Give up on workers that don't answer before the request is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			wk.cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	_, err = wk.stdin.Write(append(append(reqHeader, '\n'), body...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	line, err := wk.stdout.ReadBytes('\n')
+	if err == io.EOF {
+		return nil, nil, errors.New("worker exited")
+	} else if err != nil {
+		return nil, nil, err
+	}
+	var respHeader FrameHeader
+	err = json.Unmarshal(line, &respHeader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid response header: %v", err)
+	}
+	if respHeader.Length < 0 || respHeader.Length > maxResponseLength {
+		return nil, nil, fmt.Errorf("invalid response length %v", respHeader.Length)
+	}
+
+	respBody := make([]byte, respHeader.Length)
+	_, err = io.ReadFull(wk.stdout, respBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &respHeader, respBody, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The test binary runs as the worker executable when TEST_WORKER is set.
+const testWorkerEnvVar = "TEST_WORKER"
+
+func TestMain(m *testing.M) {
+	if len(os.Getenv(testWorkerEnvVar)) > 0 {
+		runTestWorker()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runTestWorker answers requests with its pid, unless the body of the
+// request tells it to misbehave.
+func runTestWorker() {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		line, err := in.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var header FrameHeader
+		json.Unmarshal(line, &header)
+		body := make([]byte, header.Length)
+		io.ReadFull(in, body)
+
+		pid := strconv.Itoa(os.Getpid())
+		switch string(body) {
+		case "exit":
+			os.Exit(1)
+		case "hang":
+			time.Sleep(time.Hour)
+		case "huge":
+			writeTestFrame(FrameHeader{Length: maxResponseLength + 1}, pid)
+			continue
+		}
+		writeTestFrame(FrameHeader{Status: http.StatusOK, Length: int64(len(pid))}, pid)
+		if string(body) == "exit-idle" {
+			os.Exit(0)
+		}
+	}
+}
+
+func writeTestFrame(header FrameHeader, body string) {
+	line, _ := json.Marshal(header)
+	os.Stdout.Write(append(append(line, '\n'), body...))
+}
+
+func startTestPool(t *testing.T) *workerPool {
+	pool, err := startWorkerPool(os.Args[0], []string{testWorkerEnvVar + "=1"}, 1)
+	if err != nil {
+		t.Fatalf("error starting workers: %v", err)
+	}
+	return pool
+}
+
+func stopTestPool(pool *workerPool) {
+	for {
+		select {
+		case wk := <-pool.idle:
+			wk.stop()
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+// invokeTestPool sends a request with the body to the pool, and returns
+// the status and body of the response.
+func invokeTestPool(ctx context.Context, pool *workerPool, body string) (int, string) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+func testWorkerPid(t *testing.T, pool *workerPool) int {
+	code, body := invokeTestPool(context.Background(), pool, "")
+	pid, err := strconv.Atoi(body)
+	if code != http.StatusOK || err != nil {
+		t.Fatalf("expected pid of worker, got %v %q", code, body)
+	}
+	return pid
+}
+
+// waitForExit waits for the process to exit, and to be reaped.
+func waitForExit(t *testing.T, pid int) {
+	for i := 0; i < 100; i++ {
+		if syscall.Kill(pid, 0) != nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("expected worker %v to exit", pid)
+}
+
+func TestWorkerRestart(t *testing.T) {
+	pool := startTestPool(t)
+	defer stopTestPool(pool)
+
+	pid := testWorkerPid(t, pool)
+	if other := testWorkerPid(t, pool); other != pid {
+		t.Fatalf("expected worker to handle requests in turn, got %v and %v", pid, other)
+	}
+
+	// A worker crashing fails the request it handles, and is restarted
+	if code, _ := invokeTestPool(context.Background(), pool, "exit"); code != http.StatusBadGateway {
+		t.Fatalf("expected crash to fail the request with %v, got %v", http.StatusBadGateway, code)
+	}
+	restarted := testWorkerPid(t, pool)
+	if restarted == pid {
+		t.Fatalf("expected another worker after the crash")
+	}
+	if err := pool.healthy(); err != nil {
+		t.Fatalf("expected restarted pool to be healthy: %v", err)
+	}
+
+	// A worker exiting while idle is restarted before it takes a request
+	if code, _ := invokeTestPool(context.Background(), pool, "exit-idle"); code != http.StatusOK {
+		t.Fatalf("expected response before the worker exits, got %v", code)
+	}
+	waitForExit(t, restarted)
+	if pid := testWorkerPid(t, pool); pid == restarted {
+		t.Fatalf("expected another worker after the idle worker exited")
+	}
+}
+
+func TestWorkerResponseLength(t *testing.T) {
+	pool := startTestPool(t)
+	defer stopTestPool(pool)
+
+	pid := testWorkerPid(t, pool)
+	code, body := invokeTestPool(context.Background(), pool, "huge")
+	if code != http.StatusBadGateway || !strings.Contains(body, "invalid response length") {
+		t.Fatalf("expected oversized response to fail, got %v %q", code, body)
+	}
+
+	// The worker is left in the middle of a frame, so it's replaced
+	waitForExit(t, pid)
+	if other := testWorkerPid(t, pool); other == pid {
+		t.Fatalf("expected another worker after the oversized response")
+	}
+}
+
+func TestWorkerCancel(t *testing.T) {
+	pool := startTestPool(t)
+	defer stopTestPool(pool)
+
+	pid := testWorkerPid(t, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if code, _ := invokeTestPool(ctx, pool, "hang"); code != http.StatusBadGateway {
+		t.Fatalf("expected cancelled request to fail with %v, got %v", http.StatusBadGateway, code)
+	}
+
+	// The worker that didn't answer is killed, and another takes the
+	// next request
+	waitForExit(t, pid)
+	if other := testWorkerPid(t, pool); other == pid {
+		t.Fatalf("expected another worker after the cancelled request")
+	}
+
+	// Requests cancelled while they wait for a worker don't get one
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	wk := <-pool.idle
+	invokeTestPool(ctx, pool, "")
+	pool.idle <- wk
+	testWorkerPid(t, pool)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -24,6 +26,15 @@ type (
 	BinaryServer struct {
 		fetchedCodePath  string
 		internalCodePath string
+
+		// environment variables of the function, set for every invocation
+		funcEnv map[string]string
+
+		// workers of the persistent mode, nil in the CGI mode
+		pool *workerPool
+
+		// state of the v3 environment interface
+		lifecycle
 	}
 
 	FunctionLoadRequest struct {
@@ -41,6 +52,19 @@ type (
 		// URL to expose this function at. Optional; defaults
 		// to "/".
 		URL string `json:"url"`
+
+		// Env are environment variables of the function. Optional.
+		Env map[string]string `json:"env"`
+
+		// FunctionMetadata identifies the function. Set by the fetcher
+		// of v2 environments.
+		FunctionMetadata *FunctionMetadata `json:"FunctionMetadata"`
+	}
+
+	FunctionMetadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		UID       string `json:"uid"`
 	}
 )
 
@@ -52,19 +76,38 @@ func (bs *BinaryServer) SpecializeHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	request := FunctionLoadRequest{}
-
-	codePath := bs.fetchedCodePath
 	err := json.NewDecoder(r.Body).Decode(&request)
-	switch {
-	case err == io.EOF:
-	case err != nil:
-		panic(err)
+	if err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Failed to decode load request: %s", err)))
+		return
 	}
 
+	var uid string
+	if request.FunctionMetadata != nil {
+		uid = request.FunctionMetadata.UID
+	}
+	bs.setLoadStatus(uid, &FunctionLoadStatus{State: LOAD_STATE_LOADING})
+	status, err := bs.specialize(request)
+	if err != nil {
+		bs.setLoadStatus(uid, &FunctionLoadStatus{State: LOAD_STATE_FAILED, Error: err.Error()})
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	bs.setLoadStatus(uid, &FunctionLoadStatus{State: LOAD_STATE_LOADED})
+	specialized = true
+	fmt.Println("Done")
+}
+
+// specialize loads the executable, and returns the status code of the
+// error if it fails to.
+func (bs *BinaryServer) specialize(request FunctionLoadRequest) (int, error) {
+	codePath := bs.fetchedCodePath
 	if request.FilePath != "" {
 		fileStat, err := os.Stat(request.FilePath)
 		if err != nil {
-			panic(err)
+			return http.StatusNotFound, err
 		}
 
 		codePath = request.FilePath
@@ -74,15 +117,12 @@ func (bs *BinaryServer) SpecializeHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	_, err = os.Stat(codePath)
+	_, err := os.Stat(codePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(codePath + ": not found"))
-			return
-		} else {
-			panic(err)
+			return http.StatusNotFound, errors.New(codePath + ": not found")
 		}
+		return http.StatusInternalServerError, err
 	}
 
 	// Future: Check if executable is correct architecture/executable.
@@ -90,31 +130,69 @@ func (bs *BinaryServer) SpecializeHandler(w http.ResponseWriter, r *http.Request
 	// Copy the executable to ensure that file is executable and immutable.
 	userFunc, err := ioutil.ReadFile(codePath)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to read executable."))
-		return
+		return http.StatusInternalServerError, errors.New("Failed to read executable.")
 	}
 	err = ioutil.WriteFile(bs.internalCodePath, userFunc, 0555)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to write executable to target location."))
-		return
+		return http.StatusInternalServerError, errors.New("Failed to write executable to target location.")
 	}
 
 	fmt.Println("Specializing ...")
-	specialized = true
-	fmt.Println("Done")
+	bs.funcEnv = request.Env
+	if bs.setting(MODE_ENV_VAR) == MODE_PERSISTENT {
+		workers := 1
+		if val := bs.setting(WORKERS_ENV_VAR); len(val) > 0 {
+			workers, err = strconv.Atoi(val)
+			if err != nil || workers < 1 {
+				return http.StatusBadRequest, fmt.Errorf("Invalid %v: %v", WORKERS_ENV_VAR, val)
+			}
+		}
+		env := NewEnv(nil)
+		for key, val := range bs.funcEnv {
+			env.SetEnv(&EnvVar{key, val})
+		}
+		bs.pool, err = startWorkerPool(bs.internalCodePath, env.ToStringEnv(), workers)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Failed to start workers: %s", err)
+		}
+		fmt.Printf("Started %v persistent workers\n", workers)
+	}
+	return http.StatusOK, nil
+}
+
+// setting returns the value of the environment variable of the function,
+// or else of the server.
+func (bs *BinaryServer) setting(key string) string {
+	if val, ok := bs.funcEnv[key]; ok {
+		return val
+	}
+	return os.Getenv(key)
 }
 
 func (bs *BinaryServer) InvocationHandler(w http.ResponseWriter, r *http.Request) {
+	if !bs.startRequest() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Draining"))
+		return
+	}
+	defer bs.endRequest()
+
 	if !specialized {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Generic container: no requests supported"))
 		return
 	}
 
+	if bs.pool != nil {
+		bs.pool.ServeHTTP(w, r)
+		return
+	}
+
 	// CGI-like passing of environment variables
 	execEnv := NewEnv(nil)
+	for key, val := range bs.funcEnv {
+		execEnv.SetEnv(&EnvVar{key, val})
+	}
 	execEnv.SetEnv(&EnvVar{"REQUEST_METHOD", r.Method})
 	execEnv.SetEnv(&EnvVar{"REQUEST_URI", r.RequestURI})
 	execEnv.SetEnv(&EnvVar{"CONTENT_LENGTH", fmt.Sprintf("%d", r.ContentLength)})
@@ -123,7 +201,7 @@ func (bs *BinaryServer) InvocationHandler(w http.ResponseWriter, r *http.Request
 		execEnv.SetEnv(&EnvVar{fmt.Sprintf("HTTP_%s", strings.ToUpper(header)), val[0]})
 	}
 
-	// Future: could be improved by keeping subprocess open while environment is specialized
+	// A process per request; the persistent mode keeps processes open
 	cmd := exec.Command(bs.internalCodePath)
 	cmd.Env = execEnv.ToStringEnv()
 
@@ -154,6 +232,17 @@ func (bs *BinaryServer) InvocationHandler(w http.ResponseWriter, r *http.Request
 	w.Write(out)
 }
 
+// ReloadHandler is told that the Secrets and ConfigMaps of the function
+// changed. The executable reads them again on every request in the CGI
+// mode; persistent workers are restarted so that they do.
+func (bs *BinaryServer) ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if bs.pool != nil {
+		fmt.Println("Restarting persistent workers to reload")
+		bs.pool.reload()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func main() {
 	codePath := flag.String("c", DEFAULT_CODE_PATH, "Path to expected fetched executable.")
 	internalCodePath := flag.String("i", DEFAULT_INTERNAL_CODE_PATH, "Path to specialized executable.")
@@ -165,10 +254,15 @@ func main() {
 	fmt.Printf("Using fetched code path: %s\n", *codePath)
 	fmt.Printf("Using internal code path: %s\n", absInternalCodePath)
 
-	server := &BinaryServer{*codePath, absInternalCodePath}
+	server := &BinaryServer{fetchedCodePath: *codePath, internalCodePath: absInternalCodePath}
 	http.HandleFunc("/", server.InvocationHandler)
 	http.HandleFunc("/specialize", server.SpecializeHandler)
 	http.HandleFunc("/v2/specialize", server.SpecializeHandler)
+	http.HandleFunc("/v3/status", server.LoadStatusHandler)
+	http.HandleFunc("/v3/readiness", server.ReadinessHandler)
+	http.HandleFunc("/v3/drain", server.DrainHandler)
+	http.HandleFunc("/v3/health", server.HealthHandler)
+	http.HandleFunc("/v3/reload", server.ReloadHandler)
 
 	fmt.Println("Listening on 8888 ...")
 	err = http.ListenAndServe(":8888", nil)
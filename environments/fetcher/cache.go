@@ -0,0 +1,246 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mholt/archiver"
+	uuid "github.com/satori/go.uuid"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/fission/fission"
+)
+
+const cacheTmpPrefix = ".tmp-"
+
+type (
+	// ArchiveCache keeps downloaded archives in a directory shared by the
+	// fetchers of a node, e.g. a hostPath volume, named by the namespace
+	// of their package and their SHA-256 checksum. Packages only get
+	// archives their namespace downloaded, so knowing a checksum doesn't
+	// give access to the archives of other namespaces. Once the archives
+	// in it are bigger than maxSize, the least recently used ones are
+	// removed. The fetchers of a node only ever rename complete files into
+	// it, so they can share it without locking.
+	ArchiveCache struct {
+		dir     string
+		maxSize int64
+
+		// serializes evictions of this fetcher; the others may still evict
+		// at the same time, which only removes a few more files
+		lock sync.Mutex
+	}
+
+	cachedFile struct {
+		path    string
+		size    int64
+		lastUse time.Time
+	}
+)
+
+func MakeArchiveCache(dir string, maxSize int64) (*ArchiveCache, error) {
+	err := os.MkdirAll(dir, os.ModeDir|0700)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveCache{dir: dir, maxSize: maxSize}, nil
+}
+
+// cachePath returns the cache path of an archive of the namespace with
+// the checksum, or false if the checksum isn't a SHA-256 one or the
+// namespace isn't a valid one, so that they're usable as file names.
+func (cache *ArchiveCache) cachePath(namespace string, checksum *fission.Checksum) (string, bool) {
+	if checksum.Type != fission.ChecksumTypeSHA256 {
+		return "", false
+	}
+	sum, err := hex.DecodeString(checksum.Sum)
+	if err != nil || len(sum) != 32 {
+		return "", false
+	}
+	if len(validation.IsDNS1123Label(namespace)) > 0 {
+		return "", false
+	}
+	return filepath.Join(cache.dir, namespace, strings.ToLower(checksum.Sum)), true
+}
+
+// canLink returns whether the archive at path can be shared with the cache
+// by a hard link. Only zip archives that are unarchived are; other files
+// are handed to functions as they are, which may change them.
+func canLink(path string, unarchive bool) bool {
+	return unarchive && archiver.Zip.Match(path)
+}
+
+// Get puts the cached archive of the namespace with the checksum at path,
+// returning false if it isn't cached. Archives are hard linked when
+// possible, and copied otherwise.
+func (cache *ArchiveCache) Get(namespace string, checksum *fission.Checksum, path string, unarchive bool) bool {
+	cached, ok := cache.cachePath(namespace, checksum)
+	if !ok {
+		return false
+	}
+
+	err := linkOrCopy(cached, path, canLink(cached, unarchive))
+	if os.IsNotExist(err) {
+		return false
+	} else if err != nil {
+		log.Printf("Error getting archive %v from cache: %v", checksum.Sum, err)
+		os.Remove(path)
+		return false
+	}
+
+	// The modification time of cached archives is their last use
+	now := time.Now()
+	err = os.Chtimes(cached, now, now)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error updating last use of cached archive %v: %v", checksum.Sum, err)
+	}
+	return true
+}
+
+// Put adds the archive of the namespace at path, whose checksum was
+// verified, to the cache and evicts the least recently used archives if
+// the cache is full.
+func (cache *ArchiveCache) Put(namespace string, checksum *fission.Checksum, path string, unarchive bool) {
+	cached, ok := cache.cachePath(namespace, checksum)
+	if !ok {
+		return
+	}
+	if _, err := os.Stat(cached); err == nil {
+		return
+	}
+
+	tmpPath := filepath.Join(cache.dir, cacheTmpPrefix+uuid.NewV4().String())
+	err := os.MkdirAll(filepath.Dir(cached), os.ModeDir|0700)
+	if err == nil {
+		err = linkOrCopy(path, tmpPath, canLink(path, unarchive))
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, cached)
+	}
+	if err != nil {
+		log.Printf("Error adding archive %v to cache: %v", checksum.Sum, err)
+		os.Remove(tmpPath)
+		return
+	}
+
+	cache.evict()
+}
+
+// Remove removes the cached archive of the namespace with the checksum,
+// e.g. if it's been corrupted.
+func (cache *ArchiveCache) Remove(namespace string, checksum *fission.Checksum) {
+	cached, ok := cache.cachePath(namespace, checksum)
+	if !ok {
+		return
+	}
+	err := os.Remove(cached)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing cached archive %v: %v", checksum.Sum, err)
+	}
+}
+
+// evict removes the least recently used archives until the cache is no
+// bigger than its maximum size.
+func (cache *ArchiveCache) evict() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	infos, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		log.Printf("Error listing archive cache: %v", err)
+		return
+	}
+
+	var files []cachedFile
+	var size int64
+	for _, info := range infos {
+		path := filepath.Join(cache.dir, info.Name())
+		if info.IsDir() {
+			nsFiles, err := ioutil.ReadDir(path)
+			if err != nil {
+				log.Printf("Error listing archive cache of namespace %v: %v", info.Name(), err)
+				continue
+			}
+			for _, f := range nsFiles {
+				if !f.IsDir() {
+					files = append(files, cachedFile{path: filepath.Join(path, f.Name()), size: f.Size(), lastUse: f.ModTime()})
+					size += f.Size()
+				}
+			}
+			continue
+		}
+		// Temporary files of copies that never finished, and archives
+		// cached before they were kept by namespace
+		if !strings.HasPrefix(info.Name(), cacheTmpPrefix) || time.Since(info.ModTime()) > time.Hour {
+			os.Remove(path)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].lastUse.Before(files[j].lastUse) })
+	for _, f := range files {
+		if size <= cache.maxSize {
+			break
+		}
+		err := os.Remove(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error evicting cached archive %v: %v", f.path, err)
+			continue
+		}
+		log.Printf("Evicted cached archive %v", strings.TrimPrefix(f.path, cache.dir+string(filepath.Separator)))
+		size -= f.size
+	}
+}
+
+// linkOrCopy hard links src to dst if link is set, falling back to a copy
+// when that fails, e.g. across file systems.
+func linkOrCopy(src string, dst string, link bool) error {
+	if link {
+		err := os.Link(src, dst)
+		if err == nil || os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Sync()
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
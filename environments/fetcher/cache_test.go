@@ -0,0 +1,67 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fission/fission"
+)
+
+func TestArchiveCacheNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetcher-cache-")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := MakeArchiveCache(filepath.Join(dir, "cache"), 1<<20)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+
+	data := []byte("archive")
+	archive := filepath.Join(dir, "archive")
+	ioutil.WriteFile(archive, data, 0600)
+	checksum := &fission.Checksum{Type: fission.ChecksumTypeSHA256, Sum: fmt.Sprintf("%x", sha256.Sum256(data))}
+	cache.Put("team-a", checksum, archive, false)
+
+	got := filepath.Join(dir, "got")
+	if !cache.Get("team-a", checksum, got, false) {
+		t.Fatalf("archive wasn't cached")
+	}
+	if content, _ := ioutil.ReadFile(got); string(content) != string(data) {
+		t.Fatalf("cached archive has content %q", content)
+	}
+
+	// Other namespaces don't get the archive by its checksum
+	if cache.Get("team-b", checksum, filepath.Join(dir, "other"), false) {
+		t.Fatalf("archive of team-a was got for team-b")
+	}
+	if cache.Get("../cache/team-a", checksum, filepath.Join(dir, "other"), false) {
+		t.Fatalf("archive was got with an invalid namespace")
+	}
+
+	cache.Remove("team-a", checksum)
+	if cache.Get("team-a", checksum, filepath.Join(dir, "removed"), false) {
+		t.Fatalf("removed archive was got")
+	}
+}
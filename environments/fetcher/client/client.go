@@ -44,14 +44,31 @@ func (c *Client) getUploadUrl() string {
 	return c.url + "/upload"
 }
 
+func (c *Client) getReloadUrl() string {
+	return c.url + "/reload"
+}
+
 func (c *Client) Specialize(ctx context.Context, req *fission.FunctionSpecializeRequest) error {
 	_, err := sendRequest(ctx, c.httpClient, req, c.getSpecializeUrl())
 	return err
 }
 
-func (c *Client) Fetch(ctx context.Context, fr *fission.FunctionFetchRequest) error {
-	_, err := sendRequest(ctx, c.httpClient, fr, c.getFetchUrl())
-	return err
+func (c *Client) Fetch(ctx context.Context, fr *fission.FunctionFetchRequest) (*fission.FunctionFetchResponse, error) {
+	body, err := sendRequest(ctx, c.httpClient, fr, c.getFetchUrl())
+	if err != nil {
+		return nil, err
+	}
+
+	// Older fetchers reply with an empty body
+	fetchResp := fission.FunctionFetchResponse{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &fetchResp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &fetchResp, nil
 }
 
 func (c *Client) Upload(ctx context.Context, fr *fission.ArchiveUploadRequest) (*fission.ArchiveUploadResponse, error) {
@@ -66,6 +83,27 @@ func (c *Client) Upload(ctx context.Context, fr *fission.ArchiveUploadRequest) (
 	return &uploadResp, nil
 }
 
+// Reload asks the fetcher to rewrite Secrets and ConfigMaps that changed.
+// Unlike other requests it isn't retried; the next change reloads them
+// again.
+func (c *Client) Reload(ctx context.Context, req *fission.FunctionReloadRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := ctxhttp.Post(ctx, c.httpClient, c.getReloadUrl(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fission.MakeErrorFromHTTP(resp)
+	}
+	return nil
+}
+
 func sendRequest(ctx context.Context, httpClient *http.Client, req interface{}, url string) ([]byte, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
@@ -87,7 +125,12 @@ func sendRequest(ctx context.Context, httpClient *http.Client, req interface{},
 				resp.Body.Close()
 				return body, err
 			}
-			err = fission.MakeErrorFromHTTP(resp)
+			err = fission.MakeSpecializationErrorFromHTTP(resp)
+
+			// Specializing failed after the fetcher's own retries
+			if _, ok := err.(*fission.SpecializationError); ok {
+				return nil, err
+			}
 		}
 
 		if i < maxRetries-1 {
@@ -71,6 +71,9 @@ func main() {
 	specializePayload := flag.String("specialize-request", "", "JSON payload for specialize request")
 	secretDir := flag.String("secret-dir", "", "Path to shared secrets directory")
 	configDir := flag.String("cfgmap-dir", "", "Path to shared configmap directory")
+	cacheDir := flag.String("cache-dir", "", "Path to the archive cache shared by the fetchers of the node (optional)")
+	cacheMaxSize := flag.Int64("cache-max-size", 10<<30, "Size in bytes the archive cache is bounded to")
+	packageKeysNamespace := flag.String("package-keys-namespace", "", "Namespace of the ConfigMap with the keys trusted to sign packages (optional)")
 
 	flag.Parse()
 	if flag.NArg() == 0 {
@@ -101,6 +104,14 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error making fetcher: %v", err)
 	}
+	if len(*cacheDir) > 0 {
+		cache, err := fetcher.MakeArchiveCache(*cacheDir, *cacheMaxSize)
+		if err != nil {
+			log.Fatalf("Error making archive cache: %v", err)
+		}
+		f.SetArchiveCache(cache)
+	}
+	f.SetPackageKeysNamespace(*packageKeysNamespace)
 
 	readyToServe := false
 
@@ -128,6 +139,7 @@ func main() {
 	mux.HandleFunc("/fetch", f.FetchHandler)
 	mux.HandleFunc("/specialize", f.SpecializeHandler)
 	mux.HandleFunc("/upload", f.UploadHandler)
+	mux.HandleFunc("/reload", f.ReloadHandler)
 	mux.HandleFunc("/version", f.VersionHandler)
 	mux.HandleFunc("/readniess-healthz", func(w http.ResponseWriter, r *http.Request) {
 		if !*specializeOnStart || readyToServe {
@@ -148,5 +160,5 @@ func main() {
 }
 
 func fetcherUsage() {
-	fmt.Printf("Usage: fetcher [-specialize-on-startup] [-specialize-request <json>] [-secret-dir <string>] [-cfgmap-dir <string>] <shared volume path> \n")
+	fmt.Printf("Usage: fetcher [-specialize-on-startup] [-specialize-request <json>] [-secret-dir <string>] [-cfgmap-dir <string>] [-cache-dir <string>] [-cache-max-size <bytes>] <shared volume path> \n")
 }
@@ -1,11 +1,13 @@
 package container
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -15,6 +17,7 @@ import (
 
 	"github.com/fission/fission"
 	crd "github.com/fission/fission/crd"
+	storageSvcClient "github.com/fission/fission/storagesvc/client"
 )
 
 type Config struct {
@@ -36,8 +39,27 @@ type Config struct {
 	serviceAccount string
 
 	jaegerCollectorEndpoint string
+
+	// archiveUrlSigner gets signed URLs of the archives fetchers download
+	archiveUrlSigner *storageSvcClient.ArchiveUrlSigner
+
+	// host directory of the archive cache fetchers on a node share, if any
+	cacheHostPath string
+	cacheMaxSize  int64
+
+	// namespace of the ConfigMap of the keys trusted to sign packages,
+	// if fetchers verify them
+	packageKeysNamespace string
 }
 
+const (
+	// Pools specialize right after signing, so their URLs are short lived
+	specializeArchiveUrlTTL = 10 * time.Minute
+
+	cacheVolume    = "fetcher-cache"
+	cacheMountPath = "/cache"
+)
+
 func getFetcherResources() (apiv1.ResourceRequirements, error) {
 	mincpu, err := resource.ParseQuantity(os.Getenv("FETCHER_MINCPU"))
 	if err != nil {
@@ -87,6 +109,21 @@ func MakeFetcherConfig(sharedMountPath string) (*Config, error) {
 		fetcherImagePullPolicy = "IfNotPresent"
 	}
 
+	storageServiceUrl := os.Getenv("STORAGE_SERVICE_URL")
+	if len(storageServiceUrl) == 0 {
+		storageServiceUrl = "http://storagesvc.fission"
+	}
+
+	var cacheMaxSize int64
+	cacheHostPath := os.Getenv("FETCHER_CACHE_HOST_PATH")
+	if len(cacheHostPath) > 0 {
+		size, err := resource.ParseQuantity(os.Getenv("FETCHER_CACHE_MAX_SIZE"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid FETCHER_CACHE_MAX_SIZE: %v", err)
+		}
+		cacheMaxSize = size.Value()
+	}
+
 	return &Config{
 		resourceRequirements:     resources,
 		fetcherImage:             fetcherImage,
@@ -98,7 +135,11 @@ func MakeFetcherConfig(sharedMountPath string) (*Config, error) {
 		dockerRegistryUsername:   os.Getenv("FETCHER_DOCKER_REGISTRY_USERNAME"),
 		dockerRegistryPassword:   os.Getenv("FETCHER_DOCKER_REGISTRY_PASSWORD"),
 		jaegerCollectorEndpoint:  os.Getenv("OPENCENSUS_TRACE_JAEGER_COLLECTOR_ENDPOINT"),
+		archiveUrlSigner:         storageSvcClient.MakeArchiveUrlSigner(storageServiceUrl, os.Getenv("ARCHIVE_URL_ISSUER_TOKEN")),
 		serviceAccount:           fission.FissionFetcherSA,
+		cacheHostPath:            cacheHostPath,
+		cacheMaxSize:             cacheMaxSize,
+		packageKeysNamespace:     os.Getenv("PACKAGE_KEYS_NAMESPACE"),
 	}, nil
 }
 
@@ -109,6 +150,16 @@ func (cfg *Config) SetupServiceAccount(kubernetesClient *kubernetes.Clientset, n
 		return err
 	}
 
+	// Fetchers read the trusted keys in the fission namespace
+	if len(cfg.packageKeysNamespace) > 0 {
+		err = fission.SetupRoleBinding(kubernetesClient, fission.PackageKeysGetterRB, cfg.packageKeysNamespace,
+			fission.PackageKeysGetterRole, fission.Role, fission.FissionFetcherSA, namespace)
+		if err != nil {
+			log.Printf("Error : %v granting %s in ns : %s access to trusted package keys", err, fission.FissionFetcherSA, namespace)
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -121,8 +172,14 @@ func (cfg *Config) NewSpecializeRequest(fn *crd.Function, env *crd.Environment)
 	// still try to load user function from hard coded
 	// path /userfunc/user
 	targetFilename := "user"
-	if env.Spec.Version == 2 {
+	if env.Spec.Version >= 2 {
 		targetFilename = string(fn.Metadata.UID)
+		// Pods of environments allowing more than one function per
+		// container are specialized again once a function is updated,
+		// which needs a new file as the fetcher skips existing ones.
+		if env.Spec.AllowedFunctionsPerContainer == fission.AllowedFunctionsPerContainerInfinite {
+			targetFilename = fmt.Sprintf("%v-%v", fn.Metadata.UID, fn.Metadata.ResourceVersion)
+		}
 	}
 
 	return fission.FunctionSpecializeRequest{
@@ -150,8 +207,40 @@ func (cfg *Config) AddFetcherToPodSpec(podSpec *apiv1.PodSpec, mainContainerName
 	return cfg.addFetcherToPodSpecWithCommand(podSpec, mainContainerName, cfg.fetcherCommand())
 }
 
-func (cfg *Config) AddSpecializingFetcherToPodSpec(podSpec *apiv1.PodSpec, mainContainerName string, fn *crd.Function, env *crd.Environment) error {
-	specializeReq := cfg.NewSpecializeRequest(fn, env)
+// ArchiveUrlsSigned returns whether fetchers get signed archive URLs.
+func (cfg *Config) ArchiveUrlsSigned() bool {
+	return cfg.archiveUrlSigner.Enabled()
+}
+
+// SignArchiveUrl adds a signed URL of the package's deployment archive to
+// the fetch request, if the storage service only serves signed URLs. The
+// URL of pools is valid for a few minutes. Deployments, which keep
+// specializing new pods with the request in their pod spec, get a fetch
+// grant instead, with which the fetcher signs the URL when it specializes.
+func (cfg *Config) SignArchiveUrl(ctx context.Context, fetchReq *fission.FunctionFetchRequest, pkg *crd.Package, deployment bool) error {
+	if !cfg.archiveUrlSigner.Enabled() {
+		return nil
+	}
+	if deployment {
+		grant, err := cfg.archiveUrlSigner.FetchGrant(ctx, pkg.Spec.Deployment.URL)
+		if err != nil {
+			return err
+		}
+		fetchReq.ArchiveGrant = grant
+		return nil
+	}
+
+	archiveUrl, err := cfg.archiveUrlSigner.Sign(ctx, pkg.Spec.Deployment.URL, specializeArchiveUrlTTL)
+	if err != nil {
+		return err
+	}
+	if archiveUrl != pkg.Spec.Deployment.URL {
+		fetchReq.ArchiveUrl = archiveUrl
+	}
+	return nil
+}
+
+func (cfg *Config) AddSpecializingFetcherToPodSpec(podSpec *apiv1.PodSpec, mainContainerName string, specializeReq fission.FunctionSpecializeRequest) error {
 	specializePayload, err := json.Marshal(specializeReq)
 	if err != nil {
 		return err
@@ -182,6 +271,12 @@ func (cfg *Config) fetcherCommand(extraArgs ...string) []string {
 	if cfg.dockerRegistryPassword != "" {
 		command = append(command, "-docker-registry-password", cfg.dockerRegistryPassword)
 	}
+	if cfg.cacheHostPath != "" {
+		command = append(command, "-cache-dir", cacheMountPath, "-cache-max-size", fmt.Sprintf("%v", cfg.cacheMaxSize))
+	}
+	if cfg.packageKeysNamespace != "" {
+		command = append(command, "-package-keys-namespace", cfg.packageKeysNamespace)
+	}
 
 	command = append(command, extraArgs...)
 	command = append(command, cfg.sharedMountPath)
@@ -227,15 +322,43 @@ func (cfg *Config) volumesWithMounts() ([]apiv1.Volume, []apiv1.VolumeMount) {
 	return volumes, mounts
 }
 
+// cacheVolumeWithMount returns the host directory of the archive cache,
+// which only the fetcher mounts.
+func (cfg *Config) cacheVolumeWithMount() (*apiv1.Volume, *apiv1.VolumeMount) {
+	if cfg.cacheHostPath == "" {
+		return nil, nil
+	}
+	hostPathType := apiv1.HostPathDirectoryOrCreate
+	volume := &apiv1.Volume{
+		Name: cacheVolume,
+		VolumeSource: apiv1.VolumeSource{
+			HostPath: &apiv1.HostPathVolumeSource{
+				Path: cfg.cacheHostPath,
+				Type: &hostPathType,
+			},
+		},
+	}
+	mount := &apiv1.VolumeMount{
+		Name:      cacheVolume,
+		MountPath: cacheMountPath,
+	}
+	return volume, mount
+}
+
 func (cfg *Config) addFetcherToPodSpecWithCommand(podSpec *apiv1.PodSpec, mainContainerName string, command []string) error {
 	volumes, mounts := cfg.volumesWithMounts()
+	fetcherMounts := mounts
+	cacheVolume, cacheMount := cfg.cacheVolumeWithMount()
+	if cacheVolume != nil {
+		fetcherMounts = append(append([]apiv1.VolumeMount{}, mounts...), *cacheMount)
+	}
 	c := apiv1.Container{
 		Name:                   "fetcher",
 		Command:                command,
 		Image:                  cfg.fetcherImage,
 		ImagePullPolicy:        cfg.fetcherImagePullPolicy,
 		TerminationMessagePath: "/dev/termination-log",
-		VolumeMounts:           mounts,
+		VolumeMounts:           fetcherMounts,
 		Resources:              cfg.resourceRequirements,
 		ReadinessProbe: &apiv1.Probe{
 			InitialDelaySeconds: 1,
@@ -306,6 +429,9 @@ func (cfg *Config) addFetcherToPodSpecWithCommand(podSpec *apiv1.PodSpec, mainCo
 	}
 
 	podSpec.Volumes = append(podSpec.Volumes, volumes...)
+	if cacheVolume != nil {
+		podSpec.Volumes = append(podSpec.Volumes, *cacheVolume)
+	}
 	podSpec.Containers = append(podSpec.Containers, c)
 	if podSpec.ServiceAccountName == "" {
 		podSpec.ServiceAccountName = fission.FissionFetcherSA
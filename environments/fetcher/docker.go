@@ -2,115 +2,347 @@ package fetcher
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"runtime"
+	"strings"
 
 	"github.com/fission/fission/environments/fetcher/tarextract"
 
 	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 	"github.com/tesserai/docker-registry-client/registry"
+	"golang.org/x/net/context/ctxhttp"
 )
 
+// Manifests are small, anything bigger isn't one
+const maxManifestSize = 4 << 20
+
+// Images are extracted to the pod's volume, whose files they can't make
+// bigger than this
+const maxImageSize = 4 << 30
+
 type (
 	dockerCreds struct {
 		username string
 		password string
 	}
 
+	// RegistryCreds are the credentials of docker registries by domain.
+	RegistryCreds map[string]dockerCreds
+
 	DockerBlobFetcher struct {
-		defaultRegistryURL string
-		transport          http.RoundTripper
+		defaultRegistryDomain string
+		transport             http.RoundTripper
+
+		credsByDomain RegistryCreds
+	}
 
-		credsByDomain map[string]dockerCreds
+	// Image is an image resolved to the manifest of its layers.
+	Image struct {
+		// Repository is the fully qualified name of the image, e.g.
+		// docker.io/library/python.
+		Repository string
+
+		// Digest is the digest of the manifest, or of the manifest list,
+		// that the image reference points to.
+		Digest digest.Digest
+
+		// Layers of the image, for the platform of the fetcher.
+		Layers []distribution.Descriptor
+	}
+
+	// imageManifest is a docker v2 or OCI image manifest, or a manifest
+	// list or image index.
+	imageManifest struct {
+		MediaType string                    `json:"mediaType"`
+		Layers    []distribution.Descriptor `json:"layers"`
+		Manifests []distribution.Descriptor `json:"manifests"`
+	}
+
+	// dockerConfigAuth is the entry of a registry in a docker config file.
+	dockerConfigAuth struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
 	}
 )
 
-func MakeDockerBlobFetcher(defaultRegistryURL string, transport http.RoundTripper) *DockerBlobFetcher {
+func MakeDockerBlobFetcher(defaultRegistryDomain string, transport http.RoundTripper) *DockerBlobFetcher {
 	if transport == nil {
 		transport = http.DefaultTransport
 	}
 	return &DockerBlobFetcher{
-		defaultRegistryURL: defaultRegistryURL,
-		transport:          transport,
-		credsByDomain:      map[string]dockerCreds{},
+		defaultRegistryDomain: defaultRegistryDomain,
+		transport:             transport,
+		credsByDomain:         RegistryCreds{},
 	}
 
 }
 
-func (df *DockerBlobFetcher) registryForDomain(domain string) *registry.Registry {
-	var url string
+// normalizeRegistryDomain returns the domain of a registry the way image
+// references name it; docker config files use URLs, and Docker Hub goes
+// by several names.
+func normalizeRegistryDomain(domain string) string {
+	domain = strings.TrimPrefix(domain, "https://")
+	domain = strings.TrimPrefix(domain, "http://")
+	domain = strings.SplitN(domain, "/", 2)[0]
+	switch domain {
+	case "", "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return domain
+}
 
-	if domain != "" {
-		url = "https://" + domain
-	} else {
-		url = df.defaultRegistryURL
+func (df *DockerBlobFetcher) registryForDomain(domain string, creds RegistryCreds) *registry.Registry {
+	domain = normalizeRegistryDomain(domain)
+
+	url := "https://" + domain
+	if domain == "docker.io" {
+		url = "https://" + strings.TrimPrefix(df.defaultRegistryDomain, "https://")
 	}
 
-	creds := df.credsByDomain[domain]
+	c, ok := creds[domain]
+	if !ok {
+		c = df.credsByDomain[domain]
+	}
 
 	return &registry.Registry{
 		URL: url,
 		Client: &http.Client{
-			Transport: &registry.BasicTransport{
-				Transport: df.transport,
-				URL:       url,
-				Username:  creds.username,
-				Password:  creds.password,
-			},
+			Transport: registry.WrapTransport(df.transport, url, c.username, c.password),
 		},
 		Logf: registry.Log,
 	}
 }
 
 func (df *DockerBlobFetcher) SetBasicAuthForDomain(domain, username, password string) {
-	df.credsByDomain[domain] = dockerCreds{username, password}
+	if len(username) == 0 && len(password) == 0 {
+		return
+	}
+	df.credsByDomain[normalizeRegistryDomain(domain)] = dockerCreds{username, password}
 }
 
-func (df *DockerBlobFetcher) DownloadFinalLayer(ctx context.Context, imageReference string, tmpPath string) error {
-	ref, err := reference.ParseAnyReference(imageReference)
+// ParseDockerConfig returns the registry credentials of a docker config
+// file, or of the legacy .dockercfg one; these are the contents of image
+// pull secrets.
+func ParseDockerConfig(data []byte) (RegistryCreds, error) {
+	var config struct {
+		Auths map[string]dockerConfigAuth `json:"auths"`
+	}
+	err := json.Unmarshal(data, &config)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	named, ok := ref.(reference.Named)
-	if !ok {
-		return fmt.Errorf("Cannot parse image reference into something fetchable: %s", imageReference)
+	if config.Auths == nil {
+		err = json.Unmarshal(data, &config.Auths)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	hub := df.registryForDomain(reference.Domain(named))
+	creds := RegistryCreds{}
+	for domain, auth := range config.Auths {
+		if len(auth.Auth) > 0 {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error decoding auth of %v", domain)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid auth of %v", domain)
+			}
+			auth.Username, auth.Password = parts[0], parts[1]
+		}
+		creds[normalizeRegistryDomain(domain)] = dockerCreds{auth.Username, auth.Password}
+	}
+	return creds, nil
+}
+
+func getManifest(ctx context.Context, hub *registry.Registry, repository string, ref string) ([]byte, *imageManifest, error) {
+	url := fmt.Sprintf("%v/v2/%v/manifests/%v", hub.URL, repository, ref)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		v1.MediaTypeImageIndex,
+		v1.MediaTypeImageManifest,
+		manifestlist.MediaTypeManifestList,
+		schema2.MediaTypeManifest,
+	}, ", "))
 
-	imageName := reference.Path(named)
-	var imageTag string
-	if tagged, ok := ref.(reference.Tagged); ok {
-		imageTag = tagged.Tag()
+	resp, err := ctxhttp.Do(ctx, hub.Client, req)
+	if err != nil {
+		return nil, nil, err
 	}
-	manifest, err := hub.Manifest(ctx, imageName, imageTag)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	var manifest imageManifest
+	err = json.Unmarshal(body, &manifest)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error decoding manifest")
+	}
+	// OCI manifests don't have to name their media type
+	if len(manifest.MediaType) == 0 {
+		manifest.MediaType = strings.TrimSpace(strings.SplitN(resp.Header.Get("Content-Type"), ";", 2)[0])
+	}
+	return body, &manifest, nil
+}
+
+// platformManifest returns the manifest of a manifest list or index for
+// the platform of the fetcher, which is that of the function containers.
+func platformManifest(manifests []distribution.Descriptor) (*distribution.Descriptor, error) {
+	for i, m := range manifests {
+		if m.Platform != nil && m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH {
+			return &manifests[i], nil
+		}
+	}
+	if len(manifests) == 1 && manifests[0].Platform == nil {
+		return &manifests[0], nil
+	}
+	return nil, fmt.Errorf("image has no manifest for platform %v/%v", runtime.GOOS, runtime.GOARCH)
+}
+
+func (df *DockerBlobFetcher) resolveImage(ctx context.Context, imageReference string, creds RegistryCreds) (*Image, *registry.Registry, error) {
+	named, err := reference.ParseNormalizedNamed(imageReference)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ref string
+	var pinned digest.Digest
+	if canonical, ok := named.(reference.Canonical); ok {
+		pinned = canonical.Digest()
+		ref = pinned.String()
+	} else {
+		named = reference.TagNameOnly(named)
+		ref = named.(reference.Tagged).Tag()
+	}
+
+	hub := df.registryForDomain(reference.Domain(named), creds)
+	repository := reference.Path(named)
+
+	body, manifest, err := getManifest(ctx, hub, repository, ref)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error getting manifest of %v", imageReference)
 	}
+	image := &Image{
+		Repository: named.Name(),
+		Digest:     digest.FromBytes(body),
+	}
+	if len(pinned) > 0 && image.Digest != pinned {
+		return nil, nil, fmt.Errorf("manifest of %v has digest %v", imageReference, image.Digest)
+	}
+
+	if manifest.MediaType == v1.MediaTypeImageIndex || manifest.MediaType == manifestlist.MediaTypeManifestList {
+		desc, err := platformManifest(manifest.Manifests)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, manifest, err = getManifest(ctx, hub, repository, desc.Digest.String())
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error getting platform manifest of %v", imageReference)
+		}
+		if digest.FromBytes(body) != desc.Digest {
+			return nil, nil, fmt.Errorf("platform manifest of %v doesn't match digest %v", imageReference, desc.Digest)
+		}
+	}
+
+	if manifest.MediaType != v1.MediaTypeImageManifest && manifest.MediaType != schema2.MediaTypeManifest {
+		return nil, nil, fmt.Errorf("unsupported manifest type %q of %v", manifest.MediaType, imageReference)
+	}
+	image.Layers = manifest.Layers
+
+	return image, hub, nil
+}
+
+// ResolveImage gets the manifest of an image, verifying it against the
+// digest of the reference if it has one.
+func (df *DockerBlobFetcher) ResolveImage(ctx context.Context, imageReference string, creds RegistryCreds) (*Image, error) {
+	image, _, err := df.resolveImage(ctx, imageReference, creds)
+	return image, err
+}
+
+// PinnedReference returns the reference to the image by digest.
+func (image *Image) PinnedReference() string {
+	return image.Repository + "@" + image.Digest.String()
+}
 
-	var lastLayer distribution.Descriptor
-	for _, layer := range manifest.References() {
-		lastLayer = layer
+// DownloadImage extracts the files of an image to the directory at path,
+// applying all its layers in order. creds are used for the registry of the
+// image before those the fetcher was started with.
+func (df *DockerBlobFetcher) DownloadImage(ctx context.Context, imageReference string, creds RegistryCreds, path string) error {
+	image, hub, err := df.resolveImage(ctx, imageReference, creds)
+	if err != nil {
+		return err
 	}
 
-	reader, err := hub.DownloadBlob(ctx, imageName, lastLayer.Digest)
+	named, err := reference.ParseNormalizedNamed(image.Repository)
 	if err != nil {
 		return err
 	}
+	repository := reference.Path(named)
+
+	var size int64
+	for _, layer := range image.Layers {
+		if strings.HasSuffix(layer.MediaType, "+zstd") {
+			return fmt.Errorf("unsupported layer type %v", layer.MediaType)
+		}
+		layerSize, err := df.applyLayer(ctx, hub, repository, layer, path, maxImageSize-size)
+		size += layerSize
+		if err != nil {
+			return errors.Wrapf(err, "error applying layer %v", layer.Digest)
+		}
+	}
+
+	return nil
+}
+
+// applyLayer downloads the layer and extracts it once its digest is
+// verified, returning the size of its files, which can be at most maxSize.
+func (df *DockerBlobFetcher) applyLayer(ctx context.Context, hub *registry.Registry, repository string, layer distribution.Descriptor, path string, maxSize int64) (int64, error) {
+	reader, err := hub.DownloadBlob(ctx, repository, layer.Digest)
+	if err != nil {
+		return 0, err
+	}
 	defer reader.Close()
 
-	verifier := lastLayer.Digest.Verifier()
-	hashingReader := io.TeeReader(reader, verifier)
-	err = tarextract.ExtractTarGz(hashingReader, tmpPath)
+	blobPath := path + ".layer"
+	blob, err := os.OpenFile(blobPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer os.Remove(blobPath)
+	defer blob.Close()
 
+	verifier := layer.Digest.Verifier()
+	_, err = io.Copy(blob, io.TeeReader(reader, verifier))
+	if err != nil {
+		return 0, err
+	}
 	if !verifier.Verified() {
-		return fmt.Errorf("Downloaded blob failed to match digest: %#v", lastLayer.Digest)
+		return 0, fmt.Errorf("Downloaded blob failed to match digest: %#v", layer.Digest)
 	}
 
-	return nil
+	_, err = blob.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return tarextract.ApplyLayer(blob, path, maxSize)
 }
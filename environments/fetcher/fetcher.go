@@ -15,6 +15,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.opencensus.io/trace"
@@ -23,6 +25,7 @@ import (
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/net/context/ctxhttp"
+	apiv1 "k8s.io/api/core/v1"
 	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -32,6 +35,15 @@ import (
 	storageSvcClient "github.com/fission/fission/storagesvc/client"
 )
 
+// Prefix of the files Secrets and ConfigMaps are written to before they
+// replace the files of their keys
+const (
+	secretTmpPrefix = "..tmp-"
+
+	// archiveUrls signed with fetch grants are used right away
+	grantedArchiveUrlTTL = 10 * time.Minute
+)
+
 type (
 	Fetcher struct {
 		sharedVolumePath string
@@ -42,6 +54,17 @@ type (
 		httpClient       *http.Client
 
 		dockerBlobFetcher *DockerBlobFetcher
+
+		// cache of the archives of the node, if any
+		cache *ArchiveCache
+
+		// packageKeysNamespace is the fission namespace, holding the
+		// keys trusted to sign packages, if packages are verified
+		packageKeysNamespace string
+
+		// envVersion is the version of the environment interface of
+		// the runtime, once the pod is specialized
+		envVersion int32
 	}
 )
 
@@ -73,6 +96,78 @@ func MakeFetcher(sharedVolumePath string, sharedSecretPath string, sharedConfigP
 	}, nil
 }
 
+// SetArchiveCache makes the fetcher get archives from the cache, and add
+// those it downloads to it.
+func (fetcher *Fetcher) SetArchiveCache(cache *ArchiveCache) {
+	fetcher.cache = cache
+}
+
+// SetPackageKeysNamespace makes the fetcher verify the signatures of
+// packages with the keys trusted in the ConfigMap of the namespace.
+func (fetcher *Fetcher) SetPackageKeysNamespace(namespace string) {
+	fetcher.packageKeysNamespace = namespace
+}
+
+// getCachedArchive puts the archive of a package of the namespace at path
+// if it's cached for the namespace and intact.
+func (fetcher *Fetcher) getCachedArchive(namespace string, archive *fission.Archive, path string, unarchive bool) bool {
+	if fetcher.cache == nil || !fetcher.cache.Get(namespace, &archive.Checksum, path, unarchive) {
+		return false
+	}
+
+	checksum, err := getChecksum(path)
+	if err == nil {
+		err = verifyChecksum(checksum, &archive.Checksum)
+	}
+	if err != nil {
+		log.Printf("Removing cached archive %v: %v", archive.Checksum.Sum, err)
+		fetcher.cache.Remove(namespace, &archive.Checksum)
+		os.Remove(path)
+		return false
+	}
+
+	log.Printf("Using cached archive %v", archive.Checksum.Sum)
+	return true
+}
+
+// imagePullCreds returns the registry credentials of the image pull
+// secrets of the default service account of the namespace, which pods of
+// the namespace pull their images with.
+func (fetcher *Fetcher) imagePullCreds(namespace string) RegistryCreds {
+	sa, err := fetcher.kubeClient.CoreV1().ServiceAccounts(namespace).Get("default", metav1.GetOptions{})
+	if err != nil {
+		if !k8serr.IsNotFound(err) {
+			log.Printf("Error getting image pull secrets of namespace %v: %v", namespace, err)
+		}
+		return nil
+	}
+
+	creds := RegistryCreds{}
+	for _, ref := range sa.ImagePullSecrets {
+		secret, err := fetcher.kubeClient.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Error getting image pull secret %v in namespace %v: %v", ref.Name, namespace, err)
+			continue
+		}
+		data, ok := secret.Data[apiv1.DockerConfigJsonKey]
+		if !ok {
+			data = secret.Data[apiv1.DockerConfigKey]
+		}
+		secretCreds, err := ParseDockerConfig(data)
+		if err != nil {
+			log.Printf("Error parsing image pull secret %v in namespace %v: %v", ref.Name, namespace, err)
+			continue
+		}
+		// The first secret with credentials for a registry wins
+		for domain, c := range secretCreds {
+			if _, ok := creds[domain]; !ok {
+				creds[domain] = c
+			}
+		}
+	}
+	return creds
+}
+
 func downloadUrl(ctx context.Context, httpClient *http.Client, url string, localPath string) (*fission.Checksum, error) {
 	resp, err := ctxhttp.Get(ctx, httpClient, url)
 	if err != nil {
@@ -106,6 +201,24 @@ func downloadUrl(ctx context.Context, httpClient *http.Client, url string, local
 	}, nil
 }
 
+// isSignedUrlOf returns whether signedUrl is a signed download URL of the
+// same archive; URLs signed before the package was updated aren't used.
+func isSignedUrlOf(signedUrl string, archiveUrl string) bool {
+	if len(signedUrl) == 0 {
+		return false
+	}
+	signed, err := url.Parse(signedUrl)
+	if err != nil {
+		return false
+	}
+	archive, err := url.Parse(archiveUrl)
+	if err != nil {
+		return false
+	}
+	return signed.Host == archive.Host && signed.Path == archive.Path &&
+		signed.Query().Get("id") == archive.Query().Get("id")
+}
+
 func getChecksum(path string) (*fission.Checksum, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -137,16 +250,40 @@ func verifyChecksum(got, expect *fission.Checksum) error {
 	return nil
 }
 
+// writeSecretOrConfigMap writes the keys of a Secret or ConfigMap to files
+// in dirPath and removes the files of keys it no longer has. Each file is
+// written to a temporary file first and renamed over the old one, so that
+// running functions never read a partially written file on reloads.
 func writeSecretOrConfigMap(dataMap map[string][]byte, dirPath string) error {
 	for key, val := range dataMap {
 		writeFilePath := filepath.Join(dirPath, key)
-		err := ioutil.WriteFile(writeFilePath, val, 0600)
+		// Keys can't start with "..", so temporary files never clash with them
+		tmpFilePath := filepath.Join(dirPath, secretTmpPrefix+key)
+		err := ioutil.WriteFile(tmpFilePath, val, 0600)
+		if err == nil {
+			err = os.Rename(tmpFilePath, writeFilePath)
+		}
 		if err != nil {
+			os.Remove(tmpFilePath)
 			e := fmt.Sprintf("Failed to write file %v: %v", writeFilePath, err)
-			log.Printf(e)
+			log.Println(e)
 			return errors.New(e)
 		}
 	}
+
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list directory %v", dirPath)
+	}
+	for _, file := range files {
+		if _, ok := dataMap[file.Name()]; ok || strings.HasPrefix(file.Name(), "..") {
+			continue
+		}
+		err = os.Remove(filepath.Join(dirPath, file.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Failed to remove file %v", file.Name())
+		}
+	}
 	return nil
 }
 
@@ -190,22 +327,35 @@ func (fetcher *Fetcher) FetchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	code, err := fetcher.Fetch(r.Context(), req, filepath.Join(fetcher.sharedVolumePath, req.Filename))
+	fetchResp, code, err := fetcher.Fetch(r.Context(), req, filepath.Join(fetcher.sharedVolumePath, req.Filename))
 	if err != nil {
 		httpError(w, r, err, code)
 		return
 	}
 
 	log.Printf("Checking secrets/cfgmaps")
-	code, err = fetcher.FetchSecretsAndCfgMaps(req.Secrets, req.ConfigMaps)
+	secretPath, configPath := fetcher.sharedSecretPath, fetcher.sharedConfigPath
+	if len(req.SecretsDir) > 0 {
+		dir := filepath.Join(fetcher.sharedVolumePath, filepath.Clean("/"+req.SecretsDir))
+		secretPath, configPath = filepath.Join(dir, "secrets"), filepath.Join(dir, "configs")
+	}
+	code, err = fetcher.fetchSecretsAndCfgMaps(req.Secrets, req.ConfigMaps, secretPath, configPath)
 	if err != nil {
 		httpError(w, r, err, code)
 		return
 	}
 
+	rBody, err := json.Marshal(fetchResp)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
 	log.Printf("Completed fetch request")
 	// all done
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write(rBody)
 }
 
 func (fetcher *Fetcher) SpecializeHandler(w http.ResponseWriter, r *http.Request) {
@@ -234,7 +384,10 @@ func (fetcher *Fetcher) SpecializeHandler(w http.ResponseWriter, r *http.Request
 	err = fetcher.SpecializePod(r.Context(), req.FetchReq, req.LoadReq)
 	if err != nil {
 		log.Printf("Error specializing: %#v %v", req, err)
-		httpError(w, r, err, http.StatusInternalServerError)
+		trace.FromContext(r.Context()).AddAttributes(
+			trace.StringAttribute("error", err.Error()),
+		)
+		fission.WriteSpecializationError(w, specializationError(fission.SpecializationErrorFetchFailed, err), http.StatusInternalServerError)
 		return
 	}
 
@@ -242,23 +395,83 @@ func (fetcher *Fetcher) SpecializeHandler(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 }
 
+// ReloadHandler rewrites the Secrets and ConfigMaps of a reload request
+// and tells the runtime they changed.
+func (fetcher *Fetcher) ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("only POST is supported on this endpoint, %v received", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body")
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	var req fission.FunctionReloadRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		log.Printf("Error reading request body: %v", err)
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	code, err := fetcher.FetchSecretsAndCfgMaps(req.Secrets, req.ConfigMaps)
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+
+	err = fetcher.notifyReload(r.Context(), body)
+	if err != nil {
+		log.Printf("Error notifying runtime of reload: %v", err)
+		httpError(w, r, err, http.StatusBadGateway)
+		return
+	}
+
+	log.Printf("Reloaded %v secrets and %v configmaps", len(req.Secrets), len(req.ConfigMaps))
+	w.WriteHeader(http.StatusOK)
+}
+
+// notifyReload sends the reload request to the runtime of a v3
+// environment, which may re-read the files. Runtimes of older environments
+// read the files again on their own whenever they need them.
+func (fetcher *Fetcher) notifyReload(ctx context.Context, body []byte) error {
+	if atomic.LoadInt32(&fetcher.envVersion) < 3 {
+		return nil
+	}
+
+	resp, err := ctxhttp.Post(ctx, fetcher.httpClient, "http://localhost:8888"+fission.EnvReloadPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	return fission.MakeErrorFromHTTP(resp)
+}
+
 // Fetch takes FetchRequest and makes the fetch call
-// It returns the HTTP code and error if any
-func (fetcher *Fetcher) Fetch(ctx context.Context, req fission.FunctionFetchRequest, destPath string) (int, error) {
+// It returns what it fetched, the HTTP code and error if any
+func (fetcher *Fetcher) Fetch(ctx context.Context, req fission.FunctionFetchRequest, destPath string) (*fission.FunctionFetchResponse, int, error) {
 	// check that the requested filename is not an empty string and error out if so
 	if len(req.Filename) == 0 {
 		e := fmt.Sprintf("Fetch request received for an empty file name, request: %v", req)
 		log.Printf(e)
-		return http.StatusBadRequest, errors.New(e)
+		return nil, http.StatusBadRequest, errors.New(e)
 	}
 
 	// verify first if the file already exists.
 	if _, err := os.Stat(destPath); err == nil {
 		log.Printf("Requested file: %s already exists. Skipping fetch", destPath)
-		return http.StatusOK, nil
+		return &fission.FunctionFetchResponse{}, http.StatusOK, nil
 	}
 
 	tmpPath := destPath + ".tmp"
+	resp := &fission.FunctionFetchResponse{}
 
 	if req.FetchType == fission.FETCH_URL {
 		// fetch the file and save it to the tmp path
@@ -266,7 +479,7 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, req fission.FunctionFetchRequ
 		if err != nil {
 			e := fmt.Sprintf("Failed to download url %s %v: %v; %#v", req.Url, tmpPath, err, req)
 			log.Printf(e)
-			return http.StatusBadRequest, errors.New(e)
+			return nil, http.StatusBadRequest, errors.New(e)
 		}
 	} else {
 		// get pkg
@@ -274,7 +487,7 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, req fission.FunctionFetchRequ
 		if err != nil {
 			e := fmt.Sprintf("Failed to get package: %v", err)
 			log.Printf(e)
-			return http.StatusInternalServerError, errors.New(e)
+			return nil, http.StatusInternalServerError, errors.New(e)
 		}
 
 		var archive *fission.Archive
@@ -288,7 +501,7 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, req fission.FunctionFetchRequ
 			if pkg.Status.BuildStatus != fission.BuildStatusSucceeded && pkg.Status.BuildStatus != fission.BuildStatusNone {
 				e := fmt.Sprintf("Build status for the function's pkg : %s.%s is : %s, can't fetch deployment", pkg.Metadata.Name, pkg.Metadata.Namespace, pkg.Status.BuildStatus)
 				log.Printf(e)
-				return http.StatusInternalServerError, errors.New(e)
+				return nil, http.StatusInternalServerError, &fission.SpecializationError{Reason: fission.SpecializationErrorPackageNotReady, Message: e}
 			}
 			archive = &pkg.Spec.Deployment
 		}
@@ -299,32 +512,70 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, req fission.FunctionFetchRequ
 			if err != nil {
 				e := fmt.Sprintf("Failed to write file %v: %v", tmpPath, err)
 				log.Printf(e)
-				return http.StatusInternalServerError, errors.New(e)
+				return nil, http.StatusInternalServerError, errors.New(e)
 			}
 		} else if len(archive.URL) > 0 {
-			// download and verify
-			checksum, err := downloadUrl(ctx, fetcher.httpClient, archive.URL, tmpPath)
-			if err != nil {
-				e := fmt.Sprintf("Failed to download url %#v %v: %v", archive.URL, tmpPath, err)
-				log.Printf(e)
-				return http.StatusBadRequest, errors.New(e)
-			}
+			if !fetcher.getCachedArchive(pkg.Metadata.Namespace, archive, tmpPath, !req.KeepArchive) {
+				archiveUrl := archive.URL
+				if isSignedUrlOf(req.ArchiveUrl, archive.URL) {
+					archiveUrl = req.ArchiveUrl
+				} else if len(req.ArchiveGrant) > 0 {
+					archiveUrl, err = storageSvcClient.SignWithFetchGrant(ctx, archive.URL, req.ArchiveGrant, grantedArchiveUrlTTL)
+					if err != nil {
+						e := fmt.Sprintf("Failed to sign url %#v: %v", archive.URL, err)
+						log.Println(e)
+						return nil, http.StatusInternalServerError, errors.New(e)
+					}
+				}
 
-			err = verifyChecksum(checksum, &archive.Checksum)
-			if err != nil {
-				e := fmt.Sprintf("Failed to verify checksum: %v", err)
-				log.Printf(e)
-				return http.StatusBadRequest, errors.New(e)
+				// download and verify
+				checksum, err := downloadUrl(ctx, fetcher.httpClient, archiveUrl, tmpPath)
+				if err != nil {
+					e := fmt.Sprintf("Failed to download url %#v %v: %v", archive.URL, tmpPath, err)
+					log.Println(e)
+					return nil, http.StatusBadRequest, errors.New(e)
+				}
+
+				err = verifyChecksum(checksum, &archive.Checksum)
+				if err != nil {
+					e := fmt.Sprintf("Failed to verify checksum: %v", err)
+					log.Println(e)
+					return nil, http.StatusBadRequest, &fission.SpecializationError{Reason: fission.SpecializationErrorChecksumMismatch, Message: e}
+				}
+
+				if fetcher.cache != nil {
+					fetcher.cache.Put(pkg.Metadata.Namespace, checksum, tmpPath, !req.KeepArchive)
+				}
 			}
 		} else if len(archive.Image) > 0 {
-			err := fetcher.dockerBlobFetcher.DownloadFinalLayer(ctx, archive.Image, tmpPath)
+			creds := fetcher.imagePullCreds(pkg.Metadata.Namespace)
+			err := fetcher.dockerBlobFetcher.DownloadImage(ctx, archive.Image, creds, tmpPath)
 			if err != nil {
-				return http.StatusInternalServerError, err
+				e := fmt.Sprintf("Failed to download image %v: %v", archive.Image, err)
+				log.Println(e)
+				os.RemoveAll(tmpPath)
+				return nil, http.StatusBadRequest, errors.New(e)
 			}
+		} else if archive.Git != nil {
+			commit, err := fetcher.fetchGitSource(ctx, pkg.Metadata.Namespace, archive.Git, tmpPath)
+			if err != nil {
+				e := fmt.Sprintf("Failed to fetch git source %v: %v", archive.Git.URL, err)
+				log.Println(e)
+				return nil, http.StatusBadRequest, errors.New(e)
+			}
+			resp.GitCommit = commit
 		} else {
 			e := fmt.Sprintf("Nothing to fetch")
 			log.Printf(e)
-			return http.StatusBadRequest, errors.New(e)
+			return nil, http.StatusBadRequest, errors.New(e)
+		}
+
+		err = fetcher.verifyArchive(pkg, archive, tmpPath, req.FetchType == fission.FETCH_DEPLOYMENT)
+		if err != nil {
+			e := fmt.Sprintf("Failed to verify package signature: %v", err)
+			log.Println(e)
+			os.RemoveAll(tmpPath)
+			return nil, http.StatusForbidden, &fission.SpecializationError{Reason: fission.SpecializationErrorInvalidArchive, Message: e}
 		}
 	}
 
@@ -340,7 +591,7 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, req fission.FunctionFetchRequ
 			err := fetcher.unarchive(useArchiver, tmpPath, tmpUnarchivePath)
 			if err != nil {
 				log.Println(err.Error())
-				return http.StatusInternalServerError, err
+				return nil, http.StatusInternalServerError, &fission.SpecializationError{Reason: fission.SpecializationErrorInvalidArchive, Message: err.Error()}
 			}
 
 			tmpPath = tmpUnarchivePath
@@ -351,16 +602,23 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, req fission.FunctionFetchRequ
 	err := fetcher.rename(tmpPath, destPath)
 	if err != nil {
 		log.Println(err.Error())
-		return http.StatusInternalServerError, err
+		return nil, http.StatusInternalServerError, err
 	}
 
 	log.Printf("Successfully placed at %v", destPath)
-	return http.StatusOK, nil
+	return resp, http.StatusOK, nil
 }
 
 // FetchSecretsAndCfgMaps fetches secrets and configmaps specified by user
 // It returns the HTTP code and error if any
 func (fetcher *Fetcher) FetchSecretsAndCfgMaps(secrets []fission.SecretReference, cfgmaps []fission.ConfigMapReference) (int, error) {
+	return fetcher.fetchSecretsAndCfgMaps(secrets, cfgmaps, fetcher.sharedSecretPath, fetcher.sharedConfigPath)
+}
+
+// fetchSecretsAndCfgMaps writes secrets and configmaps into
+// <secretPath|configPath>/<namespace>/<name>/<key>
+func (fetcher *Fetcher) fetchSecretsAndCfgMaps(secrets []fission.SecretReference, cfgmaps []fission.ConfigMapReference,
+	sharedSecretPath string, sharedConfigPath string) (int, error) {
 	if len(secrets) > 0 {
 		for _, secret := range secrets {
 			data, err := fetcher.kubeClient.CoreV1().Secrets(secret.Namespace).Get(secret.Name, metav1.GetOptions{})
@@ -378,7 +636,7 @@ func (fetcher *Fetcher) FetchSecretsAndCfgMaps(secrets []fission.SecretReference
 			}
 
 			secretPath := filepath.Join(secret.Namespace, secret.Name)
-			secretDir := filepath.Join(fetcher.sharedSecretPath, secretPath)
+			secretDir := filepath.Join(sharedSecretPath, secretPath)
 			err = os.MkdirAll(secretDir, os.ModeDir|0644)
 			if err != nil {
 				e := fmt.Sprintf("Failed to create directory %v: %v", secretDir, err)
@@ -409,7 +667,7 @@ func (fetcher *Fetcher) FetchSecretsAndCfgMaps(secrets []fission.SecretReference
 			}
 
 			configPath := filepath.Join(config.Namespace, config.Name)
-			configDir := filepath.Join(fetcher.sharedConfigPath, configPath)
+			configDir := filepath.Join(sharedConfigPath, configPath)
 			err = os.MkdirAll(configDir, os.ModeDir|0644)
 			if err != nil {
 				e := fmt.Sprintf("Failed to create directory %v: %v", configDir, err)
@@ -430,6 +688,50 @@ func (fetcher *Fetcher) FetchSecretsAndCfgMaps(secrets []fission.SecretReference
 	return http.StatusOK, nil
 }
 
+// resolveEnv returns the values of environment variables of a function,
+// reading those that refer to keys of Secrets and ConfigMaps of its
+// namespace.
+func (fetcher *Fetcher) resolveEnv(namespace string, envVars []apiv1.EnvVar) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, e := range envVars {
+		if e.ValueFrom == nil {
+			env[e.Name] = e.Value
+			continue
+		}
+
+		var value string
+		var found, optional bool
+		if ref := e.ValueFrom.SecretKeyRef; ref != nil {
+			optional = ref.Optional != nil && *ref.Optional
+			secret, err := fetcher.kubeClient.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+			if err != nil && !(optional && k8serr.IsNotFound(err)) {
+				return nil, errors.Wrapf(err, "Failed to get secret %v of %v", ref.Name, e.Name)
+			} else if err == nil {
+				var data []byte
+				data, found = secret.Data[ref.Key]
+				value = string(data)
+			}
+		} else if ref := e.ValueFrom.ConfigMapKeyRef; ref != nil {
+			optional = ref.Optional != nil && *ref.Optional
+			cm, err := fetcher.kubeClient.CoreV1().ConfigMaps(namespace).Get(ref.Name, metav1.GetOptions{})
+			if err != nil && !(optional && k8serr.IsNotFound(err)) {
+				return nil, errors.Wrapf(err, "Failed to get configmap %v of %v", ref.Name, e.Name)
+			} else if err == nil {
+				value, found = cm.Data[ref.Key]
+			}
+		} else {
+			return nil, fmt.Errorf("Unsupported value source of %v", e.Name)
+		}
+
+		if found {
+			env[e.Name] = value
+		} else if !optional {
+			return nil, fmt.Errorf("Key of %v not found", e.Name)
+		}
+	}
+	return env, nil
+}
+
 func (fetcher *Fetcher) UploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "only POST is supported on this endpoint", http.StatusMethodNotAllowed)
@@ -484,7 +786,7 @@ func (fetcher *Fetcher) UploadHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Starting upload...")
 	ssClient := storageSvcClient.MakeClient(req.StorageSvcUrl)
 
-	fileID, err := ssClient.Upload(r.Context(), dstFilepath, nil)
+	fileID, err := ssClient.Upload(r.Context(), dstFilepath, &req.Metadata)
 	if err != nil {
 		e := fmt.Sprintf("Error uploading zip file: %v", err)
 		log.Println(e)
@@ -563,14 +865,24 @@ func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq fission.Func
 		log.Printf("Elapsed time in fetch request = %v", elapsed)
 	}()
 
-	_, err := fetcher.Fetch(ctx, fetchReq, loadReq.FilePath)
+	_, _, err := fetcher.Fetch(ctx, fetchReq, loadReq.FilePath)
 	if err != nil {
-		return errors.Wrap(err, "Error fetching deploy package")
+		return specializationError(fission.SpecializationErrorFetchFailed,
+			errors.Wrap(err, "Error fetching deploy package"))
 	}
 
 	_, err = fetcher.FetchSecretsAndCfgMaps(fetchReq.Secrets, fetchReq.ConfigMaps)
 	if err != nil {
-		return errors.Wrap(err, "Error fetching secrets/configmaps")
+		return specializationError(fission.SpecializationErrorSecretsFailed,
+			errors.Wrap(err, "Error fetching secrets/configmaps"))
+	}
+
+	if len(fetchReq.Env) > 0 && loadReq.FunctionMetadata != nil {
+		loadReq.Env, err = fetcher.resolveEnv(loadReq.FunctionMetadata.Namespace, fetchReq.Env)
+		if err != nil {
+			return specializationError(fission.SpecializationErrorSecretsFailed,
+				errors.Wrap(err, "Error resolving environment variables"))
+		}
 	}
 
 	// Specialize the pod
@@ -582,7 +894,8 @@ func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq fission.Func
 
 	loadPayload, err := json.Marshal(loadReq)
 	if err != nil {
-		return errors.Wrap(err, "Error encoding load request")
+		return specializationError(fission.SpecializationErrorLoadFailed,
+			errors.Wrap(err, "Error encoding load request"))
 	}
 
 	if loadReq.EnvVersion >= 2 {
@@ -596,10 +909,18 @@ func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq fission.Func
 	}
 
 	for i := 0; i < maxRetries; i++ {
-		resp, err := ctxhttp.Post(ctx, fetcher.httpClient, specializeURL, contentType, reader)
+		var resp *http.Response
+		resp, err = ctxhttp.Post(ctx, fetcher.httpClient, specializeURL, contentType, reader)
 		if err == nil && resp.StatusCode < 300 {
 			// Success
 			resp.Body.Close()
+			if loadReq.EnvVersion >= 3 {
+				err = fetcher.waitForLoad(ctx, loadReq)
+				if err != nil {
+					return specializationError(fission.SpecializationErrorLoadFailed, err)
+				}
+			}
+			atomic.StoreInt32(&fetcher.envVersion, int32(loadReq.EnvVersion))
 			return nil
 		}
 
@@ -616,12 +937,65 @@ func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq fission.Func
 			}
 		}
 
-		if err == nil {
-			err = fission.MakeErrorFromHTTP(resp)
+		if err != nil {
+			return specializationError(fission.SpecializationErrorRuntimeUnavailable,
+				errors.Wrap(err, "Error specializing function pod"))
 		}
+		// The runtime failed to load the function
+		return specializationError(fission.SpecializationErrorLoadFailed,
+			errors.Wrap(fission.MakeErrorFromHTTP(resp), "Error specializing function pod"))
+	}
+
+	return specializationError(fission.SpecializationErrorRuntimeUnavailable,
+		errors.Wrap(err, fmt.Sprintf("Error specializing function pod after %v times", maxRetries)))
+}
+
+// specializationError describes err as a SpecializationError, keeping
+// the reason of the error Fetch returned, if any.
+func specializationError(reason fission.SpecializationErrorReason, err error) *fission.SpecializationError {
+	if cause, ok := errors.Cause(err).(*fission.SpecializationError); ok {
+		reason = cause.Reason
+	}
+	return &fission.SpecializationError{Reason: reason, Message: err.Error()}
+}
 
-		return errors.Wrap(err, "Error specializing function pod")
+// waitForLoad polls the load status of the function in a v3 runtime
+// until the runtime loaded it or failed to.
+func (fetcher *Fetcher) waitForLoad(ctx context.Context, loadReq fission.FunctionLoadRequest) error {
+	statusURL := "http://localhost:8888" + fission.EnvLoadStatusPath
+	if loadReq.FunctionMetadata != nil {
+		statusURL += "?uid=" + url.QueryEscape(string(loadReq.FunctionMetadata.UID))
 	}
 
-	return errors.Wrap(err, fmt.Sprintf("Error specializing function pod after %v times", maxRetries))
+	for {
+		resp, err := ctxhttp.Get(ctx, fetcher.httpClient, statusURL)
+		if err != nil {
+			return errors.Wrap(err, "Error getting load status of function")
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = fission.MakeErrorFromHTTP(resp)
+			resp.Body.Close()
+			return errors.Wrap(err, "Error getting load status of function")
+		}
+
+		var status fission.FunctionLoadStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if err != nil {
+			return errors.Wrap(err, "Error decoding load status of function")
+		}
+
+		switch status.State {
+		case fission.FunctionLoadStateLoaded:
+			return nil
+		case fission.FunctionLoadStateFailed:
+			return fmt.Errorf("Error loading function: %v", status.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "Error waiting for function to load")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
 }
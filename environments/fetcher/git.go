@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+)
+
+// Keys of the credentials in the secret of a git source; the same as the
+// ones of the kubernetes.io/basic-auth and kubernetes.io/ssh-auth secret
+// types.
+const (
+	gitSecretUsername   = "username"
+	gitSecretPassword   = "password"
+	gitSecretSSHKey     = "ssh-privatekey"
+	gitSecretKnownHosts = "known_hosts"
+)
+
+// gitProtocols are the transports git may use to fetch sources; it must
+// not read local repositories of the fetcher.
+const gitProtocols = "https:ssh:git"
+
+type gitCommand struct {
+	ctx context.Context
+	dir string
+	env []string
+}
+
+func (gc *gitCommand) run(args ...string) (string, error) {
+	cmd := exec.CommandContext(gc.ctx, "git", args...)
+	cmd.Dir = gc.dir
+	cmd.Env = gc.env
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %v: %v: %v", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// fetchGitSource clones the git source of a package in the namespace into
+// destPath, and returns the SHA of the commit it checked out.
+func (fetcher *Fetcher) fetchGitSource(ctx context.Context, namespace string,
+	src *fission.GitSource, destPath string) (string, error) {
+
+	if strings.HasPrefix(src.URL, "-") || strings.HasPrefix(src.Ref, "-") {
+		return "", fmt.Errorf("invalid git source %v at %v", src.URL, src.Ref)
+	}
+
+	workDir := filepath.Join(fetcher.sharedVolumePath, uuid.NewV4().String())
+	err := os.MkdirAll(workDir, 0700)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+
+	repoPath := filepath.Join(workDir, "repo")
+	err = os.Mkdir(repoPath, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	git := &gitCommand{
+		ctx: ctx,
+		dir: repoPath,
+		env: append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+gitProtocols),
+	}
+
+	_, err = git.run("init", "-q")
+	if err != nil {
+		return "", err
+	}
+	if len(src.Secret) > 0 {
+		err = fetcher.setupGitCredentials(git, namespace, src.Secret, workDir)
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err = git.run("remote", "add", "--", "origin", src.URL)
+	if err != nil {
+		return "", err
+	}
+
+	ref := src.Ref
+	if len(ref) == 0 {
+		ref = "HEAD"
+	}
+
+	// A shallow fetch of the ref works for branches, tags and, on most
+	// servers, full commit SHAs; fall back to fetching everything for
+	// the rest, e.g. abbreviated SHAs.
+	_, err = git.run("fetch", "-q", "--depth", "1", "--", "origin", ref)
+	if err == nil {
+		_, err = git.run("checkout", "-q", "FETCH_HEAD")
+	} else {
+		log.Printf("Shallow fetch of %v at %v failed, fetching the whole repository: %v", src.URL, ref, err)
+		_, err = git.run("fetch", "-q", "--tags", "--", "origin", "+refs/heads/*:refs/remotes/origin/*")
+		if err != nil {
+			return "", err
+		}
+		commit, err := git.run("rev-parse", "--verify", "-q", ref+"^{commit}")
+		if err != nil {
+			commit, err = git.run("rev-parse", "--verify", "-q", "origin/"+ref+"^{commit}")
+			if err != nil {
+				return "", fmt.Errorf("ref %v not found in %v", ref, src.URL)
+			}
+		}
+		_, err = git.run("checkout", "-q", commit)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	commit, err := git.run("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	err = os.RemoveAll(filepath.Join(repoPath, ".git"))
+	if err != nil {
+		return "", err
+	}
+
+	srcPath := repoPath
+	if len(src.SubDir) > 0 {
+		srcPath = filepath.Join(repoPath, filepath.Clean("/"+src.SubDir))
+		fi, err := os.Stat(srcPath)
+		if err != nil || !fi.IsDir() {
+			return "", fmt.Errorf("directory %v not found in %v at %v", src.SubDir, src.URL, commit)
+		}
+	}
+
+	err = os.Rename(srcPath, destPath)
+	if err != nil {
+		return "", errors.Wrap(err, "error moving git source into place")
+	}
+
+	log.Printf("Fetched %v at %v (commit %v)", src.URL, ref, commit)
+	return commit, nil
+}
+
+// setupGitCredentials makes git use the credentials in the secret, writing
+// SSH keys into workDir and HTTP credentials into the configuration of the
+// repository initialized in workDir, so that neither shows up in the
+// arguments of a process.
+func (fetcher *Fetcher) setupGitCredentials(git *gitCommand, namespace string, secretName string, workDir string) error {
+	secret, err := fetcher.kubeClient.CoreV1().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error getting git credentials secret %v", secretName)
+	}
+
+	if key, ok := secret.Data[gitSecretSSHKey]; ok {
+		keyPath := filepath.Join(workDir, "id")
+		err = ioutil.WriteFile(keyPath, key, 0600)
+		if err != nil {
+			return err
+		}
+
+		sshCommand := fmt.Sprintf("ssh -i %v -o IdentitiesOnly=yes", keyPath)
+		if knownHosts, ok := secret.Data[gitSecretKnownHosts]; ok {
+			knownHostsPath := filepath.Join(workDir, "known_hosts")
+			err = ioutil.WriteFile(knownHostsPath, knownHosts, 0600)
+			if err != nil {
+				return err
+			}
+			sshCommand += fmt.Sprintf(" -o UserKnownHostsFile=%v -o StrictHostKeyChecking=yes", knownHostsPath)
+		} else {
+			log.Printf("Git credentials secret %v has no %v, not verifying SSH host keys", secretName, gitSecretKnownHosts)
+			sshCommand += " -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no"
+		}
+		git.env = append(git.env, "GIT_SSH_COMMAND="+sshCommand)
+		return nil
+	}
+
+	username, password := secret.Data[gitSecretUsername], secret.Data[gitSecretPassword]
+	if len(username) == 0 && len(password) == 0 {
+		return fmt.Errorf("git credentials secret %v has neither %v nor %v and %v",
+			secretName, gitSecretSSHKey, gitSecretUsername, gitSecretPassword)
+	}
+
+	// Pass the credentials as a header rather than in the URL, so that
+	// they don't show up in error messages.
+	auth := base64.StdEncoding.EncodeToString([]byte(string(username) + ":" + string(password)))
+	config, err := os.OpenFile(filepath.Join(git.dir, ".git", "config"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(config, "[http]\n\textraHeader = Authorization: Basic %v\n", auth)
+	if err != nil {
+		config.Close()
+		return err
+	}
+	return config.Close()
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd/fake"
+)
+
+func TestGitCredentials(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("Skipping test, git not installed")
+	}
+	dir, err := ioutil.TempDir("", "fetcher-git-")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := fake.NewAPIServer()
+	defer server.Close()
+	_, kubernetesClient := server.Clients()
+	server.Add("/api/v1/namespaces/team-a/secrets/creds", &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds"},
+		Data: map[string][]byte{
+			gitSecretUsername: []byte("user"),
+			gitSecretPassword: []byte("secret"),
+		},
+	})
+	fetcher := &Fetcher{sharedVolumePath: dir, kubeClient: kubernetesClient}
+
+	git := &gitCommand{ctx: context.Background(), dir: dir, env: os.Environ()}
+	if _, err := git.run("init", "-q"); err != nil {
+		t.Fatalf("error initializing repository: %v", err)
+	}
+	err = fetcher.setupGitCredentials(git, "team-a", "creds", dir)
+	if err != nil {
+		t.Fatalf("error setting up credentials: %v", err)
+	}
+
+	// The credentials are in the configuration of the repository rather
+	// than in the arguments or the environment of git
+	header, err := git.run("config", "http.extraHeader")
+	expected := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	if err != nil || header != expected {
+		t.Fatalf("expected header %q in repository configuration, got %q, %v", expected, header, err)
+	}
+	for _, e := range git.env {
+		if strings.Contains(e, "Authorization") {
+			t.Fatalf("expected credentials not to be in the environment of git")
+		}
+	}
+}
+
+func TestGitSourceProtocols(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("Skipping test, git not installed")
+	}
+	dir, err := ioutil.TempDir("", "fetcher-git-")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A repository on the file system of the fetcher
+	repoPath := filepath.Join(dir, "local")
+	err = os.Mkdir(repoPath, 0700)
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	git := &gitCommand{ctx: context.Background(), dir: repoPath, env: os.Environ()}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "test"},
+	} {
+		if _, err := git.run(args...); err != nil {
+			t.Fatalf("error creating repository: %v", err)
+		}
+	}
+
+	fetcher := &Fetcher{sharedVolumePath: dir}
+	for _, u := range []string{repoPath, "file://" + repoPath} {
+		_, err := fetcher.fetchGitSource(context.Background(), "default", &fission.GitSource{URL: u}, filepath.Join(dir, "src"))
+		if err == nil {
+			t.Fatalf("expected fetching local repository %v to fail", u)
+		}
+	}
+}
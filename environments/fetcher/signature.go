@@ -0,0 +1,240 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// TrustedKeysConfigMap is the ConfigMap in the fission namespace holding
+// the public keys that sign packages, keyed by "<namespace>.<key ID>".
+// Functions in namespaces with trusted keys only specialize with signed
+// deployment archives.
+const TrustedKeysConfigMap = "fission-package-keys"
+
+// oidEd25519 identifies ed25519 keys in PKIX structures (RFC 8410), which
+// crypto/x509 of the Go release fission is built with doesn't parse.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+type (
+	trustedKey struct {
+		id  string
+		key crypto.PublicKey
+	}
+
+	subjectPublicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+
+	ecdsaSignature struct {
+		R, S *big.Int
+	}
+)
+
+// parsePKIXPublicKey parses a DER encoded PKIX ed25519 or ECDSA public key.
+func parsePKIXPublicKey(der []byte) (crypto.PublicKey, error) {
+	var spki subjectPublicKeyInfo
+	rest, err := asn1.Unmarshal(der, &spki)
+	if err == nil && len(rest) == 0 && spki.Algorithm.Algorithm.Equal(oidEd25519) {
+		if len(spki.PublicKey.Bytes) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("ed25519 public key has %v bytes, expected %v", len(spki.PublicKey.Bytes), ed25519.PublicKeySize)
+		}
+		return ed25519.PublicKey(spki.PublicKey.Bytes), nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(*ecdsa.PublicKey); !ok {
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	return key, nil
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key, or a base64 encoded
+// raw ed25519 key.
+func parsePublicKey(data string) (crypto.PublicKey, error) {
+	if block, _ := pem.Decode([]byte(data)); block != nil {
+		return parsePKIXPublicKey(block.Bytes)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "key is neither PEM nor base64 encoded")
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ed25519 public key has %v bytes, expected %v", len(raw), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// trustedKeys returns the public keys trusted in the namespace. Only the
+// fission namespace holds them, so that functions can't trust keys of
+// their own; if its ConfigMap can't be read, nothing is trusted.
+func (fetcher *Fetcher) trustedKeys(namespace string) ([]trustedKey, error) {
+	if len(fetcher.packageKeysNamespace) == 0 {
+		return nil, nil
+	}
+	cm, err := fetcher.kubeClient.CoreV1().ConfigMaps(fetcher.packageKeysNamespace).Get(TrustedKeysConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting ConfigMap %v in namespace %v", TrustedKeysConfigMap, fetcher.packageKeysNamespace)
+	}
+	return parseTrustedKeys(cm.Data, namespace)
+}
+
+// parseTrustedKeys returns the keys of the namespace in the data of the
+// trusted keys ConfigMap. Namespace names can't contain dots, so the key
+// ID is what follows the first one.
+func parseTrustedKeys(cmData map[string]string, namespace string) ([]trustedKey, error) {
+	var keys []trustedKey
+	found := false
+	for name, data := range cmData {
+		if !strings.HasPrefix(name, namespace+".") {
+			continue
+		}
+		found = true
+		id := strings.TrimPrefix(name, namespace+".")
+		key, err := parsePublicKey(data)
+		if err != nil {
+			// One bad key doesn't stop the others from verifying
+			log.Printf("Error parsing trusted key %v in namespace %v: %v", id, namespace, err)
+			continue
+		}
+		keys = append(keys, trustedKey{id: id, key: key})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].id < keys[j].id })
+
+	if found && len(keys) == 0 {
+		return nil, fmt.Errorf("ConfigMap %v has no valid keys for namespace %v", TrustedKeysConfigMap, namespace)
+	}
+	return keys, nil
+}
+
+// verifySignature verifies the signature of the digest with the trusted
+// key it names, or with any trusted key of its type.
+func verifySignature(keys []trustedKey, sig *fission.ArchiveSignature, digest []byte) error {
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return errors.Wrap(err, "error decoding signature")
+	}
+
+	for _, k := range keys {
+		if len(sig.KeyID) > 0 && k.id != sig.KeyID {
+			continue
+		}
+		switch key := k.key.(type) {
+		case ed25519.PublicKey:
+			if sig.Type == fission.SignatureTypeEd25519 && ed25519.Verify(key, digest, value) {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if sig.Type == fission.SignatureTypeECDSA && verifyECDSA(key, digest, value) {
+				return nil
+			}
+		}
+	}
+
+	if len(sig.KeyID) > 0 {
+		return fmt.Errorf("signature isn't valid for trusted key %v", sig.KeyID)
+	}
+	return fmt.Errorf("signature isn't valid for any trusted %v key", sig.Type)
+}
+
+// verifyECDSA verifies an ASN.1 DER encoded ECDSA signature.
+func verifyECDSA(key *ecdsa.PublicKey, digest []byte, value []byte) bool {
+	var sig ecdsaSignature
+	rest, err := asn1.Unmarshal(value, &sig)
+	if err != nil || len(rest) > 0 || sig.R == nil || sig.S == nil || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return false
+	}
+	return ecdsa.Verify(key, digest, sig.R, sig.S)
+}
+
+// verifyArchive checks the signature of the fetched archive at path
+// against the keys trusted for the package's namespace. Deployment
+// archives must be signed once the namespace has trusted keys, and aren't
+// fetched if the keys can't be read; source archives are only checked if
+// they're signed.
+func (fetcher *Fetcher) verifyArchive(pkg *crd.Package, archive *fission.Archive, path string, required bool) error {
+	keys, err := fetcher.trustedKeys(pkg.Metadata.Namespace)
+	if err != nil {
+		if !required {
+			log.Printf("Not verifying archive of package %v: error getting trusted keys: %v", pkg.Metadata.Name, err)
+			return nil
+		}
+		return errors.Wrap(err, "error getting trusted keys")
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if archive.Signature == nil {
+		if required {
+			return fmt.Errorf("archive of package %v is not signed, and namespace %v only runs signed packages",
+				pkg.Metadata.Name, pkg.Metadata.Namespace)
+		}
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("archive of package %v is a directory, which can't be signed", pkg.Metadata.Name)
+	}
+
+	// The digest is computed from the fetched archive, since the checksum
+	// is part of the package like the signature
+	checksum, err := getChecksum(path)
+	if err != nil {
+		return err
+	}
+	digest, err := hex.DecodeString(checksum.Sum)
+	if err != nil {
+		return err
+	}
+	err = verifySignature(keys, archive.Signature, digest)
+	if err != nil {
+		return errors.Wrapf(err, "error verifying archive of package %v", pkg.Metadata.Name)
+	}
+
+	log.Printf("Verified signature of package %v", pkg.Metadata.Name)
+	return nil
+}
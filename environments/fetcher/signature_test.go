@@ -0,0 +1,194 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// testEd25519PublicKey is an ed25519 public key made by openssl, PKIX
+// encoded.
+const testEd25519PublicKey = `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAX1nR0xPwoF+EL/q7o2/Xev+ELRAHk+A6Y7cfFLVzpos=
+-----END PUBLIC KEY-----
+`
+
+func makeTestECDSAKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("error encoding public key: %v", err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func signTestECDSA(t *testing.T, key *ecdsa.PrivateKey, digest []byte) string {
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	value, err := asn1.Marshal(ecdsaSignature{R: r, S: s})
+	if err != nil {
+		t.Fatalf("error encoding signature: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(value)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, err := parsePublicKey(testEd25519PublicKey)
+	if err != nil {
+		t.Fatalf("error parsing PKIX ed25519 key: %v", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(edKey) != ed25519.PublicKeySize {
+		t.Fatalf("expected ed25519 public key, got %T", key)
+	}
+
+	raw, err := parsePublicKey(base64.StdEncoding.EncodeToString(edKey))
+	if err != nil || string(raw.(ed25519.PublicKey)) != string(edKey) {
+		t.Fatalf("error parsing raw ed25519 key: %v", err)
+	}
+
+	_, ecdsaPEM := makeTestECDSAKey(t)
+	if key, err := parsePublicKey(ecdsaPEM); err != nil {
+		t.Fatalf("error parsing ECDSA key: %v", err)
+	} else if _, ok := key.(*ecdsa.PublicKey); !ok {
+		t.Fatalf("expected ECDSA public key, got %T", key)
+	}
+
+	if _, err := parsePublicKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Fatalf("short raw key was parsed")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	digest := sha256.Sum256([]byte("archive"))
+
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	ecdsaKey, _ := makeTestECDSAKey(t)
+	keys := []trustedKey{
+		{id: "ecdsa", key: &ecdsaKey.PublicKey},
+		{id: "ed", key: edPub},
+	}
+
+	edSig := &fission.ArchiveSignature{
+		Type:  fission.SignatureTypeEd25519,
+		Value: base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, digest[:])),
+	}
+	if err := verifySignature(keys, edSig, digest[:]); err != nil {
+		t.Fatalf("error verifying ed25519 signature: %v", err)
+	}
+	ecdsaSig := &fission.ArchiveSignature{
+		Type:  fission.SignatureTypeECDSA,
+		KeyID: "ecdsa",
+		Value: signTestECDSA(t, ecdsaKey, digest[:]),
+	}
+	if err := verifySignature(keys, ecdsaSig, digest[:]); err != nil {
+		t.Fatalf("error verifying ECDSA signature: %v", err)
+	}
+
+	// Signatures only verify the digest they're of, with the key they name
+	other := sha256.Sum256([]byte("other archive"))
+	if err := verifySignature(keys, edSig, other[:]); err == nil {
+		t.Fatalf("signature of another digest was verified")
+	}
+	if err := verifySignature(keys, ecdsaSig, other[:]); err == nil {
+		t.Fatalf("ECDSA signature of another digest was verified")
+	}
+	named := *edSig
+	named.KeyID = "ecdsa"
+	if err := verifySignature(keys, &named, digest[:]); err == nil {
+		t.Fatalf("signature was verified with a key it doesn't name")
+	}
+
+	// Signatures of untrusted keys aren't verified
+	_, untrusted, _ := ed25519.GenerateKey(rand.Reader)
+	wrongKey := &fission.ArchiveSignature{
+		Type:  fission.SignatureTypeEd25519,
+		Value: base64.StdEncoding.EncodeToString(ed25519.Sign(untrusted, digest[:])),
+	}
+	if err := verifySignature(keys, wrongKey, digest[:]); err == nil {
+		t.Fatalf("signature of an untrusted key was verified")
+	}
+	otherECDSA, _ := makeTestECDSAKey(t)
+	wrongKey = &fission.ArchiveSignature{Type: fission.SignatureTypeECDSA, Value: signTestECDSA(t, otherECDSA, digest[:])}
+	if err := verifySignature(keys, wrongKey, digest[:]); err == nil {
+		t.Fatalf("ECDSA signature of an untrusted key was verified")
+	}
+
+	if err := verifySignature(nil, edSig, digest[:]); err == nil {
+		t.Fatalf("signature was verified without trusted keys")
+	}
+	if verifyECDSA(&ecdsaKey.PublicKey, digest[:], []byte("not asn1")) {
+		t.Fatalf("malformed ECDSA signature was verified")
+	}
+}
+
+func TestParseTrustedKeys(t *testing.T) {
+	_, ecdsaPEM := makeTestECDSAKey(t)
+	data := map[string]string{
+		"team-a.release": testEd25519PublicKey,
+		"team-a.ci":      ecdsaPEM,
+		"team-b.release": ecdsaPEM,
+		"team-c.broken":  "not a key",
+	}
+
+	keys, err := parseTrustedKeys(data, "team-a")
+	if err != nil || len(keys) != 2 || keys[0].id != "ci" || keys[1].id != "release" {
+		t.Fatalf("unexpected keys of team-a: %+v, %v", keys, err)
+	}
+
+	// Namespaces only trust their own keys, and those without keys don't
+	// need signatures
+	if keys, err := parseTrustedKeys(data, "team"); err != nil || len(keys) != 0 {
+		t.Fatalf("expected no keys for namespace team, got %+v, %v", keys, err)
+	}
+	if _, err := parseTrustedKeys(data, "team-c"); err == nil {
+		t.Fatalf("expected namespace with only invalid keys to fail")
+	}
+}
+
+func TestVerifyArchiveWithoutKeys(t *testing.T) {
+	f := &Fetcher{}
+	pkg := &crd.Package{}
+	pkg.Metadata.Name = "pkg"
+	pkg.Metadata.Namespace = "team-a"
+
+	// Without a keys namespace, packages aren't verified
+	if err := f.verifyArchive(pkg, &fission.Archive{}, "/nonexistent", true); err != nil {
+		t.Fatalf("error verifying archive without trusted keys: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package tarextract
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Whiteout files of image layers remove files of the layers below
+	whiteoutPrefix = ".wh."
+	// An opaque whiteout removes all the files of its directory that come
+	// from the layers below
+	whiteoutOpaqueDir = ".wh..wh..opq"
+
+	// Every entry counts as at least a tar block towards the size limit,
+	// so that layers can't make unbounded numbers of empty files
+	minEntrySize = 512
+)
+
+// ErrLayerTooLarge is returned by ApplyLayer for layers whose files are
+// bigger than the limit.
+var ErrLayerTooLarge = errors.New("layer is too large")
+
+// ApplyLayer extracts an image layer, a tar archive that may be gzip
+// compressed, on top of the layers already extracted to destination,
+// following the whiteout rules of the OCI image spec. It returns the size
+// of the extracted files, and stops with ErrLayerTooLarge once they're
+// over maxSize bytes.
+func ApplyLayer(layer io.Reader, destination string, maxSize int64) (int64, error) {
+	var size int64
+	reader := bufio.NewReader(layer)
+	magic, err := reader.Peek(2)
+	if err != nil && err != io.EOF {
+		return size, errors.Wrap(err, "reading layer")
+	}
+
+	var stream io.Reader = reader
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return size, errors.Wrap(err, "new gzip reader")
+		}
+		defer gzipReader.Close()
+		stream = gzipReader
+	}
+
+	err = os.MkdirAll(destination, 0755)
+	if err != nil {
+		return size, errors.Wrap(err, "mkdir failed")
+	}
+
+	// paths of this layer, including the directories it creates
+	// implicitly, which opaque whiteouts keep
+	layerPaths := map[string]bool{}
+
+	tarReader := tar.NewReader(stream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return size, errors.Wrap(err, "reading next entry")
+		}
+
+		entrySize := header.Size
+		if entrySize < minEntrySize {
+			entrySize = minEntrySize
+		}
+		size += entrySize
+		if size > maxSize {
+			return size, ErrLayerTooLarge
+		}
+
+		name := filepath.Clean(header.Name)
+		if filepath.IsAbs(name) {
+			name = strings.TrimPrefix(name, "/")
+		}
+		if name == "." {
+			continue
+		}
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return size, fmt.Errorf("invalid header name: %s", header.Name)
+		}
+		path := filepath.Join(destination, name)
+
+		// Entries never go through symlinks, which may point anywhere
+		err = checkNoSymlinks(destination, filepath.Dir(name))
+		if err != nil {
+			return size, err
+		}
+
+		base := filepath.Base(name)
+		if base == whiteoutOpaqueDir {
+			err = removeLowerEntries(filepath.Dir(path), layerPaths)
+			if err != nil {
+				return size, err
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			removed := strings.TrimPrefix(base, whiteoutPrefix)
+			if removed == "" || removed == "." || removed == ".." {
+				return size, fmt.Errorf("invalid whiteout: %s", header.Name)
+			}
+			err = os.RemoveAll(filepath.Join(filepath.Dir(path), removed))
+			if err != nil {
+				return size, errors.Wrap(err, "whiteout failed")
+			}
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return size, errors.Wrap(err, "mkdir failed")
+		}
+		for p := path; p != destination; p = filepath.Dir(p) {
+			layerPaths[p] = true
+		}
+
+		// Entries replace those of the layers below, except that
+		// directories are merged
+		if info, err := os.Lstat(path); err == nil && !(info.IsDir() && header.Typeflag == tar.TypeDir) {
+			err = os.RemoveAll(path)
+			if err != nil {
+				return size, errors.Wrap(err, "replace failed")
+			}
+		}
+
+		mode := os.FileMode(header.Mode & 0777)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+			if err == nil {
+				err = os.Chmod(path, mode|0700)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(path, tarReader, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, path)
+		case tar.TypeLink:
+			target := filepath.Clean(strings.TrimPrefix(header.Linkname, "/"))
+			if target == ".." || strings.HasPrefix(target, "../") {
+				return size, fmt.Errorf("invalid hard link target: %s", header.Linkname)
+			}
+			err = checkNoSymlinks(destination, filepath.Dir(target))
+			if err == nil {
+				err = os.Link(filepath.Join(destination, target), path)
+			}
+		default:
+			// Devices and fifos have no place in function packages
+			continue
+		}
+		if err != nil {
+			return size, errors.Wrapf(err, "extracting %v", header.Name)
+		}
+	}
+
+	return size, nil
+}
+
+func writeFile(path string, reader io.Reader, mode os.FileMode) error {
+	outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	_, err = io.Copy(outFile, reader)
+	return err
+}
+
+// checkNoSymlinks returns an error if any directory of the relative path
+// below root is a symlink.
+func checkNoSymlinks(root string, relPath string) error {
+	path := root
+	for _, part := range strings.Split(relPath, string(filepath.Separator)) {
+		if part == "." || part == "" {
+			continue
+		}
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("path %v goes through symlink %v", relPath, path)
+		}
+	}
+	return nil
+}
+
+// removeLowerEntries removes everything in dir that doesn't come from the
+// current layer.
+func removeLowerEntries(dir string, layerPaths map[string]bool) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !layerPaths[path] {
+			err = os.RemoveAll(path)
+		} else if entry.IsDir() {
+			err = removeLowerEntries(path, layerPaths)
+		}
+		if err != nil {
+			return errors.Wrap(err, "opaque whiteout failed")
+		}
+	}
+	return nil
+}
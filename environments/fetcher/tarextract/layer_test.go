@@ -0,0 +1,223 @@
+package tarextract
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+}
+
+func makeTestLayer(t *testing.T, entries []testEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+		}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatalf("error writing header of %v: %v", e.name, err)
+		}
+		if header.Size > 0 {
+			if _, err := w.Write([]byte(e.content)); err != nil {
+				t.Fatalf("error writing %v: %v", e.name, err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing layer: %v", err)
+	}
+	return &buf
+}
+
+func makeTestDestination(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "tarextract-")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	return filepath.Join(dir, "rootfs"), func() { os.RemoveAll(dir) }
+}
+
+func applyTestLayer(t *testing.T, destination string, entries []testEntry) {
+	if _, err := ApplyLayer(makeTestLayer(t, entries), destination, 1<<20); err != nil {
+		t.Fatalf("error applying layer: %v", err)
+	}
+}
+
+func assertExists(t *testing.T, path string, exists bool) {
+	_, err := os.Lstat(path)
+	if exists && err != nil {
+		t.Fatalf("expected %v to exist: %v", path, err)
+	} else if !exists && !os.IsNotExist(err) {
+		t.Fatalf("expected %v not to exist: %v", path, err)
+	}
+}
+
+func TestApplyLayerPaths(t *testing.T) {
+	destination, cleanup := makeTestDestination(t)
+	defer cleanup()
+
+	// Absolute names are extracted below the destination
+	applyTestLayer(t, destination, []testEntry{
+		{name: "/etc/passwd", typeflag: tar.TypeReg, content: "root"},
+		{name: "app/./main", typeflag: tar.TypeReg, content: "main"},
+	})
+	if content, err := ioutil.ReadFile(filepath.Join(destination, "etc/passwd")); err != nil || string(content) != "root" {
+		t.Fatalf("expected absolute entry in destination, got %q, %v", content, err)
+	}
+	assertExists(t, filepath.Join(destination, "app/main"), true)
+
+	for _, entries := range [][]testEntry{
+		{{name: "../escaped", typeflag: tar.TypeReg, content: "x"}},
+		{{name: "app/../../escaped", typeflag: tar.TypeReg, content: "x"}},
+		{{name: "link", typeflag: tar.TypeLink, linkname: "../../etc/passwd"}},
+	} {
+		if _, err := ApplyLayer(makeTestLayer(t, entries), destination, 1<<20); err == nil {
+			t.Fatalf("expected layer with entry %+v to fail", entries[0])
+		}
+	}
+	assertExists(t, filepath.Join(filepath.Dir(destination), "escaped"), false)
+
+	// Hard links to absolute names stay in the destination too
+	applyTestLayer(t, destination, []testEntry{
+		{name: "passwd", typeflag: tar.TypeLink, linkname: "/etc/passwd"},
+	})
+	if content, _ := ioutil.ReadFile(filepath.Join(destination, "passwd")); string(content) != "root" {
+		t.Fatalf("expected hard link to the extracted file, got %q", content)
+	}
+}
+
+func TestApplyLayerSymlinks(t *testing.T) {
+	destination, cleanup := makeTestDestination(t)
+	defer cleanup()
+	outside := filepath.Dir(destination)
+
+	applyTestLayer(t, destination, []testEntry{
+		{name: "out", typeflag: tar.TypeSymlink, linkname: outside},
+		{name: "rel", typeflag: tar.TypeSymlink, linkname: "../"},
+	})
+
+	// Entries never go through symlinks, from this layer or those below
+	for _, entries := range [][]testEntry{
+		{{name: "out/escaped", typeflag: tar.TypeReg, content: "x"}},
+		{{name: "rel/escaped", typeflag: tar.TypeReg, content: "x"}},
+		{{name: "hard", typeflag: tar.TypeLink, linkname: "out/secret"}},
+		{
+			{name: "dir", typeflag: tar.TypeSymlink, linkname: outside},
+			{name: "dir/escaped", typeflag: tar.TypeReg, content: "x"},
+		},
+	} {
+		if _, err := ApplyLayer(makeTestLayer(t, entries), destination, 1<<20); err == nil {
+			t.Fatalf("expected layer with entries %+v to fail", entries)
+		}
+	}
+	assertExists(t, filepath.Join(outside, "escaped"), false)
+
+	// Symlinks are replaced rather than followed
+	applyTestLayer(t, destination, []testEntry{
+		{name: "out", typeflag: tar.TypeReg, content: "file"},
+	})
+	if info, err := os.Lstat(filepath.Join(destination, "out")); err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("expected symlink to be replaced by a file: %v", err)
+	}
+}
+
+func TestApplyLayerWhiteouts(t *testing.T) {
+	destination, cleanup := makeTestDestination(t)
+	defer cleanup()
+
+	applyTestLayer(t, destination, []testEntry{
+		{name: "bin/", typeflag: tar.TypeDir},
+		{name: "bin/sh", typeflag: tar.TypeReg, content: "sh"},
+		{name: "bin/ls", typeflag: tar.TypeReg, content: "ls"},
+		{name: "opt/", typeflag: tar.TypeDir},
+		{name: "opt/lower", typeflag: tar.TypeReg, content: "lower"},
+		{name: "opt/sub/lower", typeflag: tar.TypeReg, content: "lower"},
+	})
+
+	applyTestLayer(t, destination, []testEntry{
+		{name: "bin/.wh.ls", typeflag: tar.TypeReg},
+		// opt/sub is only created implicitly by this layer's file, which
+		// the opaque whiteout after it keeps
+		{name: "opt/sub/upper", typeflag: tar.TypeReg, content: "upper"},
+		{name: "opt/upper", typeflag: tar.TypeReg, content: "upper"},
+		{name: "opt/.wh..wh..opq", typeflag: tar.TypeReg},
+	})
+
+	assertExists(t, filepath.Join(destination, "bin/sh"), true)
+	assertExists(t, filepath.Join(destination, "bin/ls"), false)
+	assertExists(t, filepath.Join(destination, "bin/.wh.ls"), false)
+	assertExists(t, filepath.Join(destination, "opt/upper"), true)
+	assertExists(t, filepath.Join(destination, "opt/sub/upper"), true)
+	assertExists(t, filepath.Join(destination, "opt/lower"), false)
+	assertExists(t, filepath.Join(destination, "opt/sub/lower"), false)
+	assertExists(t, filepath.Join(destination, "opt/.wh..wh..opq"), false)
+
+	for _, name := range []string{".wh.", "bin/.wh..."} {
+		if _, err := ApplyLayer(makeTestLayer(t, []testEntry{{name: name, typeflag: tar.TypeReg}}), destination, 1<<20); err == nil {
+			t.Fatalf("expected invalid whiteout %v to fail", name)
+		}
+	}
+	assertExists(t, filepath.Join(destination, "bin/sh"), true)
+}
+
+func TestApplyLayerSize(t *testing.T) {
+	destination, cleanup := makeTestDestination(t)
+	defer cleanup()
+	entries := []testEntry{
+		{name: "a", typeflag: tar.TypeReg, content: string(make([]byte, 1000))},
+		{name: "b", typeflag: tar.TypeReg, content: string(make([]byte, 1000))},
+	}
+
+	if size, err := ApplyLayer(makeTestLayer(t, entries), destination, 2000); err != nil || size != 2000 {
+		t.Fatalf("error applying layer within the limit: %v, %v", size, err)
+	}
+	if _, err := ApplyLayer(makeTestLayer(t, entries), destination, 1999); err != ErrLayerTooLarge {
+		t.Fatalf("expected %v, got %v", ErrLayerTooLarge, err)
+	}
+
+	// Empty files count towards the limit too
+	var empty []testEntry
+	for _, name := range []string{"c", "d", "e"} {
+		empty = append(empty, testEntry{name: name, typeflag: tar.TypeReg})
+	}
+	if _, err := ApplyLayer(makeTestLayer(t, empty), destination, 2*minEntrySize); err != ErrLayerTooLarge {
+		t.Fatalf("expected %v for empty files, got %v", ErrLayerTooLarge, err)
+	}
+}
+
+func TestApplyLayerGzip(t *testing.T) {
+	destination, cleanup := makeTestDestination(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(makeTestLayer(t, []testEntry{{name: "file", typeflag: tar.TypeReg, content: "gzip"}}).Bytes())
+	w.Close()
+
+	if _, err := ApplyLayer(&buf, destination, 1<<20); err != nil {
+		t.Fatalf("error applying gzip layer: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(destination, "file")); string(content) != "gzip" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
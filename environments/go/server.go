@@ -8,6 +8,9 @@ import (
 	"os"
 	"path/filepath"
 	"plugin"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 
@@ -16,6 +19,10 @@ import (
 
 const (
 	CODE_PATH = "/userfunc/user"
+
+	// FUNCTION_UID_HEADER is set by the router to the UID of the function
+	// a request is for.
+	FUNCTION_UID_HEADER = "X-Fission-Function-Uid"
 )
 
 type (
@@ -34,25 +41,153 @@ type (
 		// URL to expose this function at. Optional; defaults
 		// to "/".
 		URL string `json:"url"`
+
+		// Env are environment variables of the function. Optional.
+		Env map[string]string `json:"env"`
+
+		// FunctionMetadata identifies the function. Set by the fetcher
+		// of v2 environments.
+		FunctionMetadata *FunctionMetadata `json:"FunctionMetadata"`
 	}
+
+	FunctionMetadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		UID       string `json:"uid"`
+	}
+
+	// FunctionLoadStatus is reported on the load status endpoint of the
+	// v3 environment interface.
+	FunctionLoadStatus struct {
+		State string `json:"state"`
+		Error string `json:"error,omitempty"`
+	}
+
+	// FunctionHealth is reported for each function on the health
+	// endpoint of the v3 environment interface.
+	FunctionHealth struct {
+		Healthy bool   `json:"healthy"`
+		Error   string `json:"error,omitempty"`
+	}
+)
+
+// Load states of the v3 environment interface
+const (
+	LOAD_STATE_LOADING = "loading"
+	LOAD_STATE_LOADED  = "loaded"
+	LOAD_STATE_FAILED  = "failed"
+)
+
+var (
+	// userFuncs are the loaded functions by UID. Environments that allow
+	// more than one function per container get a v2 specialize request
+	// for each function; a function loaded without a UID (from a v1
+	// request, for instance) is the only one of the container.
+	userFuncs     = make(map[string]http.HandlerFunc)
+	userFuncsLock sync.RWMutex
+
+	// loadStatus, healthChecks and reloads of the functions, by UID like
+	// userFuncs and protected by the same lock. Functions may export a
+	// "Health" function returning an error, which is checked on the
+	// health endpoint, and a "Reload" function, which is called on the
+	// reload endpoint.
+	loadStatus   = make(map[string]FunctionLoadStatus)
+	healthChecks = make(map[string]func() error)
+	reloads      = make(map[string]func() error)
+
+	// draining is set once the container is asked to drain; requests
+	// hold requestsLock for reading, which draining waits for.
+	draining     int32
+	requestsLock sync.RWMutex
 )
 
-var userFunc http.HandlerFunc
+// getUserFunc returns the function a request is for, or the only loaded
+// function if the request doesn't name one.
+func getUserFunc(r *http.Request) http.HandlerFunc {
+	userFuncsLock.RLock()
+	defer userFuncsLock.RUnlock()
+
+	if uid := r.Header.Get(FUNCTION_UID_HEADER); len(uid) > 0 {
+		if f, ok := userFuncs[uid]; ok {
+			return f
+		}
+	}
+	if len(userFuncs) == 1 {
+		for _, f := range userFuncs {
+			return f
+		}
+	}
+	return nil
+}
+
+// canLoad returns whether the function with the given UID can be loaded
+// alongside the functions that are loaded already.
+func canLoad(uid string) bool {
+	userFuncsLock.RLock()
+	defer userFuncsLock.RUnlock()
+
+	if len(userFuncs) == 0 {
+		return true
+	}
+	if _, ok := userFuncs[""]; ok {
+		return false
+	}
+	return len(uid) > 0
+}
+
+// load loads the function with the given UID from the plugin and records
+// its load status.
+func load(uid, codePath, entrypoint string) error {
+	userFuncsLock.Lock()
+	loadStatus[uid] = FunctionLoadStatus{State: LOAD_STATE_LOADING}
+	userFuncsLock.Unlock()
+
+	userFunc, healthCheck, reload, err := loadPlugin(codePath, entrypoint)
+
+	userFuncsLock.Lock()
+	defer userFuncsLock.Unlock()
+	if err != nil {
+		loadStatus[uid] = FunctionLoadStatus{State: LOAD_STATE_FAILED, Error: err.Error()}
+		return err
+	}
+	// Loading a function again, after it was updated, replaces it.
+	userFuncs[uid] = userFunc
+	healthChecks[uid] = healthCheck
+	reloads[uid] = reload
+	loadStatus[uid] = FunctionLoadStatus{State: LOAD_STATE_LOADED}
+	return nil
+}
+
+// lookupHook returns the optional function of the plugin with the given
+// name, or nil.
+func lookupHook(p *plugin.Plugin, name string) func() error {
+	sym, err := p.Lookup(name)
+	if err != nil {
+		return nil
+	}
+	switch h := sym.(type) {
+	case func() error:
+		return h
+	case *func() error:
+		return *h
+	}
+	return nil
+}
 
-func loadPlugin(codePath, entrypoint string) (http.HandlerFunc, error) {
+func loadPlugin(codePath, entrypoint string) (http.HandlerFunc, func() error, func() error, error) {
 
 	// if codepath's a directory, load the file inside it
 	info, err := os.Stat(codePath)
 	if err != nil {
-		return nil, errors.Wrap(err, "error checking plugin path")
+		return nil, nil, nil, errors.Wrap(err, "error checking plugin path")
 	}
 	if info.IsDir() {
 		files, err := ioutil.ReadDir(codePath)
 		if err != nil {
-			return nil, errors.Wrap(err, "error reading directory")
+			return nil, nil, nil, errors.Wrap(err, "error reading directory")
 		}
 		if len(files) == 0 {
-			return nil, errors.New("No files to load")
+			return nil, nil, nil, errors.New("No files to load")
 		}
 		fi := files[0]
 		codePath = filepath.Join(codePath, fi.Name())
@@ -61,32 +196,36 @@ func loadPlugin(codePath, entrypoint string) (http.HandlerFunc, error) {
 	log.Printf("loading plugin from %v\n", codePath)
 	p, err := plugin.Open(codePath)
 	if err != nil {
-		return nil, errors.Wrap(err, "error loading plugin")
+		return nil, nil, nil, errors.Wrap(err, "error loading plugin")
 	}
 	sym, err := p.Lookup(entrypoint)
 	if err != nil {
-		return nil, errors.Wrap(err, "entry point not found")
+		return nil, nil, nil, errors.Wrap(err, "entry point not found")
 	}
 
+	// The health check and reload are optional
+	healthCheck := lookupHook(p, "Health")
+	reload := lookupHook(p, "Reload")
+
 	switch h := sym.(type) {
 	case *http.Handler:
-		return (*h).ServeHTTP, nil
+		return (*h).ServeHTTP, healthCheck, reload, nil
 	case *http.HandlerFunc:
-		return *h, nil
+		return *h, healthCheck, reload, nil
 	case func(http.ResponseWriter, *http.Request):
-		return h, nil
+		return h, healthCheck, reload, nil
 	case func(context.Context, http.ResponseWriter, *http.Request):
 		return func(w http.ResponseWriter, r *http.Request) {
 			c := context.New()
 			h(c, w, r)
-		}, nil
+		}, healthCheck, reload, nil
 	default:
-		panic("Entry point not found: bad type")
+		return nil, nil, nil, errors.New("entry point not found: bad type")
 	}
 }
 
 func specializeHandler(w http.ResponseWriter, r *http.Request) {
-	if userFunc != nil {
+	if !canLoad("") {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Not a generic container"))
 		return
@@ -109,7 +248,7 @@ func specializeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Println("Specializing ...")
-	userFunc, err = loadPlugin(CODE_PATH, "Handler")
+	err = load("", CODE_PATH, "Handler")
 	if err != nil {
 		err = errors.Wrap(err, "error specializing function")
 		log.Printf(err.Error())
@@ -121,12 +260,6 @@ func specializeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func specializeHandlerV2(w http.ResponseWriter, r *http.Request) {
-	if userFunc != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Not a generic container"))
-		return
-	}
-
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		log.Println(err.Error())
@@ -140,6 +273,16 @@ func specializeHandlerV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var uid string
+	if loadreq.FunctionMetadata != nil {
+		uid = loadreq.FunctionMetadata.UID
+	}
+	if !canLoad(uid) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Not a generic container"))
+		return
+	}
+
 	_, err = os.Stat(loadreq.FilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -156,8 +299,12 @@ func specializeHandlerV2(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	for key, val := range loadreq.Env {
+		os.Setenv(key, val)
+	}
+
 	log.Println("Specializing ...")
-	userFunc, err = loadPlugin(loadreq.FilePath, loadreq.FunctionName)
+	err = load(uid, loadreq.FilePath, loadreq.FunctionName)
 	if err != nil {
 		err = errors.Wrap(err, "error specializing function")
 		log.Printf(err.Error())
@@ -172,13 +319,125 @@ func readinessProbeHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// loadStatusHandler reports the load status of the function with the UID
+// given as the "uid" query parameter.
+func loadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userFuncsLock.RLock()
+	status, ok := loadStatus[r.URL.Query().Get("uid")]
+	userFuncsLock.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("function not loaded"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// v3ReadinessHandler reports whether the container serves requests: it
+// doesn't while it loads its first function, and once it drains.
+func v3ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&draining) != 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	userFuncsLock.RLock()
+	defer userFuncsLock.RUnlock()
+	if len(userFuncs) == 0 {
+		for _, status := range loadStatus {
+			if status.State == LOAD_STATE_LOADING {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// drainHandler stops taking requests and returns once the requests in
+// flight are done.
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Draining ...")
+	atomic.StoreInt32(&draining, 1)
+	requestsLock.Lock()
+	requestsLock.Unlock()
+	log.Println("Drained")
+	w.WriteHeader(http.StatusOK)
+}
+
+// healthHandler reports the health of each function.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	userFuncsLock.RLock()
+	defer userFuncsLock.RUnlock()
+
+	healthy := true
+	health := make(map[string]FunctionHealth)
+	for uid, status := range loadStatus {
+		h := FunctionHealth{Healthy: true}
+		if status.State == LOAD_STATE_FAILED {
+			h = FunctionHealth{Error: status.Error}
+		} else if check := healthChecks[uid]; check != nil {
+			if err := check(); err != nil {
+				h = FunctionHealth{Error: err.Error()}
+			}
+		}
+		healthy = healthy && h.Healthy
+		health[uid] = h
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+// reloadHandler calls the reload function of each function exporting one,
+// after their Secrets and ConfigMaps changed.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	userFuncsLock.RLock()
+	defer userFuncsLock.RUnlock()
+
+	var errs []string
+	for uid, reload := range reloads {
+		if reload == nil {
+			continue
+		}
+		if err := reload(); err != nil {
+			log.Printf("Error reloading function %v: %v", uid, err)
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(strings.Join(errs, "; ")))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func main() {
 	http.HandleFunc("/healthz", readinessProbeHandler)
 	http.HandleFunc("/specialize", specializeHandler)
 	http.HandleFunc("/v2/specialize", specializeHandlerV2)
+	http.HandleFunc("/v3/status", loadStatusHandler)
+	http.HandleFunc("/v3/readiness", v3ReadinessHandler)
+	http.HandleFunc("/v3/drain", drainHandler)
+	http.HandleFunc("/v3/health", healthHandler)
+	http.HandleFunc("/v3/reload", reloadHandler)
 
 	// Generic route -- all http requests go to the user function.
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&draining) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Draining"))
+			return
+		}
+		requestsLock.RLock()
+		defer requestsLock.RUnlock()
+
+		userFunc := getUserFunc(r)
 		if userFunc == nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("Generic container: no requests supported"))
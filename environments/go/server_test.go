@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setUserFuncs replaces the loaded functions with functions writing their
+// UID.
+func setUserFuncs(uids ...string) {
+	userFuncsLock.Lock()
+	defer userFuncsLock.Unlock()
+	userFuncs = make(map[string]http.HandlerFunc)
+	for _, uid := range uids {
+		uid := uid
+		userFuncs[uid] = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(uid))
+		}
+	}
+}
+
+// invokedUserFunc returns the UID of the function a request with the UID
+// header goes to, or "none".
+func invokedUserFunc(uid string) string {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if len(uid) > 0 {
+		r.Header.Set(FUNCTION_UID_HEADER, uid)
+	}
+	f := getUserFunc(r)
+	if f == nil {
+		return "none"
+	}
+	w := httptest.NewRecorder()
+	f(w, r)
+	return w.Body.String()
+}
+
+func TestGetUserFunc(t *testing.T) {
+	defer setUserFuncs()
+
+	setUserFuncs()
+	if f := invokedUserFunc("a"); f != "none" {
+		t.Fatalf("expected no function before specialization, got %v", f)
+	}
+
+	// The only function of the container takes every request
+	setUserFuncs("a")
+	for _, uid := range []string{"a", "b", ""} {
+		if f := invokedUserFunc(uid); f != "a" {
+			t.Fatalf("expected request for %q to go to the only function, got %v", uid, f)
+		}
+	}
+	setUserFuncs("")
+	if f := invokedUserFunc("a"); f != "" {
+		t.Fatalf("expected request to go to the function loaded without UID, got %v", f)
+	}
+
+	// With more functions, requests go to the function they name
+	setUserFuncs("a", "b")
+	for uid, expected := range map[string]string{"a": "a", "b": "b", "c": "none", "": "none"} {
+		if f := invokedUserFunc(uid); f != expected {
+			t.Fatalf("expected request for %q to go to %v, got %v", uid, expected, f)
+		}
+	}
+}
+
+func TestCanLoad(t *testing.T) {
+	defer setUserFuncs()
+
+	setUserFuncs()
+	for _, uid := range []string{"", "a"} {
+		if !canLoad(uid) {
+			t.Fatalf("expected empty container to load function %q", uid)
+		}
+	}
+
+	// A function loaded without UID is the only one of the container
+	setUserFuncs("")
+	for _, uid := range []string{"", "a"} {
+		if canLoad(uid) {
+			t.Fatalf("expected container with v1 function not to load function %q", uid)
+		}
+	}
+
+	// Functions with UIDs share the container, and are loaded again
+	// when they are updated
+	setUserFuncs("a")
+	for uid, expected := range map[string]bool{"a": true, "b": true, "": false} {
+		if canLoad(uid) != expected {
+			t.Fatalf("expected loading %q alongside a function to be %v", uid, expected)
+		}
+	}
+}
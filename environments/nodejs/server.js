@@ -49,6 +49,9 @@ function isFunction(func) {
 }
 
 function specializeV2(req, res) {
+    // environment variables of the function
+    Object.assign(process.env, req.body.env || {});
+
     // for V2 entrypoint, 'filename.funcname' => ['filename', 'funcname']
     const entrypoint = req.body.functionName ? req.body.functionName.split('.') : [];
     // for V2, filepath is dynamic path
@@ -44,6 +44,7 @@ class FuncApp(Flask):
             body = request.get_json()
             filepath = body['filepath']
             handler = body['functionName']
+            os.environ.update(body.get('env') or {})
 
             # The value of "functionName" is consist of
             # `<module-name>.<function-name>`.
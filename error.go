@@ -17,6 +17,7 @@ limitations under the License.
 package fission
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -46,6 +47,8 @@ func MakeErrorFromHTTP(resp *http.Response) error {
 		errCode = ErrorNotFound
 	case http.StatusConflict:
 		errCode = ErrorNameExists
+	case http.StatusServiceUnavailable:
+		errCode = ErrorUnavailable
 	default:
 		errCode = ErrorInternal
 	}
@@ -60,6 +63,37 @@ func MakeErrorFromHTTP(resp *http.Response) error {
 	return MakeError(errCode, msg)
 }
 
+func (err *SpecializationError) Error() string {
+	return err.Message
+}
+
+// WriteSpecializationError responds with the specialization error as JSON.
+func WriteSpecializationError(w http.ResponseWriter, err *SpecializationError, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(err)
+}
+
+// MakeSpecializationErrorFromHTTP returns the specialization error a
+// fetcher or executor responded with, or else the error MakeErrorFromHTTP
+// returns.
+func MakeSpecializationErrorFromHTTP(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		return MakeErrorFromHTTP(resp)
+	}
+
+	defer resp.Body.Close()
+	se := &SpecializationError{}
+	err := json.NewDecoder(resp.Body).Decode(se)
+	if err != nil || len(se.Reason) == 0 {
+		return MakeError(ErrorInternal, fmt.Sprintf("%v: invalid specialization error", resp.Status))
+	}
+	return se
+}
+
 func (err Error) HTTPStatus() int {
 	var code int
 	switch err.Code {
@@ -71,6 +105,8 @@ func (err Error) HTTPStatus() int {
 		code = http.StatusNotFound
 	case ErrorNameExists:
 		code = http.StatusConflict
+	case ErrorUnavailable:
+		code = http.StatusServiceUnavailable
 	default:
 		code = http.StatusInternalServerError
 	}
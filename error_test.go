@@ -0,0 +1,73 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fission
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpecializationErrorFromHTTP(t *testing.T) {
+	se := &SpecializationError{
+		Reason:  SpecializationErrorChecksumMismatch,
+		Message: "checksum of archive doesn't match",
+		Pod:     "env-pod",
+	}
+	w := httptest.NewRecorder()
+	WriteSpecializationError(w, se, http.StatusBadRequest)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status %v, got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+	err := MakeSpecializationErrorFromHTTP(resp)
+	parsed, ok := err.(*SpecializationError)
+	if !ok || *parsed != *se {
+		t.Fatalf("expected specialization error %+v, got %#v", se, err)
+	}
+
+	response := func(status int, contentType string, body string) *http.Response {
+		resp := &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}
+		if len(contentType) > 0 {
+			resp.Header.Set("Content-Type", contentType)
+		}
+		return resp
+	}
+
+	if err := MakeSpecializationErrorFromHTTP(response(http.StatusOK, "", "")); err != nil {
+		t.Fatalf("expected no error for successful response, got %v", err)
+	}
+
+	// Other errors are described like MakeErrorFromHTTP does
+	err = MakeSpecializationErrorFromHTTP(response(http.StatusNotFound, "text/plain", "function not found"))
+	if fe, ok := err.(Error); !ok || fe.Code != ErrorNotFound || fe.Message != "function not found" {
+		t.Fatalf("expected not found error, got %#v", err)
+	}
+	for _, body := range []string{"not json", `{"message": "no reason"}`} {
+		err := MakeSpecializationErrorFromHTTP(response(http.StatusInternalServerError, "application/json", body))
+		if fe, ok := err.(Error); !ok || fe.Code != ErrorInternal || !strings.Contains(fe.Message, "invalid specialization error") {
+			t.Fatalf("expected invalid specialization error for %q, got %#v", body, err)
+		}
+	}
+}
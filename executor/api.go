@@ -23,10 +23,14 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
 	"go.opencensus.io/plugin/ochttp"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/fission/fission"
@@ -48,7 +52,15 @@ func (executor *Executor) getServiceForFunctionApi(w http.ResponseWriter, r *htt
 	}
 
 	serviceName, err := executor.getServiceForFunction(r.Context(), &m)
-	if err != nil {
+	if se, ok := errors.Cause(err).(*fission.SpecializationError); ok {
+		log.Printf("Error specializing function %v: %v", m.Name, err)
+		fission.WriteSpecializationError(w, &fission.SpecializationError{
+			Reason:  se.Reason,
+			Message: err.Error(),
+			Pod:     se.Pod,
+		}, http.StatusInternalServerError)
+		return
+	} else if err != nil {
 		code, msg := fission.GetHTTPError(err)
 		log.Printf("Error: %v: %v", code, msg)
 		http.Error(w, msg, code)
@@ -68,16 +80,20 @@ func (executor *Executor) getServiceForFunctionApi(w http.ResponseWriter, r *htt
 // To make it optimal, plan is to add an eager cache invalidator function that watches for pod deletion events and
 // invalidates the cache entry if the pod address was cached.
 func (executor *Executor) getServiceForFunction(ctx context.Context, m *metav1.ObjectMeta) (string, error) {
+	if !executor.isLeader() {
+		return executor.getServiceForFunctionFromLeader(ctx, m)
+	}
+
 	// Check function -> svc cache
 	log.Printf("[%v] Checking for cached function service", m.Name)
-	fsvc, err := executor.fsCache.GetByFunction(m)
+	fsvc, err := executor.getFsCache().GetByFunction(m)
 	if err == nil {
 		if executor.isValidAddress(fsvc) {
 			// Cached, return svc address
 			return fsvc.Address, nil
 		} else {
 			log.Printf("[%v] Deleting cache entry for invalid address : %s", m.Name, fsvc.Address)
-			executor.fsCache.DeleteEntry(fsvc)
+			executor.getFsCache().DeleteEntry(fsvc)
 		}
 	}
 
@@ -91,10 +107,22 @@ func (executor *Executor) getServiceForFunction(ctx context.Context, m *metav1.O
 	if resp.err != nil {
 		return "", resp.err
 	}
-	executor.fsCache.IncreaseColdStarts(m.Name, string(m.UID))
+	executor.getFsCache().IncreaseColdStarts(m.Name, string(m.UID))
 	return resp.funcSvc.Address, resp.err
 }
 
+// getServiceForFunctionFromLeader answers from the service registry if the
+// leader already has a valid function service for the function, and forwards
+// the request to the leader otherwise.
+func (executor *Executor) getServiceForFunctionFromLeader(ctx context.Context, m *metav1.ObjectMeta) (string, error) {
+	fsvc, ok := executor.registry.get(m)
+	if ok && executor.isValidAddress(fsvc) {
+		return fsvc.Address, nil
+	}
+
+	return executor.leaderClient.GetServiceForFunction(ctx, m)
+}
+
 // find funcSvc and update its atime
 func (executor *Executor) tapService(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
@@ -103,9 +131,16 @@ func (executor *Executor) tapService(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	svcName := string(body)
+
+	// Function services are only cached by the leader
+	if !executor.isLeader() {
+		executor.tapServiceOnLeader(w, svcName)
+		return
+	}
+
 	svcHost := strings.TrimPrefix(svcName, "http://")
 
-	err = executor.fsCache.TouchByAddress(svcHost)
+	err = executor.getFsCache().TouchByAddress(svcHost)
 	if err != nil {
 		log.Printf("funcSvc tap error: %v", err)
 		http.Error(w, "Not found", http.StatusNotFound)
@@ -114,21 +149,200 @@ func (executor *Executor) tapService(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (executor *Executor) tapServiceOnLeader(w http.ResponseWriter, svcName string) {
+	serviceUrl, err := url.Parse(svcName)
+	if err != nil {
+		http.Error(w, "Failed to parse service url", http.StatusBadRequest)
+		return
+	}
+
+	// Taps are batched by the client like the ones of the router
+	executor.leaderClient.TapService(serviceUrl)
+	w.WriteHeader(http.StatusOK)
+}
+
+// listFunctionServicesApi lists the function services of the executor,
+// optionally only the ones of the function given by the namespace and name
+// query parameters.
+func (executor *Executor) listFunctionServicesApi(w http.ResponseWriter, r *http.Request) {
+	namespace := r.URL.Query().Get("namespace")
+	name := r.URL.Query().Get("name")
+
+	var fsvcs []fission.FunctionServiceInfo
+	var err error
+	if executor.isLeader() {
+		fsvcs, err = executor.listFunctionServices(namespace, name)
+	} else {
+		fsvcs, err = executor.leaderClient.ListFunctionServices(r.Context(), namespace, name)
+	}
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		http.Error(w, msg, code)
+		return
+	}
+
+	resp, err := json.Marshal(fsvcs)
+	if err != nil {
+		http.Error(w, "Failed to serialize function services", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(resp)
+}
+
+func (executor *Executor) listFunctionServices(namespace string, name string) ([]fission.FunctionServiceInfo, error) {
+	funcSvcs, err := executor.getFsCache().ListOld(0)
+	if err != nil {
+		return nil, err
+	}
+
+	fsvcs := make([]fission.FunctionServiceInfo, 0, len(funcSvcs))
+	for _, fsvc := range funcSvcs {
+		if (len(namespace) > 0 && fsvc.Function.Namespace != namespace) ||
+			(len(name) > 0 && fsvc.Function.Name != name) {
+			continue
+		}
+
+		info := fission.FunctionServiceInfo{
+			Name:              fsvc.Name,
+			Function:          *fsvc.Function,
+			Address:           fsvc.Address,
+			Executor:          fsvc.Executor,
+			KubernetesObjects: fsvc.KubernetesObjects,
+			Ctime:             fsvc.Ctime,
+			Atime:             fsvc.Atime,
+		}
+		if fsvc.Environment != nil {
+			info.Environment = fsvc.Environment.Metadata
+		}
+		fsvcs = append(fsvcs, info)
+	}
+	return fsvcs, nil
+}
+
+// evictFunctionApi removes all function services of a function, so that the
+// next request for the function specializes a new one.
+func (executor *Executor) evictFunctionApi(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusInternalServerError)
+		return
+	}
+
+	m := metav1.ObjectMeta{}
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		return
+	}
+
+	if executor.isLeader() {
+		err = executor.evictFunction(&m)
+	} else {
+		err = executor.leaderClient.EvictFunction(r.Context(), &m)
+	}
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		log.Printf("Error: %v: %v", code, msg)
+		http.Error(w, msg, code)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (executor *Executor) evictFunction(m *metav1.ObjectMeta) error {
+	funcSvcs, err := executor.getFsCache().ListOld(0)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	found := false
+	for _, fsvc := range funcSvcs {
+		if fsvc.Function.Namespace != m.Namespace || fsvc.Function.Name != m.Name {
+			continue
+		}
+		found = true
+
+		log.Printf("Evicting function service %v of function %v", fsvc.Name, m.Name)
+		backend, err := executor.getExecutorBackend(fsvc.Executor)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		// Remove the cache entry first so that no new requests are sent
+		// to the function service that is going away.
+		executor.getFsCache().DeleteEntry(fsvc)
+		err = backend.CleanupFuncSvc(fsvc)
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if !found {
+		return fission.MakeError(fission.ErrorNotFound,
+			fmt.Sprintf("No function service found for function %v in namespace %v", m.Name, m.Namespace))
+	}
+	return result.ErrorOrNil()
+}
+
+// warmFunctionApi specializes a function service for the current version of
+// a function ahead of the first request, and responds with its address.
+func (executor *Executor) warmFunctionApi(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusInternalServerError)
+		return
+	}
+
+	m := metav1.ObjectMeta{}
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		return
+	}
+
+	fn, err := executor.fissionClient.Functions(m.Namespace).Get(m.Name)
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		if kerrors.IsNotFound(err) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, msg, code)
+		return
+	}
+
+	serviceName, err := executor.getServiceForFunction(r.Context(), &fn.Metadata)
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		log.Printf("Error: %v: %v", code, msg)
+		http.Error(w, msg, code)
+		return
+	}
+
+	w.Write([]byte(serviceName))
+}
+
 func (executor *Executor) healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (executor *Executor) Serve(port int) {
+// getRouter returns the router of the executor API.
+func (executor *Executor) getRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/v2/getServiceForFunction", executor.getServiceForFunctionApi).Methods("POST")
 	r.HandleFunc("/v2/tapService", executor.tapService).Methods("POST")
+	r.HandleFunc("/v2/functionServices", executor.listFunctionServicesApi).Methods("GET")
+	r.HandleFunc("/v2/evict", executor.evictFunctionApi).Methods("POST")
+	r.HandleFunc("/v2/warm", executor.warmFunctionApi).Methods("POST")
 	r.HandleFunc("/healthz", executor.healthHandler).Methods("GET")
+	return r
+}
+
+func (executor *Executor) Serve(port int) {
+	r := executor.getRouter()
 	address := fmt.Sprintf(":%v", port)
 	log.Printf("starting executor at port %v", port)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	executor.ndm.Run(ctx)
-	executor.gpm.Run(ctx)
 	r.Use(fission.LoggingMiddleware)
 	err := http.ListenAndServe(address, &ochttp.Handler{
 		Handler: r,
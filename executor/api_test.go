@@ -0,0 +1,197 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/crd/fake"
+	"github.com/fission/fission/executor/executortype"
+	"github.com/fission/fission/executor/fscache"
+)
+
+type (
+	// testBackend specializes function services without any kubernetes
+	// objects.
+	testBackend struct {
+		fsCache *fscache.FunctionServiceCache
+
+		lock     sync.Mutex
+		cleanups []string
+	}
+)
+
+func (tb *testBackend) GetTypeName() fission.ExecutorType    { return fission.ExecutorTypePoolmgr }
+func (tb *testBackend) Run(ctx context.Context)              {}
+func (tb *testBackend) IsValid(fsvc *fscache.FuncSvc) bool   { return true }
+func (tb *testBackend) IdleObjectReaper(ctx context.Context) {}
+func (tb *testBackend) AdoptExistingResources()              {}
+
+func (tb *testBackend) GetFuncSvc(ctx context.Context, metadata *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
+	fsvc := &fscache.FuncSvc{
+		Name:     metadata.Name + "-pod",
+		Function: metadata,
+		Address:  "10.0.0.1:8888",
+		Executor: fission.ExecutorTypePoolmgr,
+	}
+	_, err := tb.fsCache.Add(*fsvc)
+	if err != nil {
+		return nil, err
+	}
+	return fsvc, nil
+}
+
+func (tb *testBackend) CleanupFuncSvc(fsvc *fscache.FuncSvc) error {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	tb.cleanups = append(tb.cleanups, fsvc.Name)
+	return nil
+}
+
+// makeTestExecutor returns an executor with a test backend, which is the
+// leader unless a leader URL is given.
+func makeTestExecutor(server *fake.APIServer, leaderUrl string) (*Executor, *testBackend) {
+	fissionClient, kubernetesClient := server.Clients()
+	backend := &testBackend{}
+	executor := MakeExecutor(fissionClient, kubernetesClient,
+		func(fsCache *fscache.FunctionServiceCache, instanceID string) []executortype.ExecutorBackend {
+			backend.fsCache = fsCache
+			return []executortype.ExecutorBackend{backend}
+		}, "test")
+	if len(leaderUrl) > 0 {
+		executor.elector = &leaderElector{identity: "http://10.0.0.2:8888", leader: leaderUrl}
+		executor.registry = makeServiceRegistry(kubernetesClient, "fission")
+	}
+	return executor, backend
+}
+
+func addTestFunction(server *fake.APIServer) {
+	fn := &crd.Function{}
+	fn.Metadata = metav1.ObjectMeta{Name: "hello", UID: "fn-uid"}
+	server.Add("/apis/fission.io/v1/namespaces/default/functions/hello", fn)
+}
+
+// request sends a request to the API of the executor and returns the status
+// and the body of the response.
+func request(t *testing.T, executorUrl string, method string, path string, body string) (int, string) {
+	req, err := http.NewRequest(method, executorUrl+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error sending request to %v: %v", path, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(respBody))
+}
+
+func listFunctionServices(t *testing.T, executorUrl string, query string) []fission.FunctionServiceInfo {
+	status, body := request(t, executorUrl, "GET", "/v2/functionServices?"+query, "")
+	if status != http.StatusOK {
+		t.Fatalf("error listing function services: %v %v", status, body)
+	}
+	var fsvcs []fission.FunctionServiceInfo
+	if err := json.Unmarshal([]byte(body), &fsvcs); err != nil {
+		t.Fatalf("error parsing function services %v: %v", body, err)
+	}
+	return fsvcs
+}
+
+// testFunctionServicesApi warms, lists and evicts the function service of a
+// function through the API of the executor at the URL.
+func testFunctionServicesApi(t *testing.T, executorUrl string, backend *testBackend) {
+	fn := `{"name": "hello", "namespace": "default"}`
+
+	if status, _ := request(t, executorUrl, "POST", "/v2/warm", `{"name": "missing", "namespace": "default"}`); status != http.StatusNotFound {
+		t.Fatalf("expected warming missing function to fail with %v, got %v", http.StatusNotFound, status)
+	}
+	if status, body := request(t, executorUrl, "POST", "/v2/warm", fn); status != http.StatusOK || body != "10.0.0.1:8888" {
+		t.Fatalf("expected warmed function service, got %v %v", status, body)
+	}
+
+	fsvcs := listFunctionServices(t, executorUrl, "namespace=default&name=hello")
+	if len(fsvcs) != 1 || fsvcs[0].Name != "hello-pod" || fsvcs[0].Address != "10.0.0.1:8888" ||
+		fsvcs[0].Function.UID != "fn-uid" || fsvcs[0].Executor != fission.ExecutorTypePoolmgr {
+		t.Fatalf("unexpected function services %+v", fsvcs)
+	}
+	if fsvcs := listFunctionServices(t, executorUrl, "namespace=default&name=other"); len(fsvcs) != 0 {
+		t.Fatalf("expected no function services of other function, got %+v", fsvcs)
+	}
+
+	if status, body := request(t, executorUrl, "POST", "/v2/evict", fn); status != http.StatusOK {
+		t.Fatalf("error evicting function: %v %v", status, body)
+	}
+	if len(backend.cleanups) != 1 || backend.cleanups[0] != "hello-pod" {
+		t.Fatalf("expected function service to be cleaned up, got cleanups %v", backend.cleanups)
+	}
+	if fsvcs := listFunctionServices(t, executorUrl, ""); len(fsvcs) != 0 {
+		t.Fatalf("expected no function services after eviction, got %+v", fsvcs)
+	}
+	if status, _ := request(t, executorUrl, "POST", "/v2/evict", fn); status != http.StatusNotFound {
+		t.Fatalf("expected evicting function without function services to fail with %v, got %v", http.StatusNotFound, status)
+	}
+}
+
+func TestFunctionServicesApi(t *testing.T) {
+	server := fake.NewAPIServer()
+	defer server.Close()
+	addTestFunction(server)
+
+	executor, backend := makeTestExecutor(server, "")
+	executorServer := httptest.NewServer(executor.getRouter())
+	defer executorServer.Close()
+
+	testFunctionServicesApi(t, executorServer.URL, backend)
+}
+
+func TestFollowerForwardsToLeader(t *testing.T) {
+	server := fake.NewAPIServer()
+	defer server.Close()
+	addTestFunction(server)
+
+	leader, backend := makeTestExecutor(server, "")
+	leaderServer := httptest.NewServer(leader.getRouter())
+	defer leaderServer.Close()
+
+	follower, _ := makeTestExecutor(server, leaderServer.URL)
+	followerServer := httptest.NewServer(follower.getRouter())
+	defer followerServer.Close()
+
+	// Followers have no function services of their own
+	testFunctionServicesApi(t, followerServer.URL, backend)
+
+	// Nor do they forward requests to themselves while they don't know
+	// the leader
+	follower.elector.setLeader(follower.elector.identity)
+	status, _ := request(t, followerServer.URL, "GET", "/v2/functionServices", "")
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("expected follower without leader to be unavailable, got %v", status)
+	}
+}
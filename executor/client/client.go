@@ -34,16 +34,31 @@ import (
 	"github.com/fission/fission"
 )
 
+const (
+	maxRetries        = 3
+	initialRetryDelay = 100 * time.Millisecond
+)
+
 type Client struct {
-	executorUrl string
+	resolveUrl  func() (string, error)
 	tappedByUrl map[string]bool
 	requestChan chan string
 	httpClient  *http.Client
 }
 
 func MakeClient(executorUrl string) *Client {
+	executorUrl = strings.TrimSuffix(executorUrl, "/")
+	return MakeClientWithResolver(func() (string, error) {
+		return executorUrl, nil
+	})
+}
+
+// MakeClientWithResolver returns a client of the executor at the URL
+// returned by resolveUrl, which is called again before every retry of a
+// request, so that retries follow a change of the executor leader.
+func MakeClientWithResolver(resolveUrl func() (string, error)) *Client {
 	c := &Client{
-		executorUrl: strings.TrimSuffix(executorUrl, "/"),
+		resolveUrl:  resolveUrl,
 		tappedByUrl: make(map[string]bool),
 		requestChan: make(chan string),
 		httpClient: &http.Client{
@@ -54,22 +69,24 @@ func MakeClient(executorUrl string) *Client {
 	return c
 }
 
+// GetServiceForFunction returns the address of a function service for the
+// function. Requests failing because the executor replica that received them
+// is unreachable or unavailable are retried, so that the executor service
+// routes them to another replica.
 func (c *Client) GetServiceForFunction(ctx context.Context, metadata *metav1.ObjectMeta) (string, error) {
-	executorUrl := c.executorUrl + "/v2/getServiceForFunction"
-
 	body, err := json.Marshal(metadata)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := ctxhttp.Post(ctx, c.httpClient, executorUrl, "application/json", bytes.NewReader(body))
+	resp, err := c.postWithRetry(ctx, "/v2/getServiceForFunction", "application/json", body)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return "", fission.MakeErrorFromHTTP(resp)
+		return "", fission.MakeSpecializationErrorFromHTTP(resp)
 	}
 
 	svcName, err := ioutil.ReadAll(resp.Body)
@@ -81,6 +98,93 @@ func (c *Client) GetServiceForFunction(ctx context.Context, metadata *metav1.Obj
 	return string(svcName), nil
 }
 
+// postWithRetry posts the body to the path of the executor, retrying with
+// a backoff on network errors and on responses of executor replicas that
+// can't serve the request, like a replica that doesn't know the current
+// leader. The executor URL is resolved again for every attempt.
+func (c *Client) postWithRetry(ctx context.Context, path string, bodyType string, body []byte) (*http.Response, error) {
+	delay := initialRetryDelay
+	for i := 0; ; i++ {
+		var resp *http.Response
+		executorUrl, err := c.resolveUrl()
+		if err == nil {
+			executorUrl += path
+			resp, err = ctxhttp.Post(ctx, c.httpClient, executorUrl, bodyType, bytes.NewReader(body))
+		}
+		if i >= maxRetries || ctx.Err() != nil || (err == nil && !isRetriableStatus(resp.StatusCode)) {
+			return resp, err
+		}
+		if err != nil {
+			log.Printf("Error posting to executor %v, retrying: %v", executorUrl, err)
+		} else {
+			log.Printf("Executor %v returned %v, retrying", executorUrl, resp.Status)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func isRetriableStatus(code int) bool {
+	return code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// ListFunctionServices returns the function services of the executor. The
+// namespace and name filter the function services by function, if set.
+func (c *Client) ListFunctionServices(ctx context.Context, namespace string, name string) ([]fission.FunctionServiceInfo, error) {
+	query := url.Values{}
+	query.Set("namespace", namespace)
+	query.Set("name", name)
+	executorUrl, err := c.resolveUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ctxhttp.Get(ctx, c.httpClient, executorUrl+"/v2/functionServices?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fission.MakeErrorFromHTTP(resp)
+	}
+
+	var fsvcs []fission.FunctionServiceInfo
+	err = json.NewDecoder(resp.Body).Decode(&fsvcs)
+	if err != nil {
+		return nil, err
+	}
+	return fsvcs, nil
+}
+
+// EvictFunction removes all function services of the function, along with
+// the kubernetes objects backing them.
+func (c *Client) EvictFunction(ctx context.Context, metadata *metav1.ObjectMeta) error {
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.postWithRetry(ctx, "/v2/evict", "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fission.MakeErrorFromHTTP(resp)
+	}
+	return nil
+}
+
 func (c *Client) service() {
 	ticker := time.NewTicker(time.Second * 5)
 	for {
@@ -110,9 +214,7 @@ func (c *Client) TapService(serviceUrl *url.URL) {
 }
 
 func (c *Client) _tapService(serviceUrlStr string) error {
-	executorUrl := c.executorUrl + "/v2/tapService"
-
-	resp, err := http.Post(executorUrl, "application/octet-stream", bytes.NewReader([]byte(serviceUrlStr)))
+	resp, err := c.postWithRetry(context.Background(), "/v2/tapService", "application/octet-stream", []byte(serviceUrlStr))
 	if err != nil {
 		return err
 	}
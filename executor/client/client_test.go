@@ -0,0 +1,99 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// makeTestExecutor returns an executor answering requests for function
+// services with the given status, and counting them.
+func makeTestExecutor(status int, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if r.URL.Path != "/v2/getServiceForFunction" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("10.0.0.1:8888"))
+	}))
+}
+
+func TestRetryResolvesLeaderAgain(t *testing.T) {
+	fn := &metav1.ObjectMeta{Name: "hello", Namespace: "default"}
+
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		var oldRequests, newRequests int32
+		oldLeader := makeTestExecutor(status, &oldRequests)
+		newLeader := makeTestExecutor(http.StatusOK, &newRequests)
+
+		// The leader changes after the first attempt
+		var resolved int32
+		c := MakeClientWithResolver(func() (string, error) {
+			if atomic.AddInt32(&resolved, 1) == 1 {
+				return oldLeader.URL, nil
+			}
+			return newLeader.URL, nil
+		})
+
+		address, err := c.GetServiceForFunction(context.Background(), fn)
+		if err != nil || address != "10.0.0.1:8888" {
+			t.Fatalf("expected request to be retried on new leader after %v, got %q, %v", status, address, err)
+		}
+		if oldRequests != 1 || newRequests != 1 || resolved != 2 {
+			t.Fatalf("expected one request to every leader after %v, got %v and %v, resolved %v times",
+				status, oldRequests, newRequests, resolved)
+		}
+		oldLeader.Close()
+		newLeader.Close()
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	fn := &metav1.ObjectMeta{Name: "hello", Namespace: "default"}
+
+	// Other errors are returned right away
+	var requests int32
+	executor := makeTestExecutor(http.StatusInternalServerError, &requests)
+	defer executor.Close()
+	if _, err := MakeClient(executor.URL).GetServiceForFunction(context.Background(), fn); err == nil || requests != 1 {
+		t.Fatalf("expected internal error not to be retried, got %v requests, %v", requests, err)
+	}
+
+	// Unavailable executors are retried a limited number of times
+	requests = 0
+	unavailable := makeTestExecutor(http.StatusServiceUnavailable, &requests)
+	defer unavailable.Close()
+	if _, err := MakeClient(unavailable.URL).GetServiceForFunction(context.Background(), fn); err == nil || requests != maxRetries+1 {
+		t.Fatalf("expected %v attempts, got %v requests, %v", maxRetries+1, requests, err)
+	}
+
+	// Nor are requests that were cancelled
+	requests = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := MakeClient(unavailable.URL).GetServiceForFunction(ctx, fn); err == nil || requests != 0 {
+		t.Fatalf("expected cancelled request to fail without retries, got %v requests, %v", requests, err)
+	}
+}
@@ -0,0 +1,348 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	fetcherClient "github.com/fission/fission/environments/fetcher/client"
+	"github.com/fission/fission/executor/fscache"
+)
+
+const reloadTimeout = 30 * time.Second
+
+type (
+	// configWatcher watches the Secrets and ConfigMaps of functions and,
+	// when one changes, asks the fetchers of the specialized pods of the
+	// functions to rewrite it. Only the leader watches, and only the
+	// objects that functions reference.
+	configWatcher struct {
+		kubernetesClient *kubernetes.Clientset
+		fsCache          *fscache.FunctionServiceCache
+
+		funcStore       k8sCache.Store
+		funcController  k8sCache.Controller
+		reloadRequestCh chan *fission.FunctionReloadRequest
+
+		// watches of the referenced objects by their watchKey, none are
+		// started once stopped
+		watchLock sync.Mutex
+		watches   map[watchKey]*objectWatch
+		stopped   bool
+	}
+
+	watchKey struct {
+		resource  string
+		namespace string
+		name      string
+	}
+
+	// objectWatch watches a single Secret or ConfigMap for as long as
+	// functions reference it.
+	objectWatch struct {
+		refs   int
+		stopCh chan struct{}
+	}
+)
+
+func makeConfigWatcher(fissionClient *crd.FissionClient, kubernetesClient *kubernetes.Clientset, fsCache *fscache.FunctionServiceCache) *configWatcher {
+	cw := &configWatcher{
+		kubernetesClient: kubernetesClient,
+		fsCache:          fsCache,
+		reloadRequestCh:  make(chan *fission.FunctionReloadRequest, 100),
+		watches:          make(map[watchKey]*objectWatch),
+	}
+
+	resyncPeriod := 30 * time.Second
+
+	funcLw := k8sCache.NewListWatchFromClient(fissionClient.GetCrdClient(), "functions", metav1.NamespaceAll, fields.Everything())
+	cw.funcStore, cw.funcController = k8sCache.NewInformer(funcLw, &crd.Function{}, resyncPeriod,
+		k8sCache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				cw.addWatches(obj.(*crd.Function))
+			},
+			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+				cw.addWatches(newObj.(*crd.Function))
+				cw.removeWatches(oldObj.(*crd.Function))
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(k8sCache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if fn, ok := obj.(*crd.Function); ok {
+					cw.removeWatches(fn)
+				}
+			},
+		})
+
+	return cw
+}
+
+// watchKeys returns the Secrets and ConfigMaps the function references.
+// Pods of container functions have no fetcher, so their references aren't
+// watched.
+func watchKeys(fn *crd.Function) []watchKey {
+	if fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType == fission.ExecutorTypeContainer {
+		return nil
+	}
+	var keys []watchKey
+	for _, ref := range fn.Spec.Secrets {
+		keys = append(keys, watchKey{resource: "secrets", namespace: ref.Namespace, name: ref.Name})
+	}
+	for _, ref := range fn.Spec.ConfigMaps {
+		keys = append(keys, watchKey{resource: "configmaps", namespace: ref.Namespace, name: ref.Name})
+	}
+	return keys
+}
+
+// addWatches starts watching the objects the function references that
+// aren't watched yet.
+func (cw *configWatcher) addWatches(fn *crd.Function) {
+	cw.watchLock.Lock()
+	defer cw.watchLock.Unlock()
+	if cw.stopped {
+		return
+	}
+	for _, key := range watchKeys(fn) {
+		if watch, ok := cw.watches[key]; ok {
+			watch.refs++
+			continue
+		}
+		watch := &objectWatch{refs: 1, stopCh: make(chan struct{})}
+		cw.watches[key] = watch
+		go cw.makeObjectController(key).Run(watch.stopCh)
+	}
+}
+
+// removeWatches stops watching the objects the function referenced that no
+// other function references.
+func (cw *configWatcher) removeWatches(fn *crd.Function) {
+	cw.watchLock.Lock()
+	defer cw.watchLock.Unlock()
+	for _, key := range watchKeys(fn) {
+		watch, ok := cw.watches[key]
+		if !ok {
+			continue
+		}
+		watch.refs--
+		if watch.refs == 0 {
+			close(watch.stopCh)
+			delete(cw.watches, key)
+		}
+	}
+}
+
+// stopWatches stops watching all objects.
+func (cw *configWatcher) stopWatches() {
+	cw.watchLock.Lock()
+	defer cw.watchLock.Unlock()
+	cw.stopped = true
+	for key, watch := range cw.watches {
+		close(watch.stopCh)
+		delete(cw.watches, key)
+	}
+}
+
+// makeObjectController returns an informer of the single object of the
+// key, which queues a reload whenever its data changes. Only updates
+// matter; functions get the Secrets and ConfigMaps that exist when they
+// are specialized.
+func (cw *configWatcher) makeObjectController(key watchKey) k8sCache.Controller {
+	resyncPeriod := 30 * time.Second
+	lw := k8sCache.NewListWatchFromClient(cw.kubernetesClient.CoreV1().RESTClient(), key.resource, key.namespace,
+		fields.OneTermEqualSelector("metadata.name", key.name))
+
+	if key.resource == "secrets" {
+		_, controller := k8sCache.NewInformer(lw, &apiv1.Secret{}, resyncPeriod,
+			k8sCache.ResourceEventHandlerFuncs{
+				UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+					oldSecret, newSecret := oldObj.(*apiv1.Secret), newObj.(*apiv1.Secret)
+					if reflect.DeepEqual(oldSecret.Data, newSecret.Data) {
+						return
+					}
+					cw.queue(&fission.FunctionReloadRequest{
+						Secrets: []fission.SecretReference{{Namespace: newSecret.Namespace, Name: newSecret.Name}},
+					})
+				},
+			})
+		return controller
+	}
+
+	_, controller := k8sCache.NewInformer(lw, &apiv1.ConfigMap{}, resyncPeriod,
+		k8sCache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+				oldCm, newCm := oldObj.(*apiv1.ConfigMap), newObj.(*apiv1.ConfigMap)
+				if reflect.DeepEqual(oldCm.Data, newCm.Data) {
+					return
+				}
+				cw.queue(&fission.FunctionReloadRequest{
+					ConfigMaps: []fission.ConfigMapReference{{Namespace: newCm.Namespace, Name: newCm.Name}},
+				})
+			},
+		})
+	return controller
+}
+
+// queue hands a change to the reload loop, dropping it if the loop is
+// too far behind rather than blocking the informer.
+func (cw *configWatcher) queue(req *fission.FunctionReloadRequest) {
+	select {
+	case cw.reloadRequestCh <- req:
+	default:
+		log.Printf("Too many pending reloads, dropping reload of %v", describeReload(req))
+	}
+}
+
+// run watches Secrets and ConfigMaps until the context is done.
+func (cw *configWatcher) run(ctx context.Context) {
+	defer cw.stopWatches()
+
+	go cw.funcController.Run(ctx.Done())
+	k8sCache.WaitForCacheSync(ctx.Done(), cw.funcController.HasSynced)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-cw.reloadRequestCh:
+			for _, fn := range cw.referencingFunctions(req) {
+				cw.reloadFunction(ctx, fn, req)
+			}
+		}
+	}
+}
+
+// referencingFunctions returns the functions using the Secret or ConfigMap
+// of the request.
+func (cw *configWatcher) referencingFunctions(req *fission.FunctionReloadRequest) []*crd.Function {
+	var fns []*crd.Function
+	for _, obj := range cw.funcStore.List() {
+		fn := obj.(*crd.Function)
+		// Pods of container functions have no fetcher
+		if fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType == fission.ExecutorTypeContainer {
+			continue
+		}
+		if referencesAny(fn, req) {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+func referencesAny(fn *crd.Function, req *fission.FunctionReloadRequest) bool {
+	for _, s := range req.Secrets {
+		for _, ref := range fn.Spec.Secrets {
+			if ref == s {
+				return true
+			}
+		}
+	}
+	for _, c := range req.ConfigMaps {
+		for _, ref := range fn.Spec.ConfigMaps {
+			if ref == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reloadFunction asks the fetcher of every running specialized pod of the
+// function to reload.
+func (cw *configWatcher) reloadFunction(ctx context.Context, fn *crd.Function, req *fission.FunctionReloadRequest) {
+	selector := labels.Set{fission.FUNCTION_UID: string(fn.Metadata.UID)}.AsSelector().String()
+	podList, err := cw.kubernetesClient.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		log.Printf("Error listing pods of function %v to reload %v: %v", fn.Metadata.Name, describeReload(req), err)
+		return
+	}
+	pods := append(podList.Items, cw.sharedPods(fn)...)
+
+	for _, pod := range pods {
+		if pod.Status.Phase != apiv1.PodRunning || len(pod.Status.PodIP) == 0 || pod.DeletionTimestamp != nil {
+			continue
+		}
+		go func(pod apiv1.Pod) {
+			ctx, cancel := context.WithTimeout(ctx, reloadTimeout)
+			defer cancel()
+
+			fetcherURL := fmt.Sprintf("http://%v:8000", pod.Status.PodIP)
+			err := fetcherClient.MakeClient(fetcherURL).Reload(ctx, req)
+			if err != nil {
+				log.Printf("Error reloading %v in pod %v of function %v: %v", describeReload(req), pod.Name, fn.Metadata.Name, err)
+				return
+			}
+			log.Printf("Reloaded %v in pod %v of function %v", describeReload(req), pod.Name, fn.Metadata.Name)
+		}(pod)
+	}
+}
+
+// sharedPods returns the pod of the function in pools of environments
+// allowing multiple functions per container. Such pods keep the labels of
+// the pool rather than getting those of the function, so they're found
+// through the function service cache instead.
+func (cw *configWatcher) sharedPods(fn *crd.Function) []apiv1.Pod {
+	fsvc, err := cw.fsCache.GetByFunctionUID(fn.Metadata.UID)
+	if err != nil {
+		return nil
+	}
+	if fsvc.Executor != fission.ExecutorTypePoolmgr || fsvc.Environment == nil ||
+		fsvc.Environment.Spec.AllowedFunctionsPerContainer != fission.AllowedFunctionsPerContainerInfinite {
+		return nil
+	}
+
+	var pods []apiv1.Pod
+	for _, obj := range fsvc.KubernetesObjects {
+		if strings.ToLower(obj.Kind) != "pod" {
+			continue
+		}
+		pod, err := cw.kubernetesClient.CoreV1().Pods(obj.Namespace).Get(obj.Name, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Error getting pod %v of function %v: %v", obj.Name, fn.Metadata.Name, err)
+			continue
+		}
+		if pod.UID == obj.UID {
+			pods = append(pods, *pod)
+		}
+	}
+	return pods
+}
+
+func describeReload(req *fission.FunctionReloadRequest) string {
+	if len(req.Secrets) > 0 {
+		return fmt.Sprintf("secret %v/%v", req.Secrets[0].Namespace, req.Secrets[0].Name)
+	}
+	if len(req.ConfigMaps) > 0 {
+		return fmt.Sprintf("configmap %v/%v", req.ConfigMaps[0].Namespace, req.ConfigMaps[0].Name)
+	}
+	return "nothing"
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/deployutil"
+)
+
+const (
+	// Secrets and configmaps are mounted at the same paths the fetcher
+	// uses for the other executor types.
+	secretsMountPath    = "/secrets"
+	configMapsMountPath = "/configs"
+)
+
+func (cn *Container) createOrGetDeployment(fn *crd.Function, env *crd.Environment, image string,
+	deployName string, deployLabels map[string]string, deployNamespace string, firstcreate bool) (*v1beta1.Deployment, error) {
+
+	return cn.deployMgr.CreateOrGetDeployment(fn, deployName, deployNamespace, firstcreate,
+		func() (*v1beta1.Deployment, error) {
+			return cn.getDeploymentSpec(fn, env, image, deployName, deployLabels)
+		})
+}
+
+func (cn *Container) getDeploymentSpec(fn *crd.Function, env *crd.Environment, image string,
+	deployName string, deployLabels map[string]string) (*v1beta1.Deployment, error) {
+
+	replicas := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
+
+	gracePeriodSeconds := deployutil.GetTerminationGracePeriod(env)
+	podAnnotations := deployutil.GetPodAnnotations(env, cn.useIstio)
+
+	volumes, volumeMounts := getSecretAndConfigMapVolumes(fn)
+
+	deployment := &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   deployName,
+			Labels: deployLabels,
+		},
+		Spec: v1beta1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: deployLabels,
+			},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      deployLabels,
+					Annotations: podAnnotations,
+				},
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{
+						{
+							Name:                   fn.Metadata.Name,
+							Image:                  image,
+							ImagePullPolicy:        cn.runtimeImagePullPolicy,
+							TerminationMessagePath: "/dev/termination-log",
+							// The image is not an environment runtime, so it
+							// gets the lifecycle of the earliest interface.
+							Lifecycle: fission.RuntimeLifecycle(1, gracePeriodSeconds),
+							Ports: []apiv1.ContainerPort{
+								{
+									Name:          "http-env",
+									ContainerPort: int32(8888),
+								},
+							},
+							ReadinessProbe: &apiv1.Probe{
+								Handler: apiv1.Handler{
+									TCPSocket: &apiv1.TCPSocketAction{
+										Port: intstr.FromInt(8888),
+									},
+								},
+								PeriodSeconds: 1,
+							},
+							Env:          fn.Spec.Env,
+							Resources:    deployutil.GetResources(env, fn),
+							VolumeMounts: volumeMounts,
+						},
+					},
+					Volumes:                       volumes,
+					TerminationGracePeriodSeconds: &gracePeriodSeconds,
+				},
+			},
+		},
+	}
+
+	return deployment, nil
+}
+
+// getSecretAndConfigMapVolumes returns the volumes and mounts that expose the
+// function's secrets and configmaps to the function container. Since there is
+// no fetcher, the kubernetes volume plugins take its place.
+func getSecretAndConfigMapVolumes(fn *crd.Function) ([]apiv1.Volume, []apiv1.VolumeMount) {
+	volumes := make([]apiv1.Volume, 0)
+	volumeMounts := make([]apiv1.VolumeMount, 0)
+
+	for i, secret := range fn.Spec.Secrets {
+		name := fmt.Sprintf("secret-%v", i)
+		volumes = append(volumes, apiv1.Volume{
+			Name: name,
+			VolumeSource: apiv1.VolumeSource{
+				Secret: &apiv1.SecretVolumeSource{
+					SecretName: secret.Name,
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, apiv1.VolumeMount{
+			Name:      name,
+			MountPath: filepath.Join(secretsMountPath, secret.Namespace, secret.Name),
+			ReadOnly:  true,
+		})
+	}
+
+	for i, cfgmap := range fn.Spec.ConfigMaps {
+		name := fmt.Sprintf("configmap-%v", i)
+		volumes = append(volumes, apiv1.Volume{
+			Name: name,
+			VolumeSource: apiv1.VolumeSource{
+				ConfigMap: &apiv1.ConfigMapVolumeSource{
+					LocalObjectReference: apiv1.LocalObjectReference{
+						Name: cfgmap.Name,
+					},
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, apiv1.VolumeMount{
+			Name:      name,
+			MountPath: filepath.Join(configMapsMountPath, cfgmap.Namespace, cfgmap.Name),
+			ReadOnly:  true,
+		})
+	}
+
+	return volumes, volumeMounts
+}
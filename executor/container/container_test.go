@@ -0,0 +1,219 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/crd/fake"
+	"github.com/fission/fission/executor/executortype"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// The container executor is one of the executor backends.
+var _ executortype.ExecutorBackend = &Container{}
+
+func makeTestContainer(t *testing.T) (*Container, *fake.APIServer) {
+	server := fake.NewAPIServer()
+	fissionClient, kubernetesClient := server.Clients()
+	cn := MakeContainer(fissionClient, kubernetesClient, nil, fscache.MakeFunctionServiceCache(), "test")
+	if cn.GetTypeName() != fission.ExecutorTypeContainer {
+		t.Fatalf("unexpected executor type %v", cn.GetTypeName())
+	}
+	return cn, server
+}
+
+func makeTestFunction(executorType fission.ExecutorType) *crd.Function {
+	fn := &crd.Function{}
+	fn.Metadata.Name = "hello"
+	fn.Metadata.Namespace = "team-a"
+	fn.Metadata.UID = "fn-uid"
+	fn.Metadata.ResourceVersion = "1"
+	fn.Spec.Environment = fission.EnvironmentReference{Namespace: "team-a", Name: "env"}
+	fn.Spec.Package.PackageRef = fission.PackageRef{Namespace: "team-a", Name: "pkg"}
+	fn.Spec.InvokeStrategy.ExecutionStrategy = fission.ExecutionStrategy{
+		ExecutorType: executorType,
+		MaxScale:     1,
+	}
+	return fn
+}
+
+func addTestPackage(server *fake.APIServer, image string) {
+	pkg := &crd.Package{}
+	pkg.Metadata.Name = "pkg"
+	pkg.Spec.Deployment = fission.Archive{Image: image}
+	server.Add("/apis/fission.io/v1/namespaces/team-a/packages/pkg", pkg)
+
+	env := &crd.Environment{}
+	env.Metadata.Name = "env"
+	server.Add("/apis/fission.io/v1/namespaces/team-a/environments/env", env)
+}
+
+func TestGetDeploymentSpec(t *testing.T) {
+	cn, server := makeTestContainer(t)
+	defer server.Close()
+
+	fn := makeTestFunction(fission.ExecutorTypeContainer)
+	fn.Spec.Secrets = []fission.SecretReference{{Namespace: "team-a", Name: "creds"}}
+	fn.Spec.ConfigMaps = []fission.ConfigMapReference{{Namespace: "team-a", Name: "settings"}}
+	fn.Spec.Env = []apiv1.EnvVar{
+		{Name: "LOG_LEVEL", Value: "debug"},
+		{Name: "API_TOKEN", ValueFrom: &apiv1.EnvVarSource{SecretKeyRef: &apiv1.SecretKeySelector{
+			LocalObjectReference: apiv1.LocalObjectReference{Name: "creds"},
+			Key:                  "token",
+		}}},
+	}
+	env := &crd.Environment{}
+	labels := map[string]string{"app": "hello"}
+
+	deployment, err := cn.getDeploymentSpec(fn, env, "registry/hello:1", "hello-container-team-a", labels)
+	if err != nil {
+		t.Fatalf("error getting deployment spec: %v", err)
+	}
+
+	// The image is the only container; there's no fetcher to specialize it
+	podSpec := deployment.Spec.Template.Spec
+	if len(podSpec.Containers) != 1 || podSpec.Containers[0].Image != "registry/hello:1" {
+		t.Fatalf("expected only the function image, got containers %+v", podSpec.Containers)
+	}
+	if !reflect.DeepEqual(deployment.Spec.Selector.MatchLabels, labels) {
+		t.Fatalf("unexpected selector %v", deployment.Spec.Selector.MatchLabels)
+	}
+
+	// The deployment is in the namespace of the function, so the
+	// environment variables are set on the container as they are
+	if !reflect.DeepEqual(podSpec.Containers[0].Env, fn.Spec.Env) {
+		t.Fatalf("expected environment variables of function, got %+v", podSpec.Containers[0].Env)
+	}
+
+	// Secrets and ConfigMaps are mounted where the fetcher would have
+	// written them
+	expectedVolumes := []apiv1.Volume{
+		{Name: "secret-0", VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: "creds"}}},
+		{Name: "configmap-0", VolumeSource: apiv1.VolumeSource{ConfigMap: &apiv1.ConfigMapVolumeSource{
+			LocalObjectReference: apiv1.LocalObjectReference{Name: "settings"},
+		}}},
+	}
+	if !reflect.DeepEqual(podSpec.Volumes, expectedVolumes) {
+		t.Fatalf("unexpected volumes %+v", podSpec.Volumes)
+	}
+	expectedMounts := []apiv1.VolumeMount{
+		{Name: "secret-0", MountPath: "/secrets/team-a/creds", ReadOnly: true},
+		{Name: "configmap-0", MountPath: "/configs/team-a/settings", ReadOnly: true},
+	}
+	if !reflect.DeepEqual(podSpec.Containers[0].VolumeMounts, expectedMounts) {
+		t.Fatalf("unexpected volume mounts %+v", podSpec.Containers[0].VolumeMounts)
+	}
+}
+
+func TestGetFunctionImage(t *testing.T) {
+	cn, server := makeTestContainer(t)
+	defer server.Close()
+	fn := makeTestFunction(fission.ExecutorTypeContainer)
+
+	if _, err := cn.getFunctionImage(fn); err == nil {
+		t.Fatalf("expected function without package to fail")
+	}
+
+	addTestPackage(server, "")
+	_, err := cn.getFunctionImage(fn)
+	if fe, ok := err.(fission.Error); !ok || fe.Code != fission.ErrorInvalidArgument {
+		t.Fatalf("expected package without image to be an invalid argument, got %v", err)
+	}
+
+	addTestPackage(server, "registry/hello:1")
+	if image, err := cn.getFunctionImage(fn); err != nil || image != "registry/hello:1" {
+		t.Fatalf("expected image of the package, got %q, %v", image, err)
+	}
+}
+
+func TestFnUpdate(t *testing.T) {
+	cn, server := makeTestContainer(t)
+	defer server.Close()
+	addTestPackage(server, "registry/hello:1")
+
+	fn := makeTestFunction(fission.ExecutorTypeContainer)
+	objName := cn.getObjName(fn)
+	deployPath := fmt.Sprintf("/apis/extensions/v1beta1/namespaces/team-a/deployments/%v", objName)
+
+	updated := func(fn *crd.Function, executorType fission.ExecutorType) *crd.Function {
+		newFn := *fn
+		newFn.Metadata.ResourceVersion = fn.Metadata.ResourceVersion + "1"
+		newFn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType = executorType
+		return &newFn
+	}
+
+	// Updates of functions of other executor types, and resyncs, are ignored
+	poolFn := makeTestFunction(fission.ExecutorTypePoolmgr)
+	cn.fnUpdate(poolFn, updated(poolFn, fission.ExecutorTypeNewdeploy))
+	cn.fnUpdate(fn, fn)
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Fatalf("expected no requests, got %v", requests)
+	}
+
+	// Functions switching to the container executor get their objects
+	cn.fnUpdate(poolFn, updated(poolFn, fission.ExecutorTypeContainer))
+	var deployment v1beta1.Deployment
+	if !server.Get(deployPath, &deployment) {
+		t.Fatalf("expected deployment %v, got requests %v", objName, server.Requests())
+	}
+	for _, path := range []string{
+		fmt.Sprintf("/api/v1/namespaces/team-a/services/%v", objName),
+		fmt.Sprintf("/apis/autoscaling/v1/namespaces/team-a/horizontalpodautoscalers/%v", objName),
+	} {
+		if !server.Get(path, &struct{}{}) {
+			t.Fatalf("expected %v to be created", path)
+		}
+	}
+	if _, err := cn.fsCache.GetByFunctionUID(fn.Metadata.UID); err != nil {
+		t.Fatalf("expected function service in the cache: %v", err)
+	}
+
+	// Changes of the secrets of the function update the deployment
+	withSecret := updated(fn, fission.ExecutorTypeContainer)
+	withSecret.Spec.Secrets = []fission.SecretReference{{Namespace: "team-a", Name: "creds"}}
+	cn.fnUpdate(fn, withSecret)
+	server.Get(deployPath, &deployment)
+	if volumes := deployment.Spec.Template.Spec.Volumes; len(volumes) != 1 || volumes[0].Secret.SecretName != "creds" {
+		t.Fatalf("expected deployment to mount the new secret, got volumes %+v", volumes)
+	}
+
+	// So do changes of its environment variables
+	withEnv := updated(withSecret, fission.ExecutorTypeContainer)
+	withEnv.Spec.Env = []apiv1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}
+	cn.fnUpdate(withSecret, withEnv)
+	server.Get(deployPath, &deployment)
+	if env := deployment.Spec.Template.Spec.Containers[0].Env; !reflect.DeepEqual(env, withEnv.Spec.Env) {
+		t.Fatalf("expected deployment to set the new environment variables, got %+v", env)
+	}
+
+	// Functions switching to another executor lose their objects
+	cn.fnUpdate(withSecret, updated(withSecret, fission.ExecutorTypeNewdeploy))
+	if server.Get(deployPath, &deployment) {
+		t.Fatalf("expected deployment to be deleted")
+	}
+	if _, err := cn.fsCache.GetByFunctionUID(fn.Metadata.UID); err == nil {
+		t.Fatalf("expected function service to be removed from the cache")
+	}
+}
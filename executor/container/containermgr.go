@@ -0,0 +1,449 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/deployutil"
+	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/reaper"
+)
+
+type (
+	requestType int
+
+	// Container is the executor backend for functions whose deployment
+	// archive is a container image. The image is run as-is as the function
+	// container; unlike poolmgr and newdeploy there is no fetcher sidecar
+	// and no specialization step, so the image is expected to serve the
+	// function on port 8888 as soon as it is ready.
+	Container struct {
+		kubernetesClient *kubernetes.Clientset
+		fissionClient    *crd.FissionClient
+		crdClient        *rest.RESTClient
+		instanceID       string
+
+		runtimeImagePullPolicy apiv1.PullPolicy
+		useIstio               bool
+
+		fsCache        *fscache.FunctionServiceCache // cache funcSvc's by function, address and podname
+		deployMgr      *deployutil.Manager
+		requestChannel chan *fnRequest
+
+		funcStore      k8sCache.Store
+		funcController k8sCache.Controller
+	}
+
+	fnRequest struct {
+		reqType         requestType
+		fn              *crd.Function
+		responseChannel chan *fnResponse
+		firstcreate     bool
+	}
+
+	fnResponse struct {
+		error
+		fSvc *fscache.FuncSvc
+	}
+)
+
+const (
+	FnCreate requestType = iota
+	FnDelete
+)
+
+func MakeContainer(
+	fissionClient *crd.FissionClient,
+	kubernetesClient *kubernetes.Clientset,
+	crdClient *rest.RESTClient,
+	fsCache *fscache.FunctionServiceCache,
+	instanceID string,
+) *Container {
+
+	log.Printf("Creating Container ExecutorType")
+
+	enableIstio := false
+	if len(os.Getenv("ENABLE_ISTIO")) > 0 {
+		istio, err := strconv.ParseBool(os.Getenv("ENABLE_ISTIO"))
+		if err != nil {
+			log.Println("Failed to parse ENABLE_ISTIO")
+		}
+		enableIstio = istio
+	}
+
+	cn := &Container{
+		fissionClient:    fissionClient,
+		kubernetesClient: kubernetesClient,
+		crdClient:        crdClient,
+		instanceID:       instanceID,
+
+		fsCache:   fsCache,
+		deployMgr: deployutil.MakeManager(kubernetesClient, fissionClient, fsCache, fission.ExecutorTypeContainer, 2*time.Minute),
+		useIstio:  enableIstio,
+
+		requestChannel: make(chan *fnRequest),
+	}
+
+	cn.runtimeImagePullPolicy = fission.GetImagePullPolicy(os.Getenv("RUNTIME_IMAGE_PULL_POLICY"))
+
+	if cn.crdClient != nil {
+		fnStore, fnController := cn.initFuncController()
+		cn.funcStore = fnStore
+		cn.funcController = fnController
+	}
+
+	return cn
+}
+
+func (cn *Container) Run(ctx context.Context) {
+	go cn.service()
+	go cn.funcController.Run(ctx.Done())
+}
+
+func (cn *Container) GetTypeName() fission.ExecutorType {
+	return fission.ExecutorTypeContainer
+}
+
+// AdoptExistingResources relabels the objects created by old executor
+// instances for functions that still exist. The function services are added
+// to the cache again when the function informer creates them on startup.
+func (cn *Container) AdoptExistingResources() {
+	err := reaper.AdoptDeployObjects(cn.kubernetesClient, cn.fissionClient, fission.ExecutorTypeContainer, cn.instanceID)
+	if err != nil {
+		log.Printf("Error adopting container objects of old executor instances: %v", err)
+	}
+}
+
+func (cn *Container) initFuncController() (k8sCache.Store, k8sCache.Controller) {
+	resyncPeriod := 30 * time.Second
+	listWatch := k8sCache.NewListWatchFromClient(cn.crdClient, "functions", metav1.NamespaceAll, fields.Everything())
+	store, controller := k8sCache.NewInformer(listWatch, &crd.Function{}, resyncPeriod, k8sCache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			fn := obj.(*crd.Function)
+			cn.createFunction(fn, true)
+		},
+		DeleteFunc: func(obj interface{}) {
+			fn := obj.(*crd.Function)
+			cn.deleteFunction(fn)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			oldFn := oldObj.(*crd.Function)
+			newFn := newObj.(*crd.Function)
+			cn.fnUpdate(oldFn, newFn)
+		},
+	})
+	return store, controller
+}
+
+func (cn *Container) service() {
+	for {
+		req := <-cn.requestChannel
+		switch req.reqType {
+		case FnCreate:
+			fsvc, err := cn.fnCreate(req.fn, req.firstcreate)
+			req.responseChannel <- &fnResponse{
+				error: err,
+				fSvc:  fsvc,
+			}
+		case FnDelete:
+			err := cn.fnDelete(req.fn)
+			req.responseChannel <- &fnResponse{
+				error: err,
+			}
+		}
+	}
+}
+
+func (cn *Container) GetFuncSvc(ctx context.Context, metadata *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
+	c := make(chan *fnResponse)
+	fn, err := cn.fissionClient.Functions(metadata.Namespace).Get(metadata.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	cn.requestChannel <- &fnRequest{
+		fn:              fn,
+		reqType:         FnCreate,
+		responseChannel: c,
+		firstcreate:     false,
+	}
+
+	resp := <-c
+	if resp.error != nil {
+		return nil, resp.error
+	}
+	return resp.fSvc, nil
+}
+
+func (cn *Container) createFunction(fn *crd.Function, firstcreate bool) {
+	if fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType != fission.ExecutorTypeContainer {
+		return
+	}
+
+	// Eager creation of function if minScale is greater than 0
+	log.Printf("Eagerly creating container objects for function %v", fn.Metadata.Name)
+	c := make(chan *fnResponse)
+	cn.requestChannel <- &fnRequest{
+		fn:              fn,
+		reqType:         FnCreate,
+		responseChannel: c,
+		firstcreate:     firstcreate,
+	}
+	resp := <-c
+	if resp.error != nil {
+		log.Printf("Error eager creating function: %v", resp.error)
+	}
+}
+
+func (cn *Container) deleteFunction(fn *crd.Function) {
+	if fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType != fission.ExecutorTypeContainer {
+		return
+	}
+
+	c := make(chan *fnResponse)
+	cn.requestChannel <- &fnRequest{
+		fn:              fn,
+		reqType:         FnDelete,
+		responseChannel: c,
+	}
+	resp := <-c
+	if resp.error != nil {
+		log.Printf("Error deleting the function: %v", resp.error)
+	}
+}
+
+func (cn *Container) fnCreate(fn *crd.Function, firstcreate bool) (*fscache.FuncSvc, error) {
+	fsvc, err := cn.fsCache.GetByFunction(&fn.Metadata)
+	if err == nil {
+		return fsvc, err
+	}
+
+	env, err := cn.fissionClient.
+		Environments(fn.Spec.Environment.Namespace).
+		Get(fn.Spec.Environment.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := cn.getFunctionImage(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	objName := cn.getObjName(fn)
+	deployLabels := cn.getDeployLabels(fn, env)
+
+	// The image is mounted with the function's secrets and configmaps directly,
+	// which requires the deployment to live in the same namespace as the function.
+	ns := fn.Metadata.Namespace
+
+	svc, err := cn.deployMgr.CreateOrGetSvc(deployLabels, objName, ns)
+	if err != nil {
+		log.Printf("Error creating the service %v: %v", objName, err)
+		go cn.deployMgr.Cleanup(ns, objName)
+		return nil, errors.Wrap(err, fmt.Sprintf("error creating service %v", objName))
+	}
+
+	depl, err := cn.createOrGetDeployment(fn, env, image, objName, deployLabels, ns, firstcreate)
+	if err != nil {
+		log.Printf("Error creating the deployment %v: %v", objName, err)
+		go cn.deployMgr.Cleanup(ns, objName)
+		return nil, errors.Wrap(err, fmt.Sprintf("error creating deployment %v", objName))
+	}
+
+	hpa, err := cn.deployMgr.CreateOrGetHpa(objName, &fn.Spec.InvokeStrategy.ExecutionStrategy, depl)
+	if err != nil {
+		go cn.deployMgr.Cleanup(ns, objName)
+		return nil, errors.Wrap(err, fmt.Sprintf("error creating the HPA %v", objName))
+	}
+
+	fsvc = cn.deployMgr.MakeFuncSvc(fn, env, objName, depl, svc, hpa)
+
+	_, err = cn.fsCache.Add(*fsvc)
+	if err != nil {
+		log.Printf("Error adding the function to cache: %v", err)
+		return fsvc, err
+	}
+	return fsvc, nil
+}
+
+func (cn *Container) fnUpdate(oldFn *crd.Function, newFn *crd.Function) {
+	if oldFn.Metadata.ResourceVersion == newFn.Metadata.ResourceVersion {
+		return
+	}
+
+	oldType := oldFn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType
+	newType := newFn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType
+
+	// Ignoring updates to functions which are not of container type
+	if oldType != fission.ExecutorTypeContainer && newType != fission.ExecutorTypeContainer {
+		return
+	}
+
+	// Executor type is no longer container
+	if oldType == fission.ExecutorTypeContainer && newType != fission.ExecutorTypeContainer {
+		log.Printf("function does not use container executor anymore, deleting resources: %v", newFn.Metadata.Name)
+		// IMP - pass the oldFn, as the new/modified function is not in cache
+		cn.fnDelete(oldFn)
+		return
+	}
+
+	// Executor type changed to container from something else
+	if oldType != fission.ExecutorTypeContainer && newType == fission.ExecutorTypeContainer {
+		log.Printf("function type changed to container, creating resources: %v", newFn.Metadata.Name)
+		_, err := cn.fnCreate(newFn, true)
+		if err != nil {
+			log.Printf("Error changing the function's type to container: %v", err)
+		}
+		return
+	}
+
+	ns := newFn.Metadata.Namespace
+	objName := cn.getObjName(newFn)
+
+	if oldFn.Spec.InvokeStrategy != newFn.Spec.InvokeStrategy {
+		err := cn.deployMgr.UpdateHpa(ns, objName, &newFn.Spec.InvokeStrategy.ExecutionStrategy)
+		if err != nil {
+			log.Printf("Error updating HPA while updating function %v: %v", newFn.Metadata.Name, err)
+			return
+		}
+	}
+
+	if oldFn.Spec.Environment != newFn.Spec.Environment ||
+		oldFn.Spec.Package != newFn.Spec.Package ||
+		!reflect.DeepEqual(oldFn.Spec.Secrets, newFn.Spec.Secrets) ||
+		!reflect.DeepEqual(oldFn.Spec.ConfigMaps, newFn.Spec.ConfigMaps) ||
+		!reflect.DeepEqual(oldFn.Spec.Resources, newFn.Spec.Resources) ||
+		!reflect.DeepEqual(oldFn.Spec.Env, newFn.Spec.Env) {
+
+		env, err := cn.fissionClient.Environments(newFn.Spec.Environment.Namespace).
+			Get(newFn.Spec.Environment.Name)
+		if err != nil {
+			log.Printf("Error getting environment while updating function %v: %v", newFn.Metadata.Name, err)
+			return
+		}
+
+		image, err := cn.getFunctionImage(newFn)
+		if err != nil {
+			log.Printf("Error getting image while updating function %v: %v", newFn.Metadata.Name, err)
+			return
+		}
+
+		log.Printf("updating %v deployment due to function %v update", objName, newFn.Metadata.Name)
+		deployment, err := cn.getDeploymentSpec(newFn, env, image, objName, cn.getDeployLabels(oldFn, env))
+		if err != nil {
+			log.Printf("Error getting deployment spec while updating function %v: %v", newFn.Metadata.Name, err)
+			return
+		}
+
+		err = cn.deployMgr.UpdateDeployment(deployment, ns)
+		if err != nil {
+			log.Printf("Error updating deployment while updating function %v: %v", newFn.Metadata.Name, err)
+			return
+		}
+	}
+}
+
+func (cn *Container) fnDelete(fn *crd.Function) error {
+	var multierr *multierror.Error
+
+	// GetByFunction uses resource version as part of cache key, however,
+	// the resource version in function metadata will be changed when a function
+	// is deleted. Use GetByFunctionUID instead to find the fsvc entry.
+	fsvc, err := cn.fsCache.GetByFunctionUID(fn.Metadata.UID)
+	if err == nil {
+		_, err = cn.fsCache.DeleteOld(fsvc, time.Second*0)
+		if err != nil {
+			log.Printf("Error deleting the function from cache: %v", fsvc)
+			multierr = multierror.Append(multierr, err)
+		}
+	}
+
+	err = cn.deployMgr.Cleanup(fn.Metadata.Namespace, cn.getObjName(fn))
+	if err != nil {
+		multierr = multierror.Append(multierr, err)
+	}
+
+	return multierr.ErrorOrNil()
+}
+
+// getFunctionImage returns the image of the deployment archive of the function's package.
+func (cn *Container) getFunctionImage(fn *crd.Function) (string, error) {
+	pkgRef := fn.Spec.Package.PackageRef
+	pkg, err := cn.fissionClient.Packages(pkgRef.Namespace).Get(pkgRef.Name)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error getting package %v", pkgRef.Name))
+	}
+	if len(pkg.Spec.Deployment.Image) == 0 {
+		return "", fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("package %v has no deployment image, required by the container executor", pkgRef.Name))
+	}
+	return pkg.Spec.Deployment.Image, nil
+}
+
+func (cn *Container) getObjName(fn *crd.Function) string {
+	// Use executor type as delimiter between function name and namespace to prevent deployment name conflict.
+	return strings.ToLower(fmt.Sprintf("%v-container-%v", fn.Metadata.Name, fn.Metadata.Namespace))
+}
+
+func (cn *Container) getDeployLabels(fn *crd.Function, env *crd.Environment) map[string]string {
+	return map[string]string{
+		fission.EXECUTOR_INSTANCEID_LABEL: cn.instanceID,
+		fission.EXECUTOR_TYPE:             fission.ExecutorTypeContainer,
+		fission.ENVIRONMENT_NAME:          env.Metadata.Name,
+		fission.ENVIRONMENT_NAMESPACE:     env.Metadata.Namespace,
+		fission.ENVIRONMENT_UID:           string(env.Metadata.UID),
+		fission.FUNCTION_NAME:             fn.Metadata.Name,
+		fission.FUNCTION_NAMESPACE:        fn.Metadata.Namespace,
+		fission.FUNCTION_UID:              string(fn.Metadata.UID),
+	}
+}
+
+// IsValid checks that the service of the function exists and that its
+// deployment has at least one available replica.
+func (cn *Container) IsValid(fsvc *fscache.FuncSvc) bool {
+	return cn.deployMgr.IsValid(fsvc)
+}
+
+// CleanupFuncSvc deletes the service, HPA and deployment of the function service
+func (cn *Container) CleanupFuncSvc(fsvc *fscache.FuncSvc) error {
+	return cn.deployMgr.CleanupFuncSvc(fsvc)
+}
+
+// IdleObjectReaper scales the deployments of idle functions down to their minScale
+func (cn *Container) IdleObjectReaper(ctx context.Context) {
+	cn.deployMgr.IdleObjectReaper(ctx)
+}
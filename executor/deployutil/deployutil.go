@@ -0,0 +1,531 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package deployutil implements the handling of deployments, services and
+// HPAs shared by the executor backends that run every function as its own
+// deployment (newdeploy and container).
+package deployutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	asv1 "k8s.io/api/autoscaling/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	k8sErrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/executortype"
+	"github.com/fission/fission/executor/fscache"
+)
+
+const (
+	DeploymentKind    = "Deployment"
+	DeploymentVersion = "extensions/v1beta1"
+)
+
+type (
+	// Manager creates, scales and deletes the deployment, service and HPA
+	// of functions for one executor backend.
+	Manager struct {
+		kubernetesClient *kubernetes.Clientset
+		fissionClient    *crd.FissionClient
+		fsCache          *fscache.FunctionServiceCache
+		executorType     fission.ExecutorType
+		idlePodReapTime  time.Duration
+	}
+
+	// DeploymentSpecFunc returns the spec of the deployment to create for
+	// a function that has no deployment yet.
+	DeploymentSpecFunc func() (*v1beta1.Deployment, error)
+)
+
+func MakeManager(kubernetesClient *kubernetes.Clientset, fissionClient *crd.FissionClient,
+	fsCache *fscache.FunctionServiceCache, executorType fission.ExecutorType, idlePodReapTime time.Duration) *Manager {
+	return &Manager{
+		kubernetesClient: kubernetesClient,
+		fissionClient:    fissionClient,
+		fsCache:          fsCache,
+		executorType:     executorType,
+		idlePodReapTime:  idlePodReapTime,
+	}
+}
+
+// CreateOrGetDeployment returns the deployment of a function, creating it
+// with the spec returned by getSpec if it doesn't exist, and waits for it
+// to have at least minScale available replicas.
+func (m *Manager) CreateOrGetDeployment(fn *crd.Function, deployName string, deployNamespace string,
+	firstcreate bool, getSpec DeploymentSpecFunc) (*v1beta1.Deployment, error) {
+
+	minScale := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
+
+	// If it's not the first time creation and minscale is 0 means that all pods for function were recycled,
+	// in such cases we need set minscale to 1 for router to serve requests.
+	if !firstcreate && minScale <= 0 {
+		minScale = 1
+	}
+
+	waitForDeploy := minScale > 0
+
+	existingDepl, err := m.kubernetesClient.ExtensionsV1beta1().Deployments(deployNamespace).Get(deployName, metav1.GetOptions{})
+	if err == nil {
+		if waitForDeploy {
+			err = m.ScaleDeployment(existingDepl.Namespace, existingDepl.Name, minScale)
+			if err != nil {
+				log.Printf("Error scaling up deployment for function %v: %v", fn.Metadata.Name, err)
+				return nil, err
+			}
+
+			if existingDepl.Status.AvailableReplicas < minScale {
+				existingDepl, err = m.WaitForDeploy(existingDepl, minScale)
+			}
+		}
+		return existingDepl, err
+	}
+
+	if !k8sErrs.IsNotFound(err) {
+		return nil, err
+	}
+
+	deployment, err := getSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	depl, err := m.kubernetesClient.ExtensionsV1beta1().Deployments(deployNamespace).Create(deployment)
+	if err != nil {
+		log.Printf("Error while creating deployment: %v", err)
+		return nil, err
+	}
+
+	if waitForDeploy {
+		depl, err = m.WaitForDeploy(depl, minScale)
+	}
+
+	return depl, err
+}
+
+// UpdateDeployment replaces the deployment of a function.
+func (m *Manager) UpdateDeployment(deployment *v1beta1.Deployment, ns string) error {
+	_, err := m.kubernetesClient.ExtensionsV1beta1().Deployments(ns).Update(deployment)
+	return err
+}
+
+// WaitForDeploy waits for the deployment to have at least the given
+// number of available replicas.
+func (m *Manager) WaitForDeploy(depl *v1beta1.Deployment, replicas int32) (*v1beta1.Deployment, error) {
+	for i := 0; i < 120; i++ {
+		latestDepl, err := m.kubernetesClient.ExtensionsV1beta1().Deployments(depl.ObjectMeta.Namespace).Get(depl.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		//TODO check for imagePullerror
+		// use AvailableReplicas here is better than ReadyReplicas
+		// since the pods may not be able to serve network traffic yet.
+		if latestDepl.Status.AvailableReplicas >= replicas {
+			return latestDepl, err
+		}
+		time.Sleep(time.Second)
+	}
+	return nil, errors.New("failed to create deployment within timeout window")
+}
+
+func (m *Manager) ScaleDeployment(deplNS string, deplName string, replicas int32) error {
+	log.Printf("Scale deployment %v in namespace %v to replicas %v", deplName, deplNS, replicas)
+	_, err := m.kubernetesClient.ExtensionsV1beta1().Deployments(deplNS).UpdateScale(deplName, &v1beta1.Scale{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deplName,
+			Namespace: deplNS,
+		},
+		Spec: v1beta1.ScaleSpec{
+			Replicas: replicas,
+		},
+	})
+	return err
+}
+
+// hpaReplicas returns the min and max replicas and the target CPU of the
+// HPA of a function.
+func hpaReplicas(execStrategy *fission.ExecutionStrategy) (int32, int32, int32) {
+	minRepl := int32(execStrategy.MinScale)
+	if minRepl == 0 {
+		minRepl = 1
+	}
+	maxRepl := int32(execStrategy.MaxScale)
+	if maxRepl == 0 {
+		maxRepl = minRepl
+	}
+	return minRepl, maxRepl, int32(execStrategy.TargetCPUPercent)
+}
+
+func (m *Manager) CreateOrGetHpa(hpaName string, execStrategy *fission.ExecutionStrategy, depl *v1beta1.Deployment) (*asv1.HorizontalPodAutoscaler, error) {
+	if depl == nil {
+		return nil, errors.New("failed to create HPA, found empty deployment")
+	}
+
+	existingHpa, err := m.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(depl.ObjectMeta.Namespace).Get(hpaName, metav1.GetOptions{})
+	if err == nil {
+		return existingHpa, err
+	}
+	if !k8sErrs.IsNotFound(err) {
+		return nil, err
+	}
+
+	minRepl, maxRepl, targetCPU := hpaReplicas(execStrategy)
+	hpa := asv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   hpaName,
+			Labels: depl.Labels,
+		},
+		Spec: asv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: asv1.CrossVersionObjectReference{
+				Kind:       DeploymentKind,
+				Name:       depl.ObjectMeta.Name,
+				APIVersion: DeploymentVersion,
+			},
+			MinReplicas:                    &minRepl,
+			MaxReplicas:                    maxRepl,
+			TargetCPUUtilizationPercentage: &targetCPU,
+		},
+	}
+
+	return m.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(depl.ObjectMeta.Namespace).Create(&hpa)
+}
+
+// UpdateHpa updates the replicas and target CPU of an existing HPA to the
+// execution strategy of the function.
+func (m *Manager) UpdateHpa(ns string, hpaName string, execStrategy *fission.ExecutionStrategy) error {
+	hpa, err := m.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(ns).Get(hpaName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	minRepl, maxRepl, targetCPU := hpaReplicas(execStrategy)
+	hpa.Spec.MinReplicas = &minRepl
+	hpa.Spec.MaxReplicas = maxRepl
+	hpa.Spec.TargetCPUUtilizationPercentage = &targetCPU
+
+	_, err = m.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(ns).Update(hpa)
+	return err
+}
+
+// CreateOrGetSvc returns the service of a function, creating it if it
+// doesn't exist. The service always exposes the runtime on port 80, plus
+// the given extra ports.
+func (m *Manager) CreateOrGetSvc(deployLabels map[string]string, svcName string, svcNamespace string,
+	extraPorts ...apiv1.ServicePort) (*apiv1.Service, error) {
+
+	existingSvc, err := m.kubernetesClient.CoreV1().Services(svcNamespace).Get(svcName, metav1.GetOptions{})
+	if err == nil {
+		return existingSvc, err
+	}
+	if !k8sErrs.IsNotFound(err) {
+		return nil, err
+	}
+
+	ports := append([]apiv1.ServicePort{
+		{
+			Name:       "runtime-env-port",
+			Port:       int32(80),
+			TargetPort: intstr.FromInt(8888),
+		},
+	}, extraPorts...)
+
+	service := &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   svcName,
+			Labels: deployLabels,
+		},
+		Spec: apiv1.ServiceSpec{
+			Ports:    ports,
+			Selector: deployLabels,
+			Type:     apiv1.ServiceTypeClusterIP,
+		},
+	}
+
+	return m.kubernetesClient.CoreV1().Services(svcNamespace).Create(service)
+}
+
+// Cleanup deletes the service, HPA and deployment of a function. Objects
+// that are already gone are ignored.
+func (m *Manager) Cleanup(ns string, name string) error {
+	var multierr *multierror.Error
+
+	err := m.kubernetesClient.CoreV1().Services(ns).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !k8sErrs.IsNotFound(err) {
+		log.Printf("Error deleting service for %v function %v in namespace %v, error: %v", m.executorType, name, ns, err)
+		multierr = multierror.Append(multierr, err)
+	}
+
+	err = m.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(ns).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !k8sErrs.IsNotFound(err) {
+		log.Printf("Error deleting HPA for %v function %v in namespace %v, error: %v", m.executorType, name, ns, err)
+		multierr = multierror.Append(multierr, err)
+	}
+
+	// DeletePropagationBackground deletes the object immediately and dependent are deleted later
+	// DeletePropagationForeground not advisable; it markes for deleteion and API can still serve those objects
+	deletePropagation := metav1.DeletePropagationBackground
+	err = m.kubernetesClient.ExtensionsV1beta1().Deployments(ns).Delete(name, &metav1.DeleteOptions{
+		PropagationPolicy: &deletePropagation,
+	})
+	if err != nil && !k8sErrs.IsNotFound(err) {
+		log.Printf("Error deleting deployment for %v function %v in namespace %v, error: %v", m.executorType, name, ns, err)
+		multierr = multierror.Append(multierr, err)
+	}
+
+	return multierr.ErrorOrNil()
+}
+
+// MakeFuncSvc returns the function service of a function backed by the
+// given deployment, service and HPA.
+func (m *Manager) MakeFuncSvc(fn *crd.Function, env *crd.Environment, objName string,
+	depl *v1beta1.Deployment, svc *apiv1.Service, hpa *asv1.HorizontalPodAutoscaler) *fscache.FuncSvc {
+
+	kubeObjRefs := []apiv1.ObjectReference{
+		{
+			//obj.TypeMeta.Kind does not work hence this, needs investigationa and a fix
+			Kind:            "deployment",
+			Name:            depl.ObjectMeta.Name,
+			APIVersion:      depl.TypeMeta.APIVersion,
+			Namespace:       depl.ObjectMeta.Namespace,
+			ResourceVersion: depl.ObjectMeta.ResourceVersion,
+			UID:             depl.ObjectMeta.UID,
+		},
+		{
+			Kind:            "service",
+			Name:            svc.ObjectMeta.Name,
+			APIVersion:      svc.TypeMeta.APIVersion,
+			Namespace:       svc.ObjectMeta.Namespace,
+			ResourceVersion: svc.ObjectMeta.ResourceVersion,
+			UID:             svc.ObjectMeta.UID,
+		},
+		{
+			Kind:            "horizontalpodautoscaler",
+			Name:            hpa.ObjectMeta.Name,
+			APIVersion:      hpa.TypeMeta.APIVersion,
+			Namespace:       hpa.ObjectMeta.Namespace,
+			ResourceVersion: hpa.ObjectMeta.ResourceVersion,
+			UID:             hpa.ObjectMeta.UID,
+		},
+	}
+
+	return &fscache.FuncSvc{
+		Name:              objName,
+		Function:          &fn.Metadata,
+		Environment:       env,
+		Address:           fmt.Sprintf("%v.%v", svc.Name, svc.Namespace),
+		KubernetesObjects: kubeObjRefs,
+		Executor:          m.executorType,
+	}
+}
+
+// IsValid does a get on the service address to ensure it's a valid service and
+// checks that the deployment of the function has at least one available replica.
+func (m *Manager) IsValid(fsvc *fscache.FuncSvc) bool {
+	service := strings.Split(fsvc.Address, ".")
+	if len(service) < 2 {
+		return false
+	}
+
+	_, err := m.kubernetesClient.CoreV1().Services(service[1]).Get(service[0], metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Error validating service address for function %v: %v", fsvc.Function.Name, err)
+		return false
+	}
+
+	deployObj := GetDeploymentObj(fsvc.KubernetesObjects)
+	if deployObj == nil {
+		log.Printf("Deployment obj for function %v does not exist", fsvc.Function.Name)
+		return false
+	}
+
+	currentDeploy, err := m.kubernetesClient.ExtensionsV1beta1().
+		Deployments(deployObj.Namespace).Get(deployObj.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Error validating deployment for function %v: %v", fsvc.Function.Name, err)
+		return false
+	}
+
+	return currentDeploy.Status.AvailableReplicas > 0
+}
+
+// CleanupFuncSvc deletes the service, HPA and deployment of the function service
+func (m *Manager) CleanupFuncSvc(fsvc *fscache.FuncSvc) error {
+	deployObj := GetDeploymentObj(fsvc.KubernetesObjects)
+	if deployObj == nil {
+		return fmt.Errorf("error finding deployment for function %v", fsvc.Function.Name)
+	}
+	return m.Cleanup(deployObj.Namespace, fsvc.Name)
+}
+
+// IdleObjectReaper scales the deployments of idle functions down to their
+// minScale, until the context is done.
+func (m *Manager) IdleObjectReaper(ctx context.Context) {
+	pollSleep := time.Duration(m.idlePodReapTime)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollSleep):
+		}
+
+		envs, err := m.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			log.Printf("Failed to get environment list: %v", err)
+			continue
+		}
+
+		envList := make(map[types.UID]struct{})
+		for _, env := range envs.Items {
+			envList[env.Metadata.UID] = struct{}{}
+		}
+
+		funcSvcs, err := m.fsCache.ListOld(m.idlePodReapTime)
+		if err != nil {
+			log.Printf("Error reaping idle pods: %v", err)
+			continue
+		}
+
+		for _, fsvc := range funcSvcs {
+			if fsvc.Executor != m.executorType {
+				continue
+			}
+
+			// For function with the environment that no longer exists, executor
+			// scales down the deployment as usual and prints log to notify user.
+			if _, ok := envList[fsvc.Environment.Metadata.UID]; !ok {
+				log.Printf("Environment %v for function %v no longer exists",
+					fsvc.Environment.Metadata.Name, fsvc.Name)
+			}
+
+			m.scaleDownIdle(fsvc)
+		}
+	}
+}
+
+func (m *Manager) scaleDownIdle(fsvc *fscache.FuncSvc) {
+	fn, err := m.fissionClient.Functions(fsvc.Function.Namespace).Get(fsvc.Function.Name)
+	if err != nil {
+		// The function delete event is handled by the informer of the
+		// backend, which cleans up the cache and kubernetes objects itself.
+		if !k8sErrs.IsNotFound(err) {
+			log.Printf("Error getting function %v: %v", fsvc.Function.Name, err)
+		}
+		return
+	}
+
+	deployObj := GetDeploymentObj(fsvc.KubernetesObjects)
+	if deployObj == nil {
+		log.Printf("Error finding deployment for function %v", fsvc.Function.Name)
+		return
+	}
+
+	currentDeploy, err := m.kubernetesClient.ExtensionsV1beta1().
+		Deployments(deployObj.Namespace).Get(deployObj.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Error validating deployment for function %v: %v", fsvc.Function.Name, err)
+		return
+	}
+
+	minScale := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
+
+	// do nothing if the current replicas is already lower than minScale
+	if *currentDeploy.Spec.Replicas <= minScale {
+		return
+	}
+
+	err = m.ScaleDeployment(deployObj.Namespace, deployObj.Name, minScale)
+	if err != nil {
+		log.Printf("Error scaling down deployment for function %v: %v", fsvc.Function.Name, err)
+	} else if minScale == 0 {
+		go executortype.ReportScaledToZero(m.fissionClient, fsvc.Function)
+	}
+}
+
+func GetDeploymentObj(kubeobjs []apiv1.ObjectReference) *apiv1.ObjectReference {
+	for _, kubeobj := range kubeobjs {
+		switch strings.ToLower(kubeobj.Kind) {
+		case "deployment":
+			return &kubeobj
+		}
+	}
+	return nil
+}
+
+// GetTerminationGracePeriod returns the termination grace period of the
+// function pods of an environment.
+func GetTerminationGracePeriod(env *crd.Environment) int64 {
+	gracePeriodSeconds := int64(6 * 60)
+	if env.Spec.TerminationGracePeriod > 0 {
+		gracePeriodSeconds = env.Spec.TerminationGracePeriod
+	}
+	return gracePeriodSeconds
+}
+
+// GetPodAnnotations returns the annotations of the function pods of an
+// environment.
+func GetPodAnnotations(env *crd.Environment, useIstio bool) map[string]string {
+	podAnnotations := make(map[string]string)
+	for k, v := range env.Metadata.Annotations {
+		podAnnotations[k] = v
+	}
+	if useIstio && env.Spec.AllowAccessToExternalNetwork {
+		podAnnotations["sidecar.istio.io/inject"] = "false"
+	}
+	return podAnnotations
+}
+
+// GetResources overrides only the resources which are overridden at function level otherwise
+// default to resources specified at environment level
+func GetResources(env *crd.Environment, fn *crd.Function) apiv1.ResourceRequirements {
+	resources := apiv1.ResourceRequirements{
+		Requests: make(map[apiv1.ResourceName]resource.Quantity),
+		Limits:   make(map[apiv1.ResourceName]resource.Quantity),
+	}
+	for k, v := range env.Spec.Resources.Requests {
+		resources.Requests[k] = v
+	}
+	for k, v := range env.Spec.Resources.Limits {
+		resources.Limits[k] = v
+	}
+
+	// Only override the once specified at function, rest default to values from env.
+	for k, v := range fn.Spec.Resources.Requests {
+		if !v.IsZero() {
+			resources.Requests[k] = v
+		}
+	}
+	for k, v := range fn.Spec.Resources.Limits {
+		if !v.IsZero() {
+			resources.Limits[k] = v
+		}
+	}
+
+	return resources
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"runtime/debug"
 	"strings"
 	"sync"
@@ -29,12 +30,19 @@ import (
 	"github.com/dchest/uniuri"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/fission/fission"
-	"github.com/fission/fission/cache"
 	"github.com/fission/fission/crd"
 	fetcherConfig "github.com/fission/fission/environments/fetcher/config"
+	executorClient "github.com/fission/fission/executor/client"
+	"github.com/fission/fission/executor/container"
+	"github.com/fission/fission/executor/executortype"
 	"github.com/fission/fission/executor/fscache"
 	"github.com/fission/fission/executor/newdeploy"
 	"github.com/fission/fission/executor/poolmgr"
@@ -43,15 +51,34 @@ import (
 
 type (
 	Executor struct {
-		gpm           *poolmgr.GenericPoolManager
-		ndm           *newdeploy.NewDeploy
-		functionEnv   *cache.Cache
-		fissionClient *crd.FissionClient
+		fissionClient    *crd.FissionClient
+		kubernetesClient *kubernetes.Clientset
+		makeBackends     BackendsFactory
+		recorder         record.EventRecorder
+
+		// The backends and their function service cache are made again
+		// for every term of leadership, see startTerm.
+		termLock      sync.RWMutex
+		executorTypes map[fission.ExecutorType]executortype.ExecutorBackend
 		fsCache       *fscache.FunctionServiceCache
 
+		// leadershipLock serializes starting and stopping to lead.
+		leadershipLock sync.Mutex
+
 		requestChan chan *createFuncServiceRequest
 		fsCreateWg  map[string]*sync.WaitGroup
+
+		// elector is nil if leader election is disabled, in which case
+		// this executor is the only replica and always the leader.
+		elector      *leaderElector
+		registry     *serviceRegistry
+		leaderClient *executorClient.Client
 	}
+
+	// BackendsFactory makes the executor backends of an executor instance,
+	// sharing the given function service cache.
+	BackendsFactory func(fsCache *fscache.FunctionServiceCache, instanceID string) []executortype.ExecutorBackend
+
 	createFuncServiceRequest struct {
 		ctx      context.Context
 		funcMeta *metav1.ObjectMeta
@@ -64,22 +91,56 @@ type (
 	}
 )
 
-func MakeExecutor(gpm *poolmgr.GenericPoolManager, ndm *newdeploy.NewDeploy, fissionClient *crd.FissionClient, fsCache *fscache.FunctionServiceCache) *Executor {
+func MakeExecutor(fissionClient *crd.FissionClient, kubernetesClient *kubernetes.Clientset, makeBackends BackendsFactory, instanceID string) *Executor {
 	executor := &Executor{
-		gpm:           gpm,
-		ndm:           ndm,
-		functionEnv:   cache.MakeCache(10*time.Second, 0),
-		fissionClient: fissionClient,
-		fsCache:       fsCache,
+		fissionClient:    fissionClient,
+		kubernetesClient: kubernetesClient,
+		makeBackends:     makeBackends,
+		recorder:         makeEventRecorder(kubernetesClient),
 
 		requestChan: make(chan *createFuncServiceRequest),
 		fsCreateWg:  make(map[string]*sync.WaitGroup),
 	}
+	executor.resetBackends(instanceID)
+	executor.leaderClient = executorClient.MakeClientWithResolver(executor.getLeaderUrl)
 	go executor.serveCreateFuncServices()
 
 	return executor
 }
 
+// resetBackends replaces the backends and the function service cache with
+// new ones for the given executor instance.
+func (executor *Executor) resetBackends(instanceID string) {
+	fsCache := fscache.MakeFunctionServiceCache()
+	executorTypes := make(map[fission.ExecutorType]executortype.ExecutorBackend)
+	for _, backend := range executor.makeBackends(fsCache, instanceID) {
+		executorTypes[backend.GetTypeName()] = backend
+	}
+
+	executor.termLock.Lock()
+	defer executor.termLock.Unlock()
+	executor.executorTypes = executorTypes
+	executor.fsCache = fsCache
+}
+
+// getFsCache returns the function service cache of the current term.
+func (executor *Executor) getFsCache() *fscache.FunctionServiceCache {
+	executor.termLock.RLock()
+	defer executor.termLock.RUnlock()
+	return executor.fsCache
+}
+
+// getBackends returns the backends of the current term.
+func (executor *Executor) getBackends() []executortype.ExecutorBackend {
+	executor.termLock.RLock()
+	defer executor.termLock.RUnlock()
+	backends := make([]executortype.ExecutorBackend, 0, len(executor.executorTypes))
+	for _, backend := range executor.executorTypes {
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
 // All non-cached function service requests go through this goroutine
 // serially. It parallelizes requests for different functions, and
 // ensures that for a given function, only one request causes a pod to
@@ -118,7 +179,7 @@ func (executor *Executor) serveCreateFuncServices() {
 				wg.Wait()
 
 				// get the function service from the cache
-				fsvc, err := executor.fsCache.GetByFunction(m)
+				fsvc, err := executor.getFsCache().GetByFunction(m)
 
 				// fsCache return error when the entry does not exist/expire.
 				// It normally happened if there are multiple requests are
@@ -142,6 +203,21 @@ func (executor *Executor) getFunctionExecutorType(meta *metav1.ObjectMeta) (fiss
 	return fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType, nil
 }
 
+// getExecutorBackend returns the registered backend for the given executor type.
+// Functions without an explicit executor type are served by poolmgr.
+func (executor *Executor) getExecutorBackend(executorType fission.ExecutorType) (executortype.ExecutorBackend, error) {
+	if len(executorType) == 0 {
+		executorType = fission.ExecutorTypePoolmgr
+	}
+	executor.termLock.RLock()
+	backend, ok := executor.executorTypes[executorType]
+	executor.termLock.RUnlock()
+	if !ok {
+		return nil, fission.MakeError(fission.ErrorInvalidArgument, fmt.Sprintf("Unknown executor type '%v'", executorType))
+	}
+	return backend, nil
+}
+
 func (executor *Executor) createServiceForFunction(ctx context.Context, meta *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
 	log.Printf("[%v] No cached function service found, creating one", meta.Name)
 
@@ -150,80 +226,108 @@ func (executor *Executor) createServiceForFunction(ctx context.Context, meta *me
 		return nil, err
 	}
 
-	var fsvc *fscache.FuncSvc
-	var fsvcErr error
-
-	switch executorType {
-	case fission.ExecutorTypeNewdeploy:
-		fsvc, fsvcErr = executor.ndm.GetFuncSvc(ctx, meta)
-	default:
-		// from Func -> get Env
-		log.Printf("[%v] getting environment for function", meta.Name)
-		env, err := executor.getFunctionEnv(meta)
-		if err != nil {
-			return nil, err
-		}
-
-		pool, err := executor.gpm.GetPool(env)
-		if err != nil {
-			return nil, err
-		}
-		// from GenericPool -> get one function container
-		// (this also adds to the cache)
-		log.Printf("[%v] getting function service from pool", meta.Name)
-		fsvc, fsvcErr = pool.GetFuncSvc(ctx, meta)
+	backend, err := executor.getExecutorBackend(executorType)
+	if err != nil {
+		return nil, err
 	}
 
+	start := time.Now()
+	fsvc, fsvcErr := backend.GetFuncSvc(ctx, meta)
 	if fsvcErr != nil {
 		fsvcErr = errors.Wrap(fsvcErr, fmt.Sprintf("[%v] Error creating service for function", meta.Name))
 		log.Print(fsvcErr)
+		go executortype.ReportSpecializationError(executor.fissionClient, executor.recorder, meta, fsvcErr)
+	} else {
+		go executortype.ReportSpecialized(executor.fissionClient, meta, time.Since(start))
 	}
 
 	return fsvc, fsvcErr
 }
 
-func (executor *Executor) getFunctionEnv(m *metav1.ObjectMeta) (*crd.Environment, error) {
-	var env *crd.Environment
+// isValidAddress invokes IsValid of the backend that created the function service
+func (executor *Executor) isValidAddress(fsvc *fscache.FuncSvc) bool {
+	backend, err := executor.getExecutorBackend(fsvc.Executor)
+	if err != nil {
+		log.Printf("Error validating address for function %v: %v", fsvc.Function.Name, err)
+		return false
+	}
+	return backend.IsValid(fsvc)
+}
+
+// isLeader returns true if this executor replica runs the backends.
+func (executor *Executor) isLeader() bool {
+	return executor.elector == nil || executor.elector.isLeader()
+}
 
-	// Cached ?
-	result, err := executor.functionEnv.Get(crd.CacheKey(m))
-	if err == nil {
-		env = result.(*crd.Environment)
-		return env, nil
+// getLeaderUrl returns the URL of the current leader replica.
+func (executor *Executor) getLeaderUrl() (string, error) {
+	leader := executor.elector.getLeader()
+	if len(leader) == 0 {
+		return "", fission.MakeError(fission.ErrorUnavailable, "No executor leader elected yet")
 	}
+	return leader, nil
+}
 
-	// Cache miss -- get func from controller
-	f, err := executor.fissionClient.Functions(m.Namespace).Get(m.Name)
-	if err != nil {
-		return nil, err
+// startTerm starts a term of leadership. Every term runs new backends with
+// a new instance ID, so that the objects of earlier terms of this replica
+// are adopted or cleaned up like the ones of other executor instances. It
+// takes over those objects, cleans up the rest of them and starts the
+// backends, and the watcher reloading Secrets and ConfigMaps of functions,
+// until the context is done. Only the leader replica does this, the other
+// replicas answer from the service registry and forward everything else
+// to the leader.
+func (executor *Executor) startTerm(ctx context.Context, instanceID string) {
+	executor.leadershipLock.Lock()
+	defer executor.leadershipLock.Unlock()
+
+	// The leadership may have been lost already
+	if ctx.Err() != nil {
+		return
 	}
+	executor.resetBackends(instanceID)
 
-	// Get env from metadata
-	log.Printf("[%v] getting env", m)
-	env, err = executor.fissionClient.Environments(f.Spec.Environment.Namespace).Get(f.Spec.Environment.Name)
-	if err != nil {
-		return nil, err
+	// Take over the objects of the previous executor instance that are
+	// still in use before cleaning up the rest of them.
+	backends := executor.getBackends()
+	for _, backend := range backends {
+		backend.AdoptExistingResources()
 	}
+	reaper.CleanupOldExecutorObjects(executor.kubernetesClient, instanceID)
 
-	// cache for future lookups
-	executor.functionEnv.Set(crd.CacheKey(m), env)
+	for _, backend := range backends {
+		backend.Run(ctx)
+		go backend.IdleObjectReaper(ctx)
+	}
 
-	return env, nil
-}
+	go makeConfigWatcher(executor.fissionClient, executor.kubernetesClient, executor.getFsCache()).run(ctx)
 
-// isValidAddress invokes isValidService or isValidPod depending on the type of executor
-func (executor *Executor) isValidAddress(fsvc *fscache.FuncSvc) bool {
-	if fsvc.Executor == fscache.NEWDEPLOY {
-		return executor.ndm.IsValid(fsvc)
-	} else {
-		return executor.gpm.IsValid(fsvc)
+	if executor.registry != nil {
+		go executor.registry.publish(ctx, executor.getFsCache())
 	}
 }
 
+// stopTerm drops the backends and function services of a term of
+// leadership once the context of the term is done. The objects of the
+// term are left to the new leader.
+func (executor *Executor) stopTerm(instanceID string) {
+	executor.leadershipLock.Lock()
+	defer executor.leadershipLock.Unlock()
+	executor.resetBackends(instanceID)
+}
+
 func dumpStackTrace() {
 	debug.PrintStack()
 }
 
+// makeEventRecorder returns a recorder for the events of the executor.
+func makeEventRecorder(kubernetesClient *kubernetes.Clientset) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: kubernetesClient.CoreV1().Events(""),
+	})
+	return broadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: "fission-executor"})
+}
+
 func serveMetric() {
 	// Expose the registered metrics via HTTP.
 	metricAddr := ":8080"
@@ -255,21 +359,50 @@ func StartExecutor(fissionNamespace string, functionNamespace string, envBuilder
 		return err
 	}
 
-	fsCache := fscache.MakeFunctionServiceCache()
+	makeBackends := func(fsCache *fscache.FunctionServiceCache, instanceID string) []executortype.ExecutorBackend {
+		gpm := poolmgr.MakeGenericPoolManager(
+			fissionClient, kubernetesClient,
+			functionNamespace, fsCache, fetcherConfig, instanceID)
+
+		ndm := newdeploy.MakeNewDeploy(
+			fissionClient, kubernetesClient, restClient,
+			functionNamespace, fsCache, fetcherConfig, instanceID)
+
+		cn := container.MakeContainer(
+			fissionClient, kubernetesClient, restClient,
+			fsCache, instanceID)
+
+		return []executortype.ExecutorBackend{gpm, ndm, cn}
+	}
 
-	poolID := strings.ToLower(uniuri.NewLen(8))
-	reaper.CleanupOldExecutorObjects(kubernetesClient, poolID)
-	go reaper.CleanupRoleBindings(kubernetesClient, fissionClient, functionNamespace, envBuilderNamespace, time.Minute*30)
+	newInstanceID := func() string {
+		return strings.ToLower(uniuri.NewLen(8))
+	}
+
+	api := MakeExecutor(fissionClient, kubernetesClient, makeBackends, newInstanceID())
 
-	gpm := poolmgr.MakeGenericPoolManager(
-		fissionClient, kubernetesClient,
-		functionNamespace, fsCache, fetcherConfig, poolID)
+	startLeading := func(ctx context.Context) {
+		go reaper.CleanupRoleBindings(ctx, kubernetesClient, fissionClient, functionNamespace, envBuilderNamespace, time.Minute*30)
+		api.startTerm(ctx, newInstanceID())
+	}
 
-	ndm := newdeploy.MakeNewDeploy(
-		fissionClient, kubernetesClient, restClient,
-		functionNamespace, fsCache, fetcherConfig, poolID)
+	// Leader election is enabled when the pod IP is exposed to the
+	// executor, which is what the other replicas use to reach the leader.
+	podIP := os.Getenv("POD_IP")
+	if len(podIP) == 0 {
+		log.Printf("POD_IP not set, running as single executor replica")
+		startLeading(context.Background())
+	} else {
+		api.registry = makeServiceRegistry(kubernetesClient, fissionNamespace)
+		api.registry.watch(context.Background())
 
-	api := MakeExecutor(gpm, ndm, fissionClient, fsCache)
+		api.elector, err = makeLeaderElector(kubernetesClient, api.recorder, fissionNamespace, podIP, port, startLeading,
+			func() { api.stopTerm(newInstanceID()) })
+		if err != nil {
+			return err
+		}
+		go api.elector.run(context.Background())
+	}
 
 	go api.Serve(port)
 	go serveMetric()
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executortype
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/executor/fscache"
+)
+
+type (
+	// ExecutorBackend is implemented by every executor type (poolmgr,
+	// newdeploy, container, ...). The executor keeps one backend per
+	// executor type and dispatches function service requests to the
+	// backend matching the function's ExecutionStrategy.ExecutorType.
+	ExecutorBackend interface {
+		// GetTypeName returns the executor type served by this backend.
+		GetTypeName() fission.ExecutorType
+
+		// Run starts the informers and background workers of the backend.
+		Run(ctx context.Context)
+
+		// GetFuncSvc returns a function service for the given function,
+		// creating the kubernetes objects backing it if necessary. The
+		// returned function service is added to the function service cache.
+		GetFuncSvc(ctx context.Context, metadata *metav1.ObjectMeta) (*fscache.FuncSvc, error)
+
+		// IsValid checks whether a cached function service is still able
+		// to serve requests.
+		IsValid(fsvc *fscache.FuncSvc) bool
+
+		// IdleObjectReaper periodically reaps the function services of
+		// this backend that have been idle for too long, until the
+		// context is done.
+		IdleObjectReaper(ctx context.Context)
+
+		// CleanupFuncSvc removes the kubernetes objects backing the given
+		// function service.
+		CleanupFuncSvc(fsvc *fscache.FuncSvc) error
+
+		// AdoptExistingResources takes over the kubernetes objects created
+		// by a previous executor instance for functions that still exist,
+		// so that they keep serving instead of being cleaned up. It is
+		// called once on startup, before the old objects are cleaned up.
+		AdoptExistingResources()
+	}
+)
@@ -0,0 +1,120 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executortype
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// ReportSpecialized records on the function status that a function service
+// was created for the function, and how long it took. The status is only
+// written when the function wasn't specialized before, since every write
+// changes the resource version of the function.
+func ReportSpecialized(fissionClient *crd.FissionClient, fn *metav1.ObjectMeta, duration time.Duration) {
+	updateFunctionStatus(fissionClient, fn, func(status *fission.FunctionStatus) bool {
+		changed := status.SetCondition(fission.FunctionSpecialized, apiv1.ConditionTrue, "Specialized", "")
+		if status.SetCondition(fission.FunctionScaledToZero, apiv1.ConditionFalse, "Specialized", "") {
+			changed = true
+		}
+		if changed {
+			status.LastSpecializationDuration = metav1.Duration{Duration: duration}
+		}
+		return changed
+	})
+}
+
+// ReportSpecializationError records on the function status, and as an event
+// of the function, that creating a function service for the function failed.
+func ReportSpecializationError(fissionClient *crd.FissionClient, recorder record.EventRecorder, fn *metav1.ObjectMeta, err error) {
+	reason := "SpecializationFailed"
+	message := err.Error()
+	if se, ok := errors.Cause(err).(*fission.SpecializationError); ok {
+		reason = string(se.Reason)
+		if len(se.Pod) > 0 {
+			message = fmt.Sprintf("%v (pod %v)", message, se.Pod)
+		}
+	}
+	recorder.Event(functionReference(fn), apiv1.EventTypeWarning, reason, message)
+
+	updateFunctionStatus(fissionClient, fn, func(status *fission.FunctionStatus) bool {
+		changed := status.SetCondition(fission.FunctionSpecialized, apiv1.ConditionFalse, reason, err.Error())
+		if status.LastError != err.Error() {
+			status.LastError = err.Error()
+			changed = true
+		}
+		return changed
+	})
+}
+
+// ReportScaledToZero records on the function status that all instances of
+// the function were reaped because the function was idle.
+func ReportScaledToZero(fissionClient *crd.FissionClient, fn *metav1.ObjectMeta) {
+	updateFunctionStatus(fissionClient, fn, func(status *fission.FunctionStatus) bool {
+		changed := status.SetCondition(fission.FunctionScaledToZero, apiv1.ConditionTrue, "Idle", "")
+		if status.SetCondition(fission.FunctionSpecialized, apiv1.ConditionFalse, "Idle", "") {
+			changed = true
+		}
+		return changed
+	})
+}
+
+// ReportError records an error that happened while managing the kubernetes
+// objects of the function, outside of a request for a function service.
+func ReportError(fissionClient *crd.FissionClient, fn *metav1.ObjectMeta, message string, err error) {
+	lastError := message
+	if err != nil {
+		lastError = fmt.Sprintf("%v: %v", message, err)
+	}
+	updateFunctionStatus(fissionClient, fn, func(status *fission.FunctionStatus) bool {
+		if status.LastError == lastError {
+			return false
+		}
+		status.LastError = lastError
+		return true
+	})
+}
+
+// functionReference returns the reference of the function that its events
+// are about. Recorders aggregate repeated events of the same reference and
+// reason into one.
+func functionReference(fn *metav1.ObjectMeta) *apiv1.ObjectReference {
+	return &apiv1.ObjectReference{
+		Kind:            "Function",
+		APIVersion:      "fission.io/v1",
+		Name:            fn.Name,
+		Namespace:       fn.Namespace,
+		UID:             fn.UID,
+		ResourceVersion: fn.ResourceVersion,
+	}
+}
+
+func updateFunctionStatus(fissionClient *crd.FissionClient, fn *metav1.ObjectMeta, mutate func(status *fission.FunctionStatus) bool) {
+	err := crd.UpdateFunctionStatus(fissionClient, fn.Namespace, fn.Name, mutate)
+	if err != nil {
+		log.Printf("Error updating status of function %v in namespace %v: %v", fn.Name, fn.Namespace, err)
+	}
+}
@@ -30,7 +30,6 @@ import (
 )
 
 type fscRequestType int
-type executorType int
 
 const (
 	TOUCH fscRequestType = iota
@@ -38,11 +37,6 @@ const (
 	LOG
 )
 
-const (
-	POOLMGR executorType = iota
-	NEWDEPLOY
-)
-
 type (
 	FuncSvc struct {
 		Name              string                  // Name of object
@@ -50,7 +44,7 @@ type (
 		Environment       *crd.Environment        // function's environment
 		Address           string                  // Host:Port or IP:Port that the function's service can be reached at.
 		KubernetesObjects []apiv1.ObjectReference // Kubernetes Objects (within the function namespace)
-		Executor          executorType
+		Executor          fission.ExecutorType    // type of the executor backend that created this service
 
 		Ctime time.Time
 		Atime time.Time
@@ -0,0 +1,152 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	leaderElectionLockName      = "fission-executor-leader"
+	leaderElectionLease         = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 2 * time.Second
+)
+
+type (
+	// leaderElector elects one executor replica as the leader, using a
+	// configmap as the lock.
+	//
+	// The identity of a replica is the URL other replicas use to forward
+	// requests to it once it becomes the leader.
+	leaderElector struct {
+		elector  *leaderelection.LeaderElector
+		identity string
+
+		lock    sync.RWMutex
+		leader  string
+		leading bool
+	}
+)
+
+// makeLeaderElector returns an elector that calls onStartedLeading with a
+// context that is cancelled when the replica loses the leadership again,
+// after which onStoppedLeading is called.
+func makeLeaderElector(kubernetesClient *kubernetes.Clientset, recorder record.EventRecorder, namespace string,
+	podIP string, port int, onStartedLeading func(ctx context.Context), onStoppedLeading func()) (*leaderElector, error) {
+
+	le := &leaderElector{
+		identity: fmt.Sprintf("http://%v", net.JoinHostPort(podIP, fmt.Sprintf("%v", port))),
+	}
+
+	lock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, namespace, leaderElectionLockName,
+		kubernetesClient.CoreV1(), resourcelock.ResourceLockConfig{
+			Identity:      le.identity,
+			EventRecorder: recorder,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	le.elector, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaderElectionLease,
+		RenewDeadline: leaderElectionRenewDeadline,
+		RetryPeriod:   leaderElectionRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				// This runs concurrently with the election, which may
+				// have lost the leadership again already.
+				if !le.setLeading(ctx, true) {
+					return
+				}
+				log.Printf("Executor %v became the leader", le.identity)
+				onStartedLeading(ctx)
+			},
+			OnStoppedLeading: func() {
+				if !le.setLeading(nil, false) {
+					return
+				}
+				log.Printf("Executor %v lost leadership", le.identity)
+				onStoppedLeading()
+			},
+			OnNewLeader: le.setLeader,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return le, nil
+}
+
+// run takes part in the election until the context is done. A replica that
+// loses the leadership takes part again as a follower.
+func (le *leaderElector) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		le.elector.Run(ctx)
+	}
+}
+
+func (le *leaderElector) isLeader() bool {
+	le.lock.RLock()
+	defer le.lock.RUnlock()
+	return le.leading
+}
+
+// getLeader returns the identity of the current leader, or an empty string
+// if there is none.
+func (le *leaderElector) getLeader() string {
+	le.lock.RLock()
+	defer le.lock.RUnlock()
+	// The lock still names this replica for a while after it lost the
+	// leadership; it must not forward requests to itself.
+	if le.leader == le.identity && !le.leading {
+		return ""
+	}
+	return le.leader
+}
+
+func (le *leaderElector) setLeader(leader string) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if le.leader != leader {
+		log.Printf("Executor leader is %v", leader)
+	}
+	le.leader = leader
+}
+
+// setLeading records whether this replica leads, unless the context of
+// the leadership is already done. It returns false if nothing changed.
+func (le *leaderElector) setLeading(ctx context.Context, leading bool) bool {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if le.leading == leading || (ctx != nil && ctx.Err() != nil) {
+		return false
+	}
+	le.leading = leading
+	return true
+}
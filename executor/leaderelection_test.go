@@ -0,0 +1,54 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetLeader(t *testing.T) {
+	le := &leaderElector{identity: "http://10.0.0.1:8888"}
+	if leader := le.getLeader(); leader != "" {
+		t.Fatalf("expected no leader before the election, got %v", leader)
+	}
+
+	// The lock names this replica before it starts leading, and after it
+	// stopped leading; requests must not be forwarded to itself meanwhile
+	le.setLeader(le.identity)
+	if leader := le.getLeader(); leader != "" {
+		t.Fatalf("expected no leader until the replica leads, got %v", leader)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if !le.setLeading(ctx, true) || !le.isLeader() || le.getLeader() != le.identity {
+		t.Fatalf("expected replica to lead")
+	}
+	cancel()
+	if !le.setLeading(nil, false) || le.isLeader() || le.getLeader() != "" {
+		t.Fatalf("expected replica to stop leading")
+	}
+
+	// A leadership that was lost before it started is ignored
+	if le.setLeading(ctx, true) || le.isLeader() {
+		t.Fatalf("expected leadership with a done context to be ignored")
+	}
+
+	le.setLeader("http://10.0.0.2:8888")
+	if leader := le.getLeader(); leader != "http://10.0.0.2:8888" {
+		t.Fatalf("expected new leader, got %v", leader)
+	}
+}
@@ -17,85 +17,30 @@ limitations under the License.
 package newdeploy
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"log"
-	"time"
 
-	asv1 "k8s.io/api/autoscaling/v1"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
-	k8s_err "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
-	multierror "github.com/hashicorp/go-multierror"
-)
-
-const (
-	DeploymentKind    = "Deployment"
-	DeploymentVersion = "extensions/v1beta1"
+	"github.com/fission/fission/executor/deployutil"
 )
 
 func (deploy *NewDeploy) createOrGetDeployment(fn *crd.Function, env *crd.Environment,
 	deployName string, deployLabels map[string]string, deployNamespace string, firstcreate bool) (*v1beta1.Deployment, error) {
 
-	minScale := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
-
-	// If it's not the first time creation and minscale is 0 means that all pods for function were recycled,
-	// in such cases we need set minscale to 1 for router to serve requests.
-	if !firstcreate && minScale <= 0 {
-		minScale = 1
-	}
-
-	waitForDeploy := minScale > 0
-
-	existingDepl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deployNamespace).Get(deployName, metav1.GetOptions{})
-	if err == nil {
-		if waitForDeploy {
-			err = scaleDeployment(deploy.kubernetesClient,
-				existingDepl.Namespace, existingDepl.Name, minScale)
+	return deploy.deployMgr.CreateOrGetDeployment(fn, deployName, deployNamespace, firstcreate,
+		func() (*v1beta1.Deployment, error) {
+			err := deploy.setupRBACObjs(deployNamespace, fn)
 			if err != nil {
-				log.Printf("Error scaling up deployment for function %v: %v", fn.Metadata.Name, err)
 				return nil, err
 			}
-
-			if existingDepl.Status.AvailableReplicas < minScale {
-				existingDepl, err = deploy.waitForDeploy(existingDepl, minScale)
-			}
-		}
-		return existingDepl, err
-	}
-
-	if err != nil && k8s_err.IsNotFound(err) {
-		err := deploy.setupRBACObjs(deployNamespace, fn)
-		if err != nil {
-			return nil, err
-		}
-
-		deployment, err := deploy.getDeploymentSpec(fn, env, deployName, deployLabels)
-		if err != nil {
-			return nil, err
-		}
-
-		depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deployNamespace).Create(deployment)
-		if err != nil {
-			log.Printf("Error while creating deployment: %v", err)
-			return nil, err
-		}
-
-		if waitForDeploy {
-			depl, err = deploy.waitForDeploy(depl, minScale)
-		}
-
-		return depl, err
-	}
-
-	return nil, err
-
+			return deploy.getDeploymentSpec(fn, env, deployName, deployLabels)
+		})
 }
 
 func (deploy *NewDeploy) setupRBACObjs(deployNamespace string, fn *crd.Function) error {
@@ -123,47 +68,40 @@ func (deploy *NewDeploy) setupRBACObjs(deployNamespace string, fn *crd.Function)
 	return nil
 }
 
-func (deploy *NewDeploy) getDeployment(fn *crd.Function) (*v1beta1.Deployment, error) {
-	deployName := deploy.getObjName(fn)
-	return deploy.kubernetesClient.ExtensionsV1beta1().Deployments(fn.Metadata.Namespace).Get(deployName, metav1.GetOptions{})
-}
-
-func (deploy *NewDeploy) updateDeployment(deployment *v1beta1.Deployment, ns string) error {
-	_, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(ns).Update(deployment)
-	return err
-}
-
-func (deploy *NewDeploy) deleteDeployment(ns string, name string) error {
-	// DeletePropagationBackground deletes the object immediately and dependent are deleted later
-	// DeletePropagationForeground not advisable; it markes for deleteion and API can still serve those objects
-	deletePropagation := metav1.DeletePropagationBackground
-	err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(ns).Delete(name, &metav1.DeleteOptions{
-		PropagationPolicy: &deletePropagation,
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (deploy *NewDeploy) getDeploymentSpec(fn *crd.Function, env *crd.Environment,
 	deployName string, deployLabels map[string]string) (*v1beta1.Deployment, error) {
 
 	replicas := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
 
-	gracePeriodSeconds := int64(6 * 60)
-	if env.Spec.TerminationGracePeriod > 0 {
-		gracePeriodSeconds = env.Spec.TerminationGracePeriod
-	}
-
-	podAnnotations := env.Metadata.Annotations
-	if podAnnotations == nil {
-		podAnnotations = make(map[string]string)
+	gracePeriodSeconds := deployutil.GetTerminationGracePeriod(env)
+	podAnnotations := deployutil.GetPodAnnotations(env, deploy.useIstio)
+	resources := deployutil.GetResources(env, fn)
+
+	container := fission.MergeContainerSpecs(&apiv1.Container{
+		Name:                   fn.Metadata.Name,
+		Image:                  env.Spec.Runtime.Image,
+		ImagePullPolicy:        deploy.runtimeImagePullPolicy,
+		TerminationMessagePath: "/dev/termination-log",
+		Lifecycle:              fission.RuntimeLifecycle(env.Spec.Version, gracePeriodSeconds),
+		ReadinessProbe:         fission.RuntimeReadinessProbe(env.Spec.Version),
+		Resources:              resources,
+	}, env.Spec.Runtime.Container)
+
+	// to support backward compatibility, functions created in the default
+	// ns have their deployment in the fission-function ns
+	deployNamespace := deploy.namespace
+	if fn.Metadata.Namespace != metav1.NamespaceDefault {
+		deployNamespace = fn.Metadata.Namespace
+	}
+	volumes, fetcherEnv, err := addFunctionEnvAndVolumes(&container, fn, deployNamespace)
+	if err != nil {
+		return nil, err
 	}
-	if deploy.useIstio && env.Spec.AllowAccessToExternalNetwork {
-		podAnnotations["sidecar.istio.io/inject"] = "false"
+	if len(fetcherEnv) > 0 && env.Spec.Version < 2 {
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("environment variables from secrets or configmaps of function %v in the %v namespace need an environment of version 2 or later",
+				fn.Metadata.Name, fn.Metadata.Namespace))
 	}
-	resources := deploy.getResources(env, fn)
 
 	deployment := &v1beta1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -181,226 +119,75 @@ func (deploy *NewDeploy) getDeploymentSpec(fn *crd.Function, env *crd.Environmen
 					Annotations: podAnnotations,
 				},
 				Spec: apiv1.PodSpec{
-					Containers: []apiv1.Container{
-						fission.MergeContainerSpecs(&apiv1.Container{
-							Name:                   fn.Metadata.Name,
-							Image:                  env.Spec.Runtime.Image,
-							ImagePullPolicy:        deploy.runtimeImagePullPolicy,
-							TerminationMessagePath: "/dev/termination-log",
-							Lifecycle: &apiv1.Lifecycle{
-								PreStop: &apiv1.Handler{
-									Exec: &apiv1.ExecAction{
-										Command: []string{
-											"/bin/sleep",
-											fmt.Sprintf("%v", gracePeriodSeconds),
-										},
-									},
-								},
-							},
-							Resources: resources,
-						}, env.Spec.Runtime.Container),
-					},
+					Containers:                    []apiv1.Container{container},
+					Volumes:                       volumes,
 					TerminationGracePeriodSeconds: &gracePeriodSeconds,
 				},
 			},
 		},
 	}
 
+	specializeReq := deploy.fetcherConfig.NewSpecializeRequest(fn, env)
+	specializeReq.FetchReq.Env = fetcherEnv
+	err = deploy.signArchiveUrl(&specializeReq.FetchReq, fn)
+	if err != nil {
+		return nil, err
+	}
+
 	deploy.fetcherConfig.AddSpecializingFetcherToPodSpec(
 		&deployment.Spec.Template.Spec,
 		fn.Metadata.Name,
-		fn,
-		env,
+		specializeReq,
 	)
 
 	return deployment, nil
 }
 
-// getResources overrides only the resources which are overridden at function level otherwise
-// default to resources specified at environment level
-func (deploy *NewDeploy) getResources(env *crd.Environment, fn *crd.Function) apiv1.ResourceRequirements {
-	resources := env.Spec.Resources
-	if resources.Requests == nil {
-		resources.Requests = make(map[apiv1.ResourceName]resource.Quantity)
-	}
-	if resources.Limits == nil {
-		resources.Limits = make(map[apiv1.ResourceName]resource.Quantity)
-	}
-	// Only override the once specified at function, rest default to values from env.
-	val, ok := fn.Spec.Resources.Requests[apiv1.ResourceCPU]
-	if ok && !val.IsZero() {
-		resources.Requests[apiv1.ResourceCPU] = fn.Spec.Resources.Requests[apiv1.ResourceCPU]
-	}
-
-	val, ok = fn.Spec.Resources.Requests[apiv1.ResourceMemory]
-	if ok && !val.IsZero() {
-		resources.Requests[apiv1.ResourceMemory] = fn.Spec.Resources.Requests[apiv1.ResourceMemory]
-	}
-
-	val, ok = fn.Spec.Resources.Limits[apiv1.ResourceCPU]
-	if ok && !val.IsZero() {
-		resources.Limits[apiv1.ResourceCPU] = fn.Spec.Resources.Limits[apiv1.ResourceCPU]
-	}
-
-	val, ok = fn.Spec.Resources.Limits[apiv1.ResourceMemory]
-	if ok && !val.IsZero() {
-		resources.Limits[apiv1.ResourceMemory] = fn.Spec.Resources.Limits[apiv1.ResourceMemory]
-	}
-
-	return resources
-}
-
-func (deploy *NewDeploy) createOrGetHpa(hpaName string, execStrategy *fission.ExecutionStrategy, depl *v1beta1.Deployment) (*asv1.HorizontalPodAutoscaler, error) {
-
-	minRepl := int32(execStrategy.MinScale)
-	if minRepl == 0 {
-		minRepl = 1
-	}
-	maxRepl := int32(execStrategy.MaxScale)
-	if maxRepl == 0 {
-		maxRepl = minRepl
-	}
-	targetCPU := int32(execStrategy.TargetCPUPercent)
-
-	existingHpa, err := deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(depl.ObjectMeta.Namespace).Get(hpaName, metav1.GetOptions{})
-	if err == nil {
-		return existingHpa, err
-	}
-
-	if depl == nil {
-		return nil, errors.New("failed to create HPA, found empty deployment")
-	}
-
-	if err != nil && k8s_err.IsNotFound(err) {
-		hpa := asv1.HorizontalPodAutoscaler{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:   hpaName,
-				Labels: depl.Labels,
-			},
-			Spec: asv1.HorizontalPodAutoscalerSpec{
-				ScaleTargetRef: asv1.CrossVersionObjectReference{
-					Kind:       DeploymentKind,
-					Name:       depl.ObjectMeta.Name,
-					APIVersion: DeploymentVersion,
-				},
-				MinReplicas:                    &minRepl,
-				MaxReplicas:                    maxRepl,
-				TargetCPUUtilizationPercentage: &targetCPU,
-			},
-		}
-
-		cHpa, err := deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(depl.ObjectMeta.Namespace).Create(&hpa)
-		if err != nil {
-			return nil, err
-		}
-		return cHpa, nil
-	}
-
-	return nil, err
-
-}
-
-func (deploy *NewDeploy) getHpa(ns string, fn *crd.Function) (*asv1.HorizontalPodAutoscaler, error) {
-	hpaName := deploy.getObjName(fn)
-	return deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(ns).Get(hpaName, metav1.GetOptions{})
-}
-
-func (deploy *NewDeploy) updateHpa(hpa *asv1.HorizontalPodAutoscaler) error {
-	_, err := deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(hpa.ObjectMeta.Namespace).Update(hpa)
-	return err
-}
-
-func (deploy *NewDeploy) deleteHpa(ns string, name string) error {
-	err := deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(ns).Delete(name, &metav1.DeleteOptions{})
-	return err
-}
-
-func (deploy *NewDeploy) createOrGetSvc(deployLabels map[string]string, svcName string, svcNamespace string) (*apiv1.Service, error) {
-
-	existingSvc, err := deploy.kubernetesClient.CoreV1().Services(svcNamespace).Get(svcName, metav1.GetOptions{})
-	if err == nil {
-		return existingSvc, err
-	}
-
-	if err != nil && k8s_err.IsNotFound(err) {
-		service := &apiv1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:   svcName,
-				Labels: deployLabels,
-			},
-			Spec: apiv1.ServiceSpec{
-				Ports: []apiv1.ServicePort{
-					{
-						Name:       "runtime-env-port",
-						Port:       int32(80),
-						TargetPort: intstr.FromInt(8888),
-					},
-					{
-						Name:       "fetcher-port",
-						Port:       int32(8000),
-						TargetPort: intstr.FromInt(8000),
-					},
-				},
-				Selector: deployLabels,
-				Type:     apiv1.ServiceTypeClusterIP,
-			},
-		}
-
-		svc, err := deploy.kubernetesClient.CoreV1().Services(svcNamespace).Create(service)
-		if err != nil {
-			return nil, err
+// addFunctionEnvAndVolumes adds the environment variables and volume mounts
+// of the function to its container, after those of the environment so
+// that they take precedence, and returns the volumes of the function.
+// Secrets, ConfigMaps and claims are looked up in the namespace of the
+// deployment; if that isn't the namespace of the function, the variables
+// from Secrets and ConfigMaps are returned for the fetcher to resolve in
+// the function's namespace instead, and claims are rejected.
+func addFunctionEnvAndVolumes(container *apiv1.Container, fn *crd.Function, deployNamespace string) ([]apiv1.Volume, []apiv1.EnvVar, error) {
+	container.Env = append([]apiv1.EnvVar{}, container.Env...)
+	var fetcherEnv []apiv1.EnvVar
+	for _, e := range fn.Spec.Env {
+		if e.ValueFrom != nil && deployNamespace != fn.Metadata.Namespace {
+			fetcherEnv = append(fetcherEnv, e)
+			continue
 		}
-
-		return svc, nil
+		container.Env = append(container.Env, e)
 	}
 
-	return nil, err
-}
-
-func (deploy *NewDeploy) deleteSvc(ns string, name string) error {
-	err := deploy.kubernetesClient.CoreV1().Services(ns).Delete(name, &metav1.DeleteOptions{})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (deploy *NewDeploy) waitForDeploy(depl *v1beta1.Deployment, replicas int32) (*v1beta1.Deployment, error) {
-	for i := 0; i < 120; i++ {
-		latestDepl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(depl.ObjectMeta.Namespace).Get(depl.Name, metav1.GetOptions{})
-		if err != nil {
-			return nil, err
+	var volumes []apiv1.Volume
+	for _, v := range fn.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil && deployNamespace != fn.Metadata.Namespace {
+			return nil, nil, fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("volume %v of function %v can't use a persistent volume claim in the %v namespace, create the function in another namespace",
+					v.Name, fn.Metadata.Name, fn.Metadata.Namespace))
 		}
-		//TODO check for imagePullerror
-		// use AvailableReplicas here is better than ReadyReplicas
-		// since the pods may not be able to serve network traffic yet.
-		if latestDepl.Status.AvailableReplicas >= replicas {
-			return latestDepl, err
-		}
-		time.Sleep(time.Second)
-	}
-	return nil, errors.New("failed to create deployment within timeout window")
+		volumes = append(volumes, v.Volume)
+		container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+			Name:      v.Name,
+			MountPath: v.MountPath,
+			ReadOnly:  v.ReadOnly,
+		})
+	}
+	return volumes, fetcherEnv, nil
 }
 
-func (deploy *NewDeploy) cleanupNewdeploy(ns string, name string) error {
-	var multierr *multierror.Error
-
-	err := deploy.deleteSvc(ns, name)
-	if err != nil {
-		log.Printf("Error deleting service for newdeploy function %v in namespace %v, error: %v", name, ns, err)
-		multierror.Append(multierr, err)
-	}
-
-	err = deploy.deleteHpa(ns, name)
-	if err != nil {
-		log.Printf("Error deleting HPA for newdeploy function %v in namespace %v, error: %v", name, ns, err)
-		multierror.Append(multierr, err)
+// signArchiveUrl adds a signed URL of the function's deployment archive to
+// the fetch request, if the storage service only serves signed URLs.
+func (deploy *NewDeploy) signArchiveUrl(fetchReq *fission.FunctionFetchRequest, fn *crd.Function) error {
+	if !deploy.fetcherConfig.ArchiveUrlsSigned() {
+		return nil
 	}
-
-	err = deploy.deleteDeployment(ns, name)
+	pkg, err := deploy.fissionClient.Packages(fn.Spec.Package.PackageRef.Namespace).
+		Get(fn.Spec.Package.PackageRef.Name)
 	if err != nil {
-		log.Printf("Error deleting deployment for newdeploy function %v in namespace %v, error: %v", name, ns, err)
-		multierror.Append(multierr, err)
+		return err
 	}
-	return multierr.ErrorOrNil()
+	return deploy.fetcherConfig.SignArchiveUrl(context.Background(), fetchReq, pkg, true)
 }
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package newdeploy
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func TestAddFunctionEnvAndVolumes(t *testing.T) {
+	literal := apiv1.EnvVar{Name: "LOG_LEVEL", Value: "debug"}
+	fromSecret := apiv1.EnvVar{Name: "API_TOKEN", ValueFrom: &apiv1.EnvVarSource{SecretKeyRef: &apiv1.SecretKeySelector{
+		LocalObjectReference: apiv1.LocalObjectReference{Name: "creds"},
+		Key:                  "token",
+	}}}
+	fromConfigMap := apiv1.EnvVar{Name: "REGION", ValueFrom: &apiv1.EnvVarSource{ConfigMapKeyRef: &apiv1.ConfigMapKeySelector{
+		LocalObjectReference: apiv1.LocalObjectReference{Name: "settings"},
+		Key:                  "region",
+	}}}
+	scratch := fission.FunctionVolume{
+		Volume:    apiv1.Volume{Name: "scratch", VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}}},
+		MountPath: "/tmp/scratch",
+	}
+	data := fission.FunctionVolume{
+		Volume: apiv1.Volume{Name: "data", VolumeSource: apiv1.VolumeSource{
+			PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: "my-claim"},
+		}},
+		MountPath: "/data",
+	}
+
+	fn := &crd.Function{}
+	fn.Metadata.Name = "hello"
+	fn.Metadata.Namespace = "team-a"
+	fn.Spec.Env = []apiv1.EnvVar{literal, fromSecret, fromConfigMap}
+	fn.Spec.Volumes = []fission.FunctionVolume{scratch, data}
+
+	// Deployments in the namespace of the function get everything, after
+	// the environment variables of the environment
+	envVar := apiv1.EnvVar{Name: "LOG_LEVEL", Value: "info"}
+	container := &apiv1.Container{Env: []apiv1.EnvVar{envVar}}
+	volumes, fetcherEnv, err := addFunctionEnvAndVolumes(container, fn, "team-a")
+	if err != nil {
+		t.Fatalf("error adding env and volumes: %v", err)
+	}
+	if !reflect.DeepEqual(container.Env, []apiv1.EnvVar{envVar, literal, fromSecret, fromConfigMap}) || len(fetcherEnv) != 0 {
+		t.Fatalf("expected environment variables on the container, got %+v and %+v for the fetcher", container.Env, fetcherEnv)
+	}
+	if !reflect.DeepEqual(volumes, []apiv1.Volume{scratch.Volume, data.Volume}) || len(container.VolumeMounts) != 2 ||
+		container.VolumeMounts[1] != (apiv1.VolumeMount{Name: "data", MountPath: "/data"}) {
+		t.Fatalf("unexpected volumes %+v and mounts %+v", volumes, container.VolumeMounts)
+	}
+
+	// Deployments of the default namespace are in another one, where the
+	// Secrets, ConfigMaps and claims of the function aren't
+	fn.Metadata.Namespace = "default"
+	container = &apiv1.Container{Env: []apiv1.EnvVar{envVar}}
+	_, _, err = addFunctionEnvAndVolumes(container, fn, "fission-function")
+	if fe, ok := err.(fission.Error); !ok || fe.Code != fission.ErrorInvalidArgument {
+		t.Fatalf("expected volume of claim in another namespace to be rejected, got %v", err)
+	}
+
+	fn.Spec.Volumes = []fission.FunctionVolume{scratch}
+	container = &apiv1.Container{Env: []apiv1.EnvVar{envVar}}
+	volumes, fetcherEnv, err = addFunctionEnvAndVolumes(container, fn, "fission-function")
+	if err != nil {
+		t.Fatalf("error adding env and volumes: %v", err)
+	}
+	if !reflect.DeepEqual(container.Env, []apiv1.EnvVar{envVar, literal}) ||
+		!reflect.DeepEqual(fetcherEnv, []apiv1.EnvVar{fromSecret, fromConfigMap}) {
+		t.Fatalf("expected variables from Secrets and ConfigMaps for the fetcher, got %+v on the container and %+v", container.Env, fetcherEnv)
+	}
+	if !reflect.DeepEqual(volumes, []apiv1.Volume{scratch.Volume}) {
+		t.Fatalf("unexpected volumes %+v", volumes)
+	}
+}
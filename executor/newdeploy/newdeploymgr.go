@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -28,11 +29,9 @@ import (
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	apiv1 "k8s.io/api/core/v1"
-	"k8s.io/api/extensions/v1beta1"
-	k8sErrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	k8sCache "k8s.io/client-go/tools/cache"
@@ -40,7 +39,10 @@ import (
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
 	fetcherConfig "github.com/fission/fission/environments/fetcher/config"
+	"github.com/fission/fission/executor/deployutil"
+	"github.com/fission/fission/executor/executortype"
 	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/reaper"
 )
 
 type (
@@ -58,13 +60,11 @@ type (
 		useIstio               bool
 
 		fsCache        *fscache.FunctionServiceCache // cache funcSvc's by function, address and podname
+		deployMgr      *deployutil.Manager
 		requestChannel chan *fnRequest
 
-		functions      []crd.Function
 		funcStore      k8sCache.Store
 		funcController k8sCache.Controller
-
-		idlePodReapTime time.Duration
 	}
 
 	fnRequest struct {
@@ -116,11 +116,11 @@ func MakeNewDeploy(
 		fetcherConfig: fetcherConfig,
 		namespace:     namespace,
 		fsCache:       fsCache,
+		deployMgr:     deployutil.MakeManager(kubernetesClient, fissionClient, fsCache, fission.ExecutorTypeNewdeploy, 2*time.Minute),
 
 		useIstio: enableIstio,
 
-		requestChannel:  make(chan *fnRequest),
-		idlePodReapTime: 2 * time.Minute,
+		requestChannel: make(chan *fnRequest),
 	}
 
 	nd.runtimeImagePullPolicy = fission.GetImagePullPolicy(os.Getenv("RUNTIME_IMAGE_PULL_POLICY"))
@@ -137,7 +137,20 @@ func MakeNewDeploy(
 func (deploy *NewDeploy) Run(ctx context.Context) {
 	go deploy.service()
 	go deploy.funcController.Run(ctx.Done())
-	go deploy.idleObjectReaper()
+}
+
+func (deploy *NewDeploy) GetTypeName() fission.ExecutorType {
+	return fission.ExecutorTypeNewdeploy
+}
+
+// AdoptExistingResources relabels the objects created by old executor
+// instances for functions that still exist. The function services are added
+// to the cache again when the function informer creates them on startup.
+func (deploy *NewDeploy) AdoptExistingResources() {
+	err := reaper.AdoptDeployObjects(deploy.kubernetesClient, deploy.fissionClient, fission.ExecutorTypeNewdeploy, deploy.instanceID)
+	if err != nil {
+		log.Printf("Error adopting newdeploy objects of old executor instances: %v", err)
+	}
 }
 
 func (deploy *NewDeploy) initFuncController() (k8sCache.Store, k8sCache.Controller) {
@@ -282,62 +295,30 @@ func (deploy *NewDeploy) fnCreate(fn *crd.Function, firstcreate bool) (*fscache.
 	// Since newdeploy waits for pods of deployment to be ready,
 	// change the order of kubeObject creation (create service first,
 	// then deployment) to take advantage of waiting time.
-	svc, err := deploy.createOrGetSvc(deployLabels, objName, ns)
+	svc, err := deploy.deployMgr.CreateOrGetSvc(deployLabels, objName, ns, apiv1.ServicePort{
+		Name:       "fetcher-port",
+		Port:       int32(8000),
+		TargetPort: intstr.FromInt(8000),
+	})
 	if err != nil {
 		log.Printf("Error creating the service %v: %v", objName, err)
-		go deploy.cleanupNewdeploy(ns, objName)
+		go deploy.deployMgr.Cleanup(ns, objName)
 		return fsvc, errors.Wrap(err, fmt.Sprintf("error creating service %v", objName))
 	}
-	svcAddress := fmt.Sprintf("%v.%v", svc.Name, svc.Namespace)
 	depl, err := deploy.createOrGetDeployment(fn, env, objName, deployLabels, ns, firstcreate)
 	if err != nil {
 		log.Printf("Error creating the deployment %v: %v", objName, err)
-		go deploy.cleanupNewdeploy(ns, objName)
+		go deploy.deployMgr.Cleanup(ns, objName)
 		return fsvc, errors.Wrap(err, fmt.Sprintf("error creating deployment %v", objName))
 	}
 
-	hpa, err := deploy.createOrGetHpa(objName, &fn.Spec.InvokeStrategy.ExecutionStrategy, depl)
+	hpa, err := deploy.deployMgr.CreateOrGetHpa(objName, &fn.Spec.InvokeStrategy.ExecutionStrategy, depl)
 	if err != nil {
-		go deploy.cleanupNewdeploy(ns, objName)
+		go deploy.deployMgr.Cleanup(ns, objName)
 		return fsvc, errors.Wrap(err, fmt.Sprintf("error creating the HPA %v:", objName))
 	}
 
-	kubeObjRefs := []apiv1.ObjectReference{
-		{
-			//obj.TypeMeta.Kind does not work hence this, needs investigationa and a fix
-			Kind:            "deployment",
-			Name:            depl.ObjectMeta.Name,
-			APIVersion:      depl.TypeMeta.APIVersion,
-			Namespace:       depl.ObjectMeta.Namespace,
-			ResourceVersion: depl.ObjectMeta.ResourceVersion,
-			UID:             depl.ObjectMeta.UID,
-		},
-		{
-			Kind:            "service",
-			Name:            svc.ObjectMeta.Name,
-			APIVersion:      svc.TypeMeta.APIVersion,
-			Namespace:       svc.ObjectMeta.Namespace,
-			ResourceVersion: svc.ObjectMeta.ResourceVersion,
-			UID:             svc.ObjectMeta.UID,
-		},
-		{
-			Kind:            "horizontalpodautoscaler",
-			Name:            hpa.ObjectMeta.Name,
-			APIVersion:      hpa.TypeMeta.APIVersion,
-			Namespace:       hpa.ObjectMeta.Namespace,
-			ResourceVersion: hpa.ObjectMeta.ResourceVersion,
-			UID:             hpa.ObjectMeta.UID,
-		},
-	}
-
-	fsvc = &fscache.FuncSvc{
-		Name:              objName,
-		Function:          &fn.Metadata,
-		Environment:       env,
-		Address:           svcAddress,
-		KubernetesObjects: kubeObjRefs,
-		Executor:          fscache.NEWDEPLOY,
-	}
+	fsvc = deploy.deployMgr.MakeFuncSvc(fn, env, objName, depl, svc, hpa)
 
 	_, err = deploy.fsCache.Add(*fsvc)
 	if err != nil {
@@ -378,7 +359,7 @@ func (deploy *NewDeploy) fnUpdate(oldFn *crd.Function, newFn *crd.Function) {
 			log.Printf("function type changed to new deployment, creating resources: %v", newFn)
 			_, err := deploy.fnCreate(newFn, true)
 			if err != nil {
-				updateStatus(oldFn, err, "error changing the function's type to newdeploy")
+				deploy.updateStatus(oldFn, err, "error changing the function's type to newdeploy")
 			}
 			return
 		}
@@ -390,38 +371,11 @@ func (deploy *NewDeploy) fnUpdate(oldFn *crd.Function, newFn *crd.Function) {
 			ns = newFn.Metadata.Namespace
 		}
 
-		hpa, err := deploy.getHpa(ns, newFn)
+		err := deploy.deployMgr.UpdateHpa(ns, deploy.getObjName(newFn), &newFn.Spec.InvokeStrategy.ExecutionStrategy)
 		if err != nil {
-			updateStatus(oldFn, err, "error getting HPA while updating function")
+			deploy.updateStatus(oldFn, err, "error updating HPA while updating function")
 			return
 		}
-
-		hpaChanged := false
-
-		if newFn.Spec.InvokeStrategy.ExecutionStrategy.MinScale != oldFn.Spec.InvokeStrategy.ExecutionStrategy.MinScale {
-			replicas := int32(newFn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
-			hpa.Spec.MinReplicas = &replicas
-			hpaChanged = true
-		}
-
-		if newFn.Spec.InvokeStrategy.ExecutionStrategy.MaxScale != oldFn.Spec.InvokeStrategy.ExecutionStrategy.MaxScale {
-			hpa.Spec.MaxReplicas = int32(newFn.Spec.InvokeStrategy.ExecutionStrategy.MaxScale)
-			hpaChanged = true
-		}
-
-		if newFn.Spec.InvokeStrategy.ExecutionStrategy.TargetCPUPercent != oldFn.Spec.InvokeStrategy.ExecutionStrategy.TargetCPUPercent {
-			targetCpupercent := int32(newFn.Spec.InvokeStrategy.ExecutionStrategy.TargetCPUPercent)
-			hpa.Spec.TargetCPUUtilizationPercentage = &targetCpupercent
-			hpaChanged = true
-		}
-
-		if hpaChanged {
-			err := deploy.updateHpa(hpa)
-			if err != nil {
-				updateStatus(oldFn, err, "error updating HPA while updating function")
-				return
-			}
-		}
 	}
 
 	if oldFn.Spec.Environment != newFn.Spec.Environment ||
@@ -452,11 +406,16 @@ func (deploy *NewDeploy) fnUpdate(oldFn *crd.Function, newFn *crd.Function) {
 		}
 	}
 
+	if !reflect.DeepEqual(oldFn.Spec.Env, newFn.Spec.Env) ||
+		!reflect.DeepEqual(oldFn.Spec.Volumes, newFn.Spec.Volumes) {
+		deployChanged = true
+	}
+
 	if deployChanged == true {
 		env, err := deploy.fissionClient.Environments(newFn.Spec.Environment.Namespace).
 			Get(newFn.Spec.Environment.Name)
 		if err != nil {
-			updateStatus(oldFn, err, "failed to get environment while updating function")
+			deploy.updateStatus(oldFn, err, "failed to get environment while updating function")
 			return
 		}
 		deployName := deploy.getObjName(oldFn)
@@ -464,7 +423,7 @@ func (deploy *NewDeploy) fnUpdate(oldFn *crd.Function, newFn *crd.Function) {
 		log.Printf("updating %v deployment due to function %v update", deployName, newFn.Metadata.Name)
 		newDeployment, err := deploy.getDeploymentSpec(newFn, env, deployName, deployLabels)
 		if err != nil {
-			updateStatus(oldFn, err, "failed to get new deployment spec while updating function")
+			deploy.updateStatus(oldFn, err, "failed to get new deployment spec while updating function")
 			return
 		}
 
@@ -475,9 +434,9 @@ func (deploy *NewDeploy) fnUpdate(oldFn *crd.Function, newFn *crd.Function) {
 			ns = newFn.Metadata.Namespace
 		}
 
-		err = deploy.updateDeployment(newDeployment, ns)
+		err = deploy.deployMgr.UpdateDeployment(newDeployment, ns)
 		if err != nil {
-			updateStatus(oldFn, err, "failed to update deployment while updating function")
+			deploy.updateStatus(oldFn, err, "failed to update deployment while updating function")
 			return
 		}
 	}
@@ -501,7 +460,7 @@ func (deploy *NewDeploy) fnDelete(fn *crd.Function) (*fscache.FuncSvc, error) {
 	_, err = deploy.fsCache.DeleteOld(fsvc, time.Second*0)
 	if err != nil {
 		log.Printf("Error deleting the function from cache: %v", fsvc)
-		multierror.Append(multierr, err)
+		multierr = multierror.Append(multierr, err)
 	}
 	objName := fsvc.Name
 
@@ -512,8 +471,10 @@ func (deploy *NewDeploy) fnDelete(fn *crd.Function) (*fscache.FuncSvc, error) {
 		ns = fn.Metadata.Namespace
 	}
 
-	err = deploy.cleanupNewdeploy(ns, objName)
-	multierror.Append(multierr, err)
+	err = deploy.deployMgr.Cleanup(ns, objName)
+	if err != nil {
+		multierr = multierror.Append(multierr, err)
+	}
 
 	return nil, multierr.ErrorOrNil()
 }
@@ -553,142 +514,25 @@ func (deploy *NewDeploy) updateKubeObjRefRV(fsvc *fscache.FuncSvc, objKind strin
 	return errors.New(fmt.Sprintf("error finding kubernetes object reference with kind: %v", objKind))
 }
 
-// updateStatus is a function which updates status of update.
-// Current implementation only logs messages, in future it will update function status
-func updateStatus(fn *crd.Function, err error, message string) {
-	log.Println(message, fn, err)
+// updateStatus logs an error that happened while updating the objects of
+// a function and records it as the last error in the function status.
+func (deploy *NewDeploy) updateStatus(fn *crd.Function, err error, message string) {
+	log.Printf("%v: function %v: %v", message, fn.Metadata.Name, err)
+	go executortype.ReportError(deploy.fissionClient, &fn.Metadata, message, err)
 }
 
-// IsValid does a get on the service address to ensure it's a valid service, then
-// scale deployment to 1 replica if there are no available replicas for function.
-// Return true if no error occurs, return false otherwise.
+// IsValid checks that the service of the function exists and that its
+// deployment has at least one available replica.
 func (deploy *NewDeploy) IsValid(fsvc *fscache.FuncSvc) bool {
-	service := strings.Split(fsvc.Address, ".")
-	if len(service) == 0 {
-		return false
-	}
-
-	_, err := deploy.kubernetesClient.CoreV1().Services(service[1]).Get(service[0], metav1.GetOptions{})
-	if err != nil {
-		log.Printf("Error validating service address for function %v: %v", fsvc.Function.Name, err)
-		return false
-	}
-
-	deployObj := getDeploymentObj(fsvc.KubernetesObjects)
-	if deployObj == nil {
-		log.Printf("Deployment obj for function %v does not exist", fsvc.Function.Name)
-		return false
-	}
-
-	currentDeploy, err := deploy.kubernetesClient.ExtensionsV1beta1().
-		Deployments(deployObj.Namespace).Get(deployObj.Name, metav1.GetOptions{})
-	if err != nil {
-		log.Printf("Error validating deployment for function %v: %v", fsvc.Function.Name, err)
-		return false
-	}
-
-	// return directly when available replicas > 0
-	if currentDeploy.Status.AvailableReplicas > 0 {
-		return true
-	}
-
-	return false
-}
-
-// idleObjectReaper reaps objects after certain idle time
-func (deploy *NewDeploy) idleObjectReaper() {
-
-	pollSleep := time.Duration(deploy.idlePodReapTime)
-	for {
-		time.Sleep(pollSleep)
-
-		envs, err := deploy.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
-		if err != nil {
-			log.Fatalf("Failed to get environment list: %v", err)
-		}
-
-		envList := make(map[types.UID]struct{})
-		for _, env := range envs.Items {
-			envList[env.Metadata.UID] = struct{}{}
-		}
-
-		funcSvcs, err := deploy.fsCache.ListOld(deploy.idlePodReapTime)
-		if err != nil {
-			log.Printf("Error reaping idle pods: %v", err)
-			continue
-		}
-
-		for _, fsvc := range funcSvcs {
-			if fsvc.Executor != fscache.NEWDEPLOY {
-				continue
-			}
-
-			// For function with the environment that no longer exists, executor
-			// scales down the deployment as usual and prints log to notify user.
-			if _, ok := envList[fsvc.Environment.Metadata.UID]; !ok {
-				log.Printf("Environment %v for function %v no longer exists",
-					fsvc.Environment.Metadata.Name, fsvc.Name)
-			}
-
-			fn, err := deploy.fissionClient.Functions(fsvc.Function.Namespace).Get(fsvc.Function.Name)
-			if err != nil {
-				// Newdeploy manager handles the function delete event and clean cache/kubeobjs itself,
-				// so we ignore the not found error for functions with newdeploy executor type here.
-				if k8sErrs.IsNotFound(err) && fsvc.Executor == fscache.NEWDEPLOY {
-					continue
-				}
-				log.Printf("Error getting function: %v", fsvc.Function.Name)
-				continue
-			}
-
-			deployObj := getDeploymentObj(fsvc.KubernetesObjects)
-			if deployObj == nil {
-				log.Printf("Error finding deployment for function %v: %v", fsvc.Function.Name, err)
-				continue
-			}
-
-			currentDeploy, err := deploy.kubernetesClient.ExtensionsV1beta1().
-				Deployments(deployObj.Namespace).Get(deployObj.Name, metav1.GetOptions{})
-			if err != nil {
-				log.Printf("Error validating deployment for function %v: %v", fsvc.Function.Name, err)
-				continue
-			}
-
-			minScale := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
-
-			// do nothing if the current replicas is already lower than minScale
-			if *currentDeploy.Spec.Replicas <= minScale {
-				continue
-			}
-
-			err = scaleDeployment(deploy.kubernetesClient, deployObj.Namespace, deployObj.Name, minScale)
-			if err != nil {
-				log.Printf("Error scaling down deployment for function %v: %v", fsvc.Function.Name, err)
-			}
-		}
-	}
+	return deploy.deployMgr.IsValid(fsvc)
 }
 
-func getDeploymentObj(kubeobjs []apiv1.ObjectReference) *apiv1.ObjectReference {
-	for _, kubeobj := range kubeobjs {
-		switch strings.ToLower(kubeobj.Kind) {
-		case "deployment":
-			return &kubeobj
-		}
-	}
-	return nil
+// CleanupFuncSvc deletes the service, HPA and deployment of the function service
+func (deploy *NewDeploy) CleanupFuncSvc(fsvc *fscache.FuncSvc) error {
+	return deploy.deployMgr.CleanupFuncSvc(fsvc)
 }
 
-func scaleDeployment(client *kubernetes.Clientset, deplNS string, deplName string, replicas int32) error {
-	log.Printf("Scale deployment %v in namespace %v to replicas %v", deplName, deplNS, replicas)
-	_, err := client.ExtensionsV1beta1().Deployments(deplNS).UpdateScale(deplName, &v1beta1.Scale{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      deplName,
-			Namespace: deplNS,
-		},
-		Spec: v1beta1.ScaleSpec{
-			Replicas: replicas,
-		},
-	})
-	return err
+// IdleObjectReaper reaps objects after certain idle time
+func (deploy *NewDeploy) IdleObjectReaper(ctx context.Context) {
+	deploy.deployMgr.IdleObjectReaper(ctx)
 }
@@ -234,10 +234,19 @@ func (gp *GenericPool) _choosePod(newLabels map[string]string) (*apiv1.Pod, erro
 	}
 }
 
+// labelsForFunction returns the labels of a pod specialized for the
+// function. The executor type label is left out so that the pod no longer
+// matches the selector of the pool deployment; the other labels allow a new
+// executor instance to adopt the pod.
 func (gp *GenericPool) labelsForFunction(metadata *metav1.ObjectMeta) map[string]string {
 	return map[string]string{
-		"functionName":                    metadata.Name,
-		"functionUid":                     string(metadata.UID),
+		fission.FUNCTION_NAME:             metadata.Name,
+		fission.FUNCTION_NAMESPACE:        metadata.Namespace,
+		fission.FUNCTION_UID:              string(metadata.UID),
+		fission.FUNCTION_RESOURCE_VERSION: metadata.ResourceVersion,
+		fission.ENVIRONMENT_NAME:          gp.env.Metadata.Name,
+		fission.ENVIRONMENT_NAMESPACE:     gp.env.Metadata.Namespace,
+		fission.ENVIRONMENT_UID:           string(gp.env.Metadata.UID),
 		"unmanaged":                       "true", // this allows us to easily find pods not managed by the deployment
 		fission.EXECUTOR_INSTANCEID_LABEL: gp.instanceId,
 	}
@@ -311,6 +320,33 @@ func (gp *GenericPool) specializePod(ctx context.Context, pod *apiv1.Pod, metada
 
 	specializeReq := gp.fetcherConfig.NewSpecializeRequest(fn, gp.env)
 
+	// Pre-warmed pods are already running, so the runtime sets the
+	// environment variables of the function when it loads it; only v2
+	// runtimes get a load request to read them from.
+	if len(fn.Spec.Env) > 0 {
+		if gp.env.Spec.Version < 2 {
+			return fmt.Errorf("environment variables of function %v need an environment of version 2 or later, or the newdeploy executor type", fn.Metadata.Name)
+		}
+		// The functions of such a pod share its process environment.
+		if gp.env.Spec.AllowedFunctionsPerContainer == fission.AllowedFunctionsPerContainerInfinite {
+			return fmt.Errorf("environment variables of function %v aren't supported by environments allowing multiple functions per container, use the newdeploy executor type", fn.Metadata.Name)
+		}
+		specializeReq.FetchReq.Env = fn.Spec.Env
+	}
+
+	if gp.fetcherConfig.ArchiveUrlsSigned() {
+		pkg, err := gp.fissionClient.
+			Packages(fn.Spec.Package.PackageRef.Namespace).
+			Get(fn.Spec.Package.PackageRef.Name)
+		if err != nil {
+			return err
+		}
+		err = gp.fetcherConfig.SignArchiveUrl(ctx, &specializeReq.FetchReq, pkg, false)
+		if err != nil {
+			return errors.Wrap(err, "error signing archive url")
+		}
+	}
+
 	log.Printf("[%v] specializing pod", metadata.Name)
 
 	err = fetcherClient.MakeClient(fetcherUrl).Specialize(ctx, &specializeReq)
@@ -318,6 +354,13 @@ func (gp *GenericPool) specializePod(ctx context.Context, pod *apiv1.Pod, metada
 		return err
 	}
 
+	if gp.env.Spec.Version >= 3 {
+		err = waitForRuntimeReady(ctx, fmt.Sprintf("%v:8888", podIP), gp.podReadyTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -376,16 +419,8 @@ func (gp *GenericPool) createPool() error {
 							// before grace period expires.
 							// https://kubernetes.io/docs/concepts/workloads/pods/pod/#termination-of-pods
 							// https://github.com/kubernetes/kubernetes/issues/47576#issuecomment-308900172
-							Lifecycle: &apiv1.Lifecycle{
-								PreStop: &apiv1.Handler{
-									Exec: &apiv1.ExecAction{
-										Command: []string{
-											"/bin/sleep",
-											fmt.Sprintf("%v", gracePeriodSeconds),
-										},
-									},
-								},
-							},
+							Lifecycle:      fission.RuntimeLifecycle(gp.env.Spec.Version, gracePeriodSeconds),
+							ReadinessProbe: fission.RuntimeReadinessProbe(gp.env.Spec.Version),
 						}, gp.env.Spec.Runtime.Container),
 					},
 					// TerminationGracePeriodSeconds should be equal to the
@@ -513,7 +548,13 @@ func (gp *GenericPool) GetFuncSvc(ctx context.Context, m *metav1.ObjectMeta) (*f
 
 	err = gp.specializePod(ctx, pod, m)
 	if err != nil {
-		gp.scheduleDeletePod(pod.ObjectMeta.Name)
+		if se, ok := errors.Cause(err).(*fission.SpecializationError); ok {
+			se.Pod = pod.ObjectMeta.Name
+		}
+		// Keep the pod if it serves other functions already.
+		if gp.env.Spec.AllowedFunctionsPerContainer != fission.AllowedFunctionsPerContainerInfinite {
+			gp.scheduleDeletePod(pod.ObjectMeta.Name)
+		}
 		return nil, err
 	}
 	log.Printf("Specialized pod: %v", pod.ObjectMeta.Name)
@@ -564,7 +605,7 @@ func (gp *GenericPool) GetFuncSvc(ctx context.Context, m *metav1.ObjectMeta) (*f
 		Environment:       gp.env,
 		Address:           svcHost,
 		KubernetesObjects: kubeObjRefs,
-		Executor:          fscache.POOLMGR,
+		Executor:          fission.ExecutorTypePoolmgr,
 		Ctime:             time.Now(),
 		Atime:             time.Now(),
 	}
@@ -18,20 +18,25 @@ package poolmgr
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	k8sCache "k8s.io/client-go/tools/cache"
 
 	"github.com/fission/fission"
+	"github.com/fission/fission/cache"
 	"github.com/fission/fission/crd"
 	fetcherConfig "github.com/fission/fission/environments/fetcher/config"
+	"github.com/fission/fission/executor/executortype"
 	"github.com/fission/fission/executor/fscache"
 	"github.com/fission/fission/executor/reaper"
 )
@@ -51,6 +56,7 @@ type (
 
 		fissionClient  *crd.FissionClient
 		fsCache        *fscache.FunctionServiceCache
+		functionEnv    *cache.Cache
 		instanceId     string
 		requestChannel chan *request
 
@@ -90,13 +96,13 @@ func MakeGenericPoolManager(
 		namespace:        functionNamespace,
 		fissionClient:    fissionClient,
 		fsCache:          fsCache,
+		functionEnv:      cache.MakeCache(10*time.Second, 0),
 		fetcherConfig:    fetcherConfig,
 		instanceId:       instanceId,
 		requestChannel:   make(chan *request),
 		idlePodReapTime:  2 * time.Minute,
 	}
 	go gpm.service()
-	go gpm.eagerPoolCreator()
 
 	if len(os.Getenv("ENABLE_ISTIO")) > 0 {
 		istio, err := strconv.ParseBool(os.Getenv("ENABLE_ISTIO"))
@@ -115,9 +121,63 @@ func MakeGenericPoolManager(
 }
 
 func (gpm *GenericPoolManager) Run(ctx context.Context) {
+	go gpm.eagerPoolCreator(ctx)
 	go gpm.funcController.Run(ctx.Done())
 	go gpm.pkgController.Run(ctx.Done())
-	go gpm.idleObjectReaper()
+}
+
+func (gpm *GenericPoolManager) GetTypeName() fission.ExecutorType {
+	return fission.ExecutorTypePoolmgr
+}
+
+// GetFuncSvc chooses a pod from the pool of the function's environment,
+// specializes it and returns the resulting function service.
+func (gpm *GenericPoolManager) GetFuncSvc(ctx context.Context, metadata *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
+	// from Func -> get Env
+	log.Printf("[%v] getting environment for function", metadata.Name)
+	env, err := gpm.getFunctionEnv(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := gpm.GetPool(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// from GenericPool -> get one function container
+	// (this also adds to the cache)
+	log.Printf("[%v] getting function service from pool", metadata.Name)
+	return pool.GetFuncSvc(ctx, metadata)
+}
+
+func (gpm *GenericPoolManager) getFunctionEnv(m *metav1.ObjectMeta) (*crd.Environment, error) {
+	var env *crd.Environment
+
+	// Cached ?
+	result, err := gpm.functionEnv.Get(crd.CacheKey(m))
+	if err == nil {
+		env = result.(*crd.Environment)
+		return env, nil
+	}
+
+	// Cache miss -- get func from controller
+	f, err := gpm.fissionClient.Functions(m.Namespace).Get(m.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get env from metadata
+	log.Printf("[%v] getting env", m)
+	env, err = gpm.fissionClient.Environments(f.Spec.Environment.Namespace).Get(f.Spec.Environment.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// cache for future lookups
+	gpm.functionEnv.Set(crd.CacheKey(m), env)
+
+	return env, nil
 }
 
 func (gpm *GenericPoolManager) service() {
@@ -192,9 +252,9 @@ func (gpm *GenericPoolManager) CleanupPools(envs []crd.Environment) {
 	}
 }
 
-func (gpm *GenericPoolManager) eagerPoolCreator() {
+func (gpm *GenericPoolManager) eagerPoolCreator(ctx context.Context) {
 	pollSleep := time.Duration(2 * time.Second)
-	for {
+	for ctx.Err() == nil {
 		// get list of envs from controller
 		envs, err := gpm.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
 		if err != nil {
@@ -223,7 +283,11 @@ func (gpm *GenericPoolManager) eagerPoolCreator() {
 
 		// Clean up pools whose env was deleted
 		gpm.CleanupPools(envs.Items)
-		time.Sleep(pollSleep)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(pollSleep):
+		}
 	}
 }
 
@@ -252,16 +316,136 @@ func (gpm *GenericPoolManager) IsValid(fsvc *fscache.FuncSvc) bool {
 	return false
 }
 
-// idleObjectReaper reaps objects after certain idle time
-func (gpm *GenericPoolManager) idleObjectReaper() {
+// CleanupFuncSvc deletes the specialized pod (and service, if any) of the function service.
+// Runtimes of v3 environments are drained first.
+func (gpm *GenericPoolManager) CleanupFuncSvc(fsvc *fscache.FuncSvc) error {
+	if fsvc.Environment != nil && fsvc.Environment.Spec.Version >= 3 {
+		gpm.drainPods(fsvc)
+	}
+	for _, kubeobj := range fsvc.KubernetesObjects {
+		reaper.CleanupKubeObject(gpm.kubernetesClient, &kubeobj)
+	}
+	return nil
+}
+
+// drainPods drains the runtimes of the pods of the function service.
+func (gpm *GenericPoolManager) drainPods(fsvc *fscache.FuncSvc) {
+	for _, obj := range fsvc.KubernetesObjects {
+		if obj.Kind != "pod" {
+			continue
+		}
+		pod, err := gpm.kubernetesClient.CoreV1().Pods(obj.Namespace).Get(obj.Name, metav1.GetOptions{})
+		if err != nil || len(pod.Status.PodIP) == 0 {
+			continue
+		}
+		err = drainRuntime(fmt.Sprintf("%v:8888", pod.Status.PodIP))
+		if err != nil {
+			log.Printf("Error draining pod %v: %v", obj.Name, err)
+		}
+	}
+}
+
+// AdoptExistingResources adds the pods specialized by old executor instances
+// back to the function service cache, if the function and environment they
+// were specialized for still exist and the pod is ready. Adopted pods are
+// relabeled with the current instance ID; the others are left for the cleanup
+// of old executor objects. Pools of environments outside of the default
+// namespace are in the namespace of their environment, so pods are looked
+// up in all namespaces.
+func (gpm *GenericPoolManager) AdoptExistingResources() {
+	podList, err := gpm.kubernetesClient.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		LabelSelector: labels.Set(map[string]string{"unmanaged": "true"}).AsSelector().String(),
+	})
+	if err != nil {
+		log.Printf("Error listing specialized pods of old executor instances: %v", err)
+		return
+	}
+
+	for i := range podList.Items {
+		pod := podList.Items[i]
+
+		id, ok := pod.ObjectMeta.Labels[fission.EXECUTOR_INSTANCEID_LABEL]
+		if !ok || id == gpm.instanceId {
+			continue
+		}
+
+		if !fission.IsReadyPod(&pod) || pod.ObjectMeta.DeletionTimestamp != nil {
+			log.Printf("Not adopting pod %v: pod is not ready", pod.ObjectMeta.Name)
+			continue
+		}
+
+		fn, env, err := reaper.CanAdopt(gpm.fissionClient, pod.ObjectMeta.Labels, fission.ExecutorTypePoolmgr)
+		if err != nil {
+			log.Printf("Not adopting pod %v: %v", pod.ObjectMeta.Name, err)
+			continue
+		}
+
+		pod.ObjectMeta.Labels[fission.EXECUTOR_INSTANCEID_LABEL] = gpm.instanceId
+		newPod, err := gpm.kubernetesClient.CoreV1().Pods(pod.ObjectMeta.Namespace).Update(&pod)
+		if err != nil {
+			log.Printf("Error adopting pod %v: %v", pod.ObjectMeta.Name, err)
+			continue
+		}
+
+		// The pod was specialized for the resource version of the function
+		// the router asked for, which is what the cache is keyed by.
+		m := &metav1.ObjectMeta{
+			Name:            fn.Metadata.Name,
+			Namespace:       fn.Metadata.Namespace,
+			UID:             fn.Metadata.UID,
+			ResourceVersion: pod.ObjectMeta.Labels[fission.FUNCTION_RESOURCE_VERSION],
+		}
+
+		svcHost := fmt.Sprintf("%v:8888", newPod.Status.PodIP)
+		if gpm.enableIstio {
+			svc := fission.GetFunctionIstioServiceName(m.Name, m.Namespace)
+			svcHost = fmt.Sprintf("%v.%v:8888", svc, newPod.ObjectMeta.Namespace)
+		}
+
+		fsvc := fscache.FuncSvc{
+			Name:        newPod.ObjectMeta.Name,
+			Function:    m,
+			Environment: env,
+			Address:     svcHost,
+			KubernetesObjects: []apiv1.ObjectReference{
+				{
+					Kind:            "pod",
+					Name:            newPod.ObjectMeta.Name,
+					APIVersion:      newPod.TypeMeta.APIVersion,
+					Namespace:       newPod.ObjectMeta.Namespace,
+					ResourceVersion: newPod.ObjectMeta.ResourceVersion,
+					UID:             newPod.ObjectMeta.UID,
+				},
+			},
+			Executor: fission.ExecutorTypePoolmgr,
+			Ctime:    time.Now(),
+			Atime:    time.Now(),
+		}
+
+		_, err = gpm.fsCache.Add(fsvc)
+		if err != nil {
+			log.Printf("Error adding adopted pod %v to cache: %v", newPod.ObjectMeta.Name, err)
+			continue
+		}
+		log.Printf("Adopted pod %v of function %v", newPod.ObjectMeta.Name, m.Name)
+	}
+}
+
+// IdleObjectReaper reaps objects after certain idle time
+func (gpm *GenericPoolManager) IdleObjectReaper(ctx context.Context) {
 
 	pollSleep := time.Duration(gpm.idlePodReapTime)
 	for {
-		time.Sleep(pollSleep)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollSleep):
+		}
 
 		envs, err := gpm.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
 		if err != nil {
-			log.Fatalf("Failed to get environment list: %v", err)
+			log.Printf("Failed to get environment list: %v", err)
+			continue
 		}
 
 		envList := make(map[types.UID]struct{})
@@ -276,7 +460,7 @@ func (gpm *GenericPoolManager) idleObjectReaper() {
 		}
 
 		for _, fsvc := range funcSvcs {
-			if fsvc.Executor != fscache.POOLMGR {
+			if fsvc.Executor != fission.ExecutorTypePoolmgr {
 				continue
 			}
 
@@ -300,9 +484,12 @@ func (gpm *GenericPoolManager) idleObjectReaper() {
 				continue
 			}
 
-			for _, kubeobj := range fsvc.KubernetesObjects {
-				reaper.CleanupKubeObject(gpm.kubernetesClient, &kubeobj)
-			}
+			// Draining takes up to the drain timeout, which mustn't hold
+			// up reaping the other pods
+			go func(fsvc *fscache.FuncSvc) {
+				gpm.CleanupFuncSvc(fsvc)
+				executortype.ReportScaledToZero(gpm.fissionClient, fsvc.Function)
+			}(fsvc)
 		}
 	}
 }
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/crd/fake"
+	"github.com/fission/fission/executor/fscache"
+)
+
+func makeTestPoolManager(server *fake.APIServer, instanceID string) *GenericPoolManager {
+	fissionClient, kubernetesClient := server.Clients()
+	return MakeGenericPoolManager(fissionClient, kubernetesClient, "fission-function",
+		fscache.MakeFunctionServiceCache(), nil, instanceID)
+}
+
+func TestAdoptExistingPods(t *testing.T) {
+	server := fake.NewAPIServer()
+	defer server.Close()
+	gpm := makeTestPoolManager(server, "new")
+
+	fn := &crd.Function{}
+	fn.Metadata = metav1.ObjectMeta{Name: "hello", UID: "fn-uid"}
+	server.Add("/apis/fission.io/v1/namespaces/team-a/functions/hello", fn)
+	env := &crd.Environment{}
+	env.Metadata = metav1.ObjectMeta{Name: "env", UID: "env-uid"}
+	server.Add("/apis/fission.io/v1/namespaces/team-a/environments/env", env)
+
+	addPod := func(name string, instanceID string, ready bool) string {
+		pod := &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					fission.FUNCTION_NAME:             "hello",
+					fission.FUNCTION_NAMESPACE:        "team-a",
+					fission.FUNCTION_UID:              "fn-uid",
+					fission.FUNCTION_RESOURCE_VERSION: "5",
+					fission.ENVIRONMENT_NAME:          "env",
+					fission.ENVIRONMENT_NAMESPACE:     "team-a",
+					fission.ENVIRONMENT_UID:           "env-uid",
+					fission.EXECUTOR_INSTANCEID_LABEL: instanceID,
+					"unmanaged":                       "true",
+				},
+			},
+			Status: apiv1.PodStatus{
+				PodIP:             "10.0.0.1",
+				ContainerStatuses: []apiv1.ContainerStatus{{Ready: ready}},
+			},
+		}
+		// Pools of environments outside of the default namespace are in
+		// the namespace of the environment
+		path := "/api/v1/namespaces/team-a/pods/" + name
+		server.Add(path, pod)
+		return path
+	}
+	adopted := addPod("specialized", "old", true)
+	notReady := addPod("not-ready", "old", false)
+
+	gpm.AdoptExistingResources()
+
+	fsvc, err := gpm.fsCache.GetByFunction(&metav1.ObjectMeta{
+		Name:            "hello",
+		Namespace:       "team-a",
+		UID:             "fn-uid",
+		ResourceVersion: "5",
+	})
+	if err != nil {
+		t.Fatalf("expected adopted pod in the function service cache: %v", err)
+	}
+	if fsvc.Address != "10.0.0.1:8888" || fsvc.Name != "specialized" || fsvc.Environment.Metadata.Name != "env" {
+		t.Fatalf("unexpected function service %+v", fsvc)
+	}
+
+	var pod apiv1.Pod
+	server.Get(adopted, &pod)
+	if id := pod.Labels[fission.EXECUTOR_INSTANCEID_LABEL]; id != "new" {
+		t.Fatalf("expected adopted pod to be relabeled, got instance ID %v", id)
+	}
+	server.Get(notReady, &pod)
+	if id := pod.Labels[fission.EXECUTOR_INSTANCEID_LABEL]; id != "old" {
+		t.Fatalf("expected pod that isn't ready not to be adopted, got instance ID %v", id)
+	}
+}
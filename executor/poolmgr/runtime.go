@@ -0,0 +1,87 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fission/fission"
+)
+
+// Runtimes of v3 environments report whether they can serve requests,
+// and can be told to drain, i.e. to finish the requests they serve
+// without taking new ones.
+
+const (
+	// time the reaper waits for a runtime to drain before deleting its
+	// pod anyway; the preStop hook of the pod keeps draining it
+	drainTimeout = 30 * time.Second
+)
+
+// waitForRuntimeReady polls the readiness endpoint of the runtime at the
+// given host:port until it is ready.
+func waitForRuntimeReady(ctx context.Context, host string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%v%v", host, fission.EnvReadinessPath)
+	for {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = fission.MakeErrorFromHTTP(resp)
+			}
+			return errors.Wrap(err, "runtime didn't become ready")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// drainRuntime tells the runtime at the given host:port to drain, and
+// waits until it did.
+func drainRuntime(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%v%v", host, fission.EnvDrainPath), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return fission.MakeErrorFromHTTP(resp)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+func TestWaitForRuntimeReady(t *testing.T) {
+	var polls int32
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fission.EnvReadinessPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// The runtime loads its function during the first polls
+		if atomic.AddInt32(&polls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer runtime.Close()
+	host := strings.TrimPrefix(runtime.URL, "http://")
+
+	err := waitForRuntimeReady(context.Background(), host, 5*time.Second)
+	if err != nil || atomic.LoadInt32(&polls) != 3 {
+		t.Fatalf("expected runtime to be ready after 3 polls, got %v polls, %v", polls, err)
+	}
+
+	// Runtimes that don't become ready in time fail specialization
+	atomic.StoreInt32(&polls, -100)
+	err = waitForRuntimeReady(context.Background(), host, 300*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "runtime didn't become ready") {
+		t.Fatalf("expected runtime not to be ready, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitForRuntimeReady(ctx, host, 5*time.Second); err == nil {
+		t.Fatalf("expected waiting with a done context to fail")
+	}
+}
+
+func TestDrainRuntime(t *testing.T) {
+	var drained []string
+	status := http.StatusOK
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		drained = append(drained, r.Method+" "+r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer runtime.Close()
+	host := strings.TrimPrefix(runtime.URL, "http://")
+
+	if err := drainRuntime(host); err != nil {
+		t.Fatalf("error draining runtime: %v", err)
+	}
+	if len(drained) != 1 || drained[0] != "POST "+fission.EnvDrainPath {
+		t.Fatalf("expected drain request, got %v", drained)
+	}
+
+	status = http.StatusInternalServerError
+	if err := drainRuntime(host); err == nil {
+		t.Fatalf("expected failed drain to be an error")
+	}
+	runtime.Close()
+	if err := drainRuntime(host); err == nil {
+		t.Fatalf("expected draining unreachable runtime to fail")
+	}
+}
+
+func TestRuntimeLifecycle(t *testing.T) {
+	// Runtimes of v3 environments are drained before their pods stop
+	lifecycle := fission.RuntimeLifecycle(3, 360)
+	if lifecycle.PreStop.Exec != nil || lifecycle.PreStop.HTTPGet == nil ||
+		lifecycle.PreStop.HTTPGet.Path != fission.EnvDrainPath || lifecycle.PreStop.HTTPGet.Port.IntValue() != 8888 {
+		t.Fatalf("expected v3 runtime to be drained before it stops, got %+v", lifecycle.PreStop)
+	}
+
+	// Earlier runtimes wait for the grace period
+	for _, version := range []int{1, 2} {
+		lifecycle := fission.RuntimeLifecycle(version, 360)
+		if lifecycle.PreStop.HTTPGet != nil || lifecycle.PreStop.Exec == nil ||
+			strings.Join(lifecycle.PreStop.Exec.Command, " ") != "/bin/sleep 360" {
+			t.Fatalf("expected v%v runtime to wait for the grace period, got %+v", version, lifecycle.PreStop)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reaper
+
+import (
+	"fmt"
+	"log"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// CanAdopt checks whether a kubernetes object created by an old executor
+// instance still belongs to an existing function of the given executor type,
+// using the function and environment labels of the object. It returns the
+// function and environment if so.
+func CanAdopt(fissionClient *crd.FissionClient, objLabels map[string]string,
+	executorType fission.ExecutorType) (*crd.Function, *crd.Environment, error) {
+
+	fnName, fnNamespace, fnUID := objLabels[fission.FUNCTION_NAME],
+		objLabels[fission.FUNCTION_NAMESPACE], objLabels[fission.FUNCTION_UID]
+	envName, envNamespace, envUID := objLabels[fission.ENVIRONMENT_NAME],
+		objLabels[fission.ENVIRONMENT_NAMESPACE], objLabels[fission.ENVIRONMENT_UID]
+	if len(fnName) == 0 || len(fnNamespace) == 0 || len(fnUID) == 0 ||
+		len(envName) == 0 || len(envNamespace) == 0 || len(envUID) == 0 {
+		return nil, nil, fmt.Errorf("missing function or environment labels")
+	}
+
+	fn, err := fissionClient.Functions(fnNamespace).Get(fnName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(fn.Metadata.UID) != fnUID {
+		return nil, nil, fmt.Errorf("function %v was recreated", fnName)
+	}
+
+	fnExecutorType := fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType
+	if len(fnExecutorType) == 0 {
+		fnExecutorType = fission.ExecutorTypePoolmgr
+	}
+	if fnExecutorType != executorType {
+		return nil, nil, fmt.Errorf("function %v changed executor type to %v", fnName, fnExecutorType)
+	}
+
+	env, err := fissionClient.Environments(envNamespace).Get(envName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(env.Metadata.UID) != envUID {
+		return nil, nil, fmt.Errorf("environment %v was recreated", envName)
+	}
+
+	return fn, env, nil
+}
+
+// AdoptDeployObjects relabels the deployments, services and HPAs created by
+// old executor instances for functions of the given executor type with the
+// current instance ID, so that CleanupOldExecutorObjects leaves them alone.
+// Objects of functions that no longer exist are left for the cleanup. The
+// backend finds the adopted objects again by name when it creates the
+// function service.
+func AdoptDeployObjects(kubernetesClient *kubernetes.Clientset, fissionClient *crd.FissionClient,
+	executorType fission.ExecutorType, instanceId string) error {
+
+	sel := labels.Set(map[string]string{
+		fission.EXECUTOR_TYPE: string(executorType),
+	}).AsSelector().String()
+
+	deploymentList, err := kubernetesClient.ExtensionsV1beta1().Deployments(meta_v1.NamespaceAll).List(
+		meta_v1.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return err
+	}
+
+	for _, dep := range deploymentList.Items {
+		if !isOldInstanceObject(dep.ObjectMeta.Labels, instanceId) {
+			continue
+		}
+
+		_, _, err := CanAdopt(fissionClient, dep.ObjectMeta.Labels, executorType)
+		if err != nil {
+			log.Printf("Not adopting deployment %v: %v", dep.ObjectMeta.Name, err)
+			continue
+		}
+
+		// Only the labels of the objects themselves are changed; the
+		// deployment selector and pod template keep the old instance ID
+		// so that the running pods stay untouched.
+		dep.ObjectMeta.Labels[fission.EXECUTOR_INSTANCEID_LABEL] = instanceId
+		_, err = kubernetesClient.ExtensionsV1beta1().Deployments(dep.ObjectMeta.Namespace).Update(&dep)
+		if err != nil {
+			logErr(fmt.Sprintf("adopting deployment %v", dep.ObjectMeta.Name), err)
+			continue
+		}
+
+		svc, err := kubernetesClient.CoreV1().Services(dep.ObjectMeta.Namespace).Get(dep.ObjectMeta.Name, meta_v1.GetOptions{})
+		if err == nil && isOldInstanceObject(svc.ObjectMeta.Labels, instanceId) {
+			svc.ObjectMeta.Labels[fission.EXECUTOR_INSTANCEID_LABEL] = instanceId
+			_, err = kubernetesClient.CoreV1().Services(svc.ObjectMeta.Namespace).Update(svc)
+		}
+		logErr(fmt.Sprintf("adopting service %v", dep.ObjectMeta.Name), err)
+
+		hpa, err := kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(dep.ObjectMeta.Namespace).Get(dep.ObjectMeta.Name, meta_v1.GetOptions{})
+		if err == nil && isOldInstanceObject(hpa.ObjectMeta.Labels, instanceId) {
+			hpa.ObjectMeta.Labels[fission.EXECUTOR_INSTANCEID_LABEL] = instanceId
+			_, err = kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(hpa.ObjectMeta.Namespace).Update(hpa)
+		}
+		logErr(fmt.Sprintf("adopting HPA %v", dep.ObjectMeta.Name), err)
+
+		log.Printf("Adopted %v objects %v in namespace %v", executorType, dep.ObjectMeta.Name, dep.ObjectMeta.Namespace)
+	}
+
+	return nil
+}
+
+func isOldInstanceObject(objLabels map[string]string, instanceId string) bool {
+	id, ok := objLabels[fission.EXECUTOR_INSTANCEID_LABEL]
+	return ok && id != instanceId
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reaper
+
+import (
+	"testing"
+
+	asv1 "k8s.io/api/autoscaling/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/crd/fake"
+)
+
+// addAdoptTestObjects adds the function hello and its environment in
+// namespace team-a.
+func addAdoptTestObjects(server *fake.APIServer, executorType fission.ExecutorType) {
+	fn := &crd.Function{}
+	fn.Metadata = metav1.ObjectMeta{Name: "hello", UID: "fn-uid"}
+	fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType = executorType
+	server.Add("/apis/fission.io/v1/namespaces/team-a/functions/hello", fn)
+
+	env := &crd.Environment{}
+	env.Metadata = metav1.ObjectMeta{Name: "env", UID: "env-uid"}
+	server.Add("/apis/fission.io/v1/namespaces/team-a/environments/env", env)
+}
+
+func adoptTestLabels(executorType fission.ExecutorType, instanceID string) map[string]string {
+	return map[string]string{
+		fission.EXECUTOR_TYPE:             string(executorType),
+		fission.EXECUTOR_INSTANCEID_LABEL: instanceID,
+		fission.FUNCTION_NAME:             "hello",
+		fission.FUNCTION_NAMESPACE:        "team-a",
+		fission.FUNCTION_UID:              "fn-uid",
+		fission.ENVIRONMENT_NAME:          "env",
+		fission.ENVIRONMENT_NAMESPACE:     "team-a",
+		fission.ENVIRONMENT_UID:           "env-uid",
+	}
+}
+
+func TestCanAdopt(t *testing.T) {
+	server := fake.NewAPIServer()
+	defer server.Close()
+	fissionClient, _ := server.Clients()
+	addAdoptTestObjects(server, "")
+
+	// Functions without an executor type are poolmgr functions
+	fn, env, err := CanAdopt(fissionClient, adoptTestLabels(fission.ExecutorTypePoolmgr, "old"), fission.ExecutorTypePoolmgr)
+	if err != nil || fn.Metadata.Name != "hello" || env.Metadata.Name != "env" {
+		t.Fatalf("expected objects of existing function to be adoptable, got %v", err)
+	}
+	if _, _, err := CanAdopt(fissionClient, adoptTestLabels(fission.ExecutorTypeNewdeploy, "old"), fission.ExecutorTypeNewdeploy); err == nil {
+		t.Fatalf("expected objects of a function of another executor type not to be adoptable")
+	}
+
+	for _, v := range []struct {
+		name  string
+		label string
+		value string
+	}{
+		{"missing function label", fission.FUNCTION_UID, ""},
+		{"missing environment label", fission.ENVIRONMENT_NAME, ""},
+		{"recreated function", fission.FUNCTION_UID, "other-uid"},
+		{"recreated environment", fission.ENVIRONMENT_UID, "other-uid"},
+		{"deleted function", fission.FUNCTION_NAME, "deleted"},
+		{"deleted environment", fission.ENVIRONMENT_NAME, "deleted"},
+	} {
+		objLabels := adoptTestLabels(fission.ExecutorTypePoolmgr, "old")
+		objLabels[v.label] = v.value
+		if _, _, err := CanAdopt(fissionClient, objLabels, fission.ExecutorTypePoolmgr); err == nil {
+			t.Fatalf("expected objects of %v not to be adoptable", v.name)
+		}
+	}
+}
+
+func TestAdoptDeployObjects(t *testing.T) {
+	server := fake.NewAPIServer()
+	defer server.Close()
+	fissionClient, kubernetesClient := server.Clients()
+	addAdoptTestObjects(server, fission.ExecutorTypeNewdeploy)
+
+	addObjects := func(name string, objLabels map[string]string) {
+		meta := metav1.ObjectMeta{Name: name, Labels: objLabels}
+		server.Add("/apis/extensions/v1beta1/namespaces/fission-function/deployments/"+name, &v1beta1.Deployment{ObjectMeta: meta})
+		server.Add("/api/v1/namespaces/fission-function/services/"+name, &apiv1.Service{ObjectMeta: meta})
+		server.Add("/apis/autoscaling/v1/namespaces/fission-function/horizontalpodautoscalers/"+name, &asv1.HorizontalPodAutoscaler{ObjectMeta: meta})
+	}
+	addObjects("hello", adoptTestLabels(fission.ExecutorTypeNewdeploy, "old"))
+	orphanLabels := adoptTestLabels(fission.ExecutorTypeNewdeploy, "old")
+	orphanLabels[fission.FUNCTION_NAME] = "deleted"
+	addObjects("deleted", orphanLabels)
+
+	err := AdoptDeployObjects(kubernetesClient, fissionClient, fission.ExecutorTypeNewdeploy, "new")
+	if err != nil {
+		t.Fatalf("error adopting objects: %v", err)
+	}
+
+	for _, path := range []string{
+		"/apis/extensions/v1beta1/namespaces/fission-function/deployments/",
+		"/api/v1/namespaces/fission-function/services/",
+		"/apis/autoscaling/v1/namespaces/fission-function/horizontalpodautoscalers/",
+	} {
+		var obj struct {
+			metav1.ObjectMeta `json:"metadata"`
+		}
+		server.Get(path+"hello", &obj)
+		if id := obj.Labels[fission.EXECUTOR_INSTANCEID_LABEL]; id != "new" {
+			t.Fatalf("expected %v to be adopted, got instance ID %v", path+"hello", id)
+		}
+		// Objects of deleted functions are left for the cleanup
+		server.Get(path+"deleted", &obj)
+		if id := obj.Labels[fission.EXECUTOR_INSTANCEID_LABEL]; id != "old" {
+			t.Fatalf("expected %v not to be adopted, got instance ID %v", path+"deleted", id)
+		}
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package reaper
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -35,7 +36,9 @@ var (
 	delOpt            = meta_v1.DeleteOptions{PropagationPolicy: &deletePropagation}
 )
 
-// CleanupOldExecutorObjects cleans up resources created by old executor instances
+// CleanupOldExecutorObjects cleans up resources created by old executor instances.
+// Objects adopted by the executor backends carry the current instance ID and
+// are left alone.
 func CleanupOldExecutorObjects(kubernetesClient *kubernetes.Clientset, instanceId string) {
 	go func() {
 		err := cleanup(kubernetesClient, instanceId)
@@ -137,6 +140,12 @@ func cleanupPods(client *kubernetes.Clientset, instanceId string) error {
 		return err
 	}
 	for _, pod := range podList.Items {
+		// Pods managed by a deployment are removed by the garbage
+		// collector along with their deployment, or kept running if
+		// the deployment was adopted.
+		if len(pod.ObjectMeta.OwnerReferences) > 0 {
+			continue
+		}
 		id, ok := pod.ObjectMeta.Labels[fission.EXECUTOR_INSTANCEID_LABEL]
 		if ok && id != instanceId {
 			log.Printf("Cleaning up pod %v", pod.ObjectMeta.Name)
@@ -200,9 +209,9 @@ func logErr(msg string, err error) {
 }
 
 // CleanupRoleBindings periodically lists rolebindings across all namespaces and removes Service Accounts from them or
-// deletes the rolebindings completely if there are no Service Accounts in a rolebinding object.
-func CleanupRoleBindings(client *kubernetes.Clientset, fissionClient *crd.FissionClient, functionNs, envBuilderNs string, cleanupRoleBindingInterval time.Duration) {
-	for {
+// deletes the rolebindings completely if there are no Service Accounts in a rolebinding object, until the context is done.
+func CleanupRoleBindings(ctx context.Context, client *kubernetes.Clientset, fissionClient *crd.FissionClient, functionNs, envBuilderNs string, cleanupRoleBindingInterval time.Duration) {
+	for ctx.Err() == nil {
 		log.Println("Starting cleanupRoleBindings cycle")
 		// get all rolebindings ( just to be efficient, one call to kubernetes )
 		rbList, err := client.RbacV1beta1().RoleBindings(meta_v1.NamespaceAll).List(meta_v1.ListOptions{})
@@ -327,6 +336,9 @@ func CleanupRoleBindings(client *kubernetes.Clientset, fissionClient *crd.Fissio
 		}
 
 		// some sleep before the next reaper iteration
-		time.Sleep(cleanupRoleBindingInterval)
+		select {
+		case <-ctx.Done():
+		case <-time.After(cleanupRoleBindingInterval):
+		}
 	}
 }
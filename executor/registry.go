@@ -0,0 +1,216 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"reflect"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+)
+
+const (
+	registryConfigMapPrefix = "fission-executor-registry"
+	registryLabel           = "fission.io/executor-registry"
+	registryShards          = 16
+	registryPublishPeriod   = 2 * time.Second
+)
+
+type (
+	// serviceRegistry shares the function services of the leader with the
+	// other executor replicas. The leader periodically publishes a record
+	// of every function service to one of registryShards configmaps, chosen
+	// by the function UID, keyed by function cache key; the other replicas
+	// watch the configmaps and answer requests for functions that are
+	// already specialized from them. Only the shards that changed are
+	// written.
+	serviceRegistry struct {
+		kubernetesClient *kubernetes.Clientset
+		namespace        string
+
+		store      k8sCache.Store
+		controller k8sCache.Controller
+	}
+
+	// registryEntry is the part of a function service the other replicas
+	// need to return and validate its address.
+	registryEntry struct {
+		Address  string               `json:"address"`
+		Executor fission.ExecutorType `json:"executor"`
+		Objects  []registryObject     `json:"objects"`
+	}
+
+	registryObject struct {
+		Kind      string `json:"kind"`
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	}
+)
+
+func makeServiceRegistry(kubernetesClient *kubernetes.Clientset, namespace string) *serviceRegistry {
+	sr := &serviceRegistry{
+		kubernetesClient: kubernetesClient,
+		namespace:        namespace,
+	}
+
+	selector := labels.Set{registryLabel: "true"}.AsSelector().String()
+	listWatch := k8sCache.NewFilteredListWatchFromClient(kubernetesClient.CoreV1().RESTClient(), "configmaps", namespace,
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		})
+	sr.store, sr.controller = k8sCache.NewInformer(listWatch, &apiv1.ConfigMap{}, 30*time.Second,
+		k8sCache.ResourceEventHandlerFuncs{})
+
+	return sr
+}
+
+// watch keeps the local copy of the registry up to date.
+func (sr *serviceRegistry) watch(ctx context.Context) {
+	go sr.controller.Run(ctx.Done())
+}
+
+func registryShardName(m *metav1.ObjectMeta) string {
+	h := fnv.New32a()
+	h.Write([]byte(m.UID))
+	return fmt.Sprintf("%v-%v", registryConfigMapPrefix, h.Sum32()%registryShards)
+}
+
+// get returns the function service registered for the function, if any.
+func (sr *serviceRegistry) get(m *metav1.ObjectMeta) (*fscache.FuncSvc, bool) {
+	obj, ok, err := sr.store.GetByKey(sr.namespace + "/" + registryShardName(m))
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	data, ok := obj.(*apiv1.ConfigMap).Data[crd.CacheKey(m)]
+	if !ok {
+		return nil, false
+	}
+
+	var entry registryEntry
+	err = json.Unmarshal([]byte(data), &entry)
+	if err != nil {
+		log.Printf("Error parsing registry entry for function %v: %v", m.Name, err)
+		return nil, false
+	}
+
+	fsvc := &fscache.FuncSvc{
+		Function: m,
+		Address:  entry.Address,
+		Executor: entry.Executor,
+	}
+	for _, o := range entry.Objects {
+		fsvc.KubernetesObjects = append(fsvc.KubernetesObjects, apiv1.ObjectReference{
+			Kind:      o.Kind,
+			Namespace: o.Namespace,
+			Name:      o.Name,
+		})
+	}
+	return fsvc, true
+}
+
+// publish writes the function services of the cache to the registry
+// whenever they change, until the context is done. Only the leader
+// publishes.
+func (sr *serviceRegistry) publish(ctx context.Context, fsCache *fscache.FunctionServiceCache) {
+	published := make(map[string]map[string]string)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(registryPublishPeriod):
+		}
+
+		funcSvcs, err := fsCache.ListOld(0)
+		if err != nil {
+			log.Printf("Error listing function services to publish: %v", err)
+			continue
+		}
+
+		shards := make(map[string]map[string]string)
+		for i := 0; i < registryShards; i++ {
+			shards[fmt.Sprintf("%v-%v", registryConfigMapPrefix, i)] = make(map[string]string)
+		}
+		for _, fsvc := range funcSvcs {
+			entry := registryEntry{
+				Address:  fsvc.Address,
+				Executor: fsvc.Executor,
+			}
+			for _, o := range fsvc.KubernetesObjects {
+				entry.Objects = append(entry.Objects, registryObject{
+					Kind:      o.Kind,
+					Namespace: o.Namespace,
+					Name:      o.Name,
+				})
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				log.Printf("Error serializing function service %v: %v", fsvc.Name, err)
+				continue
+			}
+			shards[registryShardName(fsvc.Function)][crd.CacheKey(fsvc.Function)] = string(data)
+		}
+
+		for name, data := range shards {
+			if old, ok := published[name]; ok && reflect.DeepEqual(data, old) {
+				continue
+			}
+			err = sr.write(name, data)
+			if err != nil {
+				log.Printf("Error publishing function services to %v: %v", name, err)
+				continue
+			}
+			published[name] = data
+		}
+	}
+}
+
+func (sr *serviceRegistry) write(name string, data map[string]string) error {
+	cms := sr.kubernetesClient.CoreV1().ConfigMaps(sr.namespace)
+
+	cm, err := cms.Get(name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = cms.Create(&apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{registryLabel: "true"},
+			},
+			Data: data,
+		})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	cm.Data = data
+	_, err = cms.Update(cm)
+	return err
+}
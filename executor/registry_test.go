@@ -0,0 +1,118 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/crd/fake"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// waitFor polls the condition until it holds or the timeout expires.
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(50 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
+
+func TestServiceRegistry(t *testing.T) {
+	server := fake.NewAPIServer()
+	defer server.Close()
+	_, kubernetesClient := server.Clients()
+
+	fn := &metav1.ObjectMeta{Name: "hello", Namespace: "default", UID: "fn-uid", ResourceVersion: "1"}
+	objects := []apiv1.ObjectReference{{Kind: "pod", Namespace: "fission-function", Name: "hello-pod"}}
+	fsCache := fscache.MakeFunctionServiceCache()
+	_, err := fsCache.Add(fscache.FuncSvc{
+		Name:              "hello-pod",
+		Function:          fn,
+		Address:           "10.0.0.1:8888",
+		KubernetesObjects: objects,
+		Executor:          fission.ExecutorTypePoolmgr,
+		Ctime:             time.Now(),
+		Atime:             time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("error adding function service: %v", err)
+	}
+
+	// The leader publishes the function service to the shard of the
+	// function, and every other shard once, empty
+	sr := makeServiceRegistry(kubernetesClient, "fission")
+	ctx, cancel := context.WithCancel(context.Background())
+	go sr.publish(ctx, fsCache)
+	shardPath := "/api/v1/namespaces/fission/configmaps/" + registryShardName(fn)
+	var shard apiv1.ConfigMap
+	if !waitFor(5*time.Second, func() bool { return server.Get(shardPath, &shard) && len(shard.Data) > 0 }) {
+		t.Fatalf("expected function service to be published to %v, got requests %v", shardPath, server.Requests())
+	}
+	if shard.Labels[registryLabel] != "true" {
+		t.Fatalf("expected registry label on shard, got labels %v", shard.Labels)
+	}
+	for i := 0; i < registryShards; i++ {
+		path := fmt.Sprintf("/api/v1/namespaces/fission/configmaps/%v-%v", registryConfigMapPrefix, i)
+		if !waitFor(time.Second, func() bool { return server.Get(path, &apiv1.ConfigMap{}) }) {
+			t.Fatalf("expected shard %v to be created, got requests %v", path, server.Requests())
+		}
+	}
+
+	// Shards that didn't change aren't written again
+	server.ResetRequests()
+	time.Sleep(registryPublishPeriod + registryPublishPeriod/2)
+	for _, request := range server.Requests() {
+		if !strings.HasPrefix(request, "GET") {
+			t.Fatalf("expected no writes of unchanged shards, got %v", request)
+		}
+	}
+	cancel()
+
+	// The other replicas find the function service of the function version
+	// in the registry
+	sr.watch(context.Background())
+	var fsvc *fscache.FuncSvc
+	if !waitFor(5*time.Second, func() bool {
+		var ok bool
+		fsvc, ok = sr.get(fn)
+		return ok
+	}) {
+		t.Fatalf("expected function service in the registry")
+	}
+	if fsvc.Address != "10.0.0.1:8888" || fsvc.Executor != fission.ExecutorTypePoolmgr ||
+		!reflect.DeepEqual(fsvc.KubernetesObjects, objects) || fsvc.Function != fn {
+		t.Fatalf("unexpected function service %+v", fsvc)
+	}
+
+	newFn := fn.DeepCopy()
+	newFn.ResourceVersion = "2"
+	if _, ok := sr.get(newFn); ok {
+		t.Fatalf("expected no function service for another version of function %v", crd.CacheKey(newFn))
+	}
+}
@@ -71,8 +71,8 @@ func runStorageSvc(port int, readWriteConfig map[string]string, readOnlyConfigs
 	}
 }
 
-func runBuilderMgr(storageSvcUrl string, envBuilderNamespace string) {
-	err := buildermgr.Start(storageSvcUrl, envBuilderNamespace)
+func runBuilderMgr(port int, storageSvcUrl string, envBuilderNamespace string) {
+	err := buildermgr.Start(port, storageSvcUrl, envBuilderNamespace)
 	if err != nil {
 		log.Fatalf("Error starting buildermgr: %v", err)
 	}
@@ -176,8 +176,8 @@ Usage:
   fission-bundle --executorPort=<port> [--namespace=<namespace>] [--fission-namespace=<namespace>] [--jaegerCollectorEndpoint=<url>]
   fission-bundle --kubewatcher [--routerUrl=<url>] [--jaegerCollectorEndpoint=<url>]
   fission-bundle --storageServicePort=<port> --filePath=<filePath> [--jaegerCollectorEndpoint=<url>]
-  fission-bundle --storageServicePort=<port> [--read-write=<provider>] [--local=<path> --local-subdir=<subdir>] [--gcs-bucket=<bucket> --gcs-json-file=<path> --gcs-project=<project>] [--jaegerCollectorEndpoint=<url>]
-  fission-bundle --builderMgr [--storageSvcUrl=<url>] [--envbuilder-namespace=<namespace>] [--jaegerCollectorEndpoint=<url>]
+  fission-bundle --storageServicePort=<port> [--read-write=<provider>] [--local=<path> --local-subdir=<subdir>] [--gcs-bucket=<bucket> --gcs-json-file=<path> --gcs-project=<project>] [--s3-bucket=<bucket> [--s3-endpoint=<url>] [--s3-region=<region>]] [--encryption-key-file=<path>] [--migrate] [--jaegerCollectorEndpoint=<url>]
+  fission-bundle --builderMgr [--builderMgrPort=<port>] [--storageSvcUrl=<url>] [--envbuilder-namespace=<namespace>] [--jaegerCollectorEndpoint=<url>]
   fission-bundle --timer [--routerUrl=<url>] [--jaegerCollectorEndpoint=<url>]
   fission-bundle --mqt   [--routerUrl=<url>] [--jaegerCollectorEndpoint=<url>]
   fission-bundle --version
@@ -187,11 +187,18 @@ Options:
   --routerPort=<port>             Port that the router should listen on.
   --executorPort=<port>           Port that the executor should listen on.
   --storageServicePort=<port>     Port that the storage service should listen on.
+  --builderMgrPort=<port>         Port that the builder manager should listen on. Defaults to 8888.
   --executorUrl=<url>             Executor URL. Not required if --executorPort is specified.
   --routerUrl=<url>               Router URL.
   --etcdUrl=<etcdUrl>             Etcd URL.
   --storageSvcUrl=<url>           StorageService URL.
   --filePath=<filePath>           Directory to store functions in.
+  --read-write=<provider>         Storage backend archives are written to: local, gcs or s3. The others are read-only.
+  --s3-bucket=<bucket>            S3 bucket; credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+  --s3-endpoint=<url>             URL of S3 or an S3-compatible store, e.g. MinIO. Defaults to https://s3.amazonaws.com.
+  --s3-region=<region>            S3 region. Defaults to us-east-1.
+  --encryption-key-file=<path>    File with base64 master keys, one per line, to encrypt archives with.
+  --migrate                       Copy the archives of the read-only backends into the read-write backend.
   --namespace=<namespace>         Kubernetes namespace in which to run function containers. Defaults to 'fission-function'.
   --kubewatcher                   Start Kubernetes events watcher.
   --timer                         Start Timer.
@@ -246,13 +253,18 @@ Options:
 	}
 
 	if arguments["--builderMgr"] == true {
-		runBuilderMgr(storageSvcUrl, envBuilderNs)
+		port := 8888
+		if arguments["--builderMgrPort"] != nil {
+			port = getPort(arguments["--builderMgrPort"])
+		}
+		runBuilderMgr(port, storageSvcUrl, envBuilderNs)
 	}
 
 	if arguments["--storageServicePort"] != nil {
 		port := getPort(arguments["--storageServicePort"])
 		var gcsConfig map[string]string
 		var localConfig map[string]string
+		var s3Config map[string]string
 
 		filePath := getStringArgWithDefault(arguments["--local"], "")
 		if filePath == "" {
@@ -290,24 +302,55 @@ Options:
 			}
 		}
 
+		s3Bucket := getStringArgWithDefault(arguments["--s3-bucket"], "")
+		if s3Bucket != "" {
+			s3Config = map[string]string{
+				storagesvc.ConfigKind:      storagesvc.ConfigKindS3,
+				storagesvc.ConfigContainer: s3Bucket,
+
+				storagesvc.ConfigS3Endpoint: getStringArgWithDefault(arguments["--s3-endpoint"], ""),
+				storagesvc.ConfigS3Region:   getStringArgWithDefault(arguments["--s3-region"], ""),
+			}
+		}
+
 		readWriteProvider := getStringArgWithDefault(arguments["--read-write"], "")
 		if readWriteProvider == "" {
 			readWriteProvider = "local"
 		}
 
-		var readWriteConfig map[string]string
-		var readOnlyConfigs []map[string]string
-
-		switch readWriteProvider {
-		case "local":
-			readWriteConfig = localConfig
-			readOnlyConfigs = append(readOnlyConfigs, gcsConfig)
-		case "gcs":
-			readWriteConfig = gcsConfig
-			readOnlyConfigs = append(readOnlyConfigs, localConfig)
-		default:
+		providerConfigs := map[string]map[string]string{
+			"local": localConfig,
+			"gcs":   gcsConfig,
+			"s3":    s3Config,
+		}
+		readWriteConfig, ok := providerConfigs[readWriteProvider]
+		if !ok {
 			log.Fatalf("Invalid value for --read-write: %s", readWriteProvider)
 		}
+		if readWriteConfig == nil {
+			log.Fatalf("Storage backend %s given by --read-write is not configured", readWriteProvider)
+		}
+
+		// Archives of the other configured backends can still be read,
+		// and copied over with --migrate
+		encryptionKeyFile := getStringArgWithDefault(arguments["--encryption-key-file"], "")
+		var readOnlyConfigs []map[string]string
+		for _, provider := range []string{"local", "gcs", "s3"} {
+			config := providerConfigs[provider]
+			if config == nil {
+				continue
+			}
+			if encryptionKeyFile != "" {
+				config[storagesvc.ConfigEncryptionKeyFile] = encryptionKeyFile
+			}
+			if provider == readWriteProvider {
+				continue
+			}
+			if arguments["--migrate"] == true {
+				config[storagesvc.ConfigMigrate] = "true"
+			}
+			readOnlyConfigs = append(readOnlyConfigs, config)
+		}
 
 		runStorageSvc(port, readWriteConfig, readOnlyConfigs)
 	}
@@ -0,0 +1,229 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission/controller/client"
+	"github.com/fission/fission/fission/log"
+	"github.com/fission/fission/fission/util"
+	storageSvcClient "github.com/fission/fission/storagesvc/client"
+)
+
+func getStorageSvcClient(c *cli.Context) *storageSvcClient.Client {
+	client := util.GetApiClient(c.GlobalString("server"))
+	return storageSvcClient.MakeClient(strings.TrimSuffix(client.Url, "/") + "/proxy/storage")
+}
+
+func formatSize(size int64) string {
+	return resource.NewQuantity(size, resource.BinarySI).String()
+}
+
+// archiveReferences returns the names of the packages whose archives,
+// build logs or revisions are stored in the archive.
+func archiveReferences(client *client.Client, id string) ([]string, error) {
+	pkgs, err := archivePackages(client, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	for _, pkg := range pkgs {
+		refs = append(refs, fmt.Sprintf("%v.%v", pkg.Name, pkg.Namespace))
+	}
+	return refs, nil
+}
+
+// archivePackages returns the packages whose archives, build logs or
+// revisions are stored in the archive.
+func archivePackages(client *client.Client, id string) ([]metav1.ObjectMeta, error) {
+	pkgs, err := client.PackageList(metav1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []metav1.ObjectMeta
+	for _, pkg := range pkgs {
+		urls := []string{pkg.Spec.Source.URL, pkg.Spec.Deployment.URL, pkg.Status.BuildLogUrl}
+		for _, rev := range pkg.Status.Revisions {
+			urls = append(urls, rev.DeploymentUrl, rev.BuildLogUrl)
+		}
+		for _, u := range urls {
+			parsed, err := url.Parse(u)
+			if err == nil && len(u) > 0 && parsed.Query().Get("id") == id {
+				refs = append(refs, pkg.Metadata)
+				break
+			}
+		}
+	}
+	return refs, nil
+}
+
+func archiveList(c *cli.Context) error {
+	ssClient := getStorageSvcClient(c)
+	namespace := c.String("archiveNamespace")
+	pkgName := c.String("package")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "ID", "SIZE", "CONTENT_TYPE", "CREATED", "NAMESPACES")
+
+	cont := ""
+	for {
+		list, err := ssClient.List(context.Background(), namespace, pkgName, 0, cont)
+		util.CheckErr(err, "list archives")
+
+		for _, m := range list.Items {
+			var namespaces []string
+			for _, o := range m.Owners {
+				namespaces = append(namespaces, o.Namespace)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", m.ID, formatSize(m.Size), m.ContentType,
+				m.Created.Format(time.RFC3339), strings.Join(namespaces, ","))
+		}
+
+		cont = list.Continue
+		if len(cont) == 0 {
+			break
+		}
+	}
+	w.Flush()
+
+	return nil
+}
+
+func archiveGet(c *cli.Context) error {
+	id := c.String("id")
+	if len(id) == 0 {
+		log.Fatal("Need ID of archive, use --id")
+	}
+	ssClient := getStorageSvcClient(c)
+
+	client := util.GetApiClient(c.GlobalString("server"))
+
+	// Archives are downloaded for a package using them, which the
+	// controller checks
+	output := c.String("output")
+	if len(output) > 0 {
+		pkgs, err := archivePackages(client, id)
+		util.CheckErr(err, "find packages using archive")
+		if len(pkgs) == 0 {
+			log.Fatal(fmt.Sprintf("Archive %v isn't used by any package, it can't be downloaded", id))
+		}
+		err = ssClient.DownloadPackageArchive(context.Background(), id, pkgs[0].Namespace, pkgs[0].Name, output)
+		util.CheckErr(err, fmt.Sprintf("download archive %v", id))
+		fmt.Printf("Archive %v saved to %v\n", id, output)
+		return nil
+	}
+
+	m, err := ssClient.GetMetadata(context.Background(), id)
+	util.CheckErr(err, fmt.Sprintf("get archive %v", id))
+
+	refs, err := archiveReferences(client, id)
+	util.CheckErr(err, "find packages using archive")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\n", "ID:", m.ID)
+	fmt.Fprintf(w, "%v\t%v (%v bytes)\n", "Size:", formatSize(m.Size), m.Size)
+	fmt.Fprintf(w, "%v\t%v\n", "Content Type:", m.ContentType)
+	fmt.Fprintf(w, "%v\t%v\n", "Created:", m.Created.Format(time.RFC3339))
+	fmt.Fprintf(w, "%v\t%v\n", "Used By:", strings.Join(refs, ", "))
+	w.Flush()
+
+	if len(m.Owners) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", "NAMESPACE", "PACKAGE", "UPLOADER", "UPLOADED")
+		for _, o := range m.Owners {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", o.Namespace, o.Package, o.Uploader, o.Uploaded.Format(time.RFC3339))
+		}
+		w.Flush()
+	}
+
+	return nil
+}
+
+func archiveDelete(c *cli.Context) error {
+	id := c.String("id")
+	if len(id) == 0 {
+		log.Fatal("Need ID of archive, use --id")
+	}
+	ssClient := getStorageSvcClient(c)
+
+	// Packages can't be built or deployed without their archives
+	client := util.GetApiClient(c.GlobalString("server"))
+	pkgs, err := archivePackages(client, id)
+	util.CheckErr(err, "find packages using archive")
+	if len(pkgs) > 0 && !c.Bool("force") {
+		var refs []string
+		for _, pkg := range pkgs {
+			refs = append(refs, fmt.Sprintf("%v.%v", pkg.Name, pkg.Namespace))
+		}
+		log.Fatal(fmt.Sprintf("Archive %v is used by packages %v; use --force to delete it anyway", id, strings.Join(refs, ", ")))
+	}
+
+	// Archives are deleted for a package using them, or that they were
+	// uploaded for, which the controller checks
+	if len(pkgs) == 0 {
+		m, err := ssClient.GetMetadata(context.Background(), id)
+		util.CheckErr(err, fmt.Sprintf("get archive %v", id))
+		for _, o := range m.Owners {
+			if len(o.Package) > 0 {
+				pkgs = append(pkgs, metav1.ObjectMeta{Name: o.Package, Namespace: o.Namespace})
+			}
+		}
+	}
+	if len(pkgs) > 0 {
+		err = ssClient.DeletePackageArchive(context.Background(), id, pkgs[0].Namespace, pkgs[0].Name)
+	} else {
+		err = ssClient.Delete(context.Background(), id)
+	}
+	util.CheckErr(err, fmt.Sprintf("delete archive %v", id))
+
+	fmt.Printf("Archive '%v' deleted\n", id)
+	return nil
+}
+
+func archiveUsage(c *cli.Context) error {
+	ssClient := getStorageSvcClient(c)
+
+	usage, err := ssClient.Usage(context.Background())
+	util.CheckErr(err, "get storage usage")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\n", "NAMESPACE", "USED", "QUOTA")
+	for _, u := range usage {
+		quota := "none"
+		if u.Quota > 0 {
+			quota = formatSize(u.Quota)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", u.Namespace, formatSize(u.Used), quota)
+	}
+	w.Flush()
+
+	return nil
+}
@@ -115,14 +115,17 @@ func envCreate(c *cli.Context) error {
 				Image: envImg,
 			},
 			Builder: fission.Builder{
-				Image:   envBuilderImg,
-				Command: envBuildCmd,
+				Image:               envBuilderImg,
+				Command:             envBuildCmd,
+				BuildTimeout:        c.Int("buildtimeout"),
+				MaxConcurrentBuilds: c.Int("maxbuilds"),
 			},
 			Poolsize:                     poolsize,
 			Resources:                    resourceReq,
 			AllowAccessToExternalNetwork: envExternalNetwork,
 			TerminationGracePeriod:       envGracePeriod,
 			KeepArchive:                  keepArchive,
+			AllowedFunctionsPerContainer: fission.AllowedFunctionsPerContainer(c.String("functionspercontainer")),
 		},
 	}
 
@@ -179,7 +182,8 @@ func envUpdate(c *cli.Context) error {
 	envBuildCmd := c.String("buildcmd")
 	envExternalNetwork := c.Bool("externalnetwork")
 
-	if len(envImg) == 0 && len(envBuilderImg) == 0 && len(envBuildCmd) == 0 {
+	if len(envImg) == 0 && len(envBuilderImg) == 0 && len(envBuildCmd) == 0 &&
+		!c.IsSet("buildtimeout") && !c.IsSet("maxbuilds") {
 		log.Fatal("Need --image to specify env image, or use --builder to specify env builder, or use --buildcmd to specify new build command.")
 	}
 
@@ -203,6 +207,12 @@ func envUpdate(c *cli.Context) error {
 	if len(envBuildCmd) > 0 {
 		env.Spec.Builder.Command = envBuildCmd
 	}
+	if c.IsSet("buildtimeout") {
+		env.Spec.Builder.BuildTimeout = c.Int("buildtimeout")
+	}
+	if c.IsSet("maxbuilds") {
+		env.Spec.Builder.MaxConcurrentBuilds = c.Int("maxbuilds")
+	}
 
 	if c.IsSet("poolsize") {
 		env.Spec.Poolsize = c.Int("poolsize")
@@ -216,6 +226,10 @@ func envUpdate(c *cli.Context) error {
 		env.Spec.KeepArchive = c.Bool("keeparchive")
 	}
 
+	if c.IsSet("functionspercontainer") {
+		env.Spec.AllowedFunctionsPerContainer = fission.AllowedFunctionsPerContainer(c.String("functionspercontainer"))
+	}
+
 	env.Spec.AllowAccessToExternalNetwork = envExternalNetwork
 
 	if c.IsSet("mincpu") || c.IsSet("maxcpu") || c.IsSet("minmemory") || c.IsSet("maxmemory") || c.IsSet("minscale") || c.IsSet("maxscale") {
@@ -85,8 +85,10 @@ func getInvokeStrategy(c *cli.Context, existingInvokeStrategy *fission.InvokeStr
 		newFnExecutor = fission.ExecutorTypePoolmgr
 	case fission.ExecutorTypeNewdeploy:
 		newFnExecutor = fission.ExecutorTypeNewdeploy
+	case fission.ExecutorTypeContainer:
+		newFnExecutor = fission.ExecutorTypeContainer
 	default:
-		return nil, errors.New("Executor type must be one of 'poolmgr' or 'newdeploy', defaults to 'poolmgr'")
+		return nil, errors.New("Executor type must be one of 'poolmgr', 'newdeploy' or 'container', defaults to 'poolmgr'")
 	}
 
 	if existingInvokeStrategy != nil {
@@ -102,7 +104,7 @@ func getInvokeStrategy(c *cli.Context, existingInvokeStrategy *fission.InvokeStr
 
 	if fnExecutor == fission.ExecutorTypePoolmgr {
 		if c.IsSet("targetcpu") || c.IsSet("minscale") || c.IsSet("maxscale") {
-			log.Fatal("To set target CPU or min/max scale for function, please specify \"--executortype newdeploy\"")
+			log.Fatal("To set target CPU or min/max scale for function, please specify \"--executortype newdeploy\" or \"--executortype container\"")
 		}
 
 		if c.IsSet("mincpu") || c.IsSet("maxcpu") || c.IsSet("minmemory") || c.IsSet("maxmemory") {
@@ -120,7 +122,8 @@ func getInvokeStrategy(c *cli.Context, existingInvokeStrategy *fission.InvokeStr
 		minScale := DEFAULT_MIN_SCALE
 		maxScale := minScale
 
-		if existingInvokeStrategy != nil && existingInvokeStrategy.ExecutionStrategy.ExecutorType == fission.ExecutorTypeNewdeploy {
+		if existingInvokeStrategy != nil && (existingInvokeStrategy.ExecutionStrategy.ExecutorType == fission.ExecutorTypeNewdeploy ||
+			existingInvokeStrategy.ExecutionStrategy.ExecutorType == fission.ExecutorTypeContainer) {
 			minScale = existingInvokeStrategy.ExecutionStrategy.MinScale
 			maxScale = existingInvokeStrategy.ExecutionStrategy.MaxScale
 			targetCPU = existingInvokeStrategy.ExecutionStrategy.TargetCPUPercent
@@ -161,6 +164,66 @@ func getInvokeStrategy(c *cli.Context, existingInvokeStrategy *fission.InvokeStr
 	return strategy, nil
 }
 
+// getEnvVars parses the --env-var flags, NAME=VALUE for literal values and
+// NAME=secret:<name>:<key> or NAME=configmap:<name>:<key> for values from
+// a key of a Secret or ConfigMap of the function's namespace.
+func getEnvVars(c *cli.Context) ([]apiv1.EnvVar, error) {
+	var envVars []apiv1.EnvVar
+	for _, flag := range c.StringSlice("env-var") {
+		kv := strings.SplitN(flag, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, fmt.Errorf("Environment variable '%v' must be NAME=VALUE", flag)
+		}
+		envVar := apiv1.EnvVar{Name: kv[0], Value: kv[1]}
+
+		parts := strings.Split(kv[1], ":")
+		if len(parts) == 3 && (parts[0] == "secret" || parts[0] == "configmap") {
+			envVar.Value = ""
+			if parts[0] == "secret" {
+				envVar.ValueFrom = &apiv1.EnvVarSource{
+					SecretKeyRef: &apiv1.SecretKeySelector{
+						LocalObjectReference: apiv1.LocalObjectReference{Name: parts[1]},
+						Key:                  parts[2],
+					},
+				}
+			} else {
+				envVar.ValueFrom = &apiv1.EnvVarSource{
+					ConfigMapKeyRef: &apiv1.ConfigMapKeySelector{
+						LocalObjectReference: apiv1.LocalObjectReference{Name: parts[1]},
+						Key:                  parts[2],
+					},
+				}
+			}
+		}
+		envVars = append(envVars, envVar)
+	}
+	return envVars, nil
+}
+
+// getVolumes parses the --volume flags, <name>:<mount path> for an
+// emptyDir volume and <name>:<mount path>:<claim> for a volume of a
+// PersistentVolumeClaim of the function's namespace.
+func getVolumes(c *cli.Context) ([]fission.FunctionVolume, error) {
+	var volumes []fission.FunctionVolume
+	for _, flag := range c.StringSlice("volume") {
+		parts := strings.Split(flag, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("Volume '%v' must be <name>:<mount path>[:<claim>]", flag)
+		}
+		volume := fission.FunctionVolume{
+			Volume:    apiv1.Volume{Name: parts[0]},
+			MountPath: parts[1],
+		}
+		if len(parts) == 3 {
+			volume.PersistentVolumeClaim = &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: parts[2]}
+		} else {
+			volume.EmptyDir = &apiv1.EmptyDirVolumeSource{}
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
 func getTargetCPU(c *cli.Context) int {
 	var targetCPU int
 	if c.IsSet("targetcpu") {
@@ -216,6 +279,18 @@ func fnCreate(c *cli.Context) error {
 	}
 	resourceReq := getResourceReq(c, apiv1.ResourceRequirements{})
 
+	envVars, err := getEnvVars(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	volumes, err := getVolumes(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(volumes) > 0 && invokeStrategy.ExecutionStrategy.ExecutorType != fission.ExecutorTypeNewdeploy {
+		log.Fatal("To mount volumes into a function, please specify \"--executortype newdeploy\"")
+	}
+
 	var pkgMetadata *metav1.ObjectMeta
 	var envName string
 	if len(pkgName) > 0 {
@@ -271,7 +346,7 @@ func fnCreate(c *cli.Context) error {
 		buildcmd := c.String("buildcmd")
 
 		// create new package in the same namespace as the function.
-		pkgMetadata = createPackage(client, fnNamespace, envName, envNamespace, srcArchiveFiles, deployArchiveFiles, buildcmd, specDir, specFile, noZip)
+		pkgMetadata = createPackage(client, fnNamespace, envName, envNamespace, srcArchiveFiles, deployArchiveFiles, buildcmd, nil, specDir, specFile, noZip)
 	}
 
 	var secrets []fission.SecretReference
@@ -333,6 +408,8 @@ func fnCreate(c *cli.Context) error {
 			ConfigMaps:     cfgmaps,
 			Resources:      resourceReq,
 			InvokeStrategy: *invokeStrategy,
+			Env:            envVars,
+			Volumes:        volumes,
 		},
 	}
 
@@ -431,9 +508,50 @@ func fnGetMeta(c *cli.Context) error {
 	fmt.Fprintf(w, "%v\t%v\t%v\n",
 		f.Metadata.Name, f.Metadata.UID, f.Spec.Environment.Name)
 	w.Flush()
+
+	if len(f.Status.Conditions) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "CONDITION", "STATUS", "REASON", "LASTTRANSITION", "MESSAGE")
+		for _, cond := range f.Status.Conditions {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
+				cond.Type, cond.Status, cond.Reason, cond.LastTransitionTime.Format(time.RFC3339),
+				strings.Replace(cond.Message, "\n", " ", -1))
+		}
+		w.Flush()
+	}
+	if f.Status.LastSpecializationDuration.Duration > 0 {
+		fmt.Printf("\nLast specialization took %v\n", f.Status.LastSpecializationDuration.Duration)
+	}
+	if len(f.Status.LastError) > 0 {
+		fmt.Printf("\nLast error: %v\n", f.Status.LastError)
+	}
 	return err
 }
 
+// fnStatusSummary returns a short description of the function status for
+// listing functions.
+func fnStatusSummary(status *fission.FunctionStatus) string {
+	if cond := status.GetCondition(fission.FunctionPackageReady); cond != nil && cond.Status != apiv1.ConditionTrue {
+		return cond.Reason
+	}
+	if cond := status.GetCondition(fission.FunctionSpecialized); cond != nil {
+		if cond.Status == apiv1.ConditionTrue {
+			return "Ready"
+		}
+		if cond.Reason == "SpecializationFailed" {
+			return cond.Reason
+		}
+	}
+	if status.IsConditionTrue(fission.FunctionScaledToZero) {
+		return "ScaledToZero"
+	}
+	if status.IsConditionTrue(fission.FunctionPackageReady) {
+		return "PackageReady"
+	}
+	return "Unknown"
+}
+
 func fnUpdate(c *cli.Context) error {
 	client := util.GetApiClient(c.GlobalString("server"))
 
@@ -557,6 +675,20 @@ func fnUpdate(c *cli.Context) error {
 	function.Spec.InvokeStrategy = *strategy
 	function.Spec.Resources = getResourceReq(c, function.Spec.Resources)
 
+	// the flags replace the environment variables and volumes of the function
+	if c.IsSet("env-var") {
+		function.Spec.Env, err = getEnvVars(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.IsSet("volume") {
+		function.Spec.Volumes, err = getVolumes(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	pkg, err := client.PackageGet(&metav1.ObjectMeta{
 		Namespace: fnNamespace,
 		Name:      pkgName,
@@ -642,11 +774,11 @@ func fnList(c *cli.Context) error {
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 
-	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "NAME", "UID", "ENV", "EXECUTORTYPE", "MINSCALE", "MAXSCALE", "MINCPU", "MAXCPU", "MINMEMORY", "MAXMEMORY", "TARGETCPU")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "NAME", "UID", "ENV", "EXECUTORTYPE", "MINSCALE", "MAXSCALE", "MINCPU", "MAXCPU", "MINMEMORY", "MAXMEMORY", "TARGETCPU", "STATUS")
 	for _, f := range fns {
 		mincpu := f.Spec.Resources.Requests.Cpu
 		mincpu().Value()
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
 			f.Metadata.Name, f.Metadata.UID, f.Spec.Environment.Name,
 			f.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType,
 			f.Spec.InvokeStrategy.ExecutionStrategy.MinScale,
@@ -655,13 +787,82 @@ func fnList(c *cli.Context) error {
 			f.Spec.Resources.Limits.Cpu().String(),
 			f.Spec.Resources.Requests.Memory().String(),
 			f.Spec.Resources.Limits.Memory().String(),
-			f.Spec.InvokeStrategy.ExecutionStrategy.TargetCPUPercent)
+			f.Spec.InvokeStrategy.ExecutionStrategy.TargetCPUPercent,
+			fnStatusSummary(&f.Status))
 	}
 	w.Flush()
 
 	return err
 }
 
+func fnPods(c *cli.Context) error {
+	client := util.GetApiClient(c.GlobalString("server"))
+
+	m := &metav1.ObjectMeta{
+		Name:      c.String("name"),
+		Namespace: c.String("fnNamespace"),
+	}
+
+	fsvcs, err := client.FunctionServiceList(m)
+	util.CheckErr(err, "list function services")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "FUNCTION", "NAME", "EXECUTORTYPE", "ADDRESS", "AGE", "IDLE", "OBJECTS")
+	for _, fsvc := range fsvcs {
+		objs := make([]string, 0, len(fsvc.KubernetesObjects))
+		for _, obj := range fsvc.KubernetesObjects {
+			objs = append(objs, fmt.Sprintf("%v/%v", strings.ToLower(obj.Kind), obj.Name))
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			fsvc.Function.Name, fsvc.Name, fsvc.Executor, fsvc.Address,
+			time.Since(fsvc.Ctime).Round(time.Second), time.Since(fsvc.Atime).Round(time.Second),
+			strings.Join(objs, ","))
+	}
+	w.Flush()
+
+	return nil
+}
+
+func fnEvict(c *cli.Context) error {
+	client := util.GetApiClient(c.GlobalString("server"))
+
+	fnName := c.String("name")
+	if len(fnName) == 0 {
+		log.Fatal("Need name of function, use --name")
+	}
+
+	m := &metav1.ObjectMeta{
+		Name:      fnName,
+		Namespace: c.String("fnNamespace"),
+	}
+
+	err := client.FunctionEvict(m)
+	util.CheckErr(err, fmt.Sprintf("evict function '%v'", fnName))
+
+	fmt.Printf("function '%v' evicted\n", fnName)
+	return nil
+}
+
+func fnWarm(c *cli.Context) error {
+	client := util.GetApiClient(c.GlobalString("server"))
+
+	fnName := c.String("name")
+	if len(fnName) == 0 {
+		log.Fatal("Need name of function, use --name")
+	}
+
+	m := &metav1.ObjectMeta{
+		Name:      fnName,
+		Namespace: c.String("fnNamespace"),
+	}
+
+	address, err := client.FunctionWarm(m)
+	util.CheckErr(err, fmt.Sprintf("warm function '%v'", fnName))
+
+	fmt.Printf("function '%v' is ready at %v\n", fnName, address)
+	return nil
+}
+
 func fnLogs(c *cli.Context) error {
 
 	client := util.GetApiClient(c.GlobalString("server"))
@@ -115,19 +115,24 @@ func newCliApp() *cli.App {
 	fnCfgMapFlag := cli.StringFlag{Name: "configmap", Usage: "function access to configmap, should be present in the same namespace as the function"}
 	fnLogCountFlag := cli.StringFlag{Name: "recordcount", Usage: "the n most recent log records"}
 	fnForceFlag := cli.BoolFlag{Name: "force", Usage: "Force update a package even if it is used by one or more functions"}
-	fnExecutorTypeFlag := cli.StringFlag{Name: "executortype", Value: fission.ExecutorTypePoolmgr, Usage: "Executor type for execution; one of 'poolmgr', 'newdeploy' defaults to 'poolmgr'"}
+	fnEnvVarFlag := cli.StringSliceFlag{Name: "env-var", Usage: "function environment variable NAME=VALUE, or NAME=secret:<name>:<key> / NAME=configmap:<name>:<key> to read the value from a secret or configmap key; can be repeated"}
+	fnVolumeFlag := cli.StringSliceFlag{Name: "volume", Usage: "mount an emptyDir volume <name>:<mount path>, or a persistent volume claim <name>:<mount path>:<claim>, into the function; needs --executortype newdeploy; can be repeated"}
+	fnExecutorTypeFlag := cli.StringFlag{Name: "executortype", Value: fission.ExecutorTypePoolmgr, Usage: "Executor type for execution; one of 'poolmgr', 'newdeploy', 'container' defaults to 'poolmgr'"}
 
 	fnSubcommands := []cli.Command{
-		{Name: "create", Usage: "Create new function (and optionally, an HTTP route to it)", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag, fnEnvNameFlag, envNamespaceFlag, specSaveFlag, fnCodeFlag, fnSrcArchiveFlag, fnDeployArchiveFlag, fnEntryPointFlag, fnBuildCmdFlag, fnPkgNameFlag, htUrlFlag, htMethodFlag, minCpu, maxCpu, minMem, maxMem, minScale, maxScale, fnExecutorTypeFlag, targetcpu, fnCfgMapFlag, fnSecretFlag}, Action: fnCreate},
+		{Name: "create", Usage: "Create new function (and optionally, an HTTP route to it)", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag, fnEnvNameFlag, envNamespaceFlag, specSaveFlag, fnCodeFlag, fnSrcArchiveFlag, fnDeployArchiveFlag, fnEntryPointFlag, fnBuildCmdFlag, fnPkgNameFlag, htUrlFlag, htMethodFlag, minCpu, maxCpu, minMem, maxMem, minScale, maxScale, fnExecutorTypeFlag, targetcpu, fnCfgMapFlag, fnSecretFlag, fnEnvVarFlag, fnVolumeFlag}, Action: fnCreate},
 		{Name: "get", Usage: "Get function source code", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag}, Action: fnGet},
 		{Name: "getmeta", Usage: "Get function metadata", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag}, Action: fnGetMeta},
-		{Name: "update", Usage: "Update function source code", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag, fnEnvNameFlag, envNamespaceFlag, fnCodeFlag, fnSrcArchiveFlag, fnDeployArchiveFlag, fnEntryPointFlag, fnPkgNameFlag, pkgNamespaceFlag, fnBuildCmdFlag, fnForceFlag, minCpu, maxCpu, minMem, maxMem, minScale, maxScale, fnExecutorTypeFlag, targetcpu}, Action: fnUpdate},
+		{Name: "update", Usage: "Update function source code", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag, fnEnvNameFlag, envNamespaceFlag, fnCodeFlag, fnSrcArchiveFlag, fnDeployArchiveFlag, fnEntryPointFlag, fnPkgNameFlag, pkgNamespaceFlag, fnBuildCmdFlag, fnForceFlag, minCpu, maxCpu, minMem, maxMem, minScale, maxScale, fnExecutorTypeFlag, targetcpu, fnEnvVarFlag, fnVolumeFlag}, Action: fnUpdate},
 		{Name: "delete", Usage: "Delete function", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag}, Action: fnDelete},
 		// TODO : for fnList, i feel like it's nice to allow --fns all, to list functions across all namespaces for cluster admins, although, this is against ns isolation.
 		// so, in the future, if we end up using kubeconfig in fission cli and enforcing rolebindings to be created for users by admins etc, we can add this option at the time.
 		{Name: "list", Usage: "List all functions in a namespace if specified, else, list functions across all namespaces", Flags: []cli.Flag{fnNamespaceFlag}, Action: fnList},
 		{Name: "logs", Usage: "Display function logs", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag, fnPodFlag, fnFollowFlag, fnDetailFlag, fnLogDBTypeFlag, fnLogCountFlag}, Action: fnLogs},
 		{Name: "test", Usage: "Test a function", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag, fnEnvNameFlag, fnCodeFlag, fnSrcArchiveFlag, htMethodFlag, fnBodyFlag, fnHeaderFlag, fnQueryFlag}, Action: fnTest},
+		{Name: "pods", Usage: "List the pods and services serving a function, or all functions in a namespace if no name is given", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag}, Action: fnPods},
+		{Name: "evict", Usage: "Remove the pods and services serving a function; the next request specializes new ones", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag}, Action: fnEvict},
+		{Name: "warm", Usage: "Specialize a function ahead of its first request", Flags: []cli.Flag{fnNameFlag, fnNamespaceFlag}, Action: fnWarm},
 	}
 
 	// httptriggers
@@ -214,13 +219,16 @@ func newCliApp() *cli.App {
 	envBuilderImageFlag := cli.StringFlag{Name: "builder", Usage: "Environment builder image URL (optional)"}
 	envBuildCmdFlag := cli.StringFlag{Name: "buildcmd", Usage: "Build command for environment builder to build source package (optional)"}
 	envKeepArchiveFlag := cli.BoolFlag{Name: "keeparchive", Usage: "Keep the archive instead of extracting it into a directory (optional, defaults to false)"}
+	envFunctionsPerContainerFlag := cli.StringFlag{Name: "functionspercontainer", Usage: "Number of functions a pod of the environment serves, 'single' or 'infinite' (optional, defaults to single)"}
 	envExternalNetworkFlag := cli.BoolFlag{Name: "externalnetwork", Usage: "Allow environment access external network when istio feature enabled (optional, defaults to false)"}
 	envTerminationGracePeriodFlag := cli.Int64Flag{Name: "graceperiod, period", Value: 360, Usage: "The grace time (in seconds) for pod to perform connection draining before termination (optional)"}
 	envVersionFlag := cli.IntFlag{Name: "version", Value: 1, Usage: "Environment API version (1 means v1 interface)"}
+	envBuildTimeoutFlag := cli.IntFlag{Name: "buildtimeout", Usage: "Timeout (in seconds) of a package build, defaults to 1800 (optional)"}
+	envMaxBuildsFlag := cli.IntFlag{Name: "maxbuilds", Usage: "Maximum number of packages built at the same time, defaults to 2 (optional)"}
 	envSubcommands := []cli.Command{
-		{Name: "create", Aliases: []string{"add"}, Usage: "Add an environment", Flags: []cli.Flag{envNameFlag, envNamespaceFlag, envPoolsizeFlag, envImageFlag, envBuilderImageFlag, envBuildCmdFlag, envKeepArchiveFlag, minCpu, maxCpu, minMem, maxMem, envVersionFlag, envExternalNetworkFlag, envTerminationGracePeriodFlag, specSaveFlag}, Action: envCreate},
+		{Name: "create", Aliases: []string{"add"}, Usage: "Add an environment", Flags: []cli.Flag{envNameFlag, envNamespaceFlag, envPoolsizeFlag, envImageFlag, envBuilderImageFlag, envBuildCmdFlag, envKeepArchiveFlag, envFunctionsPerContainerFlag, minCpu, maxCpu, minMem, maxMem, envVersionFlag, envExternalNetworkFlag, envTerminationGracePeriodFlag, specSaveFlag, envBuildTimeoutFlag, envMaxBuildsFlag}, Action: envCreate},
 		{Name: "get", Usage: "Get environment details", Flags: []cli.Flag{envNameFlag, envNamespaceFlag}, Action: envGet},
-		{Name: "update", Usage: "Update environment", Flags: []cli.Flag{envNameFlag, envNamespaceFlag, envPoolsizeFlag, envImageFlag, envBuilderImageFlag, envBuildCmdFlag, envKeepArchiveFlag, minCpu, maxCpu, minMem, maxMem, envExternalNetworkFlag, envTerminationGracePeriodFlag}, Action: envUpdate},
+		{Name: "update", Usage: "Update environment", Flags: []cli.Flag{envNameFlag, envNamespaceFlag, envPoolsizeFlag, envImageFlag, envBuilderImageFlag, envBuildCmdFlag, envKeepArchiveFlag, envFunctionsPerContainerFlag, minCpu, maxCpu, minMem, maxMem, envExternalNetworkFlag, envTerminationGracePeriodFlag, envBuildTimeoutFlag, envMaxBuildsFlag}, Action: envUpdate},
 		{Name: "delete", Usage: "Delete environment", Flags: []cli.Flag{envNameFlag, envNamespaceFlag}, Action: envDelete},
 		{Name: "list", Usage: "List all environments", Flags: []cli.Flag{envNamespaceFlag}, Action: envList},
 	}
@@ -246,15 +254,34 @@ func newCliApp() *cli.App {
 	pkgSrcArchiveFlag := cli.StringSliceFlag{Name: "sourcearchive, src", Usage: "Local path or URL for source archive"}
 	pkgDeployArchiveFlag := cli.StringSliceFlag{Name: "deployarchive, deploy", Usage: "Local path or URL for binary archive"}
 	pkgBuildCmdFlag := cli.StringFlag{Name: "buildcmd", Usage: "Build command for builder to run with"}
+	pkgBuildEnvFlag := cli.StringSliceFlag{Name: "buildenv", Usage: "Environment variable for the build command, as KEY=VALUE; replaces all of them on update"}
+	pkgBuildSecretFlag := cli.StringSliceFlag{Name: "buildsecret", Usage: "Secret in the package namespace the build command can read, not included in the deployment package; replaces all of them on update"}
+	pkgBuildCfgMapFlag := cli.StringSliceFlag{Name: "buildconfigmap", Usage: "ConfigMap in the package namespace the build command can read; replaces all of them on update"}
+	pkgGitUrlFlag := cli.StringFlag{Name: "git-url", Usage: "https, ssh or git URL of a git repository to build the package from, instead of a source archive"}
+	pkgGitRefFlag := cli.StringFlag{Name: "git-ref", Usage: "Branch, tag or commit of the git repository to build (default: the default branch)"}
+	pkgGitSubDirFlag := cli.StringFlag{Name: "git-subdir", Usage: "Directory of the git repository containing the package source (optional)"}
+	pkgGitSecretFlag := cli.StringFlag{Name: "git-secret", Usage: "Secret in the package namespace with credentials for the git repository (optional)"}
+	pkgImageFlag := cli.StringFlag{Name: "image", Usage: "Docker or OCI image whose files are the deployment archive, pinned to the digest of the image when given"}
 	pkgOutputFlag := cli.StringFlag{Name: "output, o", Usage: "Output filename to save archive content"}
 	pkgOrphanFlag := cli.BoolFlag{Name: "orphan", Usage: "orphan packages that are not referenced by any function"}
+	pkgFollowFlag := cli.BoolFlag{Name: "follow", Usage: "Stream the build output until the build is done"}
+	pkgRevisionFlag := cli.IntFlag{Name: "revision", Usage: "Revision to roll back to, see \"fission pkg history\" (default: the previous one)"}
+	pkgSignKeyFlag := cli.StringFlag{Name: "key", Usage: "PEM encoded ed25519 or ECDSA P-256 private key to sign the archive with"}
+	pkgSignatureFlag := cli.StringFlag{Name: "signature", Usage: "Base64 encoded signature of the archive made elsewhere, e.g. by \"cosign sign-blob\", instead of --key"}
+	pkgSigTypeFlag := cli.StringFlag{Name: "sigtype", Value: string(fission.SignatureTypeECDSA), Usage: "Type of the --signature, ed25519 or ecdsa-p256-sha256"}
+	pkgKeyIDFlag := cli.StringFlag{Name: "keyid", Usage: "ID of the key in the fission-package-keys ConfigMap, after its namespace, that verifies the signature (optional)"}
+	pkgSignSourceFlag := cli.BoolFlag{Name: "source", Usage: "Sign the source archive instead of the deployment archive"}
 	pkgSubCommands := []cli.Command{
-		{Name: "create", Usage: "Create new package", Flags: []cli.Flag{pkgNamespaceFlag, pkgEnvironmentFlag, envNamespaceFlag, pkgSrcArchiveFlag, pkgDeployArchiveFlag, pkgBuildCmdFlag}, Action: pkgCreate},
-		{Name: "update", Usage: "Update package", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag, pkgEnvironmentFlag, envNamespaceFlag, pkgSrcArchiveFlag, pkgDeployArchiveFlag, pkgBuildCmdFlag, pkgForceFlag}, Action: pkgUpdate},
+		{Name: "create", Usage: "Create new package", Flags: []cli.Flag{pkgNamespaceFlag, pkgEnvironmentFlag, envNamespaceFlag, pkgSrcArchiveFlag, pkgDeployArchiveFlag, pkgBuildCmdFlag, pkgGitUrlFlag, pkgGitRefFlag, pkgGitSubDirFlag, pkgGitSecretFlag, pkgImageFlag, pkgBuildEnvFlag, pkgBuildSecretFlag, pkgBuildCfgMapFlag}, Action: pkgCreate},
+		{Name: "update", Usage: "Update package", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag, pkgEnvironmentFlag, envNamespaceFlag, pkgSrcArchiveFlag, pkgDeployArchiveFlag, pkgBuildCmdFlag, pkgGitUrlFlag, pkgGitRefFlag, pkgGitSubDirFlag, pkgGitSecretFlag, pkgImageFlag, pkgBuildEnvFlag, pkgBuildSecretFlag, pkgBuildCfgMapFlag, pkgForceFlag}, Action: pkgUpdate},
 		{Name: "rebuild", Usage: "Rebuild a failed package", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag}, Action: pkgRebuild},
 		{Name: "getsrc", Usage: "Get source archive content", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag, pkgOutputFlag}, Action: pkgSourceGet},
 		{Name: "getdeploy", Usage: "Get deployment archive content", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag, pkgOutputFlag}, Action: pkgDeployGet},
 		{Name: "info", Usage: "Show package information", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag}, Action: pkgInfo},
+		{Name: "build-logs", Usage: "Show the build logs of a package", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag, pkgFollowFlag}, Action: pkgBuildLogs},
+		{Name: "history", Usage: "List the revisions of a package", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag}, Action: pkgHistory},
+		{Name: "rollback", Usage: "Roll a package back to a previous revision", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag, pkgRevisionFlag}, Action: pkgRollback},
+		{Name: "sign", Usage: "Sign a package archive, so that namespaces trusting the key run it", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag, pkgSignKeyFlag, pkgSignatureFlag, pkgSigTypeFlag, pkgKeyIDFlag, pkgSignSourceFlag}, Action: pkgSign},
 		{Name: "list", Usage: "List all packages", Flags: []cli.Flag{pkgOrphanFlag, pkgNamespaceFlag}, Action: pkgList},
 		{Name: "delete", Usage: "Delete package", Flags: []cli.Flag{pkgNameFlag, pkgNamespaceFlag, pkgForceFlag, pkgOrphanFlag}, Action: pkgDelete},
 	}
@@ -303,6 +330,19 @@ func newCliApp() *cli.App {
 		{Name: "list", Usage: "List all canary configs in a namespace", Flags: []cli.Flag{canaryNamespaceFlag}, Action: canaryConfigList},
 	}
 
+	// archives
+	archiveIdFlag := cli.StringFlag{Name: "id", Usage: "Archive ID"}
+	archiveNamespaceFlag := cli.StringFlag{Name: "archiveNamespace, archivens", Usage: "Only archives uploaded for packages in this namespace"}
+	archivePkgFlag := cli.StringFlag{Name: "package, pkg", Usage: "Only archives uploaded for this package"}
+	archiveOutputFlag := cli.StringFlag{Name: "output, o", Usage: "Output filename to save archive content"}
+	archiveForceFlag := cli.BoolFlag{Name: "force, f", Usage: "Delete an archive even if packages use it"}
+	archiveSubCommands := []cli.Command{
+		{Name: "list", Usage: "List the archives in the storage service", Flags: []cli.Flag{archiveNamespaceFlag, archivePkgFlag}, Action: archiveList},
+		{Name: "get", Usage: "Show the metadata of an archive, or save its content with --output", Flags: []cli.Flag{archiveIdFlag, archiveOutputFlag}, Action: archiveGet},
+		{Name: "delete", Usage: "Delete an archive from the storage service", Flags: []cli.Flag{archiveIdFlag, archiveForceFlag}, Action: archiveDelete},
+		{Name: "usage", Usage: "Show the storage used by each namespace and its quota", Action: archiveUsage},
+	}
+
 	app.Commands = []cli.Command{
 		{Name: "function", Aliases: []string{"fn"}, Usage: "Create, update and manage functions", Subcommands: fnSubcommands},
 		{Name: "httptrigger", Aliases: []string{"ht", "route"}, Usage: "Manage HTTP triggers (routes) for functions", Subcommands: htSubcommands},
@@ -314,6 +354,7 @@ func newCliApp() *cli.App {
 		{Name: "environment", Aliases: []string{"env"}, Usage: "Manage environments", Subcommands: envSubcommands},
 		{Name: "watch", Aliases: []string{"w"}, Usage: "Manage watches", Subcommands: wSubCommands},
 		{Name: "package", Aliases: []string{"pkg"}, Usage: "Manage packages", Subcommands: pkgSubCommands},
+		{Name: "archive", Aliases: []string{"ar"}, Usage: "Audit and manage archives in the storage service", Subcommands: archiveSubCommands},
 		{Name: "spec", Aliases: []string{"specs"}, Usage: "Manage a declarative app specification", Subcommands: specSubCommands},
 		{Name: "upgrade", Aliases: []string{}, Usage: "Upgrade tool from fission v0.1", Subcommands: upgradeSubCommands},
 		{Name: "support", Usage: "Collect an archive of diagnostic information for support", Subcommands: supportSubCommands},
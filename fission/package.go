@@ -27,13 +27,17 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/user"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/dchest/uniuri"
 	"github.com/fission/fission/fission/util"
+	"github.com/fission/fission/storagesvc"
 	storageSvcClient "github.com/fission/fission/storagesvc/client"
 	"github.com/hashicorp/go-multierror"
 	"github.com/mholt/archiver"
@@ -45,6 +49,7 @@ import (
 	"github.com/fission/fission"
 	"github.com/fission/fission/controller/client"
 	"github.com/fission/fission/crd"
+	"github.com/fission/fission/environments/fetcher"
 	"github.com/fission/fission/fission/log"
 )
 
@@ -62,12 +67,17 @@ func getFunctionsByPackage(client *client.Client, pkgName, pkgNamespace string)
 	return fns, nil
 }
 
-// downloadStoragesvcURL downloads and return archive content with given storage service url
-func downloadStoragesvcURL(client *client.Client, fileUrl string) io.ReadCloser {
+// downloadStoragesvcURL downloads and return archive content with given storage service url.
+// The controller only downloads archives of the package they're downloaded for.
+func downloadStoragesvcURL(client *client.Client, pkg *metav1.ObjectMeta, fileUrl string) io.ReadCloser {
 	u, err := url.ParseRequestURI(fileUrl)
 	if err != nil {
 		return nil
 	}
+	query := u.Query()
+	query.Set("namespace", pkg.Namespace)
+	query.Set("package", pkg.Name)
+	u.RawQuery = query.Encode()
 
 	// replace in-cluster storage service host with controller server url
 	fileDownloadUrl := strings.TrimSuffix(client.Url, "/") + "/proxy/storage/" + u.RequestURI()
@@ -89,12 +99,31 @@ func pkgCreate(c *cli.Context) error {
 	srcArchiveFiles := c.StringSlice("src")
 	deployArchiveFiles := c.StringSlice("deploy")
 	buildcmd := c.String("buildcmd")
+	gitSource := gitSourceArchive(c)
+	buildOpts := getPackageBuildOptions(c, pkgNamespace)
+	image := c.String("image")
+
+	if len(image) > 0 {
+		if gitSource != nil || len(srcArchiveFiles) > 0 || len(deployArchiveFiles) > 0 {
+			log.Fatal("Need either of --image or --git-url or --src or --deploy and not more than one.")
+		}
+		createImagePackage(client, pkgNamespace, envName, envNamespace, imageArchive(image))
+		return nil
+	}
+
+	if gitSource != nil {
+		if len(srcArchiveFiles) > 0 || len(deployArchiveFiles) > 0 {
+			log.Fatal("Need either of --git-url or --src or --deploy and not more than one.")
+		}
+		createGitPackage(client, pkgNamespace, envName, envNamespace, gitSource, buildcmd, buildOpts)
+		return nil
+	}
 
 	if len(srcArchiveFiles) == 0 && len(deployArchiveFiles) == 0 {
 		log.Fatal("Need --src to specify source archive, or use --deploy to specify deployment archive.")
 	}
 
-	createPackage(client, pkgNamespace, envName, envNamespace, srcArchiveFiles, deployArchiveFiles, buildcmd, "", "", false)
+	createPackage(client, pkgNamespace, envName, envNamespace, srcArchiveFiles, deployArchiveFiles, buildcmd, buildOpts, "", "", false)
 
 	return nil
 }
@@ -114,14 +143,25 @@ func pkgUpdate(c *cli.Context) error {
 	srcArchiveFiles := c.StringSlice("src")
 	deployArchiveFiles := c.StringSlice("deploy")
 	buildcmd := c.String("buildcmd")
+	gitSource := gitSourceArchive(c)
+	buildOpts := getPackageBuildOptions(c, pkgNamespace)
+	image := c.String("image")
 
 	if len(srcArchiveFiles) > 0 && len(deployArchiveFiles) > 0 {
 		log.Fatal("Need either of --src or --deploy and not both arguments.")
 	}
 
-	if len(srcArchiveFiles) == 0 && len(deployArchiveFiles) == 0 &&
-		len(envName) == 0 && len(buildcmd) == 0 {
-		log.Fatal("Need --env or --src or --deploy or --buildcmd argument.")
+	if len(image) > 0 && (gitSource != nil || len(srcArchiveFiles) > 0 || len(deployArchiveFiles) > 0) {
+		log.Fatal("Need either of --image or --git-url or --src or --deploy and not more than one.")
+	}
+
+	if gitSource != nil && (len(srcArchiveFiles) > 0 || len(deployArchiveFiles) > 0) {
+		log.Fatal("Need either of --git-url or --src or --deploy and not more than one.")
+	}
+
+	if len(srcArchiveFiles) == 0 && len(deployArchiveFiles) == 0 && gitSource == nil && buildOpts == nil &&
+		len(envName) == 0 && len(buildcmd) == 0 && len(image) == 0 {
+		log.Fatal("Need --env or --src or --deploy or --git-url or --image or --buildcmd or --buildenv or --buildsecret or --buildconfigmap argument.")
 	}
 
 	pkg, err := client.PackageGet(&metav1.ObjectMeta{
@@ -148,8 +188,24 @@ func pkgUpdate(c *cli.Context) error {
 		log.Fatal("Package is used by multiple functions, use --force to force update")
 	}
 
+	// A git source is fetched by the builder, so there's nothing to upload
+	if gitSource != nil {
+		pkg.Spec.Source = *gitSource
+	}
+	if buildOpts != nil {
+		buildOpts.apply(&pkg.Spec)
+	}
+	// An image is the deployment archive, like one given with --deploy
+	if len(image) > 0 {
+		pkg.Spec.Deployment = *imageArchive(image)
+		pkg.Status.Revision = 0
+		if pkg.Status.BuildStatus != fission.BuildStatusPending {
+			pkg.Status.BuildStatus = fission.BuildStatusSucceeded
+		}
+	}
+
 	newPkgMeta, err := updatePackage(client, pkg,
-		envName, envNamespace, srcArchiveFiles, deployArchiveFiles, buildcmd, false, false)
+		envName, envNamespace, srcArchiveFiles, deployArchiveFiles, buildcmd, gitSource != nil || buildOpts != nil, false)
 	if err != nil {
 		util.CheckErr(err, "update package")
 	}
@@ -188,14 +244,16 @@ func updatePackage(client *client.Client, pkg *crd.Package, envName, envNamespac
 	}
 
 	if len(srcArchiveFiles) > 0 {
-		srcArchiveMetadata = createArchive(client, srcArchiveFiles, false, "", "")
+		srcArchiveMetadata = createArchive(client, srcArchiveFiles, false, "", "", archiveUploadMetadata(pkg.Metadata.Namespace, pkg.Metadata.Name))
 		pkg.Spec.Source = *srcArchiveMetadata
 		needToBuild = true
 	}
 
 	if len(deployArchiveFiles) > 0 {
-		deployArchiveMetadata = createArchive(client, deployArchiveFiles, noZip, "", "")
+		deployArchiveMetadata = createArchive(client, deployArchiveFiles, noZip, "", "", archiveUploadMetadata(pkg.Metadata.Namespace, pkg.Metadata.Name))
 		pkg.Spec.Deployment = *deployArchiveMetadata
+		// The new deployment archive isn't one of the built revisions
+		pkg.Status.Revision = 0
 		// Users may update the env, envNS and deploy archive at the same time,
 		// but without the source archive. In this case, we should set needToBuild to false
 		needToBuild = false
@@ -203,9 +261,12 @@ func updatePackage(client *client.Client, pkg *crd.Package, envName, envNamespac
 
 	// Set package as pending status when needToBuild is true
 	if needToBuild || forceRebuild {
-		// change into pending state to trigger package build
+		// change into pending state to trigger package build, keeping
+		// the revision history
 		pkg.Status = fission.PackageStatus{
 			BuildStatus: fission.BuildStatusPending,
+			Revision:    pkg.Status.Revision,
+			Revisions:   pkg.Status.Revisions,
 		}
 	}
 
@@ -239,7 +300,7 @@ func pkgSourceGet(c *cli.Context) error {
 	if pkg.Spec.Source.Type == fission.ArchiveTypeLiteral {
 		reader = bytes.NewReader(pkg.Spec.Source.Literal)
 	} else if pkg.Spec.Source.Type == fission.ArchiveTypeUrl {
-		readCloser := downloadStoragesvcURL(client, pkg.Spec.Source.URL)
+		readCloser := downloadStoragesvcURL(client, &pkg.Metadata, pkg.Spec.Source.URL)
 		defer readCloser.Close()
 		reader = readCloser
 	}
@@ -276,9 +337,11 @@ func pkgDeployGet(c *cli.Context) error {
 	if pkg.Spec.Deployment.Type == fission.ArchiveTypeLiteral {
 		reader = bytes.NewReader(pkg.Spec.Deployment.Literal)
 	} else if pkg.Spec.Deployment.Type == fission.ArchiveTypeUrl {
-		readCloser := downloadStoragesvcURL(client, pkg.Spec.Deployment.URL)
+		readCloser := downloadStoragesvcURL(client, &pkg.Metadata, pkg.Spec.Deployment.URL)
 		defer readCloser.Close()
 		reader = readCloser
+	} else if pkg.Spec.Deployment.Type == fission.ArchiveTypeImage {
+		return fmt.Errorf("the deployment archive of package %v is image %v, pull it instead", pkg.Metadata.Name, pkg.Spec.Deployment.Image)
 	}
 
 	if len(output) > 0 {
@@ -310,12 +373,63 @@ func pkgInfo(c *cli.Context) error {
 	fmt.Fprintf(w, "%v\t%v\n", "Name:", pkg.Metadata.Name)
 	fmt.Fprintf(w, "%v\t%v\n", "Environment:", pkg.Spec.Environment.Name)
 	fmt.Fprintf(w, "%v\t%v\n", "Status:", pkg.Status.BuildStatus)
+	if pkg.Status.BuildQueueDepth > 0 {
+		fmt.Fprintf(w, "%v\t%v\n", "Build Queue Position:", pkg.Status.BuildQueueDepth)
+	}
+	if pkg.Spec.Source.Git != nil {
+		fmt.Fprintf(w, "%v\t%v\n", "Git Source:", pkg.Spec.Source.Git.URL)
+		if len(pkg.Status.SourceCommit) > 0 {
+			fmt.Fprintf(w, "%v\t%v\n", "Source Commit:", pkg.Status.SourceCommit)
+		}
+	}
+	if len(pkg.Spec.BuildEnv) > 0 {
+		names := make([]string, 0, len(pkg.Spec.BuildEnv))
+		for k := range pkg.Spec.BuildEnv {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "%v\t%v\n", "Build Env:", strings.Join(names, ", "))
+	}
+	for _, s := range pkg.Spec.BuildSecrets {
+		fmt.Fprintf(w, "%v\t%v\n", "Build Secret:", s.Name)
+	}
+	for _, cm := range pkg.Spec.BuildConfigMaps {
+		fmt.Fprintf(w, "%v\t%v\n", "Build ConfigMap:", cm.Name)
+	}
+	if pkg.Status.BuildCacheHit {
+		fmt.Fprintf(w, "%v\t%v\n", "Build Cache:", "hit")
+	}
+	if pkg.Status.Revision > 0 {
+		fmt.Fprintf(w, "%v\t%v\n", "Revision:", pkg.Status.Revision)
+	}
 	fmt.Fprintf(w, "%v\n%v", "Build Logs:", pkg.Status.BuildLog)
 	w.Flush()
 
 	return nil
 }
 
+func pkgBuildLogs(c *cli.Context) error {
+	client := util.GetApiClient(c.GlobalString("server"))
+
+	pkgName := c.String("name")
+	if len(pkgName) == 0 {
+		log.Fatal("Need name of package, use --name")
+	}
+	pkgNamespace := c.String("pkgNamespace")
+
+	buildLogs, err := client.PackageBuildLogs(&metav1.ObjectMeta{
+		Namespace: pkgNamespace,
+		Name:      pkgName,
+	}, c.Bool("follow"))
+	util.CheckErr(err, fmt.Sprintf("get build logs of package %s", pkgName))
+	defer buildLogs.Close()
+
+	_, err = io.Copy(os.Stdout, buildLogs)
+	util.CheckErr(err, "read build logs")
+
+	return nil
+}
+
 func pkgList(c *cli.Context) error {
 	client := util.GetApiClient(c.GlobalString("server"))
 	// option for the user to list all orphan packages (not referenced by any function)
@@ -450,6 +564,63 @@ func pkgRebuild(c *cli.Context) error {
 	return nil
 }
 
+func pkgHistory(c *cli.Context) error {
+	client := util.GetApiClient(c.GlobalString("server"))
+
+	pkgName := c.String("name")
+	if len(pkgName) == 0 {
+		log.Fatal("Need name of package, use --name")
+	}
+	pkgNamespace := c.String("pkgNamespace")
+
+	pkg, err := client.PackageGet(&metav1.ObjectMeta{
+		Name:      pkgName,
+		Namespace: pkgNamespace,
+	})
+	util.CheckErr(err, "find package")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", "REVISION", "BUILT", "SOURCE", "CURRENT")
+	for _, rev := range pkg.Status.Revisions {
+		source := rev.SourceCommit
+		if len(source) == 0 {
+			source = rev.SourceChecksum.Sum
+		}
+		if len(source) > 12 {
+			source = source[:12]
+		}
+		current := ""
+		if rev.Revision == pkg.Status.Revision {
+			current = "*"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", rev.Revision, rev.Timestamp.Format(time.RFC3339), source, current)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func pkgRollback(c *cli.Context) error {
+	client := util.GetApiClient(c.GlobalString("server"))
+
+	pkgName := c.String("name")
+	if len(pkgName) == 0 {
+		log.Fatal("Need name of package, use --name")
+	}
+	pkgNamespace := c.String("pkgNamespace")
+
+	// The builder manager rolls back the package and the functions using it
+	pkg, err := client.PackageRollback(&metav1.ObjectMeta{
+		Name:      pkgName,
+		Namespace: pkgNamespace,
+	}, c.Int("revision"))
+	util.CheckErr(err, "roll back package")
+
+	fmt.Printf("Package '%v' rolled back to revision %v\n", pkg.Metadata.Name, pkg.Status.Revision)
+
+	return nil
+}
+
 func fileSize(filePath string) int64 {
 	info, err := os.Stat(filePath)
 	util.CheckErr(err, fmt.Sprintf("stat %v", filePath))
@@ -479,7 +650,7 @@ func fileChecksum(fileName string) (*fission.Checksum, error) {
 // create an archive upload spec in the specs directory; otherwise
 // upload the archive using client.  noZip avoids zipping the
 // includeFiles, but is ignored if there's more than one includeFile.
-func createArchive(client *client.Client, includeFiles []string, noZip bool, specDir string, specFile string) *fission.Archive {
+func createArchive(client *client.Client, includeFiles []string, noZip bool, specDir string, specFile string, metadata map[string]string) *fission.Archive {
 
 	var errs *multierror.Error
 
@@ -535,10 +706,20 @@ func createArchive(client *client.Client, includeFiles []string, noZip bool, spe
 	archivePath := makeArchiveFileIfNeeded("", includeFiles, noZip)
 
 	ctx := context.Background()
-	return uploadArchive(ctx, client, archivePath)
+	return uploadArchive(ctx, client, archivePath, metadata)
 }
 
-func uploadArchive(ctx context.Context, client *client.Client, fileName string) *fission.Archive {
+// archiveUploadMetadata returns the metadata the storage service keeps for
+// the archives of a package.
+func archiveUploadMetadata(namespace string, pkgName string) map[string]string {
+	uploader := "fission-cli"
+	if u, err := user.Current(); err == nil {
+		uploader = u.Username
+	}
+	return storagesvc.UploadMetadata(namespace, pkgName, uploader)
+}
+
+func uploadArchive(ctx context.Context, client *client.Client, fileName string, metadata map[string]string) *fission.Archive {
 	var archive fission.Archive
 
 	// If filename is a URL, download it first
@@ -554,7 +735,7 @@ func uploadArchive(ctx context.Context, client *client.Client, fileName string)
 		ssClient := storageSvcClient.MakeClient(u)
 
 		// TODO add a progress bar
-		id, err := ssClient.Upload(ctx, fileName, nil)
+		id, err := ssClient.Upload(ctx, fileName, &metadata)
 		util.CheckErr(err, fmt.Sprintf("upload file %v", fileName))
 
 		storageSvc, err := client.GetSvcURL("application=fission-storage")
@@ -577,7 +758,7 @@ func uploadArchive(ctx context.Context, client *client.Client, fileName string)
 	return &archive
 }
 
-func createPackage(client *client.Client, pkgNamespace string, envName string, envNamespace string, srcArchiveFiles []string, deployArchiveFiles []string, buildcmd string, specDir string, specFile string, noZip bool) *metav1.ObjectMeta {
+func createPackage(client *client.Client, pkgNamespace string, envName string, envNamespace string, srcArchiveFiles []string, deployArchiveFiles []string, buildcmd string, buildOpts *packageBuildOptions, specDir string, specFile string, noZip bool) *metav1.ObjectMeta {
 	pkgSpec := fission.PackageSpec{
 		Environment: fission.EnvironmentReference{
 			Namespace: envNamespace,
@@ -587,26 +768,34 @@ func createPackage(client *client.Client, pkgNamespace string, envName string, e
 	var pkgStatus fission.BuildStatus = fission.BuildStatusSucceeded
 
 	var pkgName string
+	if len(srcArchiveFiles) > 0 {
+		pkgName = util.KubifyName(fmt.Sprintf("%v-%v", path.Base(srcArchiveFiles[0]), uniuri.NewLen(4)))
+	} else if len(deployArchiveFiles) > 0 {
+		pkgName = util.KubifyName(fmt.Sprintf("%v-%v", path.Base(deployArchiveFiles[0]), uniuri.NewLen(4)))
+	} else {
+		pkgName = strings.ToLower(uuid.NewV4().String())
+	}
+	metadata := archiveUploadMetadata(pkgNamespace, pkgName)
+
 	if len(deployArchiveFiles) > 0 {
 		if len(specFile) > 0 { // we should do this in all cases, i think
 			pkgStatus = fission.BuildStatusNone
 		}
-		pkgSpec.Deployment = *createArchive(client, deployArchiveFiles, noZip, specDir, specFile)
-		pkgName = util.KubifyName(fmt.Sprintf("%v-%v", path.Base(deployArchiveFiles[0]), uniuri.NewLen(4)))
+		pkgSpec.Deployment = *createArchive(client, deployArchiveFiles, noZip, specDir, specFile, metadata)
 	}
 	if len(srcArchiveFiles) > 0 {
-		pkgSpec.Source = *createArchive(client, srcArchiveFiles, false, specDir, specFile)
+		pkgSpec.Source = *createArchive(client, srcArchiveFiles, false, specDir, specFile, metadata)
 		pkgStatus = fission.BuildStatusPending // set package build status to pending
-		pkgName = util.KubifyName(fmt.Sprintf("%v-%v", path.Base(srcArchiveFiles[0]), uniuri.NewLen(4)))
 	}
 
 	if len(buildcmd) > 0 {
 		pkgSpec.BuildCommand = buildcmd
 	}
 
-	if len(pkgName) == 0 {
-		pkgName = strings.ToLower(uuid.NewV4().String())
+	if buildOpts != nil {
+		buildOpts.apply(&pkgSpec)
 	}
+
 	pkg := &crd.Package{
 		Metadata: metav1.ObjectMeta{
 			Name:      pkgName,
@@ -638,6 +827,176 @@ func createPackage(client *client.Client, pkgNamespace string, envName string, e
 	}
 }
 
+// packageBuildOptions are the build env, secrets and configmaps of a package.
+type packageBuildOptions struct {
+	env        map[string]string
+	secrets    []fission.SecretReference
+	configMaps []fission.ConfigMapReference
+}
+
+// getPackageBuildOptions returns the build options given by the --buildenv,
+// --buildsecret and --buildconfigmap flags, or nil if there are none.
+func getPackageBuildOptions(c *cli.Context, pkgNamespace string) *packageBuildOptions {
+	envVars := c.StringSlice("buildenv")
+	secrets := c.StringSlice("buildsecret")
+	configMaps := c.StringSlice("buildconfigmap")
+	if len(envVars) == 0 && len(secrets) == 0 && len(configMaps) == 0 {
+		return nil
+	}
+
+	if len(pkgNamespace) == 0 {
+		pkgNamespace = metav1.NamespaceDefault
+	}
+
+	opts := &packageBuildOptions{}
+	if len(envVars) > 0 {
+		opts.env = make(map[string]string)
+		for _, kv := range envVars {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 || len(parts[0]) == 0 {
+				log.Fatal(fmt.Sprintf("Invalid build env variable '%v', need KEY=VALUE.", kv))
+			}
+			opts.env[parts[0]] = parts[1]
+		}
+	}
+	for _, name := range secrets {
+		opts.secrets = append(opts.secrets, fission.SecretReference{
+			Namespace: pkgNamespace,
+			Name:      name,
+		})
+	}
+	for _, name := range configMaps {
+		opts.configMaps = append(opts.configMaps, fission.ConfigMapReference{
+			Namespace: pkgNamespace,
+			Name:      name,
+		})
+	}
+	return opts
+}
+
+// apply sets the build options given on the command line in the package
+// spec, replacing the ones it had.
+func (opts *packageBuildOptions) apply(spec *fission.PackageSpec) {
+	if opts.env != nil {
+		spec.BuildEnv = opts.env
+	}
+	if opts.secrets != nil {
+		spec.BuildSecrets = opts.secrets
+	}
+	if opts.configMaps != nil {
+		spec.BuildConfigMaps = opts.configMaps
+	}
+}
+
+// gitSourceArchive returns the git source archive given by the --git-*
+// flags, or nil if there is no --git-url.
+func gitSourceArchive(c *cli.Context) *fission.Archive {
+	url := c.String("git-url")
+	if len(url) == 0 {
+		if len(c.String("git-ref")) > 0 || len(c.String("git-subdir")) > 0 || len(c.String("git-secret")) > 0 {
+			log.Fatal("Need --git-url to use --git-ref, --git-subdir or --git-secret.")
+		}
+		return nil
+	}
+	return &fission.Archive{
+		Type: fission.ArchiveTypeGit,
+		Git: &fission.GitSource{
+			URL:    url,
+			Ref:    c.String("git-ref"),
+			SubDir: c.String("git-subdir"),
+			Secret: c.String("git-secret"),
+		},
+	}
+}
+
+// createGitPackage creates a package whose source is fetched from a git
+// repository by the builder.
+func createGitPackage(client *client.Client, pkgNamespace string, envName string, envNamespace string,
+	gitSource *fission.Archive, buildcmd string, buildOpts *packageBuildOptions) *metav1.ObjectMeta {
+
+	pkgName := util.KubifyName(fmt.Sprintf("%v-%v",
+		strings.TrimSuffix(path.Base(gitSource.Git.URL), ".git"), uniuri.NewLen(4)))
+	pkg := &crd.Package{
+		Metadata: metav1.ObjectMeta{
+			Name:      pkgName,
+			Namespace: pkgNamespace,
+		},
+		Spec: fission.PackageSpec{
+			Environment: fission.EnvironmentReference{
+				Namespace: envNamespace,
+				Name:      envName,
+			},
+			Source:       *gitSource,
+			BuildCommand: buildcmd,
+		},
+		Status: fission.PackageStatus{
+			BuildStatus: fission.BuildStatusPending,
+		},
+	}
+	if buildOpts != nil {
+		buildOpts.apply(&pkg.Spec)
+	}
+
+	pkgMetadata, err := client.PackageCreate(pkg)
+	util.CheckErr(err, "create package")
+	fmt.Printf("Package '%v' created\n", pkgMetadata.GetName())
+	return pkgMetadata
+}
+
+// imageArchive returns the archive of an image, pinned to the digest of its
+// manifest. If the reference has a digest, the manifest must match it.
+func imageArchive(image string) *fission.Archive {
+	creds := fetcher.RegistryCreds{}
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if len(configDir) == 0 {
+		if u, err := user.Current(); err == nil {
+			configDir = filepath.Join(u.HomeDir, ".docker")
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(configDir, "config.json")); err == nil {
+		creds, err = fetcher.ParseDockerConfig(data)
+		util.CheckErr(err, "read docker config")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resolved, err := fetcher.MakeDockerBlobFetcher("registry-1.docker.io", nil).ResolveImage(ctx, image, creds)
+	util.CheckErr(err, fmt.Sprintf("resolve image %v", image))
+
+	return &fission.Archive{
+		Type:  fission.ArchiveTypeImage,
+		Image: resolved.PinnedReference(),
+	}
+}
+
+// createImagePackage creates a deployment package of the files of an
+// image; there's nothing to build.
+func createImagePackage(client *client.Client, pkgNamespace string, envName string, envNamespace string, image *fission.Archive) *metav1.ObjectMeta {
+	repository := strings.SplitN(image.Image, "@", 2)[0]
+	pkgName := util.KubifyName(fmt.Sprintf("%v-%v", path.Base(repository), uniuri.NewLen(4)))
+	pkg := &crd.Package{
+		Metadata: metav1.ObjectMeta{
+			Name:      pkgName,
+			Namespace: pkgNamespace,
+		},
+		Spec: fission.PackageSpec{
+			Environment: fission.EnvironmentReference{
+				Namespace: envNamespace,
+				Name:      envName,
+			},
+			Deployment: *image,
+		},
+		Status: fission.PackageStatus{
+			BuildStatus: fission.BuildStatusSucceeded,
+		},
+	}
+
+	pkgMetadata, err := client.PackageCreate(pkg)
+	util.CheckErr(err, "create package")
+	fmt.Printf("Package '%v' created from image %v\n", pkgMetadata.GetName(), image.Image)
+	return pkgMetadata
+}
+
 func getContents(filePath string) []byte {
 	var code []byte
 	var err error
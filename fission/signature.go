@@ -0,0 +1,231 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ed25519"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/controller/client"
+	"github.com/fission/fission/fission/log"
+	"github.com/fission/fission/fission/util"
+)
+
+// oidEd25519 identifies ed25519 keys in PKCS #8 structures (RFC 8410),
+// which crypto/x509 of the Go release fission is built with doesn't parse.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+type (
+	pkcs8PrivateKey struct {
+		Version    int
+		Algorithm  pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+
+	ecdsaSignature struct {
+		R, S *big.Int
+	}
+)
+
+// parsePKCS8PrivateKey parses a DER encoded PKCS #8 private key.
+func parsePKCS8PrivateKey(der []byte) (interface{}, error) {
+	var pk pkcs8PrivateKey
+	rest, err := asn1.Unmarshal(der, &pk)
+	if err != nil || len(rest) > 0 || !pk.Algorithm.Algorithm.Equal(oidEd25519) {
+		return x509.ParsePKCS8PrivateKey(der)
+	}
+
+	// The private key is the seed, itself DER encoded as an octet string
+	var seed []byte
+	rest, err = asn1.Unmarshal(pk.PrivateKey, &seed)
+	if err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ed25519 private key has %v bytes, expected %v", len(seed), ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #8 ed25519 or ECDSA P-256
+// private key, or a SEC 1 ECDSA one.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	var key interface{}
+	var err error
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = parsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only ECDSA keys on the P-256 curve are supported")
+		}
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// signDigest signs the SHA-256 digest of an archive the way the fetcher
+// verifies it.
+func signDigest(key crypto.Signer, keyID string, digest []byte) (*fission.ArchiveSignature, error) {
+	sig := &fission.ArchiveSignature{KeyID: keyID}
+
+	var value []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig.Type = fission.SignatureTypeEd25519
+		value = ed25519.Sign(k, digest)
+	case *ecdsa.PrivateKey:
+		sig.Type = fission.SignatureTypeECDSA
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		value, err = asn1.Marshal(ecdsaSignature{R: r, S: s})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sig.Value = base64.StdEncoding.EncodeToString(value)
+	return sig, nil
+}
+
+// archiveDigest returns the SHA-256 digest of the content of an archive
+// of the package.
+func archiveDigest(client *client.Client, pkg *metav1.ObjectMeta, archive *fission.Archive) ([]byte, error) {
+	var reader io.Reader
+	if archive.Type == fission.ArchiveTypeLiteral {
+		reader = bytes.NewReader(archive.Literal)
+	} else if archive.Type == fission.ArchiveTypeUrl && len(archive.URL) > 0 {
+		readCloser := downloadStoragesvcURL(client, pkg, archive.URL)
+		if readCloser == nil {
+			return nil, fmt.Errorf("invalid archive URL %v", archive.URL)
+		}
+		defer readCloser.Close()
+		reader = readCloser
+	} else {
+		return nil, errors.New("only literal and URL archives can be signed")
+	}
+
+	h := sha256.New()
+	_, err := io.Copy(h, reader)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func pkgSign(c *cli.Context) error {
+	client := util.GetApiClient(c.GlobalString("server"))
+
+	pkgName := c.String("name")
+	if len(pkgName) == 0 {
+		log.Fatal("Need name of package, use --name")
+	}
+	pkgNamespace := c.String("pkgNamespace")
+
+	keyFile := c.String("key")
+	signature := c.String("signature")
+	if (len(keyFile) == 0) == (len(signature) == 0) {
+		log.Fatal("Need either of --key to sign the package or --signature to add an existing signature.")
+	}
+	keyID := c.String("keyid")
+
+	pkg, err := client.PackageGet(&metav1.ObjectMeta{
+		Namespace: pkgNamespace,
+		Name:      pkgName,
+	})
+	util.CheckErr(err, "get package")
+
+	archive := &pkg.Spec.Deployment
+	if c.Bool("source") {
+		archive = &pkg.Spec.Source
+	}
+
+	var sig *fission.ArchiveSignature
+	if len(keyFile) > 0 {
+		data, err := ioutil.ReadFile(keyFile)
+		util.CheckErr(err, fmt.Sprintf("read key file %v", keyFile))
+		key, err := parsePrivateKey(data)
+		util.CheckErr(err, fmt.Sprintf("parse private key %v", keyFile))
+
+		digest, err := archiveDigest(client, &pkg.Metadata, archive)
+		util.CheckErr(err, "get archive digest")
+
+		sig, err = signDigest(key, keyID, digest)
+		util.CheckErr(err, "sign archive")
+	} else {
+		// e.g. made by "cosign sign-blob" on the archive content
+		sig = &fission.ArchiveSignature{
+			Type:  fission.SignatureType(c.String("sigtype")),
+			KeyID: keyID,
+			Value: signature,
+		}
+		util.CheckErr(sig.Validate(), "validate signature")
+	}
+	archive.Signature = sig
+
+	fnList, err := getFunctionsByPackage(client, pkg.Metadata.Name, pkg.Metadata.Namespace)
+	util.CheckErr(err, "get function list")
+
+	newPkgMeta, err := client.PackageUpdate(pkg)
+	util.CheckErr(err, "update package")
+
+	// functions pick up the signed package like any other package update
+	for _, fn := range fnList {
+		fn.Spec.Package.PackageRef.ResourceVersion = newPkgMeta.ResourceVersion
+		_, err := client.FunctionUpdate(&fn)
+		util.CheckErr(err, "update function")
+	}
+
+	fmt.Printf("Package '%v' signed with %v signature\n", pkg.Metadata.Name, sig.Type)
+	return nil
+}
@@ -259,8 +259,10 @@ func (fr *FissionResources) validate() error {
 
 	// index packages, check outgoing refs, mark archives that are referenced
 	packages := make(map[string]bool)
+	packageSpecs := make(map[string]fission.PackageSpec)
 	for _, p := range fr.packages {
 		packages[mapKey(&p.Metadata)] = false
+		packageSpecs[mapKey(&p.Metadata)] = p.Spec
 
 		// check archive refs from package
 		aname := strings.TrimPrefix(p.Spec.Source.URL, ARCHIVE_URL_PREFIX)
@@ -341,6 +343,12 @@ func (fr *FissionResources) validate() error {
 				pkgMeta.Name))
 		} else {
 			packages[mapKey(pkgMeta)] = true
+			err := f.Spec.ValidatePackage(packageSpecs[mapKey(pkgMeta)])
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("%v: function '%v': %v",
+					fr.sourceMap.locations["Function"][f.Metadata.Namespace][f.Metadata.Name],
+					f.Metadata.Name, err))
+			}
 		}
 
 		result = multierror.Append(result, f.Validate())
@@ -844,6 +852,16 @@ func applyArchives(fclient *client.Client, specDir string, fr *FissionResources)
 		}
 	}
 
+	// the storage service records the first package using an archive
+	archiveMetadata := make(map[string]map[string]string)
+	for _, pkg := range fr.packages {
+		for _, ar := range []fission.Archive{pkg.Spec.Source, pkg.Spec.Deployment} {
+			if _, ok := archiveMetadata[ar.URL]; !ok && strings.HasPrefix(ar.URL, ARCHIVE_URL_PREFIX) {
+				archiveMetadata[ar.URL] = archiveUploadMetadata(pkg.Metadata.Namespace, pkg.Metadata.Name)
+			}
+		}
+	}
+
 	// upload archives that we need to, updating the map
 	for name, ar := range archiveFiles {
 		if ar.Type == fission.ArchiveTypeLiteral {
@@ -858,7 +876,7 @@ func applyArchives(fclient *client.Client, specDir string, fr *FissionResources)
 			// doesn't exist, upload
 			fmt.Printf("uploading archive %v\n", name)
 			// ar.URL is actually a local filename at this stage
-			uploadedAr := uploadArchive(fclient, ar.URL)
+			uploadedAr := uploadArchive(context.Background(), fclient, ar.URL, archiveMetadata[name])
 			archiveFiles[name] = *uploadedAr
 		}
 	}
@@ -1141,7 +1159,10 @@ func applyPackages(fclient *client.Client, fr *FissionResources, delete bool) (m
 			} else if reflect.DeepEqual(existingObj.Spec.Environment, o.Spec.Environment) &&
 				!reflect.DeepEqual(existingObj.Spec.Source, fission.Archive{}) &&
 				reflect.DeepEqual(existingObj.Spec.Source, o.Spec.Source) &&
-				existingObj.Spec.BuildCommand == o.Spec.BuildCommand {
+				existingObj.Spec.BuildCommand == o.Spec.BuildCommand &&
+				reflect.DeepEqual(existingObj.Spec.BuildEnv, o.Spec.BuildEnv) &&
+				reflect.DeepEqual(existingObj.Spec.BuildSecrets, o.Spec.BuildSecrets) &&
+				reflect.DeepEqual(existingObj.Spec.BuildConfigMaps, o.Spec.BuildConfigMaps) {
 
 				keep = true
 			}
@@ -1152,6 +1173,9 @@ func applyPackages(fclient *client.Client, fr *FissionResources, delete bool) (m
 			} else {
 				// update
 				o.Metadata.ResourceVersion = existingObj.Metadata.ResourceVersion
+				// the revision history isn't part of the spec
+				o.Status.Revision = existingObj.Status.Revision
+				o.Status.Revisions = existingObj.Status.Revisions
 
 				// We may be racing against the package builder to update the
 				// package (a previous version might have been getting built).  So,
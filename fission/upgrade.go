@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -293,7 +294,7 @@ func upgradeRestoreState(c *cli.Context) error {
 		tmpfile.Close()
 
 		// upload
-		archive := uploadArchive(client, tmpfile.Name())
+		archive := uploadArchive(context.Background(), client, tmpfile.Name(), archiveUploadMetadata(metav1.NamespaceDefault, pkgName))
 		os.Remove(tmpfile.Name())
 
 		// create pkg
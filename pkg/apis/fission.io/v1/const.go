@@ -25,6 +25,17 @@ const (
 	ChecksumTypeSHA256 ChecksumType = "sha256"
 )
 
+const (
+	// SignatureTypeEd25519 signatures sign the 32 byte SHA-256 digest of
+	// the archive.
+	SignatureTypeEd25519 SignatureType = "ed25519"
+
+	// SignatureTypeECDSA signatures are ASN.1 encoded ECDSA P-256
+	// signatures of the SHA-256 digest of the archive, compatible with
+	// "cosign sign-blob".
+	SignatureTypeECDSA SignatureType = "ecdsa-p256-sha256"
+)
+
 const (
 	// ArchiveTypeLiteral means the package contents are specified in the Literal field of
 	// resource itself.
@@ -33,8 +44,14 @@ const (
 	// ArchiveTypeUrl means the package contents are at the specified URL.
 	ArchiveTypeUrl ArchiveType = "url"
 
-	// ArchiveTypeImage means that the package contents are a Docker image layer
+	// ArchiveTypeImage means that the package contents are the files of
+	// the Docker or OCI image in the Image field, with all its layers
+	// applied.
 	ArchiveTypeImage ArchiveType = "image"
+
+	// ArchiveTypeGit means the package contents are cloned from the git
+	// repository specified in the Git field.
+	ArchiveTypeGit ArchiveType = "git"
 )
 
 const (
@@ -53,12 +70,26 @@ const (
 const (
 	ExecutorTypePoolmgr   = "poolmgr"
 	ExecutorTypeNewdeploy = "newdeploy"
+	ExecutorTypeContainer = "container"
 )
 
 const (
 	StrategyTypeExecution = "execution"
 )
 
+const (
+	// FunctionPackageReady is true when the function's package was built successfully.
+	FunctionPackageReady FunctionConditionType = "PackageReady"
+
+	// FunctionSpecialized is true when there is at least one running instance
+	// of the function that is ready to serve requests.
+	FunctionSpecialized FunctionConditionType = "Specialized"
+
+	// FunctionScaledToZero is true when all instances of the function were
+	// reaped because the function was idle.
+	FunctionScaledToZero FunctionConditionType = "ScaledToZero"
+)
+
 const (
 	SharedVolumeUserfunc   = "userfunc"
 	SharedVolumePackages   = "packages"
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetCondition returns the condition of the given type, or nil if the
+// condition was never set.
+func (status *FunctionStatus) GetCondition(condType FunctionConditionType) *FunctionCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == condType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition sets the status, reason and message of the condition of the
+// given type. LastTransitionTime is only updated when the status changes.
+// It returns true if anything was modified.
+func (status *FunctionStatus) SetCondition(condType FunctionConditionType,
+	condStatus apiv1.ConditionStatus, reason string, message string) bool {
+
+	cond := status.GetCondition(condType)
+	if cond == nil {
+		status.Conditions = append(status.Conditions, FunctionCondition{
+			Type:               condType,
+			Status:             condStatus,
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		})
+		return true
+	}
+
+	if cond.Status == condStatus && cond.Reason == reason && cond.Message == message {
+		return false
+	}
+
+	if cond.Status != condStatus {
+		cond.LastTransitionTime = metav1.Now()
+	}
+	cond.Status = condStatus
+	cond.Reason = reason
+	cond.Message = message
+	return true
+}
+
+// IsConditionTrue returns true if the condition of the given type is set and true.
+func (status *FunctionStatus) IsConditionTrue(condType FunctionConditionType) bool {
+	cond := status.GetCondition(condType)
+	return cond != nil && cond.Status == apiv1.ConditionTrue
+}
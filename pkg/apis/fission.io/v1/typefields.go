@@ -18,6 +18,7 @@ package v1
 
 import (
 	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type (
@@ -58,9 +59,54 @@ type (
 
 		Image string `json:"image,omitempty"`
 
+		// Git references a directory of a git repository.
+		Git *GitSource `json:"git,omitempty"`
+
 		// Checksum ensures the integrity of packages
 		// refereced by URL. Ignored for literals.
 		Checksum Checksum `json:"checksum,omitempty"`
+
+		// Signature is a detached signature of the archive contents,
+		// verified by the fetcher against the public keys trusted in
+		// the namespace of the package.
+		Signature *ArchiveSignature `json:"signature,omitempty"`
+	}
+
+	SignatureType string
+
+	// ArchiveSignature signs the SHA-256 digest of the archive contents.
+	ArchiveSignature struct {
+		// Type is the signature algorithm: ed25519, or ecdsa-p256-sha256
+		// as made by "cosign sign-blob".
+		Type SignatureType `json:"type"`
+
+		// KeyID names the public key in the trusted keys ConfigMap. If
+		// it's empty, any trusted key of the type may verify it.
+		KeyID string `json:"keyid,omitempty"`
+
+		// Value is the base64 encoded signature.
+		Value string `json:"value"`
+	}
+
+	// GitSource references a directory of a git repository at a
+	// branch, tag or commit.
+	GitSource struct {
+		// URL of the repository, over HTTPS or SSH.
+		URL string `json:"url"`
+
+		// Ref is the branch, tag or commit SHA to check out. Defaults
+		// to the default branch of the repository.
+		Ref string `json:"ref,omitempty"`
+
+		// SubDir is the directory of the repository to use as the
+		// package contents. Defaults to the whole repository.
+		SubDir string `json:"subdir,omitempty"`
+
+		// Secret is the name of a secret in the namespace of the
+		// package holding the credentials of the repository: either
+		// "username" and "password" for HTTPS, or "ssh-privatekey" and
+		// optionally "known_hosts" for SSH.
+		Secret string `json:"secret,omitempty"`
 	}
 
 	EnvironmentReference struct {
@@ -85,12 +131,67 @@ type (
 		Source       Archive              `json:"source,omitempty"`
 		Deployment   Archive              `json:"deployment,omitempty"`
 		BuildCommand string               `json:"buildcmd,omitempty"`
+
+		// BuildEnv holds environment variables for the build command,
+		// e.g. the address of a private package registry.
+		BuildEnv map[string]string `json:"buildenv,omitempty"`
+
+		// BuildSecrets and BuildConfigMaps are given to the build command
+		// as files, and are never part of the deployment archive. They
+		// must be in the namespace of the package.
+		BuildSecrets    []SecretReference    `json:"buildsecrets,omitempty"`
+		BuildConfigMaps []ConfigMapReference `json:"buildconfigmaps,omitempty"`
+
 		// In the future, we can have a debug build here too
 	}
 
 	PackageStatus struct {
 		BuildStatus BuildStatus `json:"buildstatus,omitempty"`
 		BuildLog    string      `json:"buildlog,omitempty"` // output of the build (errors etc)
+
+		// BuildLogUrl is the URL of the full build log, if it was too
+		// long for BuildLog. BuildLog holds its tail then.
+		BuildLogUrl string `json:"buildlogurl,omitempty"`
+
+		// SourceCommit is the SHA of the commit a git source was
+		// resolved to for the last build.
+		SourceCommit string `json:"sourcecommit,omitempty"`
+
+		// BuildQueueDepth is the position of the package in the build
+		// queue of its environment while the build waits for a free slot.
+		BuildQueueDepth int `json:"buildqueuedepth,omitempty"`
+
+		// BuildCacheKey identifies the inputs of a successful build: the
+		// source checksum, the environment and the build command.
+		BuildCacheKey string `json:"buildcachekey,omitempty"`
+
+		// BuildCacheHit is true if the deployment archive was reused
+		// from another package built with the same inputs.
+		BuildCacheHit bool `json:"buildcachehit,omitempty"`
+
+		// Revision is the revision the deployment archive belongs to, and
+		// Revisions holds the last successful builds, newest first, so
+		// that the package can be rolled back to one of them.
+		Revision  int               `json:"revision,omitempty"`
+		Revisions []PackageRevision `json:"revisions,omitempty"`
+	}
+
+	// PackageRevision is a successful build of a package.
+	PackageRevision struct {
+		Revision int `json:"revision"`
+
+		// SourceChecksum and SourceCommit identify the source the
+		// deployment archive was built from.
+		SourceChecksum Checksum `json:"sourcechecksum,omitempty"`
+		SourceCommit   string   `json:"sourcecommit,omitempty"`
+
+		// DeploymentUrl and DeploymentChecksum reference the deployment
+		// archive in the storage service.
+		DeploymentUrl      string   `json:"deploymenturl"`
+		DeploymentChecksum Checksum `json:"deploymentchecksum,omitempty"`
+
+		BuildLogUrl string      `json:"buildlogurl,omitempty"`
+		Timestamp   metav1.Time `json:"timestamp"`
 	}
 
 	PackageRef struct {
@@ -140,6 +241,57 @@ type (
 
 		// InvokeStrategy is a set of controls which affect how function executes
 		InvokeStrategy InvokeStrategy
+
+		// Env are environment variables of the function, in addition to
+		// those of the environment's container. Values are literals or
+		// keys of Secrets and ConfigMaps in the function's namespace.
+		// Optional.
+		Env []apiv1.EnvVar `json:"env,omitempty"`
+
+		// Volumes are mounted into the function container. Volumes
+		// can't be added to pre-warmed pods, so only functions with the
+		// newdeploy executor type can have them. Optional.
+		Volumes []FunctionVolume `json:"volumes,omitempty"`
+	}
+
+	// FunctionVolume is a PersistentVolumeClaim or EmptyDir volume of a
+	// function, and where it's mounted in the function container.
+	FunctionVolume struct {
+		apiv1.Volume `json:",inline"`
+
+		MountPath string `json:"mountPath"`
+		ReadOnly  bool   `json:"readOnly,omitempty"`
+	}
+
+	FunctionConditionType string
+
+	// FunctionCondition describes one aspect of the state of a function, in
+	// the same shape as the conditions of kubernetes objects.
+	FunctionCondition struct {
+		Type   FunctionConditionType `json:"type"`
+		Status apiv1.ConditionStatus `json:"status"`
+
+		// Last time the condition changed from one status to another.
+		LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+		// Machine readable reason and human readable message for the last transition.
+		Reason  string `json:"reason,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	// FunctionStatus is updated by the executor and the builder manager;
+	// it is served as the status subresource of the Function CRD.
+	FunctionStatus struct {
+		Conditions []FunctionCondition `json:"conditions,omitempty"`
+
+		// LastError is the most recent error encountered while building,
+		// specializing or scaling the function.
+		LastError string `json:"lastError,omitempty"`
+
+		// LastSpecializationDuration is the time it took to create the last
+		// function service (choosing and specializing a pod, or scaling up a
+		// deployment) for the function.
+		LastSpecializationDuration metav1.Duration `json:"lastSpecializationDuration,omitempty"`
 	}
 
 	/*InvokeStrategy is a set of controls over how the function executes.
@@ -254,6 +406,15 @@ type (
 		// - ReadinessProbe
 		// (optional)
 		Container *apiv1.Container `json:"container,omitempty"`
+
+		// (Optional) Timeout of a package build in seconds. Builds running
+		// longer are cancelled and fail. Defaults to 30 minutes.
+		BuildTimeout int `json:"buildtimeout,omitempty"`
+
+		// (Optional) Maximum number of packages built at the same time by
+		// the builder of this environment. Further builds wait in a queue.
+		// Defaults to 2.
+		MaxConcurrentBuilds int `json:"maxconcurrentbuilds,omitempty"`
 	}
 
 	EnvironmentSpec struct {
@@ -64,6 +64,8 @@ type (
 		metav1.TypeMeta `json:",inline"`
 		Metadata        metav1.ObjectMeta `json:"metadata"`
 		Spec            FunctionSpec      `json:"spec"`
+
+		Status FunctionStatus `json:"status"`
 	}
 
 	// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
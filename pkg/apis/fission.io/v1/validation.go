@@ -17,14 +17,19 @@ limitations under the License.
 package v1
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
 	nsUtil "github.com/nats-io/nats-streaming-server/util"
 	"github.com/robfig/cron"
+	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/validation"
 )
 
@@ -208,21 +213,106 @@ func (checksum Checksum) Validate() error {
 	return result.ErrorOrNil()
 }
 
+// scpLikeGitURL matches the [user@]host:path form of ssh URLs of git, but
+// not the <transport>::<address> form of its remote helpers.
+var scpLikeGitURL = regexp.MustCompile(`^([^@/:]+@)?[^@/:]+:[^:]`)
+
+// validGitURL returns true for https, ssh and git URLs; the fetcher must not
+// clone local paths or use other transports.
+func validGitURL(gitURL string) bool {
+	if !strings.Contains(gitURL, "://") {
+		return scpLikeGitURL.MatchString(gitURL)
+	}
+	u, err := url.Parse(gitURL)
+	if err != nil || len(u.Host) == 0 {
+		return false
+	}
+	return u.Scheme == "https" || u.Scheme == "ssh" || u.Scheme == "git"
+}
+
+func validImageDigest(digest string) bool {
+	hexSum := strings.TrimPrefix(digest, "sha256:")
+	if len(hexSum) != 64 || hexSum == digest {
+		return false
+	}
+	_, err := hex.DecodeString(hexSum)
+	return err == nil
+}
+
 func (archive Archive) Validate() error {
 	var result *multierror.Error
 
 	if len(archive.Type) > 0 {
 		switch archive.Type {
 		case ArchiveTypeLiteral, ArchiveTypeUrl: // no op
+		case ArchiveTypeImage:
+			if len(archive.Image) == 0 {
+				result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Image", "", "image archives need an image reference"))
+			}
+		case ArchiveTypeGit:
+			if archive.Git == nil || len(archive.Git.URL) == 0 {
+				result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Git.URL", "", "git archives need a repository URL"))
+			}
 		default:
 			result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "Archive.Type", archive.Type, "not a valid archive type"))
 		}
 	}
 
+	// The URL and ref are passed to git as arguments, so they must not be
+	// taken for options
+	if archive.Git != nil && strings.HasPrefix(archive.Git.URL, "-") {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Git.URL", archive.Git.URL, "must not start with '-'"))
+	}
+	if archive.Git != nil && len(archive.Git.URL) > 0 && !validGitURL(archive.Git.URL) {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Git.URL", archive.Git.URL, "must be an https, ssh or git URL"))
+	}
+	if archive.Git != nil && strings.HasPrefix(archive.Git.Ref, "-") {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Git.Ref", archive.Git.Ref, "must not start with '-'"))
+	}
+
+	if archive.Git != nil && len(archive.Git.SubDir) > 0 {
+		subDir := path.Clean(archive.Git.SubDir)
+		if path.IsAbs(subDir) || subDir == ".." || strings.HasPrefix(subDir, "../") {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Git.SubDir", archive.Git.SubDir, "must be a relative path within the repository"))
+		}
+	}
+
+	// Digests of pinned images are verified by the fetcher, so they must
+	// be ones it knows
+	if i := strings.LastIndex(archive.Image, "@"); i >= 0 && !validImageDigest(archive.Image[i+1:]) {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Image", archive.Image, "image digest must be sha256:<64 hex digits>"))
+	}
+
 	if archive.Checksum != (Checksum{}) {
 		result = multierror.Append(result, archive.Checksum.Validate())
 	}
 
+	if archive.Signature != nil {
+		result = multierror.Append(result, archive.Signature.Validate())
+
+		// Signatures are of the archive content, which images and git
+		// repositories don't have; pinned image digests stand for them
+		if archive.Type == ArchiveTypeImage || archive.Type == ArchiveTypeGit || len(archive.Image) > 0 || archive.Git != nil {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Signature", archive.Type, "only literal and URL archives can be signed"))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+func (sig ArchiveSignature) Validate() error {
+	var result *multierror.Error
+
+	switch sig.Type {
+	case SignatureTypeEd25519, SignatureTypeECDSA: // no op
+	default:
+		result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "Archive.Signature.Type", sig.Type, "not a valid signature type"))
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(sig.Value); err != nil || len(sig.Value) == 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Archive.Signature.Value", sig.Value, "must be a base64 encoded signature"))
+	}
+
 	return result.ErrorOrNil()
 }
 
@@ -250,11 +340,24 @@ func (spec PackageSpec) Validate() error {
 	result = multierror.Append(result, spec.Environment.Validate())
 
 	for _, r := range []Archive{spec.Source, spec.Deployment} {
-		if len(r.URL) > 0 || len(r.Literal) > 0 {
+		if len(r.Type) > 0 || len(r.URL) > 0 || len(r.Literal) > 0 || len(r.Image) > 0 || r.Git != nil {
 			result = multierror.Append(result, r.Validate())
 		}
 	}
 
+	for k := range spec.BuildEnv {
+		e := validation.IsEnvVarName(k)
+		if len(e) > 0 {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "PackageSpec.BuildEnv", k, e...))
+		}
+	}
+	for _, s := range spec.BuildSecrets {
+		result = multierror.Append(result, s.Validate())
+	}
+	for _, c := range spec.BuildConfigMaps {
+		result = multierror.Append(result, c.Validate())
+	}
+
 	return result.ErrorOrNil()
 }
 
@@ -304,6 +407,104 @@ func (spec FunctionSpec) Validate() error {
 		result = multierror.Append(result, spec.InvokeStrategy.Validate())
 	}
 
+	for _, e := range spec.Env {
+		result = multierror.Append(result, validateEnvVar(e))
+	}
+
+	volumeNames := make(map[string]bool)
+	for _, v := range spec.Volumes {
+		result = multierror.Append(result, v.Validate())
+		if volumeNames[v.Name] {
+			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "FunctionSpec.Volumes.Name", v.Name, "duplicate volume name"))
+		}
+		volumeNames[v.Name] = true
+	}
+	if len(spec.Volumes) > 0 && spec.InvokeStrategy.ExecutionStrategy.ExecutorType != ExecutorTypeNewdeploy {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "FunctionSpec.Volumes", len(spec.Volumes),
+			"volumes need a dedicated pod, use the newdeploy executor type"))
+	}
+
+	return result.ErrorOrNil()
+}
+
+// validateEnvVar checks an environment variable of a function, whose value
+// can only come from keys of Secrets and ConfigMaps, which is all the
+// fetcher resolves.
+func validateEnvVar(e apiv1.EnvVar) error {
+	var result *multierror.Error
+
+	if errs := validation.IsEnvVarName(e.Name); len(errs) > 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "FunctionSpec.Env.Name", e.Name, errs...))
+	}
+	if e.ValueFrom == nil {
+		return result.ErrorOrNil()
+	}
+
+	if len(e.Value) > 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "FunctionSpec.Env.Value", e.Name, "can't have both a value and valueFrom"))
+	}
+	ref := e.ValueFrom
+	switch {
+	case ref.SecretKeyRef != nil && ref.ConfigMapKeyRef == nil && ref.FieldRef == nil && ref.ResourceFieldRef == nil:
+		result = multierror.Append(result,
+			ValidateKubeName("FunctionSpec.Env.ValueFrom.SecretKeyRef.Name", ref.SecretKeyRef.Name),
+			validateConfigKey("FunctionSpec.Env.ValueFrom.SecretKeyRef.Key", ref.SecretKeyRef.Key))
+	case ref.ConfigMapKeyRef != nil && ref.SecretKeyRef == nil && ref.FieldRef == nil && ref.ResourceFieldRef == nil:
+		result = multierror.Append(result,
+			ValidateKubeName("FunctionSpec.Env.ValueFrom.ConfigMapKeyRef.Name", ref.ConfigMapKeyRef.Name),
+			validateConfigKey("FunctionSpec.Env.ValueFrom.ConfigMapKeyRef.Key", ref.ConfigMapKeyRef.Key))
+	default:
+		result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "FunctionSpec.Env.ValueFrom", e.Name,
+			"only one secretKeyRef or configMapKeyRef is supported"))
+	}
+
+	return result.ErrorOrNil()
+}
+
+func validateConfigKey(field string, key string) error {
+	if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+		return MakeValidationErr(ErrorInvalidValue, field, key, errs...)
+	}
+	return nil
+}
+
+// ValidatePackage checks that the executor of the function can run the
+// given package. The container executor runs the image of the deployment
+// archive as the function container, so that image must be set.
+func (spec FunctionSpec) ValidatePackage(pkg PackageSpec) error {
+	var result *multierror.Error
+
+	if spec.InvokeStrategy.ExecutionStrategy.ExecutorType == ExecutorTypeContainer && len(pkg.Deployment.Image) == 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "PackageSpec.Deployment.Image", "",
+			"functions with executor type container need a package with a deployment image"))
+	}
+
+	return result.ErrorOrNil()
+}
+
+func (v FunctionVolume) Validate() error {
+	var result *multierror.Error
+
+	result = multierror.Append(result, ValidateKubeName("FunctionVolume.Name", v.Name))
+	switch v.Name {
+	case SharedVolumeUserfunc, SharedVolumePackages, SharedVolumeSecrets, SharedVolumeConfigmaps:
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "FunctionVolume.Name", v.Name, "name is used by fission"))
+	}
+
+	other := v.VolumeSource
+	other.PersistentVolumeClaim, other.EmptyDir = nil, nil
+	switch {
+	case other != (apiv1.VolumeSource{}), (v.PersistentVolumeClaim == nil) == (v.EmptyDir == nil):
+		result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "FunctionVolume.VolumeSource", v.Name,
+			"volumes need exactly one persistentVolumeClaim or emptyDir source"))
+	case v.PersistentVolumeClaim != nil:
+		result = multierror.Append(result, ValidateKubeName("FunctionVolume.PersistentVolumeClaim.ClaimName", v.PersistentVolumeClaim.ClaimName))
+	}
+
+	if !path.IsAbs(v.MountPath) || path.Clean(v.MountPath) == "/" {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "FunctionVolume.MountPath", v.MountPath, "must be an absolute path other than /"))
+	}
+
 	return result.ErrorOrNil()
 }
 
@@ -325,12 +526,12 @@ func (es ExecutionStrategy) Validate() error {
 	var result *multierror.Error
 
 	switch es.ExecutorType {
-	case ExecutorTypeNewdeploy, ExecutorTypePoolmgr: // no op
+	case ExecutorTypeNewdeploy, ExecutorTypePoolmgr, ExecutorTypeContainer: // no op
 	default:
 		result = multierror.Append(result, MakeValidationErr(ErrorUnsupportedType, "ExecutionStrategy.ExecutorType", es.ExecutorType, "not a valid executor type"))
 	}
 
-	if es.ExecutorType == ExecutorTypeNewdeploy {
+	if es.ExecutorType == ExecutorTypeNewdeploy || es.ExecutorType == ExecutorTypeContainer {
 		if es.MinScale < 0 {
 			result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "ExecutionStrategy.MinScale", es.MinScale, "minimum scale must be greater or equal to 0"))
 		}
@@ -383,8 +584,17 @@ func (runtime Runtime) Validate() error {
 }
 
 func (builder Builder) Validate() error {
-	// do nothing for now
-	return nil
+	var result *multierror.Error
+
+	if builder.BuildTimeout < 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.BuildTimeout", builder.BuildTimeout, "BuildTimeout must be greater or equal to 0"))
+	}
+
+	if builder.MaxConcurrentBuilds < 0 {
+		result = multierror.Append(result, MakeValidationErr(ErrorInvalidValue, "Builder.MaxConcurrentBuilds", builder.MaxConcurrentBuilds, "MaxConcurrentBuilds must be greater or equal to 0"))
+	}
+
+	return result.ErrorOrNil()
 }
 
 func (spec EnvironmentSpec) Validate() error {
@@ -19,6 +19,7 @@ package router
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -211,6 +212,15 @@ func (roundTripper RetryingRoundTripper) RoundTrip(req *http.Request) (resp *htt
 			// user function bugs.
 			statusCode, errMsg := fission.GetHTTPError(err)
 			if roundTripper.funcHandler.isDebugEnv {
+				header := make(http.Header, 0)
+				// Tell why the function couldn't be specialized
+				if se, ok := errors.Cause(err).(*fission.SpecializationError); ok {
+					body, jsonErr := json.Marshal(se)
+					if jsonErr == nil {
+						errMsg = string(body)
+						header.Set("Content-Type", "application/json")
+					}
+				}
 				return &http.Response{
 					StatusCode:    statusCode,
 					Proto:         req.Proto,
@@ -219,7 +229,7 @@ func (roundTripper RetryingRoundTripper) RoundTrip(req *http.Request) (resp *htt
 					Body:          ioutil.NopCloser(bytes.NewBufferString(errMsg)),
 					ContentLength: int64(len(errMsg)),
 					Request:       req,
-					Header:        make(http.Header, 0),
+					Header:        header,
 				}, nil
 			}
 			return nil, fission.MakeError(http.StatusInternalServerError, err.Error())
@@ -17,15 +17,18 @@ limitations under the License.
 package router
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
+	executorClient "github.com/fission/fission/executor/client"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -85,3 +88,47 @@ func TestFunctionProxying(t *testing.T) {
 
 	testRequest(fhURL, testResponseString)
 }
+
+func TestSpecializationErrorResponse(t *testing.T) {
+	se := &fission.SpecializationError{
+		Reason:  fission.SpecializationErrorLoadFailed,
+		Message: "entry point not found",
+		Pod:     "env-pod",
+	}
+	executor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fission.WriteSpecializationError(w, se, http.StatusInternalServerError)
+	}))
+	defer executor.Close()
+
+	for _, isDebugEnv := range []bool{true, false} {
+		fh := &functionHandler{
+			fmap:     makeFunctionServiceMap(0),
+			executor: executorClient.MakeClient(executor.URL),
+			function: &metav1.ObjectMeta{Name: "foo", Namespace: metav1.NamespaceDefault},
+			tsRoundTripperParams: &tsRoundTripperParams{
+				timeout:         50 * time.Millisecond,
+				timeoutExponent: 2,
+				keepAlive:       30 * time.Second,
+				maxRetries:      10,
+			},
+			isDebugEnv:         isDebugEnv,
+			svcAddrUpdateLocks: MakeUpdateLocks(30 * time.Second),
+		}
+		w := httptest.NewRecorder()
+		fh.handler(w, httptest.NewRequest("GET", "/", nil))
+
+		if !isDebugEnv {
+			// Only debug environments tell why the function failed
+			if w.Code != http.StatusBadGateway || strings.Contains(w.Body.String(), se.Message) {
+				t.Fatalf("expected bad gateway without specialization error, got %v %q", w.Code, w.Body.String())
+			}
+			continue
+		}
+		var body fission.SpecializationError
+		err := json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/json" ||
+			err != nil || body != *se {
+			t.Fatalf("expected specialization error as JSON, got %v %v %q", w.Code, w.Header(), w.Body.String())
+		}
+	}
+}
@@ -18,11 +18,14 @@ package router
 
 import (
 	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
 	k8sCache "k8s.io/client-go/tools/cache"
@@ -41,6 +44,13 @@ type (
 
 		stopCh chan struct{}
 		store  k8sCache.Store
+
+		// Status writes of the executor and buildermgr change the
+		// resource version of functions, which function services are
+		// keyed on. Functions resolve to the metadata of the version
+		// their spec last changed in instead, kept here by UID.
+		specLock     sync.Mutex
+		specVersions map[types.UID]*crd.Function
 	}
 
 	resolveResultType int
@@ -76,8 +86,9 @@ const (
 
 func makeFunctionReferenceResolver(store k8sCache.Store) *functionReferenceResolver {
 	frr := &functionReferenceResolver{
-		refCache: cache.MakeCache(time.Minute, 0),
-		store:    store,
+		refCache:     cache.MakeCache(time.Minute, 0),
+		store:        store,
+		specVersions: make(map[types.UID]*crd.Function),
 	}
 	return frr
 }
@@ -156,7 +167,7 @@ func (frr *functionReferenceResolver) resolveByName(namespace, name string) (*re
 
 	f := obj.(*crd.Function)
 	functionMetadataMap := make(map[string]*metav1.ObjectMeta, 1)
-	functionMetadataMap[f.Metadata.Name] = &f.Metadata
+	functionMetadataMap[f.Metadata.Name] = frr.specMetadata(f)
 
 	rr := resolveResult{
 		resolveResultType:   resolveResultSingleFunction,
@@ -188,7 +199,7 @@ func (frr *functionReferenceResolver) resolveByFunctionWeights(namespace string,
 		}
 
 		f := obj.(*crd.Function)
-		functionMetadataMap[f.Metadata.Name] = &f.Metadata
+		functionMetadataMap[f.Metadata.Name] = frr.specMetadata(f)
 		sumPrefix = sumPrefix + functionWeight
 		fnWtDistrList = append(fnWtDistrList, FunctionWeightDistribution{
 			name:      functionName,
@@ -207,6 +218,26 @@ func (frr *functionReferenceResolver) resolveByFunctionWeights(namespace string,
 	return &rr, nil
 }
 
+// specMetadata returns the metadata of the version of the function its
+// spec last changed in.
+func (frr *functionReferenceResolver) specMetadata(f *crd.Function) *metav1.ObjectMeta {
+	frr.specLock.Lock()
+	defer frr.specLock.Unlock()
+	specVersion, ok := frr.specVersions[f.Metadata.UID]
+	if !ok || !reflect.DeepEqual(specVersion.Spec, f.Spec) {
+		specVersion = f.DeepCopy()
+		frr.specVersions[f.Metadata.UID] = specVersion
+	}
+	return &specVersion.Metadata
+}
+
+// forget drops the spec version of a deleted function.
+func (frr *functionReferenceResolver) forget(uid types.UID) {
+	frr.specLock.Lock()
+	defer frr.specLock.Unlock()
+	delete(frr.specVersions, uid)
+}
+
 func (frr *functionReferenceResolver) delete(namespace string, triggerName, triggerRV string) error {
 	nfr := namespacedTriggerReference{
 		namespace:              namespace,
@@ -20,6 +20,7 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"reflect"
 	"sort"
 	"strings"
 	"time"
@@ -302,38 +303,49 @@ func (ts *HTTPTriggerSet) initFunctionController() (k8sCache.Store, k8sCache.Con
 			},
 			DeleteFunc: func(obj interface{}) {
 				function := obj.(*crd.Function)
+				ts.resolver.forget(function.Metadata.UID)
 				ts.syncTriggers()
 				go ts.recorderSet.DeleteFunctionFromRecorderMap(function)
 			},
 			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
-				oldFn := oldObj.(*crd.Function)
-				fn := newObj.(*crd.Function)
-
-				if oldFn.Metadata.ResourceVersion == fn.Metadata.ResourceVersion {
-					return
-				}
-
-				// update resolver function reference cache
-				for key, rr := range ts.resolver.copy() {
-					if key.namespace == fn.Metadata.Namespace &&
-						rr.functionMetadataMap[fn.Metadata.Name] != nil &&
-						rr.functionMetadataMap[fn.Metadata.Name].ResourceVersion != fn.Metadata.ResourceVersion {
-						// invalidate resolver cache
-						log.Printf("Invalidating resolver cache")
-						err := ts.resolver.delete(key.namespace, key.triggerName, key.triggerResourceVersion)
-						if err != nil {
-							log.Printf("Error deleting functionReferenceResolver cache: %v", err)
-						}
-
-						break
-					}
-				}
-				ts.syncTriggers()
+				ts.updateFunction(oldObj.(*crd.Function), newObj.(*crd.Function))
 			},
 		})
 	return store, controller
 }
 
+// updateFunction invalidates the resolved references to the function and
+// updates the router when the spec of the function changed.
+func (ts *HTTPTriggerSet) updateFunction(oldFn *crd.Function, fn *crd.Function) {
+	if oldFn.Metadata.ResourceVersion == fn.Metadata.ResourceVersion {
+		return
+	}
+
+	// Status updates written by the executor and buildermgr change the
+	// resource version too; keep resolving to the function service that
+	// is already specialized for it.
+	if reflect.DeepEqual(oldFn.Spec, fn.Spec) {
+		return
+	}
+
+	// update resolver function reference cache
+	for key, rr := range ts.resolver.copy() {
+		if key.namespace == fn.Metadata.Namespace &&
+			rr.functionMetadataMap[fn.Metadata.Name] != nil &&
+			rr.functionMetadataMap[fn.Metadata.Name].ResourceVersion != fn.Metadata.ResourceVersion {
+			// invalidate resolver cache
+			log.Printf("Invalidating resolver cache")
+			err := ts.resolver.delete(key.namespace, key.triggerName, key.triggerResourceVersion)
+			if err != nil {
+				log.Printf("Error deleting functionReferenceResolver cache: %v", err)
+			}
+
+			break
+		}
+	}
+	ts.syncTriggers()
+}
+
 func (ts *HTTPTriggerSet) initRecorderController() (k8sCache.Store, k8sCache.Controller) {
 	resyncPeriod := 30 * time.Second
 	listWatch := k8sCache.NewListWatchFromClient(ts.crdClient, "recorders", metav1.NamespaceAll, fields.Everything())
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/url"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func TestStatusUpdateKeepsFunctionService(t *testing.T) {
+	fn := &crd.Function{
+		Metadata: metav1.ObjectMeta{
+			Name:            "hello",
+			Namespace:       metav1.NamespaceDefault,
+			UID:             "fn-uid",
+			ResourceVersion: "1",
+		},
+		Spec: fission.FunctionSpec{
+			Environment: fission.EnvironmentReference{Namespace: metav1.NamespaceDefault, Name: "nodejs"},
+		},
+	}
+	store := k8sCache.NewStore(k8sCache.MetaNamespaceKeyFunc)
+	store.Add(fn)
+
+	frr := makeFunctionReferenceResolver(store)
+	ts := &HTTPTriggerSet{
+		functionServiceMap:         makeFunctionServiceMap(0),
+		resolver:                   frr,
+		updateRouterRequestChannel: make(chan struct{}, 10),
+	}
+	trigger := crd.HTTPTrigger{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, ResourceVersion: "1"},
+		Spec: fission.HTTPTriggerSpec{
+			RelativeURL: "/hello",
+			FunctionReference: fission.FunctionReference{
+				Type: fission.FunctionReferenceTypeFunctionName,
+				Name: "hello",
+			},
+		},
+	}
+	lookup := func() (*url.URL, error) {
+		rr, err := frr.resolve(trigger)
+		if err != nil {
+			t.Fatalf("error resolving trigger: %v", err)
+		}
+		return ts.functionServiceMap.lookup(rr.functionMetadataMap["hello"])
+	}
+
+	rr, err := frr.resolve(trigger)
+	if err != nil {
+		t.Fatalf("error resolving trigger: %v", err)
+	}
+	svcURL, _ := url.Parse("http://hello.fission-function")
+	ts.functionServiceMap.assign(rr.functionMetadataMap["hello"], svcURL)
+
+	// The executor writing the status of the function doesn't change the
+	// function service, nor updates the router
+	statusFn := fn.DeepCopy()
+	statusFn.Metadata.ResourceVersion = "2"
+	statusFn.Status.SetCondition(fission.FunctionSpecialized, apiv1.ConditionTrue, "Specialized", "")
+	store.Update(statusFn)
+	ts.updateFunction(fn, statusFn)
+	if len(ts.updateRouterRequestChannel) != 0 {
+		t.Fatalf("expected status update not to update the router")
+	}
+	if u, err := lookup(); err != nil || u.String() != svcURL.String() {
+		t.Fatalf("expected function service %v after status update, got %v, %v", svcURL, u, err)
+	}
+
+	// Nor once the resolved reference expires and the latest version of
+	// the function is resolved again
+	frr.delete(trigger.Metadata.Namespace, trigger.Metadata.Name, trigger.Metadata.ResourceVersion)
+	if u, err := lookup(); err != nil || u.String() != svcURL.String() {
+		t.Fatalf("expected function service %v after resolving again, got %v, %v", svcURL, u, err)
+	}
+
+	// Spec changes need a new function service
+	specFn := statusFn.DeepCopy()
+	specFn.Metadata.ResourceVersion = "3"
+	specFn.Spec.Package.FunctionName = "other"
+	store.Update(specFn)
+	ts.updateFunction(statusFn, specFn)
+	if len(ts.updateRouterRequestChannel) != 1 {
+		t.Fatalf("expected spec update to update the router")
+	}
+	if u, err := lookup(); err == nil {
+		t.Fatalf("expected no function service for the new spec, got %v", u)
+	}
+}
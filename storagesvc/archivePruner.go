@@ -71,10 +71,12 @@ func (pruner *ArchivePruner) insertArchive(archiveID string) {
 
 // A user may have deleted pkgs with kubectl or fission cli. That only deletes crd.Package objects from kubernetes
 // and not the archives that are referenced by them, leaving the archives as orphans.
+// Since archives are shared by all packages with the same content, an archive is only
+// an orphan once no package references it anymore.
 // getOrphanArchives reaps the orphaned archives.
 func (pruner *ArchivePruner) getOrphanArchives() error {
 	log.Info("getting orphan archives")
-	archivesRefByPkgs := make([]string, 0)
+	refCounts := make(map[string]int)
 	var archiveID string
 
 	// get all pkgs from kubernetes
@@ -84,41 +86,61 @@ func (pruner *ArchivePruner) getOrphanArchives() error {
 		return err
 	}
 
-	// extract archives referenced by these pkgs
+	// count the pkgs referencing each archive, including from the
+	// revisions the pkgs can be rolled back to
 	for _, pkg := range pkgList.Items {
-		if pkg.Spec.Deployment.URL != "" {
-			archiveID, err = getQueryParamValue(pkg.Spec.Deployment.URL, "id")
-			if err != nil {
-				log.WithError(err).Error("Error extracting value of archiveID from url")
-				return err
-			}
-			archivesRefByPkgs = append(archivesRefByPkgs, archiveID)
+		urls := []string{pkg.Spec.Deployment.URL, pkg.Spec.Source.URL, pkg.Status.BuildLogUrl}
+		for _, rev := range pkg.Status.Revisions {
+			urls = append(urls, rev.DeploymentUrl, rev.BuildLogUrl)
 		}
-		if pkg.Spec.Source.URL != "" {
-			archiveID, err = getQueryParamValue(pkg.Spec.Source.URL, "id")
+		pkgArchives := make(map[string]bool)
+		for _, url := range urls {
+			if url == "" {
+				continue
+			}
+			archiveID, err = getQueryParamValue(url, "id")
 			if err != nil {
 				log.WithError(err).Error("Error extracting value of archiveID from url")
 				return err
 			}
-			archivesRefByPkgs = append(archivesRefByPkgs, archiveID)
+			pkgArchives[archiveID] = true
+		}
+		for archiveID := range pkgArchives {
+			refCounts[archiveID]++
 		}
 	}
 
-	log.WithField("list", "archives referenced by packages").Debugf("%s", archivesRefByPkgs)
+	log.WithField("list", "archive reference counts").Debugf("%v", refCounts)
 
 	// get all archives on storage
 	// out of them, there may be some just created but not referenced by packages yet.
 	// need to filter them out.
-	archivesInStorage, err := pruner.stowClient.getItemIDsWithFilter(filterItemCreatedAMinuteAgo, time.Now())
+	now := time.Now()
+	archivesInStorage, err := pruner.stowClient.getItemIDsWithFilter(filterItemCreatedAMinuteAgo, now)
 	if err != nil {
 		log.WithError(err).Error("Error getting items from storage")
 		return err
 	}
 	log.WithField("list", "archives in storage").Debugf("%s", archivesInStorage)
 
-	// difference of the two lists gives us the list of orphan archives. This is just a brute force approach.
-	// need to do something more optimal at scale.
-	orphanedArchives := getDifferenceOfLists(archivesInStorage, archivesRefByPkgs)
+	// archives without references are orphans, unless they were just
+	// reused by an upload of the same content; the others keep a
+	// reference for each pkg, so that they're removed once the last pkg
+	// using them deletes them
+	orphanedArchives := make([]string, 0)
+	for _, archiveID = range archivesInStorage {
+		if pruner.stowClient.archiveUses.recentlyUsed(archiveID, now) {
+			continue
+		}
+		if refCounts[archiveID] == 0 {
+			orphanedArchives = append(orphanedArchives, archiveID)
+			continue
+		}
+		err = pruner.stowClient.setRefCount(archiveID, refCounts[archiveID])
+		if err != nil {
+			log.WithError(err).Errorf("Error updating references of archive %v", archiveID)
+		}
+	}
 	log.WithField("list", "orphan archives").Debugf("%s", orphanedArchives)
 
 	// send each orphan archive away for deletion
@@ -0,0 +1,170 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/crd/fake"
+)
+
+// makeTestPruner returns a pruner of the storage service's archives, with
+// the packages of the API server.
+func makeTestPruner(ss *StorageService, server *fake.APIServer) *ArchivePruner {
+	fissionClient, _ := server.Clients()
+	return &ArchivePruner{
+		crdClient:   fissionClient,
+		archiveChan: make(chan string, 10),
+		stowClient:  ss.storageClient,
+	}
+}
+
+// ageTestArchive makes the archive older than the archives the pruner
+// leaves alone because they may not be referenced yet.
+func ageTestArchive(t *testing.T, client *StowClient, id string) {
+	item, err := client.writeContainer.Item(id)
+	if err != nil {
+		t.Fatalf("error getting archive %v: %v", id, err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(item.ID(), old, old); err != nil {
+		t.Fatalf("error changing time of archive %v: %v", id, err)
+	}
+}
+
+func archiveUrl(id string) string {
+	return "http://storagesvc/v1/archive?id=" + id
+}
+
+// prunedArchives runs the pruner once and returns the archives it found
+// orphaned.
+func prunedArchives(t *testing.T, pruner *ArchivePruner) []string {
+	err := pruner.getOrphanArchives()
+	if err != nil {
+		t.Fatalf("error getting orphan archives: %v", err)
+	}
+	var orphans []string
+	for len(pruner.archiveChan) > 0 {
+		orphans = append(orphans, <-pruner.archiveChan)
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+func TestPrunerRevisionReferences(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+	server := fake.NewAPIServer()
+	defer server.Close()
+	pruner := makeTestPruner(ss, server)
+
+	ids := make(map[string]string)
+	for _, name := range []string{"current", "previous", "previous-log", "orphan"} {
+		ids[name] = storeTestContent(t, ss.storageClient, []byte(name))
+		ageTestArchive(t, ss.storageClient, ids[name])
+	}
+	// The archives weren't just uploaded
+	ss.storageClient.archiveUses = makeArchiveUses()
+
+	// Archives of the revisions a package can be rolled back to are
+	// referenced by the package
+	pkg := &crd.Package{}
+	pkg.Spec.Deployment = fission.Archive{Type: fission.ArchiveTypeUrl, URL: archiveUrl(ids["current"])}
+	pkg.Status.Revision = 2
+	pkg.Status.Revisions = []fission.PackageRevision{
+		{Revision: 2, DeploymentUrl: archiveUrl(ids["current"])},
+		{Revision: 1, DeploymentUrl: archiveUrl(ids["previous"]), BuildLogUrl: archiveUrl(ids["previous-log"])},
+	}
+	server.Add("/apis/fission.io/v1/namespaces/default/packages/pkg", pkg)
+
+	orphans := prunedArchives(t, pruner)
+	if len(orphans) != 1 || orphans[0] != ids["orphan"] {
+		t.Fatalf("expected only archive without references to be orphaned, got %v", orphans)
+	}
+
+	// Once the revision is dropped, so is the reference
+	pkg.Status.Revisions = pkg.Status.Revisions[:1]
+	server.Add("/apis/fission.io/v1/namespaces/default/packages/pkg", pkg)
+	expected := []string{ids["orphan"], ids["previous"], ids["previous-log"]}
+	sort.Strings(expected)
+	orphans = prunedArchives(t, pruner)
+	if len(orphans) != 3 || orphans[0] != expected[0] || orphans[1] != expected[1] || orphans[2] != expected[2] {
+		t.Fatalf("expected archives of dropped revision to be orphaned, got %v", orphans)
+	}
+}
+
+func TestPrunerRecountsReferences(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+	server := fake.NewAPIServer()
+	defer server.Close()
+	pruner := makeTestPruner(ss, server)
+	client := ss.storageClient
+
+	ids := make(map[string]string)
+	for name, uploads := range map[string]int{"shared": 1, "released": 3, "reused": 1} {
+		ids[name] = storeTestContent(t, client, []byte(name))
+		for i := 0; i < uploads; i++ {
+			err := client.recordUpload(ids[name], int64(len(name)), uploadInfo{namespace: "default"}, nil)
+			if err != nil {
+				t.Fatalf("error recording upload: %v", err)
+			}
+		}
+		ageTestArchive(t, client, ids[name])
+	}
+	client.archiveUses = makeArchiveUses()
+
+	// The shared archive is the source of two packages; the released
+	// archive is the deployment and a revision of one package, which
+	// counts once, and the other packages using it were deleted without
+	// releasing it
+	for name, archives := range map[string][]string{"a": {"shared", "released"}, "b": {"shared"}} {
+		pkg := &crd.Package{}
+		pkg.Spec.Source = fission.Archive{Type: fission.ArchiveTypeUrl, URL: archiveUrl(ids[archives[0]])}
+		if len(archives) > 1 {
+			pkg.Spec.Deployment = fission.Archive{Type: fission.ArchiveTypeUrl, URL: archiveUrl(ids[archives[1]])}
+			pkg.Status.Revisions = []fission.PackageRevision{{Revision: 1, DeploymentUrl: archiveUrl(ids[archives[1]])}}
+		}
+		server.Add("/apis/fission.io/v1/namespaces/default/packages/"+name, pkg)
+	}
+	// The reused archive has no references yet, but was just uploaded
+	// again
+	client.archiveUses.touch(ids["reused"])
+
+	if orphans := prunedArchives(t, pruner); len(orphans) != 0 {
+		t.Fatalf("expected no orphans, got %v", orphans)
+	}
+	for name, expected := range map[string]int{"shared": 2, "released": 1, "reused": 1} {
+		if refCount := testArchiveRefCount(t, client, ids[name]); refCount != expected {
+			t.Fatalf("expected %v references to %v archive, got %v", expected, name, refCount)
+		}
+	}
+
+	// Releasing the archive from the package that still uses it removes
+	// it now
+	if err := client.releaseArchive(ids["released"]); err != nil {
+		t.Fatalf("error releasing archive: %v", err)
+	}
+	if _, _, err := client.findItemForUploadName(ids["released"]); err == nil {
+		t.Fatalf("expected archive to be removed with its last reference")
+	}
+}
@@ -0,0 +1,519 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Resumable uploads send an archive in chunks:
+//
+//   POST  /v1/uploads                  creates an upload, given X-File-Size, X-File-Sha256
+//                                      and the X-Archive-* metadata
+//   PATCH /v1/uploads?id=...           appends the body at the X-Upload-Offset of the upload
+//   HEAD  /v1/uploads?id=...           returns the X-Upload-Offset received so far
+//   POST  /v1/uploads/finalize?id=...  verifies the checksum and stores the archive
+//   DELETE /v1/uploads?id=...          abandons the upload
+//
+// A client whose request fails asks for the offset and continues from
+// there, instead of sending the whole archive again.
+
+// Uploads that don't receive any data for this long are abandoned.
+const chunkedUploadTimeout = 1 * time.Hour
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrOffsetMismatch   = errors.New("offset doesn't match the upload")
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	ErrUploadTooLarge   = errors.New("upload is larger than its declared size")
+
+	// A request writing to an upload stops once a newer request resumes
+	// the upload, e.g. when its client timed out.
+	errUploadSuperseded = errors.New("upload was resumed by another request")
+
+	// An upload that is being stored can't be written to or abandoned.
+	errUploadFinalizing = errors.New("upload is being finalized")
+)
+
+type (
+	// ChunkedUploadStatus describes a resumable upload.
+	ChunkedUploadStatus struct {
+		ID     string `json:"id"`
+		Offset int64  `json:"offset"`
+		Size   int64  `json:"size"`
+	}
+
+	// chunkedUpload assembles the chunks of an upload in a temporary file.
+	// It counts the received bytes for the upload status.
+	//
+	// While it's finalized, the upload keeps its file until it's stored;
+	// once closed, it can't be finalized anymore.
+	chunkedUpload struct {
+		lock       sync.Mutex
+		id         string
+		uploadName string
+		info       uploadInfo
+		size       int64
+		sha256     string
+		file       *os.File
+		offset     int64
+		generation int
+		finalizing bool
+		closed     bool
+		lastActive time.Time
+		extra      interface{}
+
+		// reservation is the quota space reserved for the upload
+		reservation *quotaReservation
+	}
+
+	chunkedUploads struct {
+		lock    sync.Mutex
+		uploads map[string]*chunkedUpload
+	}
+)
+
+func makeChunkedUploads() *chunkedUploads {
+	return &chunkedUploads{
+		uploads: make(map[string]*chunkedUpload),
+	}
+}
+
+func (cu *chunkedUpload) N() int64 {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	return cu.offset
+}
+
+func (cu *chunkedUpload) Err() error {
+	return nil
+}
+
+func (cu *chunkedUpload) Extra() interface{} {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	return cu.extra
+}
+
+func (cu *chunkedUpload) SetExtra(extra interface{}) {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	cu.extra = extra
+}
+
+func (cu *chunkedUpload) status() ChunkedUploadStatus {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	return ChunkedUploadStatus{
+		ID:     cu.id,
+		Offset: cu.offset,
+		Size:   cu.size,
+	}
+}
+
+// resume starts a request writing at offset, which must be the offset
+// received so far. Earlier requests stop writing.
+func (cu *chunkedUpload) resume(offset int64) (int, error) {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	if cu.finalizing || cu.closed {
+		return 0, errUploadFinalizing
+	}
+	if offset != cu.offset {
+		return 0, ErrOffsetMismatch
+	}
+	cu.generation++
+	cu.lastActive = time.Now()
+	return cu.generation, nil
+}
+
+func (cu *chunkedUpload) write(generation int, p []byte) error {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	if generation != cu.generation {
+		return errUploadSuperseded
+	}
+	if cu.offset+int64(len(p)) > cu.size {
+		return ErrUploadTooLarge
+	}
+	n, err := cu.file.WriteAt(p, cu.offset)
+	cu.offset += int64(n)
+	cu.lastActive = time.Now()
+	return err
+}
+
+// appendFrom appends the data of a request to the upload.
+func (cu *chunkedUpload) appendFrom(generation int, r io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			werr := cu.write(generation, buf[:n])
+			if werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// beginFinalize stops requests writing to a complete upload, and keeps
+// it from being abandoned until endFinalize.
+func (cu *chunkedUpload) beginFinalize() error {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	if cu.closed {
+		return ErrUploadNotFound
+	}
+	if cu.finalizing {
+		return errUploadFinalizing
+	}
+	if cu.offset != cu.size {
+		return ErrUploadIncomplete
+	}
+	cu.finalizing = true
+	cu.generation++
+	return nil
+}
+
+func (cu *chunkedUpload) endFinalize() {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	cu.finalizing = false
+}
+
+// markClosed marks the upload as closed, returning false if it was closed
+// already, or if it's being finalized by another request.
+func (cu *chunkedUpload) markClosed(finalizer bool) bool {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	if cu.closed || (cu.finalizing && !finalizer) {
+		return false
+	}
+	cu.closed = true
+	// stop any requests still writing
+	cu.generation++
+	return true
+}
+
+func (cu *chunkedUpload) close() {
+	cu.lock.Lock()
+	defer cu.lock.Unlock()
+	cu.file.Close()
+	os.Remove(cu.file.Name())
+}
+
+func (cus *chunkedUploads) create(uploadName string, info uploadInfo, size int64, sha256sum string, reservation *quotaReservation) (*chunkedUpload, error) {
+	f, err := ioutil.TempFile("", "storagesvc-chunked-")
+	if err != nil {
+		return nil, err
+	}
+
+	cu := &chunkedUpload{
+		id:          uuid.NewV4().String(),
+		uploadName:  uploadName,
+		info:        info,
+		size:        size,
+		sha256:      strings.ToLower(sha256sum),
+		file:        f,
+		lastActive:  time.Now(),
+		reservation: reservation,
+	}
+	if len(cu.uploadName) == 0 {
+		cu.uploadName = cu.id
+	}
+
+	cus.lock.Lock()
+	defer cus.lock.Unlock()
+	cus.uploads[cu.id] = cu
+	return cu, nil
+}
+
+func (cus *chunkedUploads) get(id string) (*chunkedUpload, error) {
+	cus.lock.Lock()
+	defer cus.lock.Unlock()
+	cu, ok := cus.uploads[id]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	return cu, nil
+}
+
+// remove forgets the upload, returning false if it was removed already.
+func (cus *chunkedUploads) remove(cu *chunkedUpload) bool {
+	cus.lock.Lock()
+	defer cus.lock.Unlock()
+	if cus.uploads[cu.id] != cu {
+		return false
+	}
+	delete(cus.uploads, cu.id)
+	return true
+}
+
+// expired returns the uploads that didn't receive data within the timeout.
+func (cus *chunkedUploads) expired(now time.Time) []*chunkedUpload {
+	cus.lock.Lock()
+	defer cus.lock.Unlock()
+
+	var expired []*chunkedUpload
+	for _, cu := range cus.uploads {
+		cu.lock.Lock()
+		if now.Sub(cu.lastActive) > chunkedUploadTimeout {
+			expired = append(expired, cu)
+		}
+		cu.lock.Unlock()
+	}
+	return expired
+}
+
+// removeExpiredUploads periodically abandons inactive uploads, deleting
+// their data.
+func (ss *StorageService) removeExpiredUploads() {
+	for {
+		time.Sleep(chunkedUploadTimeout / 4)
+		for _, cu := range ss.chunkedUploads.expired(time.Now()) {
+			log.Infof("Removing inactive upload %v", cu.id)
+			ss.abandonUpload(cu)
+		}
+	}
+}
+
+// abandonUpload deletes the data of an upload, unless it's being
+// finalized, in which case it returns false.
+func (ss *StorageService) abandonUpload(cu *chunkedUpload) bool {
+	if !cu.markClosed(false) {
+		return false
+	}
+	ss.removeUpload(cu)
+	return true
+}
+
+// finishUpload deletes the data of an upload that is being finalized.
+func (ss *StorageService) finishUpload(cu *chunkedUpload) {
+	if cu.markClosed(true) {
+		ss.removeUpload(cu)
+	}
+}
+
+func (ss *StorageService) removeUpload(cu *chunkedUpload) {
+	if ss.chunkedUploads.remove(cu) {
+		ss.storageClient.uploads.remove(cu.uploadName, cu)
+		ss.storageClient.releaseQuota(cu.reservation)
+		cu.close()
+	}
+}
+
+func (ss *StorageService) getChunkedUpload(w http.ResponseWriter, r *http.Request) *chunkedUpload {
+	id, err := ss.getIdFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	cu, err := ss.chunkedUploads.get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	return cu
+}
+
+func writeChunkedUploadStatus(w http.ResponseWriter, code int, status ChunkedUploadStatus) {
+	w.Header().Set("X-Upload-Offset", strconv.FormatInt(status.Offset, 10))
+	w.Header().Set("X-File-Size", strconv.FormatInt(status.Size, 10))
+	resp, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, "Error marshaling response", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(code)
+	w.Write(resp)
+}
+
+// createChunkedUploadHandler starts a resumable upload of X-File-Size
+// bytes, whose content must match X-File-Sha256.
+func (ss *StorageService) createChunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
+	fileSize, err := strconv.ParseInt(r.Header.Get("X-File-Size"), 10, 64)
+	if err != nil || fileSize < 0 {
+		http.Error(w, "bad X-File-Size header", http.StatusBadRequest)
+		return
+	}
+	sum := r.Header.Get("X-File-Sha256")
+	if len(contentID(sum)) == 0 {
+		http.Error(w, "bad X-File-Sha256 header", http.StatusBadRequest)
+		return
+	}
+
+	info, err := ss.uploadInfoFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	reservation, err := ss.storageClient.reserveQuota(info.namespace, fileSize, contentID(sum))
+	if !writeQuotaError(w, info, err) {
+		return
+	}
+
+	cu, err := ss.chunkedUploads.create(r.URL.Query().Get("archiveID"), info, fileSize, sum, reservation)
+	if err != nil {
+		ss.storageClient.releaseQuota(reservation)
+		log.WithError(err).Error("Error creating upload")
+		http.Error(w, "Error creating upload", http.StatusInternalServerError)
+		return
+	}
+	ss.storageClient.uploads.declareCounter(cu.uploadName, fileSize, cu)
+
+	log.Infof("Created upload %v of %v bytes", cu.id, fileSize)
+	w.Header().Set("Location", fmt.Sprintf("/v1/uploads?id=%v", cu.id))
+	writeChunkedUploadStatus(w, http.StatusCreated, cu.status())
+}
+
+// chunkedUploadOffsetHandler returns the offset to resume an upload from.
+func (ss *StorageService) chunkedUploadOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	cu := ss.getChunkedUpload(w, r)
+	if cu == nil {
+		return
+	}
+	writeChunkedUploadStatus(w, http.StatusOK, cu.status())
+}
+
+// uploadChunkHandler appends the request body to an upload, at the offset
+// given in X-Upload-Offset.
+func (ss *StorageService) uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	cu := ss.getChunkedUpload(w, r)
+	if cu == nil {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("X-Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad X-Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+
+	generation, err := cu.resume(offset)
+	if err != nil {
+		// The client has to ask for the offset again
+		writeChunkedUploadStatus(w, http.StatusConflict, cu.status())
+		return
+	}
+
+	err = cu.appendFrom(generation, r.Body)
+	if err == ErrUploadTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		log.WithError(err).Errorf("Error receiving chunk of upload %v", cu.id)
+		http.Error(w, "Error receiving chunk", http.StatusInternalServerError)
+		return
+	}
+
+	writeChunkedUploadStatus(w, http.StatusOK, cu.status())
+}
+
+// finalizeChunkedUploadHandler verifies the checksum of a complete upload
+// and stores it like an archive uploaded at once.
+func (ss *StorageService) finalizeChunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
+	cu := ss.getChunkedUpload(w, r)
+	if cu == nil {
+		return
+	}
+
+	// Stop other requests from writing to or abandoning the upload while
+	// it's stored
+	err := cu.beginFinalize()
+	switch err {
+	case nil:
+	case ErrUploadNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errUploadFinalizing:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cu.endFinalize()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, io.NewSectionReader(cu.file, 0, cu.size))
+	if err != nil {
+		log.WithError(err).Errorf("Error reading upload %v", cu.id)
+		http.Error(w, "Error reading upload", http.StatusInternalServerError)
+		return
+	}
+	digest := hexdigest(hasher)
+	if digest != cu.sha256 {
+		// The data is broken; the client has to upload it again
+		log.Errorf("Upload %v did not match expected X-File-Sha256 %s, got %s", cu.id, cu.sha256, digest)
+		ss.finishUpload(cu)
+		http.Error(w, "Didn't match expected X-File-Sha256", http.StatusBadRequest)
+		return
+	}
+
+	archiveID, _, err := ss.storageClient.storeContent(cu.file, cu.size, digest)
+	if err != nil {
+		http.Error(w, "Error saving uploaded file", http.StatusInternalServerError)
+		return
+	}
+	err = ss.storageClient.recordUpload(archiveID, cu.size, cu.info, cu.reservation)
+	if err != nil {
+		log.WithError(err).Errorf("Error recording metadata of archive %v", archiveID)
+		http.Error(w, "Error saving uploaded file", http.StatusInternalServerError)
+		return
+	}
+	ss.finishUpload(cu)
+	log.Infof("Completed upload %v as archive %v", cu.id, archiveID)
+
+	resp, err := json.Marshal(&UploadResponse{ID: archiveID})
+	if err != nil {
+		http.Error(w, "Error marshaling response", http.StatusInternalServerError)
+		return
+	}
+	w.Write(resp)
+}
+
+func (ss *StorageService) deleteChunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
+	cu := ss.getChunkedUpload(w, r)
+	if cu == nil {
+		return
+	}
+	if !ss.abandonUpload(cu) {
+		http.Error(w, errUploadFinalizing.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
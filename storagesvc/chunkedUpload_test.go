@@ -0,0 +1,278 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/graymeta/stow"
+	"github.com/graymeta/stow/local"
+)
+
+// makeTestStorageService returns a storage service keeping its archives in
+// a temporary directory, and a function removing it.
+func makeTestStorageService(t *testing.T) (*StorageService, func()) {
+	dir, err := ioutil.TempDir("", "storagesvc-test-")
+	if err != nil {
+		t.Fatalf("error creating storage directory: %v", err)
+	}
+	container, err := ResolveContainer(local.Kind, "archives", stow.ConfigMap{local.ConfigKeyPath: dir})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("error creating storage container: %v", err)
+	}
+	ss := MakeStorageService(MakeStowClient(container), 0)
+	return ss, func() { os.RemoveAll(dir) }
+}
+
+func createTestUpload(t *testing.T, ss *StorageService, data []byte) ChunkedUploadStatus {
+	req := httptest.NewRequest(http.MethodPost, "/v1/uploads", nil)
+	req.Header.Set("X-File-Size", strconv.Itoa(len(data)))
+	req.Header.Set("X-File-Sha256", fmt.Sprintf("%x", sha256.Sum256(data)))
+	w := httptest.NewRecorder()
+	ss.createChunkedUploadHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("error creating upload: %v %v", w.Code, w.Body.String())
+	}
+	var status ChunkedUploadStatus
+	err := json.Unmarshal(w.Body.Bytes(), &status)
+	if err != nil {
+		t.Fatalf("error parsing upload status: %v", err)
+	}
+	return status
+}
+
+func sendTestChunk(ss *StorageService, id string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/v1/uploads?id="+id, bytes.NewReader(chunk))
+	req.Header.Set("X-Upload-Offset", strconv.Itoa(offset))
+	w := httptest.NewRecorder()
+	ss.uploadChunkHandler(w, req)
+	return w
+}
+
+func finalizeTestUpload(ss *StorageService, id string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ss.finalizeChunkedUploadHandler(w, httptest.NewRequest(http.MethodPost, "/v1/uploads/finalize?id="+id, nil))
+	return w
+}
+
+func deleteTestUpload(ss *StorageService, id string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ss.deleteChunkedUploadHandler(w, httptest.NewRequest(http.MethodDelete, "/v1/uploads?id="+id, nil))
+	return w
+}
+
+func assertStoredContent(t *testing.T, ss *StorageService, data []byte) {
+	var stored bytes.Buffer
+	id := contentID(fmt.Sprintf("%x", sha256.Sum256(data)))
+	err := ss.storageClient.copyFileToStream(id, &stored)
+	if err != nil {
+		t.Fatalf("error reading archive %v: %v", id, err)
+	}
+	if !bytes.Equal(stored.Bytes(), data) {
+		t.Fatalf("archive %v has %v bytes, expected %v", id, stored.Len(), len(data))
+	}
+}
+
+func TestChunkedUploadResumeAfterGap(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	status := createTestUpload(t, ss, data)
+
+	w := sendTestChunk(ss, status.ID, 0, data[:4000])
+	if w.Code != http.StatusOK {
+		t.Fatalf("error sending first chunk: %v %v", w.Code, w.Body.String())
+	}
+
+	// A chunk past the received data is refused, telling the client
+	// where to resume
+	w = sendTestChunk(ss, status.ID, 6000, data[6000:])
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected chunk after a gap to conflict, got %v", w.Code)
+	}
+	if offset := w.Header().Get("X-Upload-Offset"); offset != "4000" {
+		t.Fatalf("expected offset 4000 to resume from, got %v", offset)
+	}
+
+	// An incomplete upload can't be finalized
+	w = finalizeTestUpload(ss, status.ID)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected incomplete upload to be refused, got %v", w.Code)
+	}
+
+	w = sendTestChunk(ss, status.ID, 4000, data[4000:])
+	if w.Code != http.StatusOK {
+		t.Fatalf("error resuming upload: %v %v", w.Code, w.Body.String())
+	}
+
+	w = finalizeTestUpload(ss, status.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error finalizing upload: %v %v", w.Code, w.Body.String())
+	}
+	assertStoredContent(t, ss, data)
+
+	if _, err := ss.chunkedUploads.get(status.ID); err != ErrUploadNotFound {
+		t.Fatalf("finalized upload is still registered")
+	}
+}
+
+func TestChunkedUploadGeneration(t *testing.T) {
+	cus := makeChunkedUploads()
+	cu, err := cus.create("", uploadInfo{}, 6, "", nil)
+	if err != nil {
+		t.Fatalf("error creating upload: %v", err)
+	}
+	defer cu.close()
+
+	old, err := cu.resume(0)
+	if err != nil {
+		t.Fatalf("error starting upload: %v", err)
+	}
+	if err := cu.write(old, []byte("ab")); err != nil {
+		t.Fatalf("error writing upload: %v", err)
+	}
+
+	// A request resuming the upload stops the earlier one
+	if _, err := cu.resume(0); err != ErrOffsetMismatch {
+		t.Fatalf("expected resume at a stale offset to fail with %v, got %v", ErrOffsetMismatch, err)
+	}
+	current, err := cu.resume(2)
+	if err != nil {
+		t.Fatalf("error resuming upload: %v", err)
+	}
+	if err := cu.write(old, []byte("xx")); err != errUploadSuperseded {
+		t.Fatalf("expected superseded write to fail with %v, got %v", errUploadSuperseded, err)
+	}
+	if err := cu.write(current, []byte("cdefg")); err != ErrUploadTooLarge {
+		t.Fatalf("expected oversized write to fail with %v, got %v", ErrUploadTooLarge, err)
+	}
+	if err := cu.write(current, []byte("cdef")); err != nil {
+		t.Fatalf("error writing upload: %v", err)
+	}
+	if cu.N() != 6 {
+		t.Fatalf("expected 6 bytes received, got %v", cu.N())
+	}
+
+	// Finalizing stops writers and keeps the upload from being closed
+	if err := cu.beginFinalize(); err != nil {
+		t.Fatalf("error finalizing upload: %v", err)
+	}
+	if err := cu.write(current, nil); err != errUploadSuperseded {
+		t.Fatalf("expected write while finalizing to fail with %v, got %v", errUploadSuperseded, err)
+	}
+	if _, err := cu.resume(6); err != errUploadFinalizing {
+		t.Fatalf("expected resume while finalizing to fail with %v, got %v", errUploadFinalizing, err)
+	}
+	if err := cu.beginFinalize(); err != errUploadFinalizing {
+		t.Fatalf("expected concurrent finalize to fail with %v, got %v", errUploadFinalizing, err)
+	}
+	if cu.markClosed(false) {
+		t.Fatalf("upload was closed while finalizing")
+	}
+	cu.endFinalize()
+	if !cu.markClosed(false) {
+		t.Fatalf("upload was not closed after finalizing")
+	}
+	if err := cu.beginFinalize(); err != ErrUploadNotFound {
+		t.Fatalf("expected finalize of closed upload to fail with %v, got %v", ErrUploadNotFound, err)
+	}
+}
+
+func TestChunkedUploadAbandonDuringFinalize(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+
+	for i := 0; i < 20; i++ {
+		data := bytes.Repeat([]byte(fmt.Sprintf("%04d", i)), 64*1024)
+		status := createTestUpload(t, ss, data)
+		if w := sendTestChunk(ss, status.ID, 0, data); w.Code != http.StatusOK {
+			t.Fatalf("error sending upload: %v %v", w.Code, w.Body.String())
+		}
+		cu, err := ss.chunkedUploads.get(status.ID)
+		if err != nil {
+			t.Fatalf("error getting upload: %v", err)
+		}
+
+		var finalized, deleted *httptest.ResponseRecorder
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			finalized = finalizeTestUpload(ss, status.ID)
+		}()
+		go func() {
+			defer wg.Done()
+			deleted = deleteTestUpload(ss, status.ID)
+		}()
+		wg.Wait()
+
+		// Either the upload is stored completely, or it's abandoned
+		// before anything is stored
+		id := contentID(fmt.Sprintf("%x", sha256.Sum256(data)))
+		switch finalized.Code {
+		case http.StatusOK:
+			if deleted.Code == http.StatusOK {
+				t.Fatalf("upload was both finalized and abandoned")
+			}
+			assertStoredContent(t, ss, data)
+		case http.StatusNotFound:
+			if deleted.Code != http.StatusOK {
+				t.Fatalf("upload was neither finalized nor abandoned: %v", deleted.Code)
+			}
+			if _, _, err := ss.storageClient.findItemForUploadName(id); err == nil {
+				t.Fatalf("abandoned upload was stored as archive %v", id)
+			}
+		default:
+			t.Fatalf("unexpected finalize response: %v %v", finalized.Code, finalized.Body.String())
+		}
+
+		if _, err := ss.chunkedUploads.get(status.ID); err != ErrUploadNotFound {
+			t.Fatalf("upload is still registered")
+		}
+		if _, err := os.Stat(cu.file.Name()); !os.IsNotExist(err) {
+			t.Fatalf("data of upload was not removed")
+		}
+	}
+
+	// An upload being finalized can't be abandoned
+	data := []byte("finalizing")
+	status := createTestUpload(t, ss, data)
+	sendTestChunk(ss, status.ID, 0, data)
+	cu, _ := ss.chunkedUploads.get(status.ID)
+	if err := cu.beginFinalize(); err != nil {
+		t.Fatalf("error finalizing upload: %v", err)
+	}
+	if w := deleteTestUpload(ss, status.ID); w.Code != http.StatusConflict {
+		t.Fatalf("expected abandoning a finalizing upload to conflict, got %v", w.Code)
+	}
+	cu.endFinalize()
+	if w := deleteTestUpload(ss, status.ID); w.Code != http.StatusOK {
+		t.Fatalf("error abandoning upload: %v", w.Code)
+	}
+}
@@ -19,6 +19,8 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -54,8 +56,11 @@ func MakeClient(url string) *Client {
 }
 
 // Upload sends the local file pointed to by filePath to the storage
-// service, along with the metadata.  It returns a file ID that can be
-// used to retrieve the file.
+// service, along with the metadata, whose keys are the storagesvc
+// Metadata* constants.  It returns a file ID that can be
+// used to retrieve the file.  Files whose content is stored already
+// aren't sent again, others are sent in chunks so that the upload can
+// resume after a failed request.
 func (c *Client) Upload(ctx context.Context, filePath string, metadata *map[string]string) (string, error) {
 	fi, err := os.Stat(filePath)
 	if err != nil {
@@ -63,14 +68,41 @@ func (c *Client) Upload(ctx context.Context, filePath string, metadata *map[stri
 	}
 	fileSize := fi.Size()
 
-	buf := &bytes.Buffer{}
-	bodyWriter := multipart.NewWriter(buf)
-	fileWriter, err := bodyWriter.CreateFormFile("uploadfile", filePath)
+	f, err := os.Open(filePath)
 	if err != nil {
 		return "", err
 	}
+	defer f.Close()
 
-	f, err := os.Open(filePath)
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	id, err := c.lookup(ctx, sum, metadata)
+	if err != nil {
+		return "", err
+	}
+	if len(id) > 0 {
+		return id, nil
+	}
+
+	id, err = c.uploadResumable(ctx, f, fileSize, sum, metadata)
+	if err != errResumableUnsupported {
+		return id, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(buf)
+	// the storage service expects this file name
+	fileWriter, err := bodyWriter.CreateFormFile("uploadfile", "uploaded")
 	if err != nil {
 		return "", err
 	}
@@ -88,6 +120,8 @@ func (c *Client) Upload(ctx context.Context, filePath string, metadata *map[stri
 		return "", err
 	}
 	req.Header["X-File-Size"] = []string{fmt.Sprintf("%v", fileSize)}
+	req.Header["X-File-Sha256"] = []string{sum}
+	setMetadataHeaders(req, metadata)
 	req.Header["Content-Type"] = []string{contentType}
 
 	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
@@ -113,6 +147,100 @@ func (c *Client) Upload(ctx context.Context, filePath string, metadata *map[stri
 	return ur.ID, nil
 }
 
+// Lookup returns the ID of the archive with the given SHA256 checksum,
+// or an empty string if the storage service doesn't have it.
+func (c *Client) Lookup(ctx context.Context, sha256sum string) (string, error) {
+	return c.lookup(ctx, sha256sum, nil)
+}
+
+// lookup finds an archive before uploading the same content, recording
+// the metadata of the upload for the archive.
+func (c *Client) lookup(ctx context.Context, sha256sum string, metadata *map[string]string) (string, error) {
+	req, err := http.NewRequest(http.MethodHead, fmt.Sprintf("%v/archive?sha256=%v", c.url, url.QueryEscape(sha256sum)), nil)
+	if err != nil {
+		return "", err
+	}
+	setMetadataHeaders(req, metadata)
+
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden {
+		return "", errors.New("storage quota exceeded")
+	}
+
+	// Older storage services don't support lookups, just upload then
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+	return resp.Header.Get("X-Archive-Id"), nil
+}
+
+func setMetadataHeaders(req *http.Request, metadata *map[string]string) {
+	if metadata == nil {
+		return
+	}
+	for k, v := range *metadata {
+		if len(v) > 0 {
+			req.Header.Set("X-Archive-"+k, v)
+		}
+	}
+}
+
+// List returns a page of the stored archives, optionally only those of a
+// namespace or package. cont is the Continue of the previous page.
+func (c *Client) List(ctx context.Context, namespace string, pkg string, limit int, cont string) (*storagesvc.ArchiveList, error) {
+	query := url.Values{}
+	query.Set("namespace", namespace)
+	query.Set("package", pkg)
+	query.Set("continue", cont)
+	if limit > 0 {
+		query.Set("limit", fmt.Sprintf("%v", limit))
+	}
+
+	var list storagesvc.ArchiveList
+	err := c.getJSON(ctx, fmt.Sprintf("%v/archives?%v", c.url, query.Encode()), &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// GetMetadata returns the metadata of the archive with the given ID.
+func (c *Client) GetMetadata(ctx context.Context, id string) (*storagesvc.ArchiveMetadata, error) {
+	var m storagesvc.ArchiveMetadata
+	err := c.getJSON(ctx, fmt.Sprintf("%v/metadata?id=%v", c.url, url.QueryEscape(id)), &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Usage returns the storage used by each namespace and its quota.
+func (c *Client) Usage(ctx context.Context) ([]storagesvc.NamespaceUsage, error) {
+	var usage []storagesvc.NamespaceUsage
+	err := c.getJSON(ctx, c.url+"/usage", &usage)
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, u string, v interface{}) error {
+	resp, err := ctxhttp.Get(ctx, c.httpClient, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
 // GetUrl returns an HTTP URL that can be used to download the file pointed to by ID
 func (c *Client) GetUrl(id string) string {
 	return fmt.Sprintf("%v/archive?id=%v", c.url, url.PathEscape(id))
@@ -121,9 +249,19 @@ func (c *Client) GetUrl(id string) string {
 // Download fetches the file identified by ID to the local file path.
 // filePath must not exist.
 func (c *Client) Download(ctx context.Context, id string, filePath string) error {
-	// url for id
-	url := c.GetUrl(id)
+	return c.download(ctx, c.GetUrl(id), filePath)
+}
+
+// DownloadPackageArchive is Download of an archive of a package, for
+// the controller, which only downloads archives for packages using them.
+func (c *Client) DownloadPackageArchive(ctx context.Context, id string, namespace string, pkg string, filePath string) error {
+	query := url.Values{}
+	query.Set("namespace", namespace)
+	query.Set("package", pkg)
+	return c.download(ctx, c.GetUrl(id)+"&"+query.Encode(), filePath)
+}
 
+func (c *Client) download(ctx context.Context, url string, filePath string) error {
 	// quit if file exists
 	_, err := os.Stat(filePath)
 	if err == nil || !os.IsNotExist(err) {
@@ -161,8 +299,20 @@ func (c *Client) Download(ctx context.Context, id string, filePath string) error
 }
 
 func (c *Client) Delete(ctx context.Context, id string) error {
-	url := c.GetUrl(id)
+	return c.delete(ctx, c.GetUrl(id))
+}
+
+// DeletePackageArchive is Delete of an archive of a package, for the
+// controller, which only deletes archives for packages using them or
+// they were uploaded for.
+func (c *Client) DeletePackageArchive(ctx context.Context, id string, namespace string, pkg string) error {
+	query := url.Values{}
+	query.Set("namespace", namespace)
+	query.Set("package", pkg)
+	return c.delete(ctx, c.GetUrl(id)+"&"+query.Encode())
+}
 
+func (c *Client) delete(ctx context.Context, url string) error {
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
 		return err
@@ -0,0 +1,253 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/fission/fission/storagesvc"
+)
+
+const (
+	// Archives are sent in chunks of this size, so that a failed
+	// request only loses one chunk.
+	uploadChunkSize = 8 * 1024 * 1024
+
+	// Failed requests are retried this many times in a row, waiting
+	// longer each time, before giving up.
+	maxUploadRetries = 8
+)
+
+// Storage services that don't support resumable uploads get the whole
+// archive in one request.
+var errResumableUnsupported = errors.New("resumable uploads are not supported")
+
+// httpError is an unexpected response of the storage service.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("HTTP error %v: %v", e.code, e.msg)
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	return &httpError{code: resp.StatusCode, msg: string(body)}
+}
+
+// retriable returns whether a request may succeed when it's sent again;
+// network errors and server errors may be temporary.
+func retriable(err error) bool {
+	herr, ok := err.(*httpError)
+	return !ok || herr.code >= http.StatusInternalServerError || herr.code == http.StatusConflict
+}
+
+// backoff waits before the given retry of a request.
+func backoff(ctx context.Context, retry int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(1<<uint(retry-1)) * 500 * time.Millisecond):
+		return nil
+	}
+}
+
+func (c *Client) uploadUrl(path string, id string) string {
+	return fmt.Sprintf("%v/uploads%v?id=%v", c.url, path, url.QueryEscape(id))
+}
+
+// uploadResumable sends the file in chunks, resuming from the last
+// received offset when a request fails. It returns the archive ID.
+func (c *Client) uploadResumable(ctx context.Context, f io.ReaderAt, fileSize int64, sha256sum string, metadata *map[string]string) (string, error) {
+	status, err := c.createUpload(ctx, fileSize, sha256sum, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	id := status.ID
+	offset := status.Offset
+	retries := 0
+	for offset < fileSize {
+		chunkSize := fileSize - offset
+		if chunkSize > uploadChunkSize {
+			chunkSize = uploadChunkSize
+		}
+
+		status, err = c.uploadChunk(ctx, id, io.NewSectionReader(f, offset, chunkSize), offset, chunkSize)
+		if err == nil {
+			offset = status.Offset
+			retries = 0
+			continue
+		}
+
+		retries++
+		if !retriable(err) || retries > maxUploadRetries {
+			c.abandonUpload(ctx, id)
+			return "", err
+		}
+		if err := backoff(ctx, retries); err != nil {
+			return "", err
+		}
+
+		// Ask the storage service how much it got before continuing
+		status, err = c.uploadOffset(ctx, id)
+		if err != nil && !retriable(err) {
+			return "", err
+		} else if err == nil {
+			offset = status.Offset
+		}
+	}
+
+	for retries = 0; ; retries++ {
+		archiveID, err := c.finalizeUpload(ctx, id)
+		if err == nil {
+			return archiveID, nil
+		}
+		if herr, ok := err.(*httpError); ok && herr.code == http.StatusNotFound {
+			// A previous attempt whose response was lost may have
+			// stored the archive already
+			archiveID, lerr := c.lookup(ctx, sha256sum, metadata)
+			if lerr == nil && len(archiveID) > 0 {
+				return archiveID, nil
+			}
+		}
+		if !retriable(err) || retries >= maxUploadRetries {
+			return "", err
+		}
+		if err := backoff(ctx, retries+1); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (c *Client) doUploadRequest(ctx context.Context, req *http.Request, expectedCode int) (*storagesvc.ChunkedUploadStatus, error) {
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedCode {
+		return nil, responseError(resp)
+	}
+
+	var status storagesvc.ChunkedUploadStatus
+	if req.Method == http.MethodHead {
+		status.Offset, err = strconv.ParseInt(resp.Header.Get("X-Upload-Offset"), 10, 64)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&status)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Client) createUpload(ctx context.Context, fileSize int64, sha256sum string, metadata *map[string]string) (*storagesvc.ChunkedUploadStatus, error) {
+	req, err := http.NewRequest(http.MethodPost, c.url+"/uploads", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-File-Size", strconv.FormatInt(fileSize, 10))
+	req.Header.Set("X-File-Sha256", sha256sum)
+	setMetadataHeaders(req, metadata)
+
+	status, err := c.doUploadRequest(ctx, req, http.StatusCreated)
+	if herr, ok := err.(*httpError); ok &&
+		(herr.code == http.StatusNotFound || herr.code == http.StatusMethodNotAllowed) {
+		return nil, errResumableUnsupported
+	}
+	return status, err
+}
+
+func (c *Client) uploadChunk(ctx context.Context, id string, chunk io.Reader, offset int64, chunkSize int64) (*storagesvc.ChunkedUploadStatus, error) {
+	req, err := http.NewRequest(http.MethodPatch, c.uploadUrl("", id), chunk)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = chunkSize
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("X-Upload-Offset", strconv.FormatInt(offset, 10))
+
+	status, err := c.doUploadRequest(ctx, req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	status.ID = id
+	return status, nil
+}
+
+func (c *Client) uploadOffset(ctx context.Context, id string) (*storagesvc.ChunkedUploadStatus, error) {
+	req, err := http.NewRequest(http.MethodHead, c.uploadUrl("", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	status, err := c.doUploadRequest(ctx, req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	status.ID = id
+	return status, nil
+}
+
+func (c *Client) finalizeUpload(ctx context.Context, id string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, c.uploadUrl("/finalize", id), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+
+	var ur storagesvc.UploadResponse
+	err = json.NewDecoder(resp.Body).Decode(&ur)
+	if err != nil {
+		return "", err
+	}
+	return ur.ID, nil
+}
+
+// abandonUpload frees the data of a failed upload on the storage service.
+func (c *Client) abandonUpload(ctx context.Context, id string) {
+	req, err := http.NewRequest(http.MethodDelete, c.uploadUrl("", id), nil)
+	if err != nil {
+		return
+	}
+	resp, err := ctxhttp.Do(ctx, c.httpClient, req)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
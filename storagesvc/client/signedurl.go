@@ -0,0 +1,232 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/fission/fission/storagesvc"
+)
+
+// ArchiveUrlSigner gets signed, expiring download URLs of archives from the
+// storage service. Without an issuer token, URLs are used as they are.
+type ArchiveUrlSigner struct {
+	client      *Client
+	issuerToken string
+}
+
+func MakeArchiveUrlSigner(storageSvcUrl string, issuerToken string) *ArchiveUrlSigner {
+	return &ArchiveUrlSigner{
+		client:      MakeClient(storageSvcUrl),
+		issuerToken: issuerToken,
+	}
+}
+
+func (s *ArchiveUrlSigner) Enabled() bool {
+	return len(s.issuerToken) > 0
+}
+
+// Authorize adds the issuer token to a request to the storage service.
+func (s *ArchiveUrlSigner) Authorize(req *http.Request) {
+	if s.Enabled() {
+		req.Header.Set("Authorization", "Bearer "+s.issuerToken)
+	}
+}
+
+// archiveID returns the ID of the archive of a storage service download
+// URL, or an empty ID for other URLs, e.g. of archives on other web
+// servers, which are used as they are.
+func archiveID(archiveUrl string) (*url.URL, string, error) {
+	u, err := url.Parse(archiveUrl)
+	if err != nil {
+		return nil, "", err
+	}
+	if !strings.HasSuffix(u.Path, "/v1/archive") {
+		return u, "", nil
+	}
+	return u, u.Query().Get("id"), nil
+}
+
+// Sign returns archiveUrl with a signature valid for ttl. URLs that aren't
+// storage service download URLs are returned as they are; the issuer
+// token is only ever sent to the configured storage service.
+func (s *ArchiveUrlSigner) Sign(ctx context.Context, archiveUrl string, ttl time.Duration) (string, error) {
+	if !s.Enabled() || len(archiveUrl) == 0 {
+		return archiveUrl, nil
+	}
+	u, id, err := archiveID(archiveUrl)
+	if err != nil {
+		return "", err
+	}
+	if len(id) == 0 {
+		return archiveUrl, nil
+	}
+
+	signUrl := fmt.Sprintf("%v/downloadurl?id=%v&ttl=%v", s.client.url, url.QueryEscape(id), int64(ttl.Seconds()))
+	req, err := http.NewRequest(http.MethodPost, signUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	s.Authorize(req)
+	return signUrlWith(ctx, s.client.httpClient, req, u)
+}
+
+// SignWithFetchGrant returns archiveUrl with a signature, using a fetch
+// grant of the archive instead of the issuer token. The signature is
+// valid for ttl, or less if the storage service limits it.
+func SignWithFetchGrant(ctx context.Context, archiveUrl string, grant string, ttl time.Duration) (string, error) {
+	u, id, err := archiveID(archiveUrl)
+	if err != nil {
+		return "", err
+	}
+	if len(id) == 0 {
+		return archiveUrl, nil
+	}
+
+	// The storage service signing the URL is the one serving it
+	storageSvcUrl := url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/v1/archive")}
+	client := MakeClient(storageSvcUrl.String())
+
+	signUrl := fmt.Sprintf("%v/downloadurl?id=%v&ttl=%v", client.url, url.QueryEscape(id), int64(ttl.Seconds()))
+	req, err := http.NewRequest(http.MethodPost, signUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(storagesvc.FetchGrantHeader, grant)
+	return signUrlWith(ctx, client.httpClient, req, u)
+}
+
+// signUrlWith sends a signing request, and returns the URL with the
+// signature it gets.
+func signUrlWith(ctx context.Context, httpClient *http.Client, req *http.Request, u *url.URL) (string, error) {
+	var sig storagesvc.DownloadUrlSignature
+	err := doSignerRequest(ctx, httpClient, req, &sig)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set(storagesvc.SignedUrlExpires, strconv.FormatInt(sig.Expires, 10))
+	query.Set(storagesvc.SignedUrlSignature, sig.Signature)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// FetchGrant returns a fetch grant of the archive, which fetchers without
+// the issuer token use to get signed URLs of it with SignWithFetchGrant.
+// It returns an empty grant without an issuer token, and for URLs that
+// aren't storage service download URLs.
+func (s *ArchiveUrlSigner) FetchGrant(ctx context.Context, archiveUrl string) (string, error) {
+	if !s.Enabled() || len(archiveUrl) == 0 {
+		return "", nil
+	}
+	_, id, err := archiveID(archiveUrl)
+	if err != nil {
+		return "", err
+	}
+	if len(id) == 0 {
+		return "", nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%v/fetchgrant?id=%v", s.client.url, url.QueryEscape(id)), nil)
+	if err != nil {
+		return "", err
+	}
+	s.Authorize(req)
+
+	var grant storagesvc.FetchGrant
+	err = doSignerRequest(ctx, s.client.httpClient, req, &grant)
+	if err != nil {
+		return "", err
+	}
+	return grant.Grant, nil
+}
+
+// Metadata returns the metadata of the archive with the given ID, which
+// needs the issuer token if the storage service signs URLs.
+func (s *ArchiveUrlSigner) Metadata(ctx context.Context, id string) (*storagesvc.ArchiveMetadata, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v/metadata?id=%v", s.client.url, url.QueryEscape(id)), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.Authorize(req)
+
+	var m storagesvc.ArchiveMetadata
+	err = doSignerRequest(ctx, s.client.httpClient, req, &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// UploadGrant returns a grant for uploads of the namespace and package,
+// valid for ttl, to be sent as the storagesvc.MetadataGrant metadata.
+// Without an issuer token, it returns an empty grant.
+func (s *ArchiveUrlSigner) UploadGrant(ctx context.Context, namespace string, pkg string, ttl time.Duration) (string, error) {
+	if !s.Enabled() || len(namespace) == 0 {
+		return "", nil
+	}
+
+	grantUrl := fmt.Sprintf("%v/uploadgrant?namespace=%v&package=%v&ttl=%v", s.client.url,
+		url.QueryEscape(namespace), url.QueryEscape(pkg), int64(ttl.Seconds()))
+	req, err := http.NewRequest(http.MethodPost, grantUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	s.Authorize(req)
+
+	var grant storagesvc.UploadGrant
+	err = doSignerRequest(ctx, s.client.httpClient, req, &grant)
+	if err != nil {
+		return "", err
+	}
+	return grant.Grant, nil
+}
+
+// GrantUpload adds an upload grant for the namespace and package of the
+// metadata to it.
+func (s *ArchiveUrlSigner) GrantUpload(ctx context.Context, metadata map[string]string, ttl time.Duration) error {
+	grant, err := s.UploadGrant(ctx, metadata[storagesvc.MetadataNamespace], metadata[storagesvc.MetadataPackage], ttl)
+	if err != nil {
+		return err
+	}
+	if len(grant) > 0 {
+		metadata[storagesvc.MetadataGrant] = grant
+	}
+	return nil
+}
+
+func doSignerRequest(ctx context.Context, httpClient *http.Client, req *http.Request, v interface{}) error {
+	resp, err := ctxhttp.Do(ctx, httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
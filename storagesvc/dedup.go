@@ -0,0 +1,170 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Archives are stored by the SHA256 digest of their content, so that
+// uploading the same content again doesn't store another copy.
+const contentIDPrefix = "sha256-"
+
+// An archive that was looked up or uploaded again isn't pruned for this
+// long, giving the client time to create the package referencing it.
+const archiveReuseGracePeriod = 5 * time.Minute
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	validSHA256 = regexp.MustCompile("^[0-9a-f]{64}$")
+)
+
+type (
+	// archiveUses records when archives were last reused, since their
+	// last modification time doesn't change when they're deduplicated.
+	archiveUses struct {
+		lock     sync.Mutex
+		lastUsed map[string]time.Time
+	}
+)
+
+func makeArchiveUses() *archiveUses {
+	return &archiveUses{
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+func (au *archiveUses) touch(id string) {
+	au.lock.Lock()
+	defer au.lock.Unlock()
+	au.lastUsed[id] = time.Now()
+}
+
+// recentlyUsed returns whether the archive was used within the grace
+// period before now, forgetting older uses.
+func (au *archiveUses) recentlyUsed(id string, now time.Time) bool {
+	au.lock.Lock()
+	defer au.lock.Unlock()
+	t, ok := au.lastUsed[id]
+	if !ok {
+		return false
+	}
+	if now.Sub(t) > archiveReuseGracePeriod {
+		delete(au.lastUsed, id)
+		return false
+	}
+	return true
+}
+
+// contentID returns the ID of the archive with the given SHA256 digest, or
+// an empty string if the digest isn't valid.
+func contentID(sha256sum string) string {
+	sha256sum = strings.ToLower(sha256sum)
+	if !validSHA256.MatchString(sha256sum) {
+		return ""
+	}
+	return contentIDPrefix + sha256sum
+}
+
+// putContent writes the file on the storage under the digest of its
+// content, unless the same content is stored already. The upload is
+// tracked under uploadName while it's running. It returns the ID of the
+// file and whether it was stored before.
+func (client *StowClient) putContent(reader io.Reader, fileSize int64, uploadName string, expectedSHA256 string) (string, bool, error) {
+	if uploadName == "" {
+		uploadName = uuid.NewV4().String()
+	}
+
+	r := client.uploads.declare(uploadName, fileSize, reader)
+	defer client.uploads.remove(uploadName, r)
+
+	// The digest is only known once the whole file is read, so keep it
+	// in a temporary file until then.
+	tmp, err := ioutil.TempFile("", "storagesvc-upload-")
+	if err != nil {
+		log.WithError(err).Error("Error creating temporary file for upload")
+		return "", false, ErrWritingFile
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err != nil {
+		log.WithError(err).Errorf("Error receiving upload %s", uploadName)
+		return "", false, ErrWritingFile
+	}
+
+	digest := hexdigest(hasher)
+	if expectedSHA256 != "" && !strings.EqualFold(digest, expectedSHA256) {
+		log.Errorf("Upload %s did not match expected X-File-Sha256 %s, got %s", uploadName, expectedSHA256, digest)
+		return "", false, ErrChecksumMismatch
+	}
+
+	return client.storeContent(tmp, size, digest)
+}
+
+// storeContent writes the file, whose SHA256 digest is known already, on
+// the storage unless the same content is stored already.
+func (client *StowClient) storeContent(f *os.File, size int64, digest string) (string, bool, error) {
+	id := contentID(digest)
+	client.archiveUses.touch(id)
+
+	_, _, err := client.findItemForUploadName(id)
+	if err == nil {
+		log.Infof("Upload has the same content as archive %s, not storing it again", id)
+		return id, true, nil
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", false, ErrWritingFile
+	}
+	_, err = client.writeContainer.Put(id, f, size, nil)
+	if err != nil {
+		log.WithError(err).Errorf("Error writing file: %s on storage, size %d", id, size)
+		// Partly written content must not be taken for the archive
+		client.removePartialContent(id)
+		return "", false, ErrWritingFile
+	}
+
+	log.Debugf("Successfully wrote file:%s on storage", id)
+	return id, false, nil
+}
+
+func (client *StowClient) removePartialContent(id string) {
+	item, err := client.writeContainer.Item(id)
+	if err != nil {
+		return
+	}
+	err = client.writeContainer.RemoveItem(item.ID())
+	if err != nil {
+		log.WithError(err).Errorf("Error removing partly written file %s", id)
+	}
+}
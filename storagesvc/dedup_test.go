@@ -0,0 +1,139 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func putTestContent(t *testing.T, client *StowClient, data []byte, expectedSHA256 string) (string, bool) {
+	id, existed, err := client.putContent(bytes.NewReader(data), int64(len(data)), "", expectedSHA256)
+	if err != nil {
+		t.Fatalf("error putting content: %v", err)
+	}
+	return id, existed
+}
+
+func testArchiveRefCount(t *testing.T, client *StowClient, id string) int {
+	m, err := client.readMetadata(id)
+	if err != nil {
+		t.Fatalf("error reading metadata of %v: %v", id, err)
+	}
+	return m.RefCount
+}
+
+func TestContentID(t *testing.T) {
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("content")))
+	if id := contentID(sum); id != "sha256-"+sum {
+		t.Fatalf("expected ID of digest, got %v", id)
+	}
+	if id := contentID(strings.ToUpper(sum)); id != "sha256-"+sum {
+		t.Fatalf("expected ID of upper case digest to be lower case, got %v", id)
+	}
+	for _, invalid := range []string{"", "abc", sum + "0", "../" + sum[3:], strings.Replace(sum, sum[:1], "g", 1)} {
+		if id := contentID(invalid); id != "" {
+			t.Fatalf("expected no ID for invalid digest %q, got %v", invalid, id)
+		}
+	}
+}
+
+func TestPutContentDeduplicates(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+	client := ss.storageClient
+	data := []byte("content")
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	id, existed := putTestContent(t, client, data, sum)
+	if id != contentID(sum) || existed {
+		t.Fatalf("expected new archive named by its digest, got %v %v", id, existed)
+	}
+	other, existed := putTestContent(t, client, data, "")
+	if other != id || !existed {
+		t.Fatalf("expected same content to be stored once, got %v %v", other, existed)
+	}
+	if ids, _ := client.getItemIDsWithFilter(filterItemCreatedAMinuteAgo, time.Now().Add(time.Hour)); len(ids) != 1 {
+		t.Fatalf("expected one stored archive, got %v", ids)
+	}
+	if !client.archiveUses.recentlyUsed(id, time.Now()) {
+		t.Fatalf("expected reused archive to be recently used")
+	}
+
+	// Content not matching the expected digest isn't stored
+	_, _, err := client.putContent(bytes.NewReader([]byte("other")), 5, "", sum)
+	if err != ErrChecksumMismatch {
+		t.Fatalf("expected %v, got %v", ErrChecksumMismatch, err)
+	}
+	if _, _, err := client.findItemForUploadName(contentID(fmt.Sprintf("%x", sha256.Sum256([]byte("other"))))); err == nil {
+		t.Fatalf("expected mismatching content not to be stored")
+	}
+}
+
+func TestArchiveRefCount(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+	client := ss.storageClient
+	data := []byte("content")
+
+	id, _ := putTestContent(t, client, data, "")
+	for _, ns := range []string{"team-a", "team-b", "team-a"} {
+		err := client.recordUpload(id, int64(len(data)), uploadInfo{namespace: ns, pkg: "pkg"}, nil)
+		if err != nil {
+			t.Fatalf("error recording upload: %v", err)
+		}
+	}
+	m, _ := client.readMetadata(id)
+	if m.RefCount != 3 || len(m.Owners) != 2 {
+		t.Fatalf("expected 3 references from 2 namespaces, got %+v", m)
+	}
+
+	// The archive stays until the last reference is dropped
+	for i := 2; i > 0; i-- {
+		if err := client.releaseArchive(id); err != nil {
+			t.Fatalf("error releasing archive: %v", err)
+		}
+		if refCount := testArchiveRefCount(t, client, id); refCount != i {
+			t.Fatalf("expected %v references, got %v", i, refCount)
+		}
+	}
+	if err := client.releaseArchive(id); err != nil {
+		t.Fatalf("error releasing archive: %v", err)
+	}
+	if _, _, err := client.findItemForUploadName(id); err == nil {
+		t.Fatalf("expected archive to be removed with its last reference")
+	}
+	if _, err := client.readMetadata(id); err != ErrNotFound {
+		t.Fatalf("expected metadata to be removed with the archive, got %v", err)
+	}
+	if err := client.recordUpload(id, int64(len(data)), uploadInfo{namespace: "team-a"}, nil); err == nil {
+		t.Fatalf("expected recording upload of removed archive to fail")
+	}
+
+	// Archives uploaded without metadata have a single reference
+	id, _ = putTestContent(t, client, []byte("unrecorded"), "")
+	if err := client.releaseArchive(id); err != nil {
+		t.Fatalf("error releasing archive: %v", err)
+	}
+	if _, _, err := client.findItemForUploadName(id); err == nil {
+		t.Fatalf("expected archive without metadata to be removed")
+	}
+}
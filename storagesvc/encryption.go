@@ -0,0 +1,421 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// Archives are encrypted with envelope encryption: every item gets a random
+// data key, which is stored in the item's header wrapped by a master key.
+// The content follows in chunks sealed with AES-GCM, so items are streamed
+// instead of held in memory.
+//
+// The header is
+//
+//	magic (8) | master key ID (8) | wrapped data key (60) | nonce prefix (4) | plaintext size (8)
+//
+// and every chunk is sealed with the nonce prefix followed by the chunk
+// index, and the header plus a final-chunk flag as additional data, so
+// chunks can't be reordered, dropped or moved between items.
+const (
+	encryptionMagic     = "FSNENC01"
+	encryptionKeyIDSize = 8
+	encryptionChunkSize = 64 * 1024
+
+	dataKeySize     = 32
+	gcmNonceSize    = 12
+	gcmTagSize      = 16
+	wrappedKeySize  = gcmNonceSize + dataKeySize + gcmTagSize
+	noncePrefixSize = 4
+
+	encryptionHeaderSize = len(encryptionMagic) + encryptionKeyIDSize + wrappedKeySize + noncePrefixSize + 8
+)
+
+var ErrUnknownEncryptionKey = errors.New("archive is encrypted with an unknown key")
+
+type (
+	// masterKey wraps the data keys of items.
+	masterKey struct {
+		id   []byte
+		aead cipher.AEAD
+	}
+
+	// encryptionKeys are the master keys from the key file. The first key
+	// encrypts new items; all of them decrypt, so keys can be rotated by
+	// adding a new key in front of the old ones.
+	encryptionKeys struct {
+		keys []*masterKey
+	}
+
+	// encryptedContainer encrypts the items put into the container it wraps
+	// and decrypts them when they're opened. Items that were stored before
+	// encryption was enabled are read as they are.
+	encryptedContainer struct {
+		stow.Container
+		keys *encryptionKeys
+	}
+
+	encryptedItem struct {
+		stow.Item
+		keys *encryptionKeys
+
+		// header is read when it's first needed; it's nil for items
+		// that aren't encrypted
+		headerRead bool
+		header     *encryptionHeader
+	}
+
+	encryptionHeader struct {
+		raw         []byte
+		dataKey     cipher.AEAD
+		noncePrefix []byte
+		size        int64
+	}
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadEncryptionKeys reads the master keys from a file with one base64
+// encoded 32 byte key per line, e.g. mounted from a Kubernetes secret.
+func loadEncryptionKeys(filename string) (*encryptionKeys, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := &encryptionKeys{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decoding key %v of %v", len(keys.keys)+1, filename)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("key %v of %v has %v bytes, expected %v", len(keys.keys)+1, filename, len(key), dataKeySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		keys.keys = append(keys.keys, &masterKey{id: sum[:encryptionKeyIDSize], aead: aead})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys.keys) == 0 {
+		return nil, fmt.Errorf("no encryption keys in %v", filename)
+	}
+	return keys, nil
+}
+
+func (keys *encryptionKeys) find(id []byte) *masterKey {
+	for _, k := range keys.keys {
+		if bytes.Equal(k.id, id) {
+			return k
+		}
+	}
+	return nil
+}
+
+// newHeader creates the header of an item with a new data key.
+func (keys *encryptionKeys) newHeader(size int64) (*encryptionHeader, error) {
+	random := make([]byte, dataKeySize+gcmNonceSize+noncePrefixSize)
+	_, err := io.ReadFull(rand.Reader, random)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := random[:dataKeySize]
+	keyNonce := random[dataKeySize : dataKeySize+gcmNonceSize]
+	noncePrefix := random[dataKeySize+gcmNonceSize:]
+
+	master := keys.keys[0]
+	raw := make([]byte, 0, encryptionHeaderSize)
+	raw = append(raw, encryptionMagic...)
+	raw = append(raw, master.id...)
+	raw = append(raw, keyNonce...)
+	raw = master.aead.Seal(raw, keyNonce, dataKey, []byte(encryptionMagic))
+	raw = append(raw, noncePrefix...)
+	raw = append(raw, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(raw[len(raw)-8:], uint64(size))
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptionHeader{raw: raw, dataKey: aead, noncePrefix: noncePrefix, size: size}, nil
+}
+
+// parseHeader unwraps the data key of an item. It returns nil if the item
+// isn't encrypted.
+func (keys *encryptionKeys) parseHeader(raw []byte) (*encryptionHeader, error) {
+	if len(raw) < encryptionHeaderSize || string(raw[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, nil
+	}
+	raw = raw[:encryptionHeaderSize]
+
+	rest := raw[len(encryptionMagic):]
+	master := keys.find(rest[:encryptionKeyIDSize])
+	if master == nil {
+		return nil, ErrUnknownEncryptionKey
+	}
+	rest = rest[encryptionKeyIDSize:]
+
+	wrapped := rest[:wrappedKeySize]
+	dataKey, err := master.aead.Open(nil, wrapped[:gcmNonceSize], wrapped[gcmNonceSize:], []byte(encryptionMagic))
+	if err != nil {
+		return nil, errors.Wrap(err, "error unwrapping data key")
+	}
+	rest = rest[wrappedKeySize:]
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptionHeader{
+		raw:         raw,
+		dataKey:     aead,
+		noncePrefix: rest[:noncePrefixSize],
+		size:        int64(binary.BigEndian.Uint64(rest[noncePrefixSize:])),
+	}, nil
+}
+
+// chunks returns the number of chunks of the content; empty items have one
+// empty chunk.
+func (h *encryptionHeader) chunks() int64 {
+	n := (h.size + encryptionChunkSize - 1) / encryptionChunkSize
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+func (h *encryptionHeader) encryptedSize() int64 {
+	return int64(len(h.raw)) + h.size + h.chunks()*gcmTagSize
+}
+
+func (h *encryptionHeader) chunkNonceAndData(index int64) ([]byte, []byte) {
+	nonce := make([]byte, gcmNonceSize)
+	copy(nonce, h.noncePrefix)
+	binary.BigEndian.PutUint64(nonce[noncePrefixSize:], uint64(index))
+
+	final := byte(0)
+	if index == h.chunks()-1 {
+		final = 1
+	}
+	additionalData := append(append([]byte{}, h.raw...), final)
+	return nonce, additionalData
+}
+
+// chunkSize returns the plaintext size of a chunk.
+func (h *encryptionHeader) chunkSize(index int64) int64 {
+	if index < h.chunks()-1 {
+		return encryptionChunkSize
+	}
+	return h.size - index*encryptionChunkSize
+}
+
+// encryptingReader reads the header and the sealed chunks of the plaintext
+// it wraps.
+type encryptingReader struct {
+	header *encryptionHeader
+	r      io.Reader
+	index  int64
+	buf    []byte
+}
+
+func (er *encryptingReader) Read(p []byte) (int, error) {
+	for len(er.buf) == 0 {
+		if er.index >= er.header.chunks() {
+			return 0, io.EOF
+		}
+		plaintext := make([]byte, er.header.chunkSize(er.index))
+		_, err := io.ReadFull(er.r, plaintext)
+		if err != nil {
+			return 0, errors.Wrap(err, "error reading content to encrypt")
+		}
+		nonce, additionalData := er.header.chunkNonceAndData(er.index)
+		er.buf = er.header.dataKey.Seal(nil, nonce, plaintext, additionalData)
+		er.index++
+	}
+	n := copy(p, er.buf)
+	er.buf = er.buf[n:]
+	return n, nil
+}
+
+// decryptingReader opens the sealed chunks following the header.
+type decryptingReader struct {
+	header *encryptionHeader
+	rc     io.ReadCloser
+	index  int64
+	buf    []byte
+}
+
+func (dr *decryptingReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.index >= dr.header.chunks() {
+			return 0, io.EOF
+		}
+		ciphertext := make([]byte, dr.header.chunkSize(dr.index)+gcmTagSize)
+		_, err := io.ReadFull(dr.rc, ciphertext)
+		if err != nil {
+			return 0, errors.Wrap(err, "error reading encrypted content")
+		}
+		nonce, additionalData := dr.header.chunkNonceAndData(dr.index)
+		dr.buf, err = dr.header.dataKey.Open(ciphertext[:0], nonce, ciphertext, additionalData)
+		if err != nil {
+			return 0, errors.Wrapf(err, "error decrypting chunk %v", dr.index)
+		}
+		dr.index++
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptingReader) Close() error {
+	return dr.rc.Close()
+}
+
+// plainReader returns the bytes read to look for a header before the rest
+// of an item that isn't encrypted.
+type plainReader struct {
+	io.Reader
+	io.Closer
+}
+
+func makeEncryptedContainer(container stow.Container, keys *encryptionKeys) stow.Container {
+	return &encryptedContainer{Container: container, keys: keys}
+}
+
+func (c *encryptedContainer) wrap(item stow.Item) stow.Item {
+	return &encryptedItem{Item: item, keys: c.keys}
+}
+
+func (c *encryptedContainer) Item(id string) (stow.Item, error) {
+	item, err := c.Container.Item(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrap(item), nil
+}
+
+func (c *encryptedContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	items, next, err := c.Container.Items(prefix, cursor, count)
+	if err != nil {
+		return nil, "", err
+	}
+	for i, item := range items {
+		items[i] = c.wrap(item)
+	}
+	return items, next, nil
+}
+
+func (c *encryptedContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	header, err := c.keys.newHeader(size)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := io.MultiReader(bytes.NewReader(header.raw), &encryptingReader{header: header, r: r})
+
+	item, err := c.Container.Put(name, encrypted, header.encryptedSize(), metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedItem{Item: item, keys: c.keys, headerRead: true, header: header}, nil
+}
+
+// readHeader reads the header of the item, returning the content reader
+// positioned after it.
+func (i *encryptedItem) readHeader() (io.ReadCloser, error) {
+	rc, err := i.Item.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, encryptionHeaderSize)
+	n, err := io.ReadFull(rc, raw)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		rc.Close()
+		return nil, err
+	}
+	raw = raw[:n]
+
+	header, err := i.keys.parseHeader(raw)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	i.headerRead = true
+	i.header = header
+	if header == nil {
+		return &plainReader{Reader: io.MultiReader(bytes.NewReader(raw), rc), Closer: rc}, nil
+	}
+	return rc, nil
+}
+
+func (i *encryptedItem) Open() (io.ReadCloser, error) {
+	rc, err := i.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if i.header == nil {
+		return rc, nil
+	}
+	return &decryptingReader{header: i.header, rc: rc}, nil
+}
+
+// Size returns the size of the plaintext.
+func (i *encryptedItem) Size() (int64, error) {
+	if !i.headerRead {
+		rc, err := i.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		rc.Close()
+	}
+	if i.header == nil {
+		return i.Item.Size()
+	}
+	return i.header.size, nil
+}
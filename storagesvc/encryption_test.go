@@ -0,0 +1,201 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func makeTestEncryptionKeys(t *testing.T, count int) *encryptionKeys {
+	f, err := ioutil.TempFile("", "storagesvc-keys-")
+	if err != nil {
+		t.Fatalf("error creating key file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	for i := 0; i < count; i++ {
+		key := make([]byte, dataKeySize)
+		rand.Read(key)
+		f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	keys, err := loadEncryptionKeys(f.Name())
+	if err != nil {
+		t.Fatalf("error loading keys: %v", err)
+	}
+	return keys
+}
+
+// encryptTestContent returns the encrypted item of the plaintext.
+func encryptTestContent(t *testing.T, keys *encryptionKeys, plaintext []byte) []byte {
+	header, err := keys.newHeader(int64(len(plaintext)))
+	if err != nil {
+		t.Fatalf("error creating header: %v", err)
+	}
+	encrypted, err := ioutil.ReadAll(io.MultiReader(bytes.NewReader(header.raw),
+		&encryptingReader{header: header, r: bytes.NewReader(plaintext)}))
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if int64(len(encrypted)) != header.encryptedSize() {
+		t.Fatalf("expected %v encrypted bytes, got %v", header.encryptedSize(), len(encrypted))
+	}
+	return encrypted
+}
+
+func decryptTestContent(keys *encryptionKeys, encrypted []byte) ([]byte, error) {
+	header, err := keys.parseHeader(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return encrypted, nil
+	}
+	rc := ioutil.NopCloser(bytes.NewReader(encrypted[encryptionHeaderSize:]))
+	return ioutil.ReadAll(&decryptingReader{header: header, rc: rc})
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	keys := makeTestEncryptionKeys(t, 1)
+	for _, size := range []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3 * encryptionChunkSize} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		decrypted, err := decryptTestContent(keys, encryptTestContent(t, keys, plaintext))
+		if err != nil {
+			t.Fatalf("error decrypting %v bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("decrypted %v bytes, expected %v", len(decrypted), size)
+		}
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	oldKeys := makeTestEncryptionKeys(t, 1)
+	encrypted := encryptTestContent(t, oldKeys, []byte("archive"))
+
+	// Items encrypted with an old key are read after a new one is added
+	keys := &encryptionKeys{keys: append(makeTestEncryptionKeys(t, 1).keys, oldKeys.keys...)}
+	decrypted, err := decryptTestContent(keys, encrypted)
+	if err != nil || string(decrypted) != "archive" {
+		t.Fatalf("error decrypting with rotated keys: %q, %v", decrypted, err)
+	}
+
+	if _, err := decryptTestContent(makeTestEncryptionKeys(t, 1), encrypted); err != ErrUnknownEncryptionKey {
+		t.Fatalf("expected decrypting with another key to fail with %v, got %v", ErrUnknownEncryptionKey, err)
+	}
+
+	// Items stored before encryption was enabled are read as they are
+	decrypted, err = decryptTestContent(keys, []byte("plain"))
+	if err != nil || string(decrypted) != "plain" {
+		t.Fatalf("error reading unencrypted item: %q, %v", decrypted, err)
+	}
+}
+
+func TestEncryptionTamper(t *testing.T) {
+	keys := makeTestEncryptionKeys(t, 1)
+	plaintext := make([]byte, 2*encryptionChunkSize+100)
+	rand.Read(plaintext)
+	encrypted := encryptTestContent(t, keys, plaintext)
+	sealedChunkSize := encryptionChunkSize + gcmTagSize
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[encryptionHeaderSize+sealedChunkSize+10] ^= 1
+	if _, err := decryptTestContent(keys, tampered); err == nil {
+		t.Fatalf("tampered chunk was decrypted")
+	}
+
+	// The header is authenticated with every chunk, so its size can't
+	// be changed to hide dropped chunks
+	tampered = append([]byte{}, encrypted...)
+	tampered[encryptionHeaderSize-1] ^= 1
+	if _, err := decryptTestContent(keys, tampered); err == nil {
+		t.Fatalf("content with tampered header was decrypted")
+	}
+
+	// Chunks can't be reordered
+	reordered := append([]byte{}, encrypted[:encryptionHeaderSize]...)
+	reordered = append(reordered, encrypted[encryptionHeaderSize+sealedChunkSize:encryptionHeaderSize+2*sealedChunkSize]...)
+	reordered = append(reordered, encrypted[encryptionHeaderSize:encryptionHeaderSize+sealedChunkSize]...)
+	reordered = append(reordered, encrypted[encryptionHeaderSize+2*sealedChunkSize:]...)
+	if _, err := decryptTestContent(keys, reordered); err == nil {
+		t.Fatalf("reordered chunks were decrypted")
+	}
+
+	// Chunks can't be moved between items, even with the same key
+	other := encryptTestContent(t, keys, plaintext)
+	mixed := append(append([]byte{}, encrypted[:encryptionHeaderSize+sealedChunkSize]...), other[encryptionHeaderSize+sealedChunkSize:]...)
+	if _, err := decryptTestContent(keys, mixed); err == nil {
+		t.Fatalf("chunks of another item were decrypted")
+	}
+}
+
+func TestEncryptionTruncation(t *testing.T) {
+	keys := makeTestEncryptionKeys(t, 1)
+	plaintext := make([]byte, 2*encryptionChunkSize)
+	rand.Read(plaintext)
+	encrypted := encryptTestContent(t, keys, plaintext)
+	sealedChunkSize := encryptionChunkSize + gcmTagSize
+
+	for _, size := range []int{len(encrypted) - 1, encryptionHeaderSize + sealedChunkSize, encryptionHeaderSize} {
+		if _, err := decryptTestContent(keys, encrypted[:size]); err == nil {
+			t.Fatalf("content truncated to %v of %v bytes was decrypted", size, len(encrypted))
+		}
+	}
+}
+
+func TestEncryptionChunkAdditionalData(t *testing.T) {
+	keys := makeTestEncryptionKeys(t, 1)
+	header, err := keys.newHeader(2 * encryptionChunkSize)
+	if err != nil {
+		t.Fatalf("error creating header: %v", err)
+	}
+
+	// Only the last chunk is flagged final
+	_, first := header.chunkNonceAndData(0)
+	_, last := header.chunkNonceAndData(1)
+	if first[len(first)-1] != 0 || last[len(last)-1] != 1 {
+		t.Fatalf("expected final flags 0 and 1, got %v and %v", first[len(first)-1], last[len(last)-1])
+	}
+	if !bytes.Equal(first[:len(first)-1], header.raw) {
+		t.Fatalf("additional data doesn't start with the header")
+	}
+
+	// A chunk only opens with its own index and final flag
+	plaintext := make([]byte, encryptionChunkSize)
+	nonce, additionalData := header.chunkNonceAndData(0)
+	sealed := header.dataKey.Seal(nil, nonce, plaintext, additionalData)
+
+	otherNonce, _ := header.chunkNonceAndData(1)
+	if _, err := header.dataKey.Open(nil, otherNonce, sealed, additionalData); err == nil {
+		t.Fatalf("chunk was opened with the index of another chunk")
+	}
+	flipped := append(append([]byte{}, header.raw...), 1)
+	if _, err := header.dataKey.Open(nil, nonce, sealed, flipped); err == nil {
+		t.Fatalf("chunk was opened as the final chunk")
+	}
+	if _, err := header.dataKey.Open(nil, nonce, sealed, additionalData); err != nil {
+		t.Fatalf("error opening chunk: %v", err)
+	}
+}
@@ -0,0 +1,621 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Keys of the metadata that clients attach to uploads. Each is sent in an
+// "X-Archive-<key>" header.
+const (
+	MetadataNamespace   = "Namespace"
+	MetadataPackage     = "Package"
+	MetadataUploader    = "Uploader"
+	MetadataContentType = "Content-Type"
+
+	// MetadataGrant is an upload grant, which the namespace and package
+	// are taken from if the storage service signs URLs.
+	MetadataGrant = "Grant"
+)
+
+// The metadata of an archive is stored next to it, in a JSON item under
+// this prefix.
+const archiveMetadataPrefix = "metadata/"
+
+// Quotas for namespaces that aren't listed.
+const defaultQuotaKey = "*"
+
+var (
+	ErrQuotaExceeded     = errors.New("storage quota exceeded")
+	ErrNamespaceRequired = errors.New("uploads need a namespace, from an upload grant")
+)
+
+type (
+	// ArchiveOwner is an upload of the archive content by a namespace.
+	ArchiveOwner struct {
+		Namespace string    `json:"namespace,omitempty"`
+		Package   string    `json:"package,omitempty"`
+		Uploader  string    `json:"uploader,omitempty"`
+		Uploaded  time.Time `json:"uploaded"`
+	}
+
+	// ArchiveMetadata describes a stored archive. Since archives with the
+	// same content are stored once, it lists each namespace that uploaded
+	// the content, and counts the references to it: every upload or
+	// lookup of the content adds one, every deletion drops one, and the
+	// content is only removed once none are left.
+	ArchiveMetadata struct {
+		ID          string         `json:"id"`
+		Size        int64          `json:"size"`
+		ContentType string         `json:"contentType,omitempty"`
+		Created     time.Time      `json:"created"`
+		Owners      []ArchiveOwner `json:"owners,omitempty"`
+		RefCount    int            `json:"refCount,omitempty"`
+	}
+
+	// ArchiveList is a page of archives. Continue is passed to get the
+	// next page, and is empty on the last one.
+	ArchiveList struct {
+		Items    []ArchiveMetadata `json:"items"`
+		Continue string            `json:"continue,omitempty"`
+	}
+
+	// NamespaceUsage is the storage used by the archives of a namespace.
+	// A Quota of 0 means unlimited.
+	NamespaceUsage struct {
+		Namespace string `json:"namespace"`
+		Used      int64  `json:"used"`
+		Quota     int64  `json:"quota"`
+	}
+
+	// uploadInfo is the metadata sent with an upload.
+	uploadInfo struct {
+		namespace   string
+		pkg         string
+		uploader    string
+		contentType string
+	}
+
+	// archiveQuotas limits the total size of the archives of each
+	// namespace. An archive counts toward every namespace that uploaded
+	// it.
+	archiveQuotas struct {
+		defaultQuota int64
+		namespaces   map[string]int64
+	}
+
+	// archiveMetadataStore keeps the usage of each namespace, computed from
+	// the stored metadata when it's first needed, and the space reserved
+	// for uploads in progress.
+	archiveMetadataStore struct {
+		lock     sync.Mutex
+		usage    map[string]int64
+		reserved map[string]int64
+		quotas   *archiveQuotas
+	}
+
+	// quotaReservation is space reserved for an upload, until it's
+	// recorded or released.
+	quotaReservation struct {
+		namespace string
+		size      int64
+		released  bool
+	}
+)
+
+// UploadMetadata returns the metadata of an archive uploaded for a package.
+func UploadMetadata(namespace string, pkg string, uploader string) map[string]string {
+	return map[string]string{
+		MetadataNamespace: namespace,
+		MetadataPackage:   pkg,
+		MetadataUploader:  uploader,
+	}
+}
+
+// uploadInfoFromRequest returns the metadata sent with an upload. If the
+// storage service signs URLs, the namespace and package are only taken
+// from an upload grant, since anyone can send the headers.
+func (ss *StorageService) uploadInfoFromRequest(r *http.Request) (uploadInfo, error) {
+	info := uploadInfo{
+		uploader:    r.Header.Get("X-Archive-" + MetadataUploader),
+		contentType: r.Header.Get("X-Archive-" + MetadataContentType),
+	}
+	if ss.downloadUrlSigner == nil {
+		info.namespace = r.Header.Get("X-Archive-" + MetadataNamespace)
+		info.pkg = r.Header.Get("X-Archive-" + MetadataPackage)
+		return info, nil
+	}
+
+	grant := r.Header.Get("X-Archive-" + MetadataGrant)
+	if len(grant) == 0 {
+		return info, nil
+	}
+	var err error
+	info.namespace, info.pkg, err = ss.downloadUrlSigner.verifyGrant(grant, time.Now())
+	return info, err
+}
+
+// parseArchiveQuotas parses quotas like "team-a=10Gi,team-b=500Mi,*=1Gi",
+// where "*" is the quota of the other namespaces.
+func parseArchiveQuotas(s string) (*archiveQuotas, error) {
+	quotas := &archiveQuotas{
+		namespaces: make(map[string]int64),
+	}
+	for _, q := range strings.Split(s, ",") {
+		q = strings.TrimSpace(q)
+		if len(q) == 0 {
+			continue
+		}
+		kv := strings.SplitN(q, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid quota '%v', should be namespace=size", q)
+		}
+		size, err := resource.ParseQuantity(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid size of quota '%v': %v", q, err)
+		}
+		ns := strings.TrimSpace(kv[0])
+		if ns == defaultQuotaKey {
+			quotas.defaultQuota = size.Value()
+		} else {
+			quotas.namespaces[ns] = size.Value()
+		}
+	}
+	return quotas, nil
+}
+
+func (q *archiveQuotas) enabled() bool {
+	return q.defaultQuota > 0 || len(q.namespaces) > 0
+}
+
+func (q *archiveQuotas) quota(namespace string) int64 {
+	if quota, ok := q.namespaces[namespace]; ok {
+		return quota
+	}
+	return q.defaultQuota
+}
+
+func makeArchiveMetadataStore() *archiveMetadataStore {
+	return &archiveMetadataStore{
+		reserved: make(map[string]int64),
+		quotas:   &archiveQuotas{namespaces: make(map[string]int64)},
+	}
+}
+
+func metadataItemName(id string) string {
+	return archiveMetadataPrefix + id + ".json"
+}
+
+func isMetadataItem(name string) bool {
+	return strings.HasPrefix(name, archiveMetadataPrefix)
+}
+
+func (m *ArchiveMetadata) owner(namespace string) *ArchiveOwner {
+	for i := range m.Owners {
+		if m.Owners[i].Namespace == namespace {
+			return &m.Owners[i]
+		}
+	}
+	return nil
+}
+
+// readMetadata returns the metadata stored for the archive, or
+// ErrNotFound for archives uploaded without metadata.
+func (client *StowClient) readMetadata(id string) (*ArchiveMetadata, error) {
+	item, err := client.writeContainer.Item(metadataItemName(id))
+	if err == stow.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	f, err := item.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m ArchiveMetadata
+	err = json.NewDecoder(f).Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	// Metadata written before references were counted has one for each
+	// owner
+	if m.RefCount == 0 {
+		m.RefCount = len(m.Owners)
+	}
+	return &m, nil
+}
+
+func (client *StowClient) writeMetadata(m *ArchiveMetadata) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = client.writeContainer.Put(metadataItemName(m.ID), bytes.NewReader(data), int64(len(data)), nil)
+	return err
+}
+
+// getMetadata returns the metadata of an archive, falling back to what the
+// storage knows about archives uploaded without metadata.
+func (client *StowClient) getMetadata(id string) (*ArchiveMetadata, error) {
+	m, err := client.readMetadata(id)
+	if err != ErrNotFound {
+		return m, err
+	}
+
+	_, item, err := client.findItemForUploadName(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return metadataForItem(id, item), nil
+}
+
+func metadataForItem(id string, item stow.Item) *ArchiveMetadata {
+	m := &ArchiveMetadata{ID: id}
+	m.Size, _ = item.Size()
+	m.Created, _ = item.LastMod()
+	return m
+}
+
+// sniffContentType guesses the content type of a stored archive.
+func (client *StowClient) sniffContentType(id string) string {
+	_, item, err := client.findItemForUploadName(id)
+	if err != nil {
+		return ""
+	}
+	f, err := item.Open()
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(f, 512))
+	if err != nil {
+		return ""
+	}
+	return http.DetectContentType(buf)
+}
+
+// loadUsage computes the usage of each namespace from the stored metadata,
+// the first time it's needed. The caller holds the metadata lock.
+func (client *StowClient) loadUsage() error {
+	if client.metadata.usage != nil {
+		return nil
+	}
+
+	usage := make(map[string]int64)
+	cursor := stow.CursorStart
+	for {
+		items, next, err := client.writeContainer.Items(archiveMetadataPrefix, cursor, PaginationSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if !isMetadataItem(item.Name()) {
+				continue
+			}
+			id := strings.TrimSuffix(path.Base(item.Name()), ".json")
+			m, err := client.readMetadata(id)
+			if err != nil {
+				log.WithError(err).Errorf("Error reading metadata of archive %v", id)
+				continue
+			}
+			for _, o := range m.Owners {
+				usage[o.Namespace] += m.Size
+			}
+		}
+		if stow.IsCursorEnd(next) {
+			break
+		}
+		cursor = next
+	}
+
+	client.metadata.usage = usage
+	return nil
+}
+
+// reserveQuota reserves size bytes of the quota of the namespace for an
+// upload, returning ErrQuotaExceeded if they would exceed it. Uploading
+// content the namespace stores already is free, so nothing is reserved.
+func (client *StowClient) reserveQuota(namespace string, size int64, id string) (*quotaReservation, error) {
+	client.metadata.lock.Lock()
+	defer client.metadata.lock.Unlock()
+
+	if !client.metadata.quotas.enabled() {
+		return nil, nil
+	}
+	if len(namespace) == 0 {
+		return nil, ErrNamespaceRequired
+	}
+	if len(id) > 0 {
+		if m, err := client.readMetadata(id); err == nil && m.owner(namespace) != nil {
+			return nil, nil
+		}
+	}
+
+	err := client.checkQuota(namespace, size)
+	if err != nil {
+		return nil, err
+	}
+	client.metadata.reserved[namespace] += size
+	return &quotaReservation{namespace: namespace, size: size}, nil
+}
+
+// releaseQuota gives back space reserved for an upload, unless it was
+// recorded already.
+func (client *StowClient) releaseQuota(res *quotaReservation) {
+	client.metadata.lock.Lock()
+	defer client.metadata.lock.Unlock()
+	client.releaseReservation(res)
+}
+
+// releaseReservation is releaseQuota for callers holding the metadata
+// lock.
+func (client *StowClient) releaseReservation(res *quotaReservation) {
+	if res == nil || res.released {
+		return
+	}
+	client.metadata.reserved[res.namespace] -= res.size
+	res.released = true
+}
+
+// checkQuota returns ErrQuotaExceeded if storing size more bytes would
+// exceed the quota of the namespace, counting the space reserved for
+// other uploads. The caller holds the metadata lock.
+func (client *StowClient) checkQuota(namespace string, size int64) error {
+	quota := client.metadata.quotas.quota(namespace)
+	if quota == 0 {
+		return nil
+	}
+	err := client.loadUsage()
+	if err != nil {
+		return err
+	}
+	used := client.metadata.usage[namespace] + client.metadata.reserved[namespace]
+	if used+size > quota {
+		log.Errorf("Upload of %v bytes would exceed the quota of namespace %v: %v of %v bytes used",
+			size, namespace, used, quota)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// recordUpload adds a reference to a stored archive, and the uploader's
+// namespace to its metadata. The space reserved for the upload, if any,
+// becomes used; otherwise the archive is checked against the quota of a
+// namespace that doesn't store it yet. It returns ErrNotFound if the
+// archive was removed in the meantime.
+func (client *StowClient) recordUpload(id string, size int64, info uploadInfo, res *quotaReservation) error {
+	client.metadata.lock.Lock()
+	defer client.metadata.lock.Unlock()
+	defer client.releaseReservation(res)
+
+	_, _, err := client.findItemForUploadName(id)
+	if err != nil {
+		return err
+	}
+
+	m, err := client.readMetadata(id)
+	if err == ErrNotFound {
+		m = &ArchiveMetadata{
+			ID:      id,
+			Size:    size,
+			Created: time.Now(),
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if len(info.contentType) > 0 {
+		m.ContentType = info.contentType
+	} else if len(m.ContentType) == 0 {
+		m.ContentType = client.sniffContentType(id)
+	}
+
+	owner := m.owner(info.namespace)
+	if owner == nil {
+		if res == nil && client.metadata.quotas.enabled() {
+			err = client.checkQuota(info.namespace, m.Size)
+			if err != nil {
+				return err
+			}
+		}
+		m.Owners = append(m.Owners, ArchiveOwner{Namespace: info.namespace})
+		owner = &m.Owners[len(m.Owners)-1]
+		if client.metadata.usage != nil {
+			client.metadata.usage[info.namespace] += m.Size
+		}
+	}
+	owner.Package = info.pkg
+	owner.Uploader = info.uploader
+	owner.Uploaded = time.Now()
+	m.RefCount++
+
+	return client.writeMetadata(m)
+}
+
+// releaseArchive drops a reference to an archive, removing it once none
+// are left. Archives without metadata have a single reference.
+func (client *StowClient) releaseArchive(id string) error {
+	client.metadata.lock.Lock()
+	defer client.metadata.lock.Unlock()
+
+	m, err := client.readMetadata(id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if m != nil && m.RefCount > 1 {
+		m.RefCount--
+		log.Infof("Archive %v still has %v references, not removing it", id, m.RefCount)
+		return client.writeMetadata(m)
+	}
+	return client.removeArchive(id, m)
+}
+
+// removeUnrecorded removes content whose upload couldn't be recorded,
+// unless another upload of the same content was recorded meanwhile.
+func (client *StowClient) removeUnrecorded(id string) {
+	client.metadata.lock.Lock()
+	defer client.metadata.lock.Unlock()
+
+	_, err := client.readMetadata(id)
+	if err != ErrNotFound {
+		return
+	}
+	err = client.removeArchive(id, nil)
+	if err != nil && err != ErrNotFound {
+		log.WithError(err).Errorf("Error removing unrecorded archive %v", id)
+	}
+}
+
+// setRefCount sets the number of references to an archive, as counted by
+// the archive pruner.
+func (client *StowClient) setRefCount(id string, refCount int) error {
+	client.metadata.lock.Lock()
+	defer client.metadata.lock.Unlock()
+
+	m, err := client.readMetadata(id)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if m.RefCount == refCount {
+		return nil
+	}
+	log.Infof("Archive %v has %v references, not %v", id, refCount, m.RefCount)
+	m.RefCount = refCount
+	return client.writeMetadata(m)
+}
+
+// forgetMetadata removes the metadata of a deleted archive. The caller
+// holds the metadata lock.
+func (client *StowClient) forgetMetadata(m *ArchiveMetadata) {
+	if client.metadata.usage != nil {
+		for _, o := range m.Owners {
+			client.metadata.usage[o.Namespace] -= m.Size
+		}
+	}
+	item, err := client.writeContainer.Item(metadataItemName(m.ID))
+	if err == nil {
+		err = client.writeContainer.RemoveItem(item.ID())
+	}
+	if err != nil {
+		log.WithError(err).Errorf("Error removing metadata of archive %v", m.ID)
+	}
+}
+
+// listArchives returns a page of at most limit archives stored by the
+// namespace and package, if given, starting at the cursor.
+func (client *StowClient) listArchives(namespace string, pkg string, cursor string, limit int) (*ArchiveList, error) {
+	list := &ArchiveList{Items: make([]ArchiveMetadata, 0)}
+	if len(cursor) == 0 {
+		cursor = stow.CursorStart
+	}
+
+	for len(list.Items) < limit {
+		items, next, err := client.writeContainer.Items(stow.NoPrefix, cursor, limit-len(list.Items))
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if isMetadataItem(item.Name()) {
+				continue
+			}
+			m, err := client.readMetadata(item.Name())
+			if err == ErrNotFound {
+				m = metadataForItem(item.Name(), item)
+			} else if err != nil {
+				return nil, err
+			}
+			if matchesOwner(m, namespace, pkg) {
+				list.Items = append(list.Items, *m)
+			}
+		}
+		if stow.IsCursorEnd(next) {
+			return list, nil
+		}
+		cursor = next
+	}
+
+	list.Continue = cursor
+	return list, nil
+}
+
+func matchesOwner(m *ArchiveMetadata, namespace string, pkg string) bool {
+	if len(namespace) == 0 && len(pkg) == 0 {
+		return true
+	}
+	for _, o := range m.Owners {
+		if (len(namespace) == 0 || o.Namespace == namespace) && (len(pkg) == 0 || o.Package == pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceUsage returns the usage and quota of each namespace storing
+// archives or having a quota.
+func (client *StowClient) namespaceUsage() ([]NamespaceUsage, error) {
+	client.metadata.lock.Lock()
+	defer client.metadata.lock.Unlock()
+	err := client.loadUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]int64)
+	for ns, used := range client.metadata.usage {
+		if len(ns) > 0 && used > 0 {
+			usage[ns] = used
+		}
+	}
+	for ns := range client.metadata.quotas.namespaces {
+		usage[ns] += 0
+	}
+
+	result := make([]NamespaceUsage, 0, len(usage))
+	for ns, used := range usage {
+		result = append(result, NamespaceUsage{
+			Namespace: ns,
+			Used:      used,
+			Quota:     client.metadata.quotas.quota(ns),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Namespace < result[j].Namespace
+	})
+	return result, nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func storeTestContent(t *testing.T, client *StowClient, data []byte) string {
+	f, err := ioutil.TempFile("", "storagesvc-test-")
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	f.Write(data)
+
+	id, _, err := client.storeContent(f, int64(len(data)), fmt.Sprintf("%x", sha256.Sum256(data)))
+	if err != nil {
+		t.Fatalf("error storing content: %v", err)
+	}
+	return id
+}
+
+func TestQuotaReservation(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+	client := ss.storageClient
+	quotas, err := parseArchiveQuotas("team-a=100")
+	if err != nil {
+		t.Fatalf("error parsing quotas: %v", err)
+	}
+	client.metadata.quotas = quotas
+
+	if _, err := client.reserveQuota("", 10, ""); err != ErrNamespaceRequired {
+		t.Fatalf("expected upload without a namespace to fail with %v, got %v", ErrNamespaceRequired, err)
+	}
+
+	// Concurrent uploads can't exceed the quota together
+	first, err := client.reserveQuota("team-a", 60, "")
+	if err != nil {
+		t.Fatalf("error reserving quota: %v", err)
+	}
+	if _, err := client.reserveQuota("team-a", 60, ""); err != ErrQuotaExceeded {
+		t.Fatalf("expected second upload to fail with %v, got %v", ErrQuotaExceeded, err)
+	}
+
+	// Recording the upload turns the reservation into usage
+	data := bytes.Repeat([]byte("a"), 60)
+	id := storeTestContent(t, client, data)
+	err = client.recordUpload(id, 60, uploadInfo{namespace: "team-a"}, first)
+	if err != nil {
+		t.Fatalf("error recording upload: %v", err)
+	}
+	client.releaseQuota(first)
+	if client.metadata.usage["team-a"] != 60 || client.metadata.reserved["team-a"] != 0 {
+		t.Fatalf("expected 60 bytes used and none reserved, got %v and %v",
+			client.metadata.usage["team-a"], client.metadata.reserved["team-a"])
+	}
+
+	// Content the namespace stores already is free
+	if res, err := client.reserveQuota("team-a", 60, id); err != nil || res != nil {
+		t.Fatalf("expected upload of stored content to be free, got %v, %v", res, err)
+	}
+
+	// Released reservations give back their space
+	second, err := client.reserveQuota("team-a", 40, "")
+	if err != nil {
+		t.Fatalf("error reserving quota: %v", err)
+	}
+	if _, err := client.reserveQuota("team-a", 1, ""); err != ErrQuotaExceeded {
+		t.Fatalf("expected upload over the quota to fail with %v, got %v", ErrQuotaExceeded, err)
+	}
+	client.releaseQuota(second)
+
+	// Lookups recording an upload without a reservation are checked too
+	other := storeTestContent(t, client, bytes.Repeat([]byte("b"), 50))
+	err = client.recordUpload(other, 50, uploadInfo{namespace: "team-a"}, nil)
+	if err != ErrQuotaExceeded {
+		t.Fatalf("expected lookup over the quota to fail with %v, got %v", ErrQuotaExceeded, err)
+	}
+}
+
+func TestUploadGrant(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+	signer, err := makeDownloadUrlSigner("key", "token")
+	if err != nil {
+		t.Fatalf("error creating signer: %v", err)
+	}
+	ss.downloadUrlSigner = signer
+
+	// The namespace header is ignored in favor of the grant
+	req := httptest.NewRequest(http.MethodPost, "/v1/uploads", nil)
+	req.Header.Set("X-Archive-"+MetadataNamespace, "team-b")
+	info, err := ss.uploadInfoFromRequest(req)
+	if err != nil || info.namespace != "" {
+		t.Fatalf("expected upload without grant to have no namespace, got %q, %v", info.namespace, err)
+	}
+
+	grant := signer.grant("team-a", "pkg", 0, time.Now())
+	req.Header.Set("X-Archive-"+MetadataGrant, grant.Grant)
+	info, err = ss.uploadInfoFromRequest(req)
+	if err != nil || info.namespace != "team-a" || info.pkg != "pkg" {
+		t.Fatalf("unexpected upload info from grant: %+v, %v", info, err)
+	}
+
+	req.Header.Set("X-Archive-"+MetadataGrant, "team-b:pkg:"+grant.Grant[len("team-a:pkg:"):])
+	if _, err := ss.uploadInfoFromRequest(req); err != ErrInvalidGrant {
+		t.Fatalf("expected tampered grant to fail with %v, got %v", ErrInvalidGrant, err)
+	}
+
+	expired := signer.grant("team-a", "pkg", 0, time.Now().Add(-2*maxUploadGrantTTL))
+	req.Header.Set("X-Archive-"+MetadataGrant, expired.Grant)
+	if _, err := ss.uploadInfoFromRequest(req); err != ErrInvalidGrant {
+		t.Fatalf("expected expired grant to fail with %v, got %v", ErrInvalidGrant, err)
+	}
+}
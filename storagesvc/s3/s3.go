@@ -0,0 +1,452 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3 is a stow location for Amazon S3 and the S3-compatible object
+// stores, e.g. MinIO or Ceph. It talks to the REST API directly with path
+// style requests, so any endpoint works without DNS for bucket names.
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graymeta/stow"
+)
+
+const (
+	// Kind is the stow kind of S3 locations.
+	Kind = "s3"
+
+	// ConfigEndpoint is the URL of the object store, e.g.
+	// https://s3.amazonaws.com or http://minio.fission:9000.
+	ConfigEndpoint = "endpoint"
+
+	// ConfigRegion is the region of the buckets, us-east-1 by default.
+	ConfigRegion = "region"
+
+	// ConfigAccessKeyID and ConfigSecretKey are the credentials; they
+	// default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	// environment variables.
+	ConfigAccessKeyID = "access_key_id"
+	ConfigSecretKey   = "secret_key"
+
+	defaultEndpoint = "https://s3.amazonaws.com"
+	defaultRegion   = "us-east-1"
+
+	metadataHeaderPrefix = "X-Amz-Meta-"
+)
+
+func init() {
+	makefn := func(config stow.Config) (stow.Location, error) {
+		return newLocation(config)
+	}
+	kindfn := func(u *url.URL) bool {
+		return u.Scheme == Kind
+	}
+	validatefn := func(config stow.Config) error {
+		_, err := newLocation(config)
+		return err
+	}
+	stow.Register(Kind, makefn, kindfn, validatefn)
+}
+
+type location struct {
+	endpoint   *url.URL
+	signer     *signer
+	httpClient *http.Client
+}
+
+func configWithDefault(config stow.Config, key string, def string) string {
+	value, ok := config.Config(key)
+	if !ok || len(value) == 0 {
+		return def
+	}
+	return value
+}
+
+func newLocation(config stow.Config) (*location, error) {
+	endpoint, err := url.Parse(configWithDefault(config, ConfigEndpoint, defaultEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %v: scheme must be http or https", endpoint)
+	}
+
+	s := &signer{
+		accessKeyID: configWithDefault(config, ConfigAccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey:   configWithDefault(config, ConfigSecretKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		region:      configWithDefault(config, ConfigRegion, defaultRegion),
+	}
+	if len(s.accessKeyID) == 0 || len(s.secretKey) == 0 {
+		return nil, errors.New("missing S3 credentials")
+	}
+
+	return &location{
+		endpoint:   endpoint,
+		signer:     s,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// objectURL returns the path style URL of the object; an empty bucket is
+// the service itself and an empty key the bucket.
+func (l *location) objectURL(bucket string, key string, query url.Values) *url.URL {
+	path := strings.TrimSuffix(l.endpoint.Path, "/") + "/"
+	if len(bucket) > 0 {
+		path += bucket
+		if len(key) > 0 {
+			path += "/" + key
+		}
+	}
+
+	u := *l.endpoint
+	u.Path = path
+	// The path is sent exactly as it was signed
+	u.RawPath = uriEncode(path, false)
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return &u
+}
+
+// errorResponse is the body of failed S3 requests.
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends a signed request; the body is sent without hashing it first.
+// Responses other than 2xx are returned as errors, 404 as
+// stow.ErrNotFound.
+func (l *location) do(method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	l.signer.sign(req, unsignedPayload, time.Now())
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, stow.ErrNotFound
+	}
+	var e errorResponse
+	data, _ := ioutil.ReadAll(resp.Body)
+	if xml.Unmarshal(data, &e) != nil || len(e.Code) == 0 {
+		return nil, fmt.Errorf("S3 %v %v: %v", method, u.Path, resp.Status)
+	}
+	return nil, fmt.Errorf("S3 %v %v: %v: %v", method, u.Path, e.Code, e.Message)
+}
+
+func (l *location) Close() error {
+	return nil
+}
+
+func (l *location) CreateContainer(name string) (stow.Container, error) {
+	var body []byte
+	if l.signer.region != defaultRegion {
+		body = []byte(fmt.Sprintf("<CreateBucketConfiguration><LocationConstraint>%v</LocationConstraint></CreateBucketConfiguration>", l.signer.region))
+	}
+	resp, err := l.do(http.MethodPut, l.objectURL(name, "", nil), bytes.NewReader(body), int64(len(body)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &container{location: l, name: name}, nil
+}
+
+func (l *location) Containers(prefix string, cursor string, count int) ([]stow.Container, string, error) {
+	resp, err := l.do(http.MethodGet, l.objectURL("", "", nil), nil, 0, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Buckets []struct {
+			Name string `xml:"Name"`
+		} `xml:"Buckets>Bucket"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Buckets aren't paginated; the cursor is the next bucket name
+	var containers []stow.Container
+	started := cursor == stow.CursorStart
+	for _, b := range result.Buckets {
+		if !started && b.Name != cursor {
+			continue
+		}
+		started = true
+		if !strings.HasPrefix(b.Name, prefix) {
+			continue
+		}
+		if len(containers) == count {
+			return containers, b.Name, nil
+		}
+		containers = append(containers, &container{location: l, name: b.Name})
+	}
+	return containers, "", nil
+}
+
+func (l *location) Container(id string) (stow.Container, error) {
+	resp, err := l.do(http.MethodHead, l.objectURL(id, "", nil), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &container{location: l, name: id}, nil
+}
+
+func (l *location) RemoveContainer(id string) error {
+	resp, err := l.do(http.MethodDelete, l.objectURL(id, "", nil), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (l *location) ItemByURL(u *url.URL) (stow.Item, error) {
+	if u.Scheme != Kind {
+		return nil, stow.ErrNotFound
+	}
+	c := &container{location: l, name: u.Host}
+	return c.Item(strings.TrimPrefix(u.Path, "/"))
+}
+
+// container is a bucket; items are named by their keys.
+type container struct {
+	location *location
+	name     string
+}
+
+func (c *container) ID() string {
+	return c.name
+}
+
+func (c *container) Name() string {
+	return c.name
+}
+
+func (c *container) Item(id string) (stow.Item, error) {
+	resp, err := c.location.do(http.MethodHead, c.location.objectURL(c.name, id, nil), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	i := &item{
+		container: c,
+		key:       id,
+		size:      resp.ContentLength,
+		etag:      strings.Trim(resp.Header.Get("ETag"), `"`),
+		metadata:  make(map[string]interface{}),
+	}
+	i.lastMod, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	for k, vs := range resp.Header {
+		if strings.HasPrefix(k, metadataHeaderPrefix) && len(vs) > 0 {
+			i.metadata[strings.ToLower(strings.TrimPrefix(k, metadataHeaderPrefix))] = vs[0]
+		}
+	}
+	return i, nil
+}
+
+// Items lists the objects with ListObjectsV2; the cursor is the
+// continuation token of the next page.
+func (c *container) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("max-keys", strconv.Itoa(count))
+	if len(prefix) > 0 {
+		query.Set("prefix", prefix)
+	}
+	if cursor != stow.CursorStart {
+		query.Set("continuation-token", cursor)
+	}
+
+	resp, err := c.location.do(http.MethodGet, c.location.objectURL(c.name, "", query), nil, 0, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+		Contents              []struct {
+			Key          string    `xml:"Key"`
+			LastModified time.Time `xml:"LastModified"`
+			ETag         string    `xml:"ETag"`
+			Size         int64     `xml:"Size"`
+		} `xml:"Contents"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, "", err
+	}
+
+	items := make([]stow.Item, 0, len(result.Contents))
+	for _, obj := range result.Contents {
+		items = append(items, &item{
+			container: c,
+			key:       obj.Key,
+			size:      obj.Size,
+			etag:      strings.Trim(obj.ETag, `"`),
+			lastMod:   obj.LastModified,
+		})
+	}
+
+	next := ""
+	if result.IsTruncated {
+		next = result.NextContinuationToken
+	}
+	return items, next, nil
+}
+
+func (c *container) RemoveItem(id string) error {
+	resp, err := c.location.do(http.MethodDelete, c.location.objectURL(c.name, id, nil), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Put uploads the object in one request; string metadata is stored as
+// x-amz-meta-* headers.
+func (c *container) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	header := http.Header{}
+	for k, v := range metadata {
+		s, ok := v.(string)
+		if !ok {
+			return nil, stow.NotSupported("non-string metadata")
+		}
+		header.Set(metadataHeaderPrefix+k, s)
+	}
+
+	// Readers that aren't files or buffers would be sent chunked, which
+	// S3 rejects without a length
+	body := r
+	if size == 0 {
+		body = http.NoBody
+	}
+	resp, err := c.location.do(http.MethodPut, c.location.objectURL(c.name, name, nil), body, size, header)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return &item{
+		container: c,
+		key:       name,
+		size:      size,
+		etag:      strings.Trim(resp.Header.Get("ETag"), `"`),
+		lastMod:   time.Now(),
+		metadata:  metadata,
+	}, nil
+}
+
+type item struct {
+	container *container
+	key       string
+	size      int64
+	etag      string
+	lastMod   time.Time
+	metadata  map[string]interface{}
+}
+
+func (i *item) ID() string {
+	return i.key
+}
+
+func (i *item) Name() string {
+	return i.key
+}
+
+func (i *item) URL() *url.URL {
+	return &url.URL{Scheme: Kind, Host: i.container.name, Path: "/" + i.key}
+}
+
+func (i *item) Size() (int64, error) {
+	return i.size, nil
+}
+
+func (i *item) Open() (io.ReadCloser, error) {
+	resp, err := i.container.location.do(http.MethodGet, i.container.location.objectURL(i.container.name, i.key, nil), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// OpenRange opens the bytes from start to end, both inclusive.
+func (i *item) OpenRange(start, end uint64) (io.ReadCloser, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := i.container.location.do(http.MethodGet, i.container.location.objectURL(i.container.name, i.key, nil), nil, 0, header)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (i *item) ETag() (string, error) {
+	return i.etag, nil
+}
+
+func (i *item) LastMod() (time.Time, error) {
+	return i.lastMod, nil
+}
+
+// Metadata returns the x-amz-meta-* headers of the object; listed items
+// are fetched again since listings don't include them.
+func (i *item) Metadata() (map[string]interface{}, error) {
+	if i.metadata == nil {
+		fetched, err := i.container.Item(i.key)
+		if err != nil {
+			return nil, err
+		}
+		i.metadata = fetched.(*item).metadata
+	}
+	return i.metadata, nil
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Query parameters of signed download URLs
+	SignedUrlExpires   = "expires"
+	SignedUrlSignature = "signature"
+
+	// FetchGrantHeader carries a fetch grant of the archive whose
+	// download URL is signed.
+	FetchGrantHeader = "X-Archive-Fetch-Grant"
+
+	// maxDownloadUrlTTL bounds how long signed URLs stay valid; they're
+	// signed right before the download.
+	maxDownloadUrlTTL = time.Hour
+
+	// maxGrantedDownloadUrlTTL bounds how long URLs signed for fetch
+	// grants stay valid; fetchers get them when they specialize.
+	maxGrantedDownloadUrlTTL = 10 * time.Minute
+
+	// maxUploadGrantTTL bounds how long upload grants stay valid; they're
+	// issued right before the upload.
+	maxUploadGrantTTL = time.Hour
+)
+
+var (
+	ErrMissingSignature = errors.New("download URL is not signed")
+	ErrInvalidSignature = errors.New("download URL signature is invalid")
+	ErrExpiredSignature = errors.New("download URL has expired")
+
+	ErrInvalidGrant = errors.New("upload grant is invalid or expired")
+)
+
+type (
+	// DownloadUrlSignature is added to the download URL of an archive, as
+	// the expires and signature query parameters.
+	DownloadUrlSignature struct {
+		Expires   int64  `json:"expires"`
+		Signature string `json:"signature"`
+	}
+
+	// FetchGrant lets the fetchers of function deployments, which don't
+	// hold the issuer token, get signed download URLs of an archive when
+	// they specialize. Grants don't expire, so that the pod specs they're
+	// in don't change.
+	FetchGrant struct {
+		Grant string `json:"grant"`
+	}
+
+	// UploadGrant is sent with uploads, as the X-Archive-Grant header, for
+	// the storage service to take their namespace and package from.
+	UploadGrant struct {
+		Grant   string `json:"grant"`
+		Expires int64  `json:"expires"`
+	}
+
+	// downloadUrlSigner signs download URLs with an HMAC of the archive ID
+	// and expiry time. Only callers presenting the issuer token get
+	// signatures; downloads without a valid one are rejected.
+	//
+	// It also signs upload and fetch grants, with keys derived from the
+	// same key so that grants can't be passed off as download signatures.
+	downloadUrlSigner struct {
+		key         []byte
+		grantKey    []byte
+		fetchKey    []byte
+		issuerToken string
+	}
+)
+
+func makeDownloadUrlSigner(key string, issuerToken string) (*downloadUrlSigner, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if len(issuerToken) == 0 {
+		return nil, errors.New("signed download URLs need an issuer token")
+	}
+	return &downloadUrlSigner{
+		key:         []byte(key),
+		grantKey:    deriveKey(key, "upload-grant"),
+		fetchKey:    deriveKey(key, "fetch-grant"),
+		issuerToken: issuerToken,
+	}, nil
+}
+
+func deriveKey(key string, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (s *downloadUrlSigner) signature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%v\n%v", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *downloadUrlSigner) sign(id string, ttl time.Duration, now time.Time) *DownloadUrlSignature {
+	if ttl <= 0 || ttl > maxDownloadUrlTTL {
+		ttl = maxDownloadUrlTTL
+	}
+	expires := now.Add(ttl).Unix()
+	return &DownloadUrlSignature{Expires: expires, Signature: s.signature(id, expires)}
+}
+
+// verify checks the signature of the download request of an archive.
+func (s *downloadUrlSigner) verify(r *http.Request, id string, now time.Time) error {
+	query := r.URL.Query()
+	sig := query.Get(SignedUrlSignature)
+	if len(sig) == 0 {
+		return ErrMissingSignature
+	}
+	expires, err := strconv.ParseInt(query.Get(SignedUrlExpires), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(id, expires))) {
+		return ErrInvalidSignature
+	}
+	if now.Unix() > expires {
+		return ErrExpiredSignature
+	}
+	return nil
+}
+
+func (s *downloadUrlSigner) grantSignature(namespace string, pkg string, expires int64) string {
+	mac := hmac.New(sha256.New, s.grantKey)
+	fmt.Fprintf(mac, "%v\n%v\n%v", namespace, pkg, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// grant returns an upload grant for the namespace and package, which are
+// Kubernetes names and so can't contain colons.
+func (s *downloadUrlSigner) grant(namespace string, pkg string, ttl time.Duration, now time.Time) *UploadGrant {
+	if ttl <= 0 || ttl > maxUploadGrantTTL {
+		ttl = maxUploadGrantTTL
+	}
+	expires := now.Add(ttl).Unix()
+	return &UploadGrant{
+		Grant:   fmt.Sprintf("%v:%v:%v:%v", namespace, pkg, expires, s.grantSignature(namespace, pkg, expires)),
+		Expires: expires,
+	}
+}
+
+// verifyGrant returns the namespace and package of a valid upload grant.
+func (s *downloadUrlSigner) verifyGrant(grant string, now time.Time) (string, string, error) {
+	parts := strings.Split(grant, ":")
+	if len(parts) != 4 {
+		return "", "", ErrInvalidGrant
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", "", ErrInvalidGrant
+	}
+	if !hmac.Equal([]byte(parts[3]), []byte(s.grantSignature(parts[0], parts[1], expires))) {
+		return "", "", ErrInvalidGrant
+	}
+	if now.Unix() > expires {
+		return "", "", ErrInvalidGrant
+	}
+	return parts[0], parts[1], nil
+}
+
+// fetchGrant returns the fetch grant of an archive.
+func (s *downloadUrlSigner) fetchGrant(id string) string {
+	mac := hmac.New(sha256.New, s.fetchKey)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *downloadUrlSigner) verifyFetchGrant(id string, grant string) bool {
+	return len(grant) > 0 && hmac.Equal([]byte(grant), []byte(s.fetchGrant(id)))
+}
+
+func (s *downloadUrlSigner) authorizedIssuer(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.issuerToken)) == 1
+}
+
+// authorized returns whether the request may see the stored archives,
+// which needs the issuer token if the storage service signs URLs.
+func (ss *StorageService) authorized(r *http.Request) bool {
+	return ss.downloadUrlSigner == nil || ss.downloadUrlSigner.authorizedIssuer(r)
+}
+
+// signDownloadUrlHandler returns the signature of the archive's download
+// URL, valid for the ttl query parameter in seconds. Callers without the
+// issuer token need a fetch grant of the archive, and get shorter lived
+// signatures.
+func (ss *StorageService) signDownloadUrlHandler(w http.ResponseWriter, r *http.Request) {
+	signer := ss.downloadUrlSigner
+	if signer == nil {
+		http.Error(w, "Signed download URLs are not enabled", http.StatusNotFound)
+		return
+	}
+
+	fileId, err := ss.getIdFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl, err := strconv.Atoi(r.URL.Query().Get("ttl"))
+	if err != nil {
+		http.Error(w, "Invalid `ttl' query param", http.StatusBadRequest)
+		return
+	}
+	signTTL := time.Duration(ttl) * time.Second
+
+	if !signer.authorizedIssuer(r) {
+		if !signer.verifyFetchGrant(fileId, r.Header.Get(FetchGrantHeader)) {
+			http.Error(w, "Invalid issuer token or fetch grant", http.StatusUnauthorized)
+			return
+		}
+		if signTTL <= 0 || signTTL > maxGrantedDownloadUrlTTL {
+			signTTL = maxGrantedDownloadUrlTTL
+		}
+	}
+
+	sig := signer.sign(fileId, signTTL, time.Now())
+	log.Debugf("Signed download URL of archive %v until %v", fileId, time.Unix(sig.Expires, 0))
+
+	err = json.NewEncoder(w).Encode(sig)
+	if err != nil {
+		log.WithError(err).Error("Error writing download URL signature")
+	}
+}
+
+// uploadGrantHandler returns a grant for uploads of the namespace and
+// package query parameters, valid for the ttl query parameter in seconds.
+func (ss *StorageService) uploadGrantHandler(w http.ResponseWriter, r *http.Request) {
+	signer := ss.downloadUrlSigner
+	if signer == nil {
+		http.Error(w, "Signed download URLs are not enabled", http.StatusNotFound)
+		return
+	}
+	if !signer.authorizedIssuer(r) {
+		http.Error(w, "Invalid issuer token", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	namespace := query.Get("namespace")
+	pkg := query.Get("package")
+	if len(namespace) == 0 || strings.Contains(namespace, ":") || strings.Contains(pkg, ":") {
+		http.Error(w, "Invalid `namespace' or `package' query param", http.StatusBadRequest)
+		return
+	}
+	ttl, err := strconv.Atoi(query.Get("ttl"))
+	if err != nil {
+		http.Error(w, "Invalid `ttl' query param", http.StatusBadRequest)
+		return
+	}
+
+	grant := signer.grant(namespace, pkg, time.Duration(ttl)*time.Second, time.Now())
+	log.Debugf("Granted uploads of namespace %v until %v", namespace, time.Unix(grant.Expires, 0))
+
+	err = json.NewEncoder(w).Encode(grant)
+	if err != nil {
+		log.WithError(err).Error("Error writing upload grant")
+	}
+}
+
+// fetchGrantHandler returns the fetch grant of the archive.
+func (ss *StorageService) fetchGrantHandler(w http.ResponseWriter, r *http.Request) {
+	signer := ss.downloadUrlSigner
+	if signer == nil {
+		http.Error(w, "Signed download URLs are not enabled", http.StatusNotFound)
+		return
+	}
+	if !signer.authorizedIssuer(r) {
+		http.Error(w, "Invalid issuer token", http.StatusUnauthorized)
+		return
+	}
+
+	fileId, err := ss.getIdFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(&FetchGrant{Grant: signer.fetchGrant(fileId)})
+	if err != nil {
+		log.WithError(err).Error("Error writing fetch grant")
+	}
+}
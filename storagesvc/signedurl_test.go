@@ -0,0 +1,190 @@
+/*
+Copyright 2018 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storagesvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func makeTestSigner(t *testing.T) *downloadUrlSigner {
+	signer, err := makeDownloadUrlSigner("key", "token")
+	if err != nil {
+		t.Fatalf("error creating signer: %v", err)
+	}
+	return signer
+}
+
+func signedTestRequest(id string, sig *DownloadUrlSignature) *http.Request {
+	return httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/archive?id=%v&%v=%v&%v=%v",
+		id, SignedUrlExpires, sig.Expires, SignedUrlSignature, sig.Signature), nil)
+}
+
+func TestSignedUrlVerify(t *testing.T) {
+	signer := makeTestSigner(t)
+	now := time.Now()
+	sig := signer.sign("sha256-a", time.Minute, now)
+
+	if err := signer.verify(signedTestRequest("sha256-a", sig), "sha256-a", now); err != nil {
+		t.Fatalf("error verifying signed url: %v", err)
+	}
+
+	// The signature covers the ID and the expiry time
+	if err := signer.verify(signedTestRequest("sha256-b", sig), "sha256-b", now); err != ErrInvalidSignature {
+		t.Fatalf("expected url of another archive to fail with %v, got %v", ErrInvalidSignature, err)
+	}
+	extended := &DownloadUrlSignature{Expires: sig.Expires + 3600, Signature: sig.Signature}
+	if err := signer.verify(signedTestRequest("sha256-a", extended), "sha256-a", now); err != ErrInvalidSignature {
+		t.Fatalf("expected url with a changed expiry time to fail with %v, got %v", ErrInvalidSignature, err)
+	}
+	flipped := "0"
+	if sig.Signature[0] == '0' {
+		flipped = "1"
+	}
+	tampered := &DownloadUrlSignature{Expires: sig.Expires, Signature: flipped + sig.Signature[1:]}
+	if err := signer.verify(signedTestRequest("sha256-a", tampered), "sha256-a", now); err != ErrInvalidSignature {
+		t.Fatalf("expected tampered signature to fail with %v, got %v", ErrInvalidSignature, err)
+	}
+
+	// Signatures of another key aren't accepted
+	other, _ := makeDownloadUrlSigner("other", "token")
+	if err := signer.verify(signedTestRequest("sha256-a", other.sign("sha256-a", time.Minute, now)), "sha256-a", now); err != ErrInvalidSignature {
+		t.Fatalf("expected signature of another key to fail with %v, got %v", ErrInvalidSignature, err)
+	}
+
+	if err := signer.verify(signedTestRequest("sha256-a", sig), "sha256-a", now.Add(2*time.Minute)); err != ErrExpiredSignature {
+		t.Fatalf("expected expired url to fail with %v, got %v", ErrExpiredSignature, err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/v1/archive?id=sha256-a", nil)
+	if err := signer.verify(req, "sha256-a", now); err != ErrMissingSignature {
+		t.Fatalf("expected unsigned url to fail with %v, got %v", ErrMissingSignature, err)
+	}
+
+	// Long lived URLs are cut to the maximum
+	if sig := signer.sign("sha256-a", 30*24*time.Hour, now); sig.Expires != now.Add(maxDownloadUrlTTL).Unix() {
+		t.Fatalf("expected url to expire after %v, got %v", maxDownloadUrlTTL, time.Unix(sig.Expires, 0).Sub(now))
+	}
+}
+
+func signTestDownloadUrl(ss *StorageService, id string, ttl time.Duration, header string, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/downloadurl?id=%v&ttl=%v", id, int64(ttl.Seconds())), nil)
+	if len(header) > 0 {
+		req.Header.Set(header, value)
+	}
+	w := httptest.NewRecorder()
+	ss.signDownloadUrlHandler(w, req)
+	return w
+}
+
+func TestFetchGrant(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+	signer := makeTestSigner(t)
+	ss.downloadUrlSigner = signer
+
+	if w := signTestDownloadUrl(ss, "sha256-a", time.Minute, "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected signing without a token or grant to be unauthorized, got %v", w.Code)
+	}
+
+	// Only the issuer gets fetch grants
+	req := httptest.NewRequest(http.MethodPost, "/v1/fetchgrant?id=sha256-a", nil)
+	w := httptest.NewRecorder()
+	ss.fetchGrantHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected fetch grant without a token to be unauthorized, got %v", w.Code)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	w = httptest.NewRecorder()
+	ss.fetchGrantHandler(w, req)
+	var grant FetchGrant
+	if err := json.Unmarshal(w.Body.Bytes(), &grant); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("error getting fetch grant: %v %v", w.Code, err)
+	}
+
+	// Grants sign URLs of their archive only, for a short time
+	w = signTestDownloadUrl(ss, "sha256-a", time.Hour, FetchGrantHeader, grant.Grant)
+	var sig DownloadUrlSignature
+	if err := json.Unmarshal(w.Body.Bytes(), &sig); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("error signing url with fetch grant: %v %v", w.Code, err)
+	}
+	if ttl := time.Until(time.Unix(sig.Expires, 0)); ttl > maxGrantedDownloadUrlTTL {
+		t.Fatalf("expected url signed with a grant to expire within %v, got %v", maxGrantedDownloadUrlTTL, ttl)
+	}
+	if err := signer.verify(signedTestRequest("sha256-a", &sig), "sha256-a", time.Now()); err != nil {
+		t.Fatalf("error verifying url signed with a grant: %v", err)
+	}
+	if w := signTestDownloadUrl(ss, "sha256-b", time.Minute, FetchGrantHeader, grant.Grant); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected grant of another archive to be unauthorized, got %v", w.Code)
+	}
+
+	// The issuer gets longer lived URLs
+	w = signTestDownloadUrl(ss, "sha256-b", time.Hour, "Authorization", "Bearer token")
+	if err := json.Unmarshal(w.Body.Bytes(), &sig); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("error signing url: %v %v", w.Code, err)
+	}
+	if ttl := time.Until(time.Unix(sig.Expires, 0)); ttl <= maxGrantedDownloadUrlTTL {
+		t.Fatalf("expected url signed by the issuer to expire after an hour, got %v", ttl)
+	}
+}
+
+func TestSignedStorageAuthorization(t *testing.T) {
+	ss, cleanup := makeTestStorageService(t)
+	defer cleanup()
+	ss.downloadUrlSigner = makeTestSigner(t)
+	id := storeTestContent(t, ss.storageClient, []byte("archive"))
+
+	for _, v := range []struct {
+		method  string
+		url     string
+		handler http.HandlerFunc
+	}{
+		{http.MethodGet, "/v1/archives", ss.listHandler},
+		{http.MethodGet, "/v1/metadata?id=" + id, ss.metadataHandler},
+		{http.MethodGet, "/v1/usage", ss.usageHandler},
+		{http.MethodHead, "/v1/archive?id=" + id, ss.headHandler},
+		{http.MethodDelete, "/v1/archive?id=" + id, ss.deleteHandler},
+	} {
+		w := httptest.NewRecorder()
+		v.handler(w, httptest.NewRequest(v.method, v.url, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected %v %v without a token to be unauthorized, got %v", v.method, v.url, w.Code)
+		}
+
+		req := httptest.NewRequest(v.method, v.url, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		w = httptest.NewRecorder()
+		v.handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("error in %v %v with the token: %v %v", v.method, v.url, w.Code, w.Body.String())
+		}
+	}
+
+	// Lookups with an upload grant record an upload instead
+	id = storeTestContent(t, ss.storageClient, []byte("other"))
+	grant := ss.downloadUrlSigner.grant("team-a", "pkg", 0, time.Now())
+	req := httptest.NewRequest(http.MethodHead, "/v1/archive?id="+id, nil)
+	req.Header.Set("X-Archive-"+MetadataGrant, grant.Grant)
+	w := httptest.NewRecorder()
+	ss.headHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("error looking up archive with an upload grant: %v", w.Code)
+	}
+}
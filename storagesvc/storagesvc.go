@@ -18,7 +18,6 @@ package storagesvc
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"hash"
@@ -41,6 +40,7 @@ import (
 	"github.com/fission/fission"
 	"github.com/fission/fission/storagesvc/multipartformdata"
 	"github.com/fission/fission/storagesvc/progress"
+	"github.com/fission/fission/storagesvc/s3"
 )
 
 const (
@@ -54,12 +54,30 @@ const (
 	ConfigGCSJSON      = google.ConfigJSON
 	ConfigGCSProjectId = google.ConfigProjectId
 	ConfigGCSScopes    = google.ConfigScopes
+
+	ConfigKindS3        = s3.Kind
+	ConfigS3Endpoint    = s3.ConfigEndpoint
+	ConfigS3Region      = s3.ConfigRegion
+	ConfigS3AccessKeyID = s3.ConfigAccessKeyID
+	ConfigS3SecretKey   = s3.ConfigSecretKey
+
+	// ConfigEncryptionKeyFile is a file with the master keys that encrypt
+	// the archives of the container.
+	ConfigEncryptionKeyFile = "fission/storagesvc/encryption-key-file"
+
+	// ConfigMigrate copies the archives of a read-only container into the
+	// read-write container when it's set to "true".
+	ConfigMigrate = "fission/storagesvc/migrate"
 )
 
 type (
 	StorageService struct {
-		storageClient *StowClient
-		port          int
+		storageClient  *StowClient
+		chunkedUploads *chunkedUploads
+		port           int
+
+		// downloadUrlSigner is set if downloads need signed URLs
+		downloadUrlSigner *downloadUrlSigner
 	}
 
 	UploadStatus struct {
@@ -105,12 +123,27 @@ func (ss *StorageService) uploadHandler(w http.ResponseWriter, r *http.Request)
 	}
 	uploadName, ok := mux.Vars(r)["archiveID"]
 
+	info, err := ss.uploadInfoFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	// Uploads of unknown size are checked against the quota once stored
+	var reservation *quotaReservation
+	if fileSize >= 0 {
+		reservation, err = ss.storageClient.reserveQuota(info.namespace, int64(fileSize), contentID(expectedFileSHA256))
+		if !writeQuotaError(w, info, err) {
+			return
+		}
+		defer ss.storageClient.releaseQuota(reservation)
+	}
+
 	mr, err := r.MultipartReader()
 	if err != nil {
 		log.WithError(err).Error("error parsing multipart form")
 	}
 
-	var digest string
+	var archiveID string
 	visitor := func(filename string, header textproto.MIMEHeader, reader io.Reader) (func() error, error) {
 		log.Infof("Handling upload for %v", filename)
 
@@ -118,43 +151,58 @@ func (ss *StorageService) uploadHandler(w http.ResponseWriter, r *http.Request)
 			return nil, fmt.Errorf("Unexpected file: %s", filename)
 		}
 
-		// TODO: allow headers to add more metadata (e.g. environment
-		// and function metadata)
+		id, existed, err := ss.storageClient.putContent(reader, int64(fileSize), uploadName, expectedFileSHA256)
+		if err != nil {
+			return nil, err
+		}
+		archiveID = id
 
-		hasher := sha256.New()
-		digestReader := io.TeeReader(reader, hasher)
-		_, err = ss.storageClient.putFile(digestReader, int64(fileSize), uploadName)
+		size := int64(fileSize)
+		if _, item, err := ss.storageClient.findItemForUploadName(id); err == nil {
+			size, _ = item.Size()
+		}
+		err = ss.storageClient.recordUpload(id, size, info, reservation)
 		if err != nil {
+			log.WithError(err).Errorf("Error recording metadata of archive %v", id)
+			if !existed {
+				ss.storageClient.removeUnrecorded(id)
+			}
 			return nil, err
 		}
-		digest = hexdigest(hasher)
+		if existed {
+			log.Debugf("Upload %v references stored archive %v", uploadName, id)
+		}
 
-		return func() error { return ss.storageClient.removeFileByID(uploadName) }, nil
+		// Drop the reference of this upload; content stored before it
+		// keeps its other references
+		return func() error {
+			return ss.storageClient.releaseArchive(id)
+		}, nil
 	}
 
 	err = multipartformdata.ReadForm(mr, visitor)
-	if err != nil {
+	if err == ErrChecksumMismatch {
+		http.Error(w, "Didn't match expected X-File-Sha256", http.StatusBadRequest)
+		return
+	} else if err == ErrQuotaExceeded || err == ErrNamespaceRequired {
+		writeQuotaError(w, info, err)
+		return
+	} else if err != nil {
 		log.WithError(err).Error("error parsing multipart form")
 		http.Error(w, "Error saving uploaded file", http.StatusInternalServerError)
 		return
 	}
 
 	// handle upload
-	if digest == "" {
+	if archiveID == "" {
 		log.WithError(err).Error("missing upload file")
 		http.Error(w, "missing upload file", http.StatusBadRequest)
 		return
 	}
 
-	if expectedFileSHA256 != "" && digest != expectedFileSHA256 {
-		log.Errorf("Did not match expected X-File-Sha256 %s, got %s", expectedFileSHA256, digest)
-		http.Error(w, "Didn't match expected X-File-Sha256", http.StatusBadRequest)
-		return
-	}
-
 	// respond with an ID that can be used to retrieve the file
 	ur := &UploadResponse{
-		ID: uploadName,
+		ID: archiveID,
 	}
 	resp, err := json.Marshal(ur)
 	if err != nil {
@@ -164,6 +212,23 @@ func (ss *StorageService) uploadHandler(w http.ResponseWriter, r *http.Request)
 	w.Write(resp)
 }
 
+// writeQuotaError responds with the error of a quota reservation, if any,
+// returning whether the upload can go on.
+func writeQuotaError(w http.ResponseWriter, info uploadInfo, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case ErrQuotaExceeded:
+		http.Error(w, fmt.Sprintf("Storage quota of namespace %v exceeded", info.namespace), http.StatusForbidden)
+	case ErrNamespaceRequired:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.WithError(err).Error("Error checking storage quota")
+		http.Error(w, "Error checking storage quota", http.StatusInternalServerError)
+	}
+	return false
+}
+
 func (ss *StorageService) getIdFromRequest(r *http.Request) (string, error) {
 	values := r.URL.Query()
 	ids, ok := values["id"]
@@ -175,7 +240,151 @@ func (ss *StorageService) getIdFromRequest(r *http.Request) (string, error) {
 	return filepath.Base(id), nil
 }
 
+// headHandler answers whether an archive exists, given its ID or the
+// SHA256 checksum of its content, so that clients can skip uploading
+// content that is stored already. The ID is returned in X-Archive-Id.
+// The X-Archive-* metadata of a skipped upload is recorded like that of
+// an upload.
+func (ss *StorageService) headHandler(w http.ResponseWriter, r *http.Request) {
+	var fileId string
+	if sum := r.URL.Query().Get("sha256"); len(sum) > 0 {
+		fileId = contentID(sum)
+		if len(fileId) == 0 {
+			http.Error(w, "Invalid sha256 checksum", http.StatusBadRequest)
+			return
+		}
+	} else {
+		var err error
+		fileId, err = ss.getIdFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A lookup with metadata stands for an upload of the same content;
+	// others need the issuer token, so that IDs can't be probed for
+	info, err := ss.uploadInfoFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if len(info.namespace) == 0 && !ss.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_, item, err := ss.storageClient.findItemForUploadName(fileId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	size, _ := item.Size()
+
+	if len(info.namespace) > 0 {
+		err = ss.storageClient.recordUpload(fileId, size, info, nil)
+		if err == ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err == ErrQuotaExceeded {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		} else if err != nil {
+			log.WithError(err).Errorf("Error recording metadata of archive %v", fileId)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The client is about to reference the archive
+	ss.storageClient.archiveUses.touch(fileId)
+
+	w.Header().Set("X-Archive-Id", fileId)
+	w.Header().Set("X-File-Size", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// listHandler returns a page of the stored archives, optionally only
+// those uploaded by a namespace or for a package.
+func (ss *StorageService) listHandler(w http.ResponseWriter, r *http.Request) {
+	if !ss.authorized(r) {
+		http.Error(w, "Invalid issuer token", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	limit := 100
+	if l := query.Get("limit"); len(l) > 0 {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	list, err := ss.storageClient.listArchives(query.Get("namespace"), query.Get("package"), query.Get("continue"), limit)
+	if err != nil {
+		log.WithError(err).Error("Error listing archives")
+		http.Error(w, fmt.Sprintf("Error listing archives: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(list)
+	if err != nil {
+		log.WithError(err).Error("Error writing archive list")
+	}
+}
+
+func (ss *StorageService) metadataHandler(w http.ResponseWriter, r *http.Request) {
+	if !ss.authorized(r) {
+		http.Error(w, "Invalid issuer token", http.StatusUnauthorized)
+		return
+	}
+	fileId, err := ss.getIdFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := ss.storageClient.getMetadata(fileId)
+	if err == ErrNotFound {
+		http.Error(w, "Error retrieving item: not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving metadata: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(m)
+	if err != nil {
+		log.WithError(err).Errorf("Error writing metadata of archive %v", fileId)
+	}
+}
+
+// usageHandler returns the storage used by each namespace and its quota.
+func (ss *StorageService) usageHandler(w http.ResponseWriter, r *http.Request) {
+	if !ss.authorized(r) {
+		http.Error(w, "Invalid issuer token", http.StatusUnauthorized)
+		return
+	}
+	usage, err := ss.storageClient.namespaceUsage()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error computing usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(usage)
+	if err != nil {
+		log.WithError(err).Error("Error writing usage")
+	}
+}
+
 func (ss *StorageService) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !ss.authorized(r) {
+		http.Error(w, "Invalid issuer token", http.StatusUnauthorized)
+		return
+	}
 	// get id from request
 	fileId, err := ss.getIdFromRequest(r)
 	if err != nil {
@@ -183,8 +392,13 @@ func (ss *StorageService) deleteHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = ss.storageClient.removeFileByID(fileId)
-	if err != nil {
+	// Archives with the same content are shared, so this only drops a
+	// reference unless it's the last one
+	err = ss.storageClient.releaseArchive(fileId)
+	if err == ErrNotFound {
+		http.Error(w, "Error deleting item: not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		msg := fmt.Sprintf("Error deleting item: %v", err)
 		http.Error(w, msg, http.StatusInternalServerError)
 		return
@@ -200,6 +414,15 @@ func (ss *StorageService) downloadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if ss.downloadUrlSigner != nil {
+		err = ss.downloadUrlSigner.verify(r, fileId, time.Now())
+		if err != nil {
+			log.WithError(err).Warnf("Rejected download of archive '%v'", fileId)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
 	// Get the file (called "item" in stow's jargon), open it,
 	// stream it to response
 	err = ss.storageClient.copyFileToStream(fileId, w)
@@ -375,13 +598,28 @@ func resolveContainerFromConfig(config map[string]string) (stow.Container, error
 	kind := config[ConfigKind]
 	containerName := config[ConfigContainer]
 
-	return ResolveContainer(kind, containerName, config)
+	container, err := ResolveContainer(kind, containerName, config)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile := config[ConfigEncryptionKeyFile]
+	if len(keyFile) == 0 {
+		return container, nil
+	}
+	keys, err := loadEncryptionKeys(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading encryption keys")
+	}
+	log.Infof("Encrypting archives of %v container %v", kind, containerName)
+	return makeEncryptedContainer(container, keys), nil
 }
 
 func MakeStorageService(storageClient *StowClient, port int) *StorageService {
 	return &StorageService{
-		storageClient: storageClient,
-		port:          port,
+		storageClient:  storageClient,
+		chunkedUploads: makeChunkedUploads(),
+		port:           port,
 	}
 }
 
@@ -389,13 +627,28 @@ func (ss *StorageService) Start(port int) error {
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/archive", ss.uploadHandler).Queries("archiveID", "{archiveID}").Methods("POST")
 	r.HandleFunc("/v1/archive/{archiveID}", ss.uploadHandler).Methods("POST")
+	r.HandleFunc("/v1/archive", ss.uploadHandler).Methods("POST")
 	r.HandleFunc("/v1/archive", ss.downloadHandler).Methods("GET")
+	r.HandleFunc("/v1/archive", ss.headHandler).Methods("HEAD")
+	r.HandleFunc("/v1/uploads", ss.createChunkedUploadHandler).Methods("POST")
+	r.HandleFunc("/v1/uploads", ss.chunkedUploadOffsetHandler).Methods("HEAD")
+	r.HandleFunc("/v1/uploads", ss.uploadChunkHandler).Methods("PATCH")
+	r.HandleFunc("/v1/uploads", ss.deleteChunkedUploadHandler).Methods("DELETE")
+	r.HandleFunc("/v1/uploads/finalize", ss.finalizeChunkedUploadHandler).Methods("POST")
 	r.HandleFunc("/v1/status", ss.statusHandler).Methods("GET")
 	r.HandleFunc("/v1/status", ss.setStatusExtraHandler).Methods("POST")
 	r.HandleFunc("/v1/events", ss.eventsHandler).Methods("GET")
 	r.HandleFunc("/v1/archive", ss.deleteHandler).Methods("DELETE")
+	r.HandleFunc("/v1/archives", ss.listHandler).Methods("GET")
+	r.HandleFunc("/v1/metadata", ss.metadataHandler).Methods("GET")
+	r.HandleFunc("/v1/usage", ss.usageHandler).Methods("GET")
+	r.HandleFunc("/v1/downloadurl", ss.signDownloadUrlHandler).Methods("POST")
+	r.HandleFunc("/v1/uploadgrant", ss.uploadGrantHandler).Methods("POST")
+	r.HandleFunc("/v1/fetchgrant", ss.fetchGrantHandler).Methods("POST")
 	r.HandleFunc("/healthz", ss.healthHandler).Methods("GET")
 
+	go ss.removeExpiredUploads()
+
 	address := fmt.Sprintf(":%v", port)
 
 	r.Use(fission.LoggingMiddleware)
@@ -420,19 +673,50 @@ func RunStorageService(port int, enablePruner bool, readWriteConfig map[string]s
 		return err
 	}
 	readOnlyContainers := []stow.Container{}
+	migrateContainers := []stow.Container{}
 	for _, readOnlyConfig := range readOnlyConfigs {
 		readOnlyContainer, err := resolveContainerFromConfig(readOnlyConfig)
 		if err != nil {
 			return err
 		}
 		readOnlyContainers = append(readOnlyContainers, readOnlyContainer)
+		if readOnlyConfig[ConfigMigrate] == "true" {
+			migrateContainers = append(migrateContainers, readOnlyContainer)
+		}
 	}
 
 	storageClient := MakeStowClient(readWriteContainer, readOnlyContainers...)
 
+	// Archives are served from the read-only containers until they're
+	// copied, so the migration doesn't hold up the service
+	if len(migrateContainers) > 0 {
+		go storageClient.migrate(migrateContainers)
+	}
+
+	// ARCHIVE_QUOTAS limits the storage of namespaces, e.g. "team-a=10Gi,*=1Gi"
+	quotas, err := parseArchiveQuotas(os.Getenv("ARCHIVE_QUOTAS"))
+	if err != nil {
+		return errors.Wrap(err, "Error parsing ARCHIVE_QUOTAS")
+	}
+	storageClient.metadata.quotas = quotas
+
 	// create http handlers
 	storageService := MakeStorageService(storageClient, port)
 
+	// ARCHIVE_URL_SIGNING_KEY makes downloads need URLs signed by the
+	// storage service, which are issued to holders of ARCHIVE_URL_ISSUER_TOKEN
+	storageService.downloadUrlSigner, err = makeDownloadUrlSigner(
+		os.Getenv("ARCHIVE_URL_SIGNING_KEY"), os.Getenv("ARCHIVE_URL_ISSUER_TOKEN"))
+	if err != nil {
+		return err
+	}
+
+	// Quotas are charged to the namespace of upload grants, which are
+	// signed with the same key
+	if quotas.enabled() && storageService.downloadUrlSigner == nil {
+		return errors.New("ARCHIVE_QUOTAS needs ARCHIVE_URL_SIGNING_KEY, to sign the namespaces of uploads")
+	}
+
 	// enablePruner prevents storagesvc unit test from needing to talk to kubernetes
 	if enablePruner {
 		// get the prune interval and start the archive pruner
@@ -19,7 +19,6 @@ package storagesvc
 import (
 	"errors"
 	"io"
-	"path/filepath"
 	"time"
 
 	multierror "github.com/hashicorp/go-multierror"
@@ -27,7 +26,6 @@ import (
 	"github.com/fission/fission/storagesvc/progress"
 	"github.com/graymeta/stow"
 	_ "github.com/graymeta/stow/local"
-	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -37,6 +35,8 @@ type (
 
 		readContainers []stow.Container
 		uploads        *UploadRegistry
+		archiveUses    *archiveUses
+		metadata       *archiveMetadataStore
 	}
 )
 
@@ -79,31 +79,12 @@ func MakeStowClient(readWriteContainer stow.Container, readOnlyContainers ...sto
 		writeContainer: readWriteContainer,
 		readContainers: append([]stow.Container{readWriteContainer}, readOnlyContainers...),
 
-		uploads: NewUploadRegistry(),
+		uploads:     NewUploadRegistry(),
+		archiveUses: makeArchiveUses(),
+		metadata:    makeArchiveMetadataStore(),
 	}
 }
 
-// putFile writes the file on the storage
-func (client *StowClient) putFile(reader io.Reader, fileSize int64, uploadName string) (string, error) {
-	if uploadName == "" {
-		// This is not the item ID (that's returned by Put)
-		// should we just use handler.Filename? what are the constraints here?
-		uploadName = uuid.NewV4().String()
-	}
-
-	r := client.uploads.declare(uploadName, fileSize, reader)
-	defer client.uploads.remove(uploadName, r)
-
-	item, err := client.writeContainer.Put(uploadName, r, int64(fileSize), nil)
-	if err != nil {
-		log.WithError(err).Errorf("Error writing file: %s on storage, size %d", uploadName, fileSize)
-		return "", ErrWritingFile
-	}
-
-	log.Debugf("Successfully wrote file:%s on storage", uploadName)
-	return item.ID(), nil
-}
-
 type completedUpload int64
 
 func (cu completedUpload) N() int64 {
@@ -125,9 +106,10 @@ func (client *StowClient) setStatusExtra(uploadName string, extra interface{}) e
 func (client *StowClient) findItemForUploadName(uploadName string) (stow.Container, stow.Item, error) {
 	merr := &multierror.Error{}
 	for _, container := range client.readContainers {
-		itemID := filepath.Join(container.ID(), uploadName)
-
-		item, err := container.Item(itemID)
+		// Item IDs of the local backend are paths, but it also
+		// accepts names relative to the container, which is what
+		// the object stores use
+		item, err := container.Item(uploadName)
 		if err == nil {
 			return container, item, nil
 		}
@@ -192,13 +174,35 @@ func (client *StowClient) copyFileToStream(uploadName string, w io.Writer) error
 	return nil
 }
 
-// removeFileByID deletes the file from storage
+// removeFileByID deletes the file from storage, however many references
+// to it are left.
 func (client *StowClient) removeFileByID(uploadName string) error {
-	container, _, err := client.findItemForUploadName(uploadName)
+	client.metadata.lock.Lock()
+	defer client.metadata.lock.Unlock()
+
+	m, err := client.readMetadata(uploadName)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	return client.removeArchive(uploadName, m)
+}
+
+// removeArchive deletes the file and its metadata, if any, from storage.
+// The caller holds the metadata lock.
+func (client *StowClient) removeArchive(uploadName string, m *ArchiveMetadata) error {
+	container, item, err := client.findItemForUploadName(uploadName)
+	if err != nil {
+		return err
+	}
+	// Item IDs of local files are paths, not the upload name
+	err = container.RemoveItem(item.ID())
 	if err != nil {
 		return err
 	}
-	return container.RemoveItem(uploadName)
+	if m != nil {
+		client.forgetMetadata(m)
+	}
+	return nil
 }
 
 // filter defines an interface to filter out items from a set of items
@@ -220,11 +224,16 @@ func (client *StowClient) getItemIDsWithFilter(filterFunc filter, filterFuncPara
 		}
 
 		for _, item := range items {
+			if isMetadataItem(item.Name()) {
+				continue
+			}
 			isItemFilterable := filterFunc(item, filterFuncParam)
 			if isItemFilterable {
 				continue
 			}
-			archiveIDList = append(archiveIDList, item.ID())
+			// The name is the archive ID; the item ID of some
+			// backends, e.g. local files, is a full path
+			archiveIDList = append(archiveIDList, item.Name())
 		}
 
 		if stow.IsCursorEnd(cursor) {
@@ -245,3 +254,60 @@ func filterItemCreatedAMinuteAgo(item stow.Item, currentTime interface{}) bool {
 	}
 	return false
 }
+
+// migrate copies the archives and their metadata from the containers into
+// the read-write container, skipping those it has already. Archives are
+// decrypted and encrypted again on the way if the containers are
+// encrypted, so this also moves archives to a new backend or encryption
+// key.
+func (client *StowClient) migrate(containers []stow.Container) {
+	copied := 0
+	for _, container := range containers {
+		cursor := stow.CursorStart
+		for {
+			items, next, err := container.Items(stow.NoPrefix, cursor, PaginationSize)
+			if err != nil {
+				log.WithError(err).Errorf("Error listing items of container %v to migrate", container.Name())
+				break
+			}
+			for _, item := range items {
+				_, err := client.writeContainer.Item(item.Name())
+				if err == nil {
+					continue
+				}
+				err = copyItem(client.writeContainer, item)
+				if err != nil {
+					log.WithError(err).Errorf("Error migrating %v from container %v", item.Name(), container.Name())
+					continue
+				}
+				copied++
+			}
+			if stow.IsCursorEnd(next) {
+				break
+			}
+			cursor = next
+		}
+	}
+
+	// The usage is computed again from the copied metadata
+	client.metadata.lock.Lock()
+	client.metadata.usage = nil
+	client.metadata.lock.Unlock()
+
+	log.Infof("Migrated %v items into container %v", copied, client.writeContainer.Name())
+}
+
+func copyItem(dst stow.Container, item stow.Item) error {
+	size, err := item.Size()
+	if err != nil {
+		return err
+	}
+	f, err := item.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = dst.Put(item.Name(), f, size, nil)
+	return err
+}
@@ -9,9 +9,16 @@ import (
 )
 
 type (
+	// uploadCounter counts the bytes received for an upload, e.g. a
+	// progress.Reader over the request body.
+	uploadCounter interface {
+		progress.Counter
+		SetExtra(interface{})
+	}
+
 	pendingUpload struct {
-		reader *progress.Reader
-		size   int64
+		counter uploadCounter
+		size    int64
 	}
 	UploadRegistry struct {
 		mutex       sync.RWMutex
@@ -29,18 +36,22 @@ func NewUploadRegistry() *UploadRegistry {
 
 func (reg *UploadRegistry) declare(uploadName string, size int64, reader io.Reader) *progress.Reader {
 	r := progress.NewReader(reader)
+	reg.declareCounter(uploadName, size, r)
+	return r
+}
+
+func (reg *UploadRegistry) declareCounter(uploadName string, size int64, counter uploadCounter) {
 	reg.mutex.Lock()
 	defer reg.mutex.Unlock()
 
 	fmt.Printf("declare(%s, ...)\n", uploadName)
-	reg.pending[uploadName] = &pendingUpload{reader: r, size: size}
+	reg.pending[uploadName] = &pendingUpload{counter: counter, size: size}
 
 	extra, ok := reg.earlyExtras[uploadName]
 	if ok {
-		r.SetExtra(extra)
+		counter.SetExtra(extra)
 		delete(reg.earlyExtras, uploadName)
 	}
-	return r
 }
 
 func (reg *UploadRegistry) setExtra(uploadName string, extra interface{}) error {
@@ -53,7 +64,7 @@ func (reg *UploadRegistry) setExtra(uploadName string, extra interface{}) error
 		reg.earlyExtras[uploadName] = extra
 		return nil
 	}
-	pending.reader.SetExtra(extra)
+	pending.counter.SetExtra(extra)
 	return nil
 }
 
@@ -66,16 +77,16 @@ func (reg *UploadRegistry) get(uploadName string) (progress.Counter, int64) {
 	if !ok {
 		return nil, -1
 	}
-	return pending.reader, pending.size
+	return pending.counter, pending.size
 }
 
-func (reg *UploadRegistry) remove(uploadName string, r *progress.Reader) {
+func (reg *UploadRegistry) remove(uploadName string, counter uploadCounter) {
 	reg.mutex.Lock()
 	defer reg.mutex.Unlock()
 
 	fmt.Printf("remove(%s, ...)\n", uploadName)
 	existing, ok := reg.pending[uploadName]
-	if ok && existing.reader == r {
+	if ok && existing.counter == counter {
 		delete(reg.pending, uploadName)
 	}
 }
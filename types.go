@@ -17,6 +17,9 @@ limitations under the License.
 package fission
 
 import (
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	fv1 "github.com/fission/fission/pkg/apis/fission.io/v1"
@@ -27,17 +30,25 @@ type (
 	Checksum                     = fv1.Checksum
 	ArchiveType                  = fv1.ArchiveType
 	Archive                      = fv1.Archive
+	GitSource                    = fv1.GitSource
+	SignatureType                = fv1.SignatureType
+	ArchiveSignature             = fv1.ArchiveSignature
 	EnvironmentReference         = fv1.EnvironmentReference
 	SecretReference              = fv1.SecretReference
 	ConfigMapReference           = fv1.ConfigMapReference
 	BuildStatus                  = fv1.BuildStatus
 	PackageSpec                  = fv1.PackageSpec
 	PackageStatus                = fv1.PackageStatus
+	PackageRevision              = fv1.PackageRevision
 	PackageRef                   = fv1.PackageRef
 	FunctionPackageRef           = fv1.FunctionPackageRef
+	FunctionVolume               = fv1.FunctionVolume
 	ExecutorType                 = fv1.ExecutorType
 	StrategyType                 = fv1.StrategyType
 	FunctionSpec                 = fv1.FunctionSpec
+	FunctionStatus               = fv1.FunctionStatus
+	FunctionCondition            = fv1.FunctionCondition
+	FunctionConditionType        = fv1.FunctionConditionType
 	InvokeStrategy               = fv1.InvokeStrategy
 	ExecutionStrategy            = fv1.ExecutionStrategy
 	FunctionReferenceType        = fv1.FunctionReferenceType
@@ -89,6 +100,27 @@ type (
 		Secrets       []SecretReference    `json:"secretList"`
 		ConfigMaps    []ConfigMapReference `json:"configMapList"`
 		KeepArchive   bool                 `json:"keeparchive"`
+
+		// SecretsDir is a directory in the shared volume to put the
+		// Secrets and ConfigMaps in, instead of the paths function pods
+		// read them from. Builds use it to keep them out of function pods.
+		SecretsDir string `json:"secretsDir,omitempty"`
+
+		// ArchiveUrl is a signed download URL of the package's archive,
+		// used instead of the archive URL when the storage service
+		// only serves signed URLs.
+		ArchiveUrl string `json:"archiveUrl,omitempty"`
+
+		// ArchiveGrant is a fetch grant of the package's archive, with
+		// which the fetcher gets a signed download URL when it
+		// specializes. Function deployments get it instead of an
+		// ArchiveUrl, which would expire.
+		ArchiveGrant string `json:"archiveGrant,omitempty"`
+
+		// Env are environment variables of the function that the
+		// fetcher resolves, reading the keys of Secrets and ConfigMaps
+		// they refer to, and hands to the runtime in the load request.
+		Env []apiv1.EnvVar `json:"env,omitempty"`
 	}
 
 	FunctionLoadRequest struct {
@@ -111,6 +143,50 @@ type (
 		FunctionMetadata *metav1.ObjectMeta
 
 		EnvVersion int `json:"envVersion"`
+
+		// Env are environment variables of the function, which the
+		// runtime sets before loading it. Optional.
+		Env map[string]string `json:"env,omitempty"`
+	}
+
+	// FunctionReloadRequest is sent by the executor to the fetcher of a
+	// specialized pod when Secrets or ConfigMaps of its function change.
+	// The fetcher sends it on to the runtime once the files are rewritten.
+	FunctionReloadRequest struct {
+		Secrets    []SecretReference    `json:"secretList,omitempty"`
+		ConfigMaps []ConfigMapReference `json:"configMapList,omitempty"`
+	}
+
+	// FunctionLoadState is the state of a function in the runtime.
+	FunctionLoadState string
+
+	// FunctionLoadStatus is reported by the runtimes of v3 environments
+	// for a function they were asked to load.
+	FunctionLoadStatus struct {
+		State FunctionLoadState `json:"state"`
+		Error string            `json:"error,omitempty"`
+	}
+
+	// FunctionHealth is reported by the runtimes of v3 environments for
+	// each function they loaded, keyed by function UID.
+	FunctionHealth struct {
+		Healthy bool   `json:"healthy"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	// SpecializationErrorReason tells which step of specializing a pod
+	// failed.
+	SpecializationErrorReason string
+
+	// SpecializationError describes why a pod couldn't be specialized for
+	// a function. The fetcher responds with it to a failed specialize
+	// request, and the executor passes it on to the router.
+	SpecializationError struct {
+		Reason  SpecializationErrorReason `json:"reason"`
+		Message string                    `json:"message"`
+
+		// Pod is the pod that failed to specialize, set by the executor.
+		Pod string `json:"pod,omitempty"`
 	}
 
 	// ArchiveUploadRequest send from builder manager describes which
@@ -119,6 +195,17 @@ type (
 		Filename       string `json:"filename"`
 		StorageSvcUrl  string `json:"storagesvcurl"`
 		ArchivePackage bool   `json:"archivepackage"`
+
+		// Metadata is sent to the storage service with the archive,
+		// keyed by the storagesvc Metadata* constants.
+		Metadata map[string]string `json:"metadata,omitempty"`
+	}
+
+	// FunctionFetchResponse describes what the fetcher fetched.
+	FunctionFetchResponse struct {
+		// GitCommit is the SHA of the commit a git source was
+		// resolved to.
+		GitCommit string `json:"gitCommit,omitempty"`
 	}
 
 	// ArchiveUploadResponse defines the download url of an archive and
@@ -127,6 +214,19 @@ type (
 		ArchiveDownloadUrl string   `json:"archiveDownloadUrl"`
 		Checksum           Checksum `json:"checksum"`
 	}
+
+	// FunctionServiceInfo describes a function service of the executor,
+	// i.e. the kubernetes objects serving a function.
+	FunctionServiceInfo struct {
+		Name              string                  `json:"name"`
+		Function          metav1.ObjectMeta       `json:"function"`
+		Environment       metav1.ObjectMeta       `json:"environment"`
+		Address           string                  `json:"address"`
+		Executor          ExecutorType            `json:"executor"`
+		KubernetesObjects []apiv1.ObjectReference `json:"kubernetesObjects"`
+		Ctime             time.Time               `json:"ctime"`
+		Atime             time.Time               `json:"atime"`
+	}
 )
 
 const (
@@ -135,6 +235,36 @@ const (
 	FETCH_URL // remove this?
 )
 
+// Endpoints of the runtimes of v3 environments, in addition to those of
+// v2. The load request is sent to /v2/specialize as before; the runtime
+// may answer before the function is loaded and report the progress on
+// the load status endpoint, which takes the function UID as the "uid"
+// query parameter. The reload endpoint is told that the Secrets and
+// ConfigMaps of the functions changed.
+const (
+	EnvLoadStatusPath = "/v3/status"
+	EnvReadinessPath  = "/v3/readiness"
+	EnvDrainPath      = "/v3/drain"
+	EnvHealthPath     = "/v3/health"
+	EnvReloadPath     = "/v3/reload"
+)
+
+const (
+	FunctionLoadStateLoading FunctionLoadState = "loading"
+	FunctionLoadStateLoaded  FunctionLoadState = "loaded"
+	FunctionLoadStateFailed  FunctionLoadState = "failed"
+)
+
+const (
+	SpecializationErrorFetchFailed        SpecializationErrorReason = "FetchFailed"
+	SpecializationErrorPackageNotReady    SpecializationErrorReason = "PackageNotReady"
+	SpecializationErrorChecksumMismatch   SpecializationErrorReason = "ChecksumMismatch"
+	SpecializationErrorInvalidArchive     SpecializationErrorReason = "InvalidArchive"
+	SpecializationErrorSecretsFailed      SpecializationErrorReason = "SecretsFailed"
+	SpecializationErrorLoadFailed         SpecializationErrorReason = "LoadFailed"
+	SpecializationErrorRuntimeUnavailable SpecializationErrorReason = "RuntimeUnavailable"
+)
+
 const EXECUTOR_INSTANCEID_LABEL = fv1.EXECUTOR_INSTANCEID_LABEL
 const POOLMGR_INSTANCEID_LABEL = fv1.POOLMGR_INSTANCEID_LABEL
 
@@ -142,6 +272,11 @@ const (
 	ChecksumTypeSHA256 = fv1.ChecksumTypeSHA256
 )
 
+const (
+	SignatureTypeEd25519 = fv1.SignatureTypeEd25519
+	SignatureTypeECDSA   = fv1.SignatureTypeECDSA
+)
+
 const (
 	// ArchiveTypeLiteral means the package contents are specified in the Literal field of
 	// resource itself.
@@ -149,6 +284,14 @@ const (
 
 	// ArchiveTypeUrl means the package contents are at the specified URL.
 	ArchiveTypeUrl = fv1.ArchiveTypeUrl
+
+	// ArchiveTypeImage means the package contents are the files of a
+	// Docker or OCI image.
+	ArchiveTypeImage = fv1.ArchiveTypeImage
+
+	// ArchiveTypeGit means the package contents are cloned from a git
+	// repository.
+	ArchiveTypeGit = fv1.ArchiveTypeGit
 )
 
 const (
@@ -166,24 +309,32 @@ const (
 
 // executor kubernetes object label key
 const (
-	ENVIRONMENT_NAMESPACE = "environmentNamespace"
-	ENVIRONMENT_NAME      = "environmentName"
-	ENVIRONMENT_UID       = "environmentUid"
-	FUNCTION_NAMESPACE    = "functionNamespace"
-	FUNCTION_NAME         = "functionName"
-	FUNCTION_UID          = "functionUid"
-	EXECUTOR_TYPE         = "executorType"
+	ENVIRONMENT_NAMESPACE     = "environmentNamespace"
+	ENVIRONMENT_NAME          = "environmentName"
+	ENVIRONMENT_UID           = "environmentUid"
+	FUNCTION_NAMESPACE        = "functionNamespace"
+	FUNCTION_NAME             = "functionName"
+	FUNCTION_UID              = "functionUid"
+	FUNCTION_RESOURCE_VERSION = "functionResourceVersion"
+	EXECUTOR_TYPE             = "executorType"
 )
 
 const (
 	ExecutorTypePoolmgr   = fv1.ExecutorTypePoolmgr
 	ExecutorTypeNewdeploy = fv1.ExecutorTypeNewdeploy
+	ExecutorTypeContainer = fv1.ExecutorTypeContainer
 )
 
 const (
 	StrategyTypeExecution = fv1.StrategyTypeExecution
 )
 
+const (
+	FunctionPackageReady = fv1.FunctionPackageReady
+	FunctionSpecialized  = fv1.FunctionSpecialized
+	FunctionScaledToZero = fv1.FunctionScaledToZero
+)
+
 const (
 	SharedVolumeUserfunc   = fv1.SharedVolumeUserfunc
 	SharedVolumePackages   = fv1.SharedVolumePackages
@@ -222,6 +373,7 @@ const (
 	ErrorNotImplmented
 	ErrorChecksumFail
 	ErrorSizeLimitExceeded
+	ErrorUnavailable
 )
 
 // must match order and len of the above const
@@ -235,6 +387,7 @@ var errorDescriptions = []string{
 	"Not implemented",
 	"Checksum verification failed",
 	"Size limit exceeded",
+	"Service unavailable",
 }
 
 const (
@@ -251,7 +404,13 @@ const (
 	PackageGetterCR = "system-fission-package-getter"
 	PackageGetterRB = "system-fission-package-getter-binding"
 
+	// PackageKeysGetterRole, in the fission namespace, only reads the
+	// ConfigMap of the keys trusted to sign packages
+	PackageKeysGetterRole = "system-fission-package-keys-getter"
+	PackageKeysGetterRB   = "system-fission-package-keys-getter-binding"
+
 	ClusterRole = "ClusterRole"
+	Role        = "Role"
 )
 
 const (